@@ -11,6 +11,8 @@ var (
 	apiGroup   string
 	apiVersion string
 	apiKind    string
+
+	apiWithWebhook bool
 )
 
 var createAPICmd = &cobra.Command{
@@ -23,6 +25,7 @@ This command generates:
   - Hydration template YAML file
   - Updates to registration and scheme code
   - Sample instance file
+  - Defaulting/validation webhook stubs (with --with-webhook)
 
 Example:
   krm-sdk create api --group platform --version v1alpha1 --kind WebService`,
@@ -34,10 +37,11 @@ Example:
 		verbose, _ := cmd.Flags().GetBool("verbose")
 
 		scaffolder := scaffold.NewAPIScaffolder(scaffold.APIConfig{
-			Group:   apiGroup,
-			Version: apiVersion,
-			Kind:    apiKind,
-			Verbose: verbose,
+			Group:       apiGroup,
+			Version:     apiVersion,
+			Kind:        apiKind,
+			WithWebhook: apiWithWebhook,
+			Verbose:     verbose,
 		})
 
 		if err := scaffolder.Scaffold(); err != nil {
@@ -62,6 +66,7 @@ func init() {
 	createAPICmd.Flags().StringVar(&apiGroup, "group", "", "API group name (required)")
 	createAPICmd.Flags().StringVar(&apiVersion, "version", "", "API version (required)")
 	createAPICmd.Flags().StringVar(&apiKind, "kind", "", "API kind name (required)")
+	createAPICmd.Flags().BoolVar(&apiWithWebhook, "with-webhook", false, "also generate defaulting/validation webhook stubs for the kind")
 	createAPICmd.MarkFlagRequired("group")
 	createAPICmd.MarkFlagRequired("version")
 	createAPICmd.MarkFlagRequired("kind")
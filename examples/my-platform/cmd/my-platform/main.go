@@ -1,15 +1,18 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"github.com/example/my-platform/cmd/my-platform/commands"
+	"github.com/zachaller/k8s-client-api-builder/pkg/cli"
 )
 
 func main() {
-	if err := commands.Execute(); err != nil {
+	err := commands.Execute()
+	if err != nil && !errors.Is(err, cli.ErrDiffFound) {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
 	}
+	os.Exit(cli.ExitCode(err))
 }
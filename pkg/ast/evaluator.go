@@ -1,31 +1,185 @@
 package ast
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
 )
 
-// Evaluator evaluates an AST and produces Kubernetes resources
+// Evaluator evaluates an AST and produces Kubernetes resources.
+// An Evaluator swaps its context and collects resources while it walks the
+// AST, so it must not be used from more than one goroutine at a time. Parsed
+// ASTs are read-only and may be shared; give each goroutine its own Evaluator
+// via NewEvaluator or Clone.
 type Evaluator struct {
 	instance      map[string]interface{}   // The input instance data
 	dslEvaluator  *dsl.Evaluator           // DSL expression evaluator (exported for hydrator pass2)
 	context       map[string]interface{}   // Current evaluation context (includes loop variables)
 	resources     []map[string]interface{} // Collected resources
 	resourceDepth int                      // Depth counter to track when we're inside a resource
+	trace         dsl.TraceFunc            // Expression trace callback, kept across loop scopes
+	maxDepth      int                      // Nesting limit; 0 means dsl.DefaultMaxDepth
+	depth         int                      // Current nesting of maps, arrays and loops
+	loopVars      []string                 // Variables of the enclosing @for loops
+	nilMissing    bool                     // Bind nil for destructured fields missing from an element
+	strictLoops   bool                     // Fail @for over a nil or absent iterable instead of skipping it
+	clock         func() time.Time         // Current time for now(), kept across loop scopes
+	secrets       dsl.SecretResolver       // Values for secret(), kept across loop scopes
+	files         fs.FS                    // Directory read by file(), kept across loop scopes
 }
 
+// ValuesKey is the context key under which external values are exposed to expressions
+const ValuesKey = "$values"
+
 // NewEvaluator creates a new AST evaluator
 func NewEvaluator(instance map[string]interface{}) *Evaluator {
+	return NewEvaluatorWithValues(instance, nil)
+}
+
+// NewEvaluatorWithValues creates a new AST evaluator that exposes values as $values.
+// Instance fields override values with the same path.
+func NewEvaluatorWithValues(instance, values map[string]interface{}) *Evaluator {
+	context := instance
+	if values != nil {
+		context = make(map[string]interface{}, len(instance)+1)
+		for k, v := range instance {
+			context[k] = v
+		}
+		context[ValuesKey] = mergeValues(values, instance)
+	}
+
 	return &Evaluator{
 		instance:     instance,
-		dslEvaluator: dsl.NewEvaluator(instance),
-		context:      instance,
+		dslEvaluator: dsl.NewEvaluator(context),
+		context:      context,
 		resources:    []map[string]interface{}{},
 	}
 }
 
+// Clone returns a fresh evaluator for the same instance and values, with no
+// collected resources, that can be used independently of e
+func (e *Evaluator) Clone() *Evaluator {
+	context := make(map[string]interface{}, len(e.context))
+	for k, v := range e.context {
+		context[k] = v
+	}
+
+	return &Evaluator{
+		instance:     e.instance,
+		dslEvaluator: e.dslEvaluator.Clone(),
+		context:      context,
+		resources:    []map[string]interface{}{},
+		trace:        e.trace,
+		maxDepth:     e.maxDepth,
+		nilMissing:   e.nilMissing,
+		strictLoops:  e.strictLoops,
+		clock:        e.clock,
+		secrets:      e.secrets,
+		files:        e.files,
+	}
+}
+
+// SetTrace installs an expression trace callback on the DSL evaluators used
+// for this AST, including the ones created for loop bodies
+func (e *Evaluator) SetTrace(fn dsl.TraceFunc) {
+	e.trace = fn
+	e.dslEvaluator.SetTrace(fn)
+}
+
+// SetMaxDepth limits how deeply maps, arrays and loops may nest during
+// evaluation, including the values walked when resolving references;
+// 0 restores dsl.DefaultMaxDepth
+func (e *Evaluator) SetMaxDepth(depth int) {
+	e.maxDepth = depth
+	e.dslEvaluator.SetMaxDepth(depth)
+}
+
+// SetClock sets the clock now() reads in the DSL evaluators used for this AST
+func (e *Evaluator) SetClock(clock func() time.Time) {
+	e.clock = clock
+	e.dslEvaluator.SetClock(clock)
+}
+
+// SetSecretResolver sets where secret() reads values in the DSL evaluators
+// used for this AST
+func (e *Evaluator) SetSecretResolver(resolver dsl.SecretResolver) {
+	e.secrets = resolver
+	e.dslEvaluator.SetSecretResolver(resolver)
+}
+
+// SetFiles sets the directory file() reads from in the DSL evaluators used
+// for this AST
+func (e *Evaluator) SetFiles(fsys fs.FS) {
+	e.files = fsys
+	e.dslEvaluator.SetFiles(fsys)
+}
+
+// SetNilMissingFields binds nil for fields of a @for destructure list that
+// are missing from an element, instead of failing the loop
+func (e *Evaluator) SetNilMissingFields(enabled bool) {
+	e.nilMissing = enabled
+}
+
+// SetStrictLoops makes a @for over a nil or absent iterable fail instead of
+// producing no iterations
+func (e *Evaluator) SetStrictLoops(strict bool) {
+	e.strictLoops = strict
+}
+
+// newDSLEvaluator creates a DSL evaluator for context that keeps the trace
+// callback and depth limit
+func (e *Evaluator) newDSLEvaluator(context map[string]interface{}) *dsl.Evaluator {
+	evaluator := dsl.NewEvaluator(context)
+	evaluator.SetTrace(e.trace)
+	evaluator.SetMaxDepth(e.maxDepth)
+	evaluator.SetClock(e.clock)
+	evaluator.SetSecretResolver(e.secrets)
+	evaluator.SetFiles(e.files)
+	return evaluator
+}
+
+// enter records one more level of nesting, failing once the limit is exceeded.
+// Every successful enter must be paired with a leave.
+func (e *Evaluator) enter() error {
+	limit := e.maxDepth
+	if limit <= 0 {
+		limit = dsl.DefaultMaxDepth
+	}
+	if e.depth >= limit {
+		return fmt.Errorf("%w (limit %d)", dsl.ErrMaxDepthExceeded, limit)
+	}
+	e.depth++
+	return nil
+}
+
+// leave undoes an enter
+func (e *Evaluator) leave() {
+	e.depth--
+}
+
+// mergeValues deep merges override on top of base without modifying either map
+func mergeValues(base, override map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range override {
+		baseMap, baseIsMap := result[k].(map[string]interface{})
+		overrideMap, overrideIsMap := v.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			result[k] = mergeValues(baseMap, overrideMap)
+			continue
+		}
+		result[k] = v
+	}
+	return result
+}
+
 // GetDSLEvaluator returns the underlying DSL evaluator (for pass2 resource references)
 func (e *Evaluator) GetDSLEvaluator() *dsl.Evaluator {
 	return e.dslEvaluator
@@ -53,38 +207,30 @@ func (e *Evaluator) VisitRoot(node *RootNode) (interface{}, error) {
 
 // VisitForLoop visits a for loop node
 func (e *Evaluator) VisitForLoop(node *ForLoopNode) (interface{}, error) {
-	// Evaluate the iterable expression
-	iterableValue, err := e.evaluateExpression(node.Iterable)
-	if err != nil {
-		return nil, fmt.Errorf("failed to evaluate iterable: %w", err)
+	if err := e.enter(); err != nil {
+		return nil, err
 	}
+	defer e.leave()
 
-	// Convert to slice
-	items, ok := iterableValue.([]interface{})
-	if !ok {
-		// Try to convert from other types
-		switch v := iterableValue.(type) {
-		case []map[string]interface{}:
-			items = make([]interface{}, len(v))
-			for i, item := range v {
-				items[i] = item
-			}
-		default:
-			return nil, fmt.Errorf("iterable must be an array, got %T", iterableValue)
-		}
+	// Bind every combination of the items of the loop's clauses
+	clauses := node.Clauses()
+	contexts, err := e.loopContexts(clauses, e.context, e.dslEvaluator)
+	if err != nil {
+		return nil, err
+	}
+	var loopVars []string
+	for _, clause := range clauses {
+		loopVars = append(loopVars, clause.Variables()...)
 	}
 
-	// Iterate over items
+	// Iterate over items, counting matches so offset and limit apply after filtering
 	results := []interface{}{}
-	for _, item := range items {
-		// Create new context with loop variable
-		loopContext := e.copyContext()
-		loopContext[node.Variable] = item
-
+	matched := 0
+	for _, loopContext := range contexts {
 		// If there's a where clause, evaluate it
 		if node.WhereClause != nil {
 			// Create evaluator with loop context
-			loopEvaluator := dsl.NewEvaluator(loopContext)
+			loopEvaluator := e.newDSLEvaluator(loopContext)
 			condResult, err := loopEvaluator.Evaluate(node.WhereClause)
 			if err != nil {
 				// Skip items where condition evaluation fails
@@ -109,58 +255,199 @@ func (e *Evaluator) VisitForLoop(node *ForLoopNode) (interface{}, error) {
 			}
 		}
 
+		matched++
+		if matched <= node.Offset {
+			continue
+		}
+		if node.Limit != nil && matched > node.Offset+*node.Limit {
+			break
+		}
+
 		// Execute loop body with new context
 		oldContext := e.context
 		oldEvaluator := e.dslEvaluator
+		oldLoopVars := e.loopVars
 		e.context = loopContext
-		e.dslEvaluator = dsl.NewEvaluator(loopContext)
+		e.dslEvaluator = e.newDSLEvaluator(loopContext)
+		e.loopVars = append(append([]string{}, oldLoopVars...), loopVars...)
 
 		for _, bodyNode := range node.Body {
 			result, err := bodyNode.Accept(e)
 			if err != nil {
 				e.context = oldContext
 				e.dslEvaluator = oldEvaluator
+				e.loopVars = oldLoopVars
 				return nil, err
 			}
-			if result != nil {
-				results = append(results, result)
-			}
+			results = appendBodyResult(results, bodyNode, result)
 		}
 
 		e.context = oldContext
 		e.dslEvaluator = oldEvaluator
+		e.loopVars = oldLoopVars
 	}
 
 	// Note: Resources have already been added to e.resources by VisitResource/VisitMap
 	return results, nil
 }
 
-// VisitConditional visits a conditional node
-func (e *Evaluator) VisitConditional(node *ConditionalNode) (interface{}, error) {
-	// Evaluate the condition
-	// If evaluation fails (e.g., key not found), treat as false
-	condResult, err := e.evaluateExpression(node.Condition)
-	conditionTrue := false
-
+// loopContexts returns a copy of context for every combination of the items
+// of clauses, with each clause's variables bound. Combinations are ordered by
+// the items of the first clause, then the second, and so on. evaluator
+// evaluates expressions in context.
+func (e *Evaluator) loopContexts(clauses []ForClause, context map[string]interface{}, evaluator *dsl.Evaluator) ([]map[string]interface{}, error) {
+	clause := clauses[0]
+	items, err := e.loopItems(clause.Iterable, evaluator)
 	if err != nil {
-		// If the condition evaluation fails (e.g., missing key), treat as false
-		// This allows for optional field checking like @if(component.args)
-		conditionTrue = false
-	} else {
-		// Check if condition is true
-		switch v := condResult.(type) {
-		case bool:
-			conditionTrue = v
-		case string:
-			conditionTrue = v != "" && v != "false" && v != "0"
-		case int, int32, int64:
-			conditionTrue = v != 0
-		case float32, float64:
-			conditionTrue = v != 0.0
+		return nil, err
+	}
+
+	contexts := []map[string]interface{}{}
+	for _, item := range items {
+		// Create new context with loop variable
+		loopContext := copyContext(context)
+		if clause.Fields != nil {
+			if err := e.bindFields(loopContext, clause.Fields, item); err != nil {
+				return nil, err
+			}
+		} else {
+			loopContext[clause.Variable] = item
+		}
+
+		if len(clauses) == 1 {
+			contexts = append(contexts, loopContext)
+			continue
+		}
+		inner, err := e.loopContexts(clauses[1:], loopContext, e.newDSLEvaluator(loopContext))
+		if err != nil {
+			return nil, err
+		}
+		contexts = append(contexts, inner...)
+	}
+	return contexts, nil
+}
+
+// loopItems evaluates the iterable of a @for clause. An absent path
+// evaluates to no items, so optional lists can be iterated without a guard.
+func (e *Evaluator) loopItems(iterable *dsl.Expression, evaluator *dsl.Evaluator) ([]interface{}, error) {
+	iterableValue, err := evaluator.Evaluate(iterable)
+	if err != nil && (e.strictLoops || iterable.Type != dsl.ExprPath || !errors.Is(err, dsl.ErrKeyNotFound)) {
+		return nil, fmt.Errorf("failed to evaluate iterable: %w", err)
+	}
+	if iterableValue == nil && !e.strictLoops {
+		return []interface{}{}, nil
+	}
+
+	// Convert to slice
+	items, ok := iterableValue.([]interface{})
+	if !ok {
+		// Try to convert from other types
+		switch v := iterableValue.(type) {
+		case []map[string]interface{}:
+			items = make([]interface{}, len(v))
+			for i, item := range v {
+				items[i] = item
+			}
 		default:
-			conditionTrue = v != nil
+			return nil, fmt.Errorf("iterable must be an array, got %T", iterableValue)
+		}
+	}
+	return items, nil
+}
+
+// setField evaluates a field value and sets it in result under key. A
+// conditional field is only set when its condition holds, and a switch sets
+// the matching branch. name identifies the field in errors.
+func (e *Evaluator) setField(result map[string]interface{}, name, key string, valueNode Node) error {
+	switch vNode := valueNode.(type) {
+	case *ConditionalFieldNode:
+		// Optional field - only set when the condition holds
+		if !e.evaluateCondition(vNode.Condition) {
+			return nil
+		}
+		valueNode = vNode.Value
+	case *SwitchNode:
+		// Switch as a field value - the matching branch becomes the value
+		switchResult, err := vNode.Accept(e)
+		if err != nil {
+			return err
+		}
+		switchResults, _ := switchResult.([]interface{})
+		if len(switchResults) == 1 {
+			result[key] = switchResults[0]
+		} else if len(switchResults) > 1 {
+			result[key] = switchResults
+		}
+		return nil
+	}
+
+	value, err := valueNode.Accept(e)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate map field %s: %w", name, err)
+	}
+	result[key] = value
+	return nil
+}
+
+// setKeyExprFields sets the fields whose key is an @expr(...) expression. They
+// are set in order of their expressions after all other fields, and a key
+// that is already set is an error, so collisions never depend on map order.
+func (e *Evaluator) setKeyExprFields(node *MapNode, result map[string]interface{}) error {
+	rawKeys := make([]string, 0, len(node.KeyExpr))
+	for rawKey := range node.KeyExpr {
+		rawKeys = append(rawKeys, rawKey)
+	}
+	sort.Strings(rawKeys)
+
+	for _, rawKey := range rawKeys {
+		keyValue, err := e.evaluateExpression(node.KeyExpr[rawKey])
+		if err != nil {
+			return fmt.Errorf("failed to evaluate map key %s: %w", rawKey, err)
+		}
+		switch keyValue.(type) {
+		case nil, map[string]interface{}, []interface{}:
+			return fmt.Errorf("map key %s must evaluate to a string, got %T", rawKey, keyValue)
+		}
+
+		key := fmt.Sprintf("%v", keyValue)
+		if _, exists := result[key]; exists {
+			return fmt.Errorf("map key %s evaluates to %q, which is already set", rawKey, key)
+		}
+		if err := e.setField(result, rawKey, key, node.Fields[rawKey]); err != nil {
+			return err
 		}
 	}
+	return nil
+}
+
+// bindFields binds each destructured field of a loop element in context.
+// Loop variables share the context with the instance's top-level fields, so
+// a field named like one of them, such as kind, is an error rather than
+// hiding it.
+func (e *Evaluator) bindFields(context map[string]interface{}, fields []string, item interface{}) error {
+	element, ok := item.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("cannot destructure {%s} from %T, expected a map", strings.Join(fields, ", "), item)
+	}
+
+	for _, field := range fields {
+		if _, ok := e.instance[field]; ok {
+			return fmt.Errorf("destructured field '%s' would hide the instance's .%s; use a loop variable instead", field, field)
+		}
+		value, ok := element[field]
+		if !ok && !e.nilMissing {
+			return fmt.Errorf("destructured field '%s' not found in loop element", field)
+		}
+		context[field] = value
+	}
+	return nil
+}
+
+// VisitConditional visits a conditional node
+func (e *Evaluator) VisitConditional(node *ConditionalNode) (interface{}, error) {
+	// Evaluate the condition
+	// If evaluation fails (e.g., key not found), treat as false
+	conditionTrue := e.evaluateCondition(node.Condition)
 
 	// Execute the appropriate branch
 	var branch []Node
@@ -176,14 +463,99 @@ func (e *Evaluator) VisitConditional(node *ConditionalNode) (interface{}, error)
 		if err != nil {
 			return nil, err
 		}
-		if result != nil {
-			results = append(results, result)
+		results = appendBodyResult(results, branchNode, result)
+	}
+
+	return results, nil
+}
+
+// appendBodyResult appends the result of a node in a body list to results.
+// The chosen branch of an @if, @else or @switch item and the elements of an
+// @spread item are added as items, as they would be in a list, rather than
+// as a nested list.
+func appendBodyResult(results []interface{}, node Node, result interface{}) []interface{} {
+	switch node.(type) {
+	case *ConditionalNode, *SwitchNode, *SpreadNode:
+		if branchResults, ok := result.([]interface{}); ok {
+			return append(results, branchResults...)
+		}
+	}
+	if result != nil {
+		results = append(results, result)
+	}
+	return results
+}
+
+// VisitSwitch visits a switch node and executes the first case whose value
+// matches the switch value (compared as strings), or the default branch
+func (e *Evaluator) VisitSwitch(node *SwitchNode) (interface{}, error) {
+	// If the switch value can't be evaluated (e.g., missing key), fall through to default
+	value, err := e.evaluateExpression(node.Value)
+	if err != nil {
+		value = nil
+	}
+
+	// Cases have no order, so a value matching several of them is an error
+	branch := node.Default
+	matched := false
+	for _, c := range node.Cases {
+		caseValue, err := e.evaluateExpression(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate case value: %w", err)
+		}
+		if value != nil && fmt.Sprintf("%v", caseValue) == fmt.Sprintf("%v", value) {
+			if matched {
+				return nil, fmt.Errorf("@switch value %v matches more than one @case", value)
+			}
+			branch = c.Body
+			matched = true
 		}
 	}
 
+	results := []interface{}{}
+	for _, branchNode := range branch {
+		result, err := branchNode.Accept(e)
+		if err != nil {
+			return nil, err
+		}
+		results = appendBodyResult(results, branchNode, result)
+	}
+
 	return results, nil
 }
 
+// VisitConditionalField visits a conditional field node and returns the field
+// value, or nil when the condition does not hold
+func (e *Evaluator) VisitConditionalField(node *ConditionalFieldNode) (interface{}, error) {
+	if !e.evaluateCondition(node.Condition) {
+		return nil, nil
+	}
+	return node.Value.Accept(e)
+}
+
+// evaluateCondition evaluates a condition expression in the current context
+// If evaluation fails (e.g., missing key), the condition is treated as false
+// This allows for optional field checking like @if(component.args)
+func (e *Evaluator) evaluateCondition(expr *dsl.Expression) bool {
+	condResult, err := e.evaluateExpression(expr)
+	if err != nil {
+		return false
+	}
+
+	switch v := condResult.(type) {
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false" && v != "0"
+	case int, int32, int64:
+		return v != 0
+	case float32, float64:
+		return v != 0.0
+	default:
+		return v != nil
+	}
+}
+
 // VisitResource visits a resource node (K8s resource)
 func (e *Evaluator) VisitResource(node *ResourceNode) (interface{}, error) {
 	resource := make(map[string]interface{})
@@ -207,18 +579,39 @@ func (e *Evaluator) VisitField(node *FieldNode) (interface{}, error) {
 	return node.Value.Accept(e)
 }
 
-// VisitExpression visits an expression node
+// VisitExpression visits an expression node. The result is data: maps and
+// arrays are spliced in as copies, and "$(" in their strings is escaped like
+// in @raw blocks so the reference pass never evaluates instance values.
 func (e *Evaluator) VisitExpression(node *ExpressionNode) (interface{}, error) {
-	return e.evaluateExpression(node.Expr)
+	result, err := e.evaluateExpression(node.Expr)
+	if err != nil {
+		return nil, err
+	}
+	if node.Coerce != "" {
+		if result, err = dsl.Coerce(result, node.Coerce); err != nil {
+			return nil, fmt.Errorf("@%s: %w", node.Coerce, err)
+		}
+	}
+	return escapeRaw(result), nil
 }
 
-// VisitLiteral visits a literal node
+// VisitLiteral visits a literal node. Resource references are resolved in the
+// hydrator's second pass, after the loops have finished, so loop variables
+// used in them are bound to their current values here.
 func (e *Evaluator) VisitLiteral(node *LiteralNode) (interface{}, error) {
+	if str, ok := node.Value.(string); ok && len(e.loopVars) > 0 && strings.Contains(str, "resource(") {
+		return e.dslEvaluator.BindVariables(str, e.loopVars)
+	}
 	return node.Value, nil
 }
 
 // VisitArray visits an array node
 func (e *Evaluator) VisitArray(node *ArrayNode) (interface{}, error) {
+	if err := e.enter(); err != nil {
+		return nil, err
+	}
+	defer e.leave()
+
 	result := make([]interface{}, 0)
 
 	for _, elem := range node.Elements {
@@ -243,6 +636,22 @@ func (e *Evaluator) VisitArray(node *ArrayNode) (interface{}, error) {
 			if condResults, ok := condResult.([]interface{}); ok {
 				result = append(result, condResults...)
 			}
+		case *SwitchNode:
+			// Switch in array - include the matching branch
+			switchResult, err := elemNode.Accept(e)
+			if err != nil {
+				return nil, err
+			}
+			if switchResults, ok := switchResult.([]interface{}); ok {
+				result = append(result, switchResults...)
+			}
+		case *SpreadNode:
+			// Spread in array - flatten the list into this array
+			spreadResult, err := elemNode.Accept(e)
+			if err != nil {
+				return nil, fmt.Errorf("failed to evaluate @spread: %w", err)
+			}
+			result = append(result, spreadResult.([]interface{})...)
 		default:
 			// Regular element
 			value, err := elem.Accept(e)
@@ -258,6 +667,11 @@ func (e *Evaluator) VisitArray(node *ArrayNode) (interface{}, error) {
 
 // VisitMap visits a map node
 func (e *Evaluator) VisitMap(node *MapNode) (interface{}, error) {
+	if err := e.enter(); err != nil {
+		return nil, err
+	}
+	defer e.leave()
+
 	result := make(map[string]interface{})
 
 	// Check if this is a top-level resource (has both apiVersion and kind)
@@ -269,7 +683,7 @@ func (e *Evaluator) VisitMap(node *MapNode) (interface{}, error) {
 	// If so, we should return the control flow results directly, not as a map
 	hasOnlyControlFlow := len(node.Fields) > 0
 	for key := range node.Fields {
-		if !strings.HasPrefix(key, "@for(") && !strings.HasPrefix(key, "@if(") {
+		if !strings.HasPrefix(key, "@for(") && !strings.HasPrefix(key, "@if(") && !strings.HasPrefix(key, "@switch(") {
 			hasOnlyControlFlow = false
 			break
 		}
@@ -286,6 +700,9 @@ func (e *Evaluator) VisitMap(node *MapNode) (interface{}, error) {
 			case *ConditionalNode:
 				// Return conditional results directly
 				return vNode.Accept(e)
+			case *SwitchNode:
+				// Return switch results directly
+				return vNode.Accept(e)
 			}
 		}
 	}
@@ -296,6 +713,10 @@ func (e *Evaluator) VisitMap(node *MapNode) (interface{}, error) {
 	}
 
 	for key, valueNode := range node.Fields {
+		if _, ok := node.KeyExpr[key]; ok {
+			continue // Set by setKeyExprFields once the other fields are known
+		}
+
 		// Check if this is a control flow key
 		switch vNode := valueNode.(type) {
 		case *ForLoopNode:
@@ -329,16 +750,39 @@ func (e *Evaluator) VisitMap(node *MapNode) (interface{}, error) {
 					}
 				}
 			}
+		case *SwitchNode:
+			if !strings.HasPrefix(key, "@switch(") {
+				// Switch as a field value
+				if err := e.setField(result, key, key, valueNode); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			// Switch in map - merge the matching branch
+			switchResult, err := vNode.Accept(e)
+			if err != nil {
+				return nil, err
+			}
+			switchResults, _ := switchResult.([]interface{})
+			for _, sr := range switchResults {
+				if srMap, ok := sr.(map[string]interface{}); ok {
+					for k, v := range srMap {
+						result[k] = v
+					}
+				}
+			}
 		default:
 			// Regular field
-			value, err := valueNode.Accept(e)
-			if err != nil {
-				return nil, fmt.Errorf("failed to evaluate map field %s: %w", key, err)
+			if err := e.setField(result, key, key, valueNode); err != nil {
+				return nil, err
 			}
-			result[key] = value
 		}
 	}
 
+	if err := e.setKeyExprFields(node, result); err != nil {
+		return nil, err
+	}
+
 	// Only collect as a resource if we're at depth 1 (top-level resource)
 	if isResource && e.resourceDepth == 1 {
 		e.resources = append(e.resources, result)
@@ -367,15 +811,66 @@ func (e *Evaluator) VisitMultiControlFlow(node *MultiControlFlowNode) (interface
 	return nil, nil
 }
 
+// VisitRaw visits an @raw node. The subtree is copied as written, except that
+// "$(" and "$if(" in string values are escaped as "$$(" and "$$if(" so the
+// hydrator's reference pass restores them instead of evaluating them.
+func (e *Evaluator) VisitRaw(node *RawNode) (interface{}, error) {
+	return escapeRaw(node.Value), nil
+}
+
+// VisitSpread visits an @spread node. The expression must evaluate to a list,
+// or to null for no elements; like @expr, the elements are copied as data.
+func (e *Evaluator) VisitSpread(node *SpreadNode) (interface{}, error) {
+	result, err := e.evaluateExpression(node.Expr)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := result.(type) {
+	case nil:
+		return []interface{}{}, nil
+	case []interface{}:
+		return escapeRaw(v), nil
+	default:
+		return nil, fmt.Errorf("@spread value must be a list, got %T", result)
+	}
+}
+
+// rawEscaper escapes the substitution delimiters of pass 2
+var rawEscaper = strings.NewReplacer("$(", "$$(", "$if(", "$$if(")
+
+// escapeRaw returns a deep copy of value with "$(" and "$if(" escaped in every
+// string value
+func escapeRaw(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return rawEscaper.Replace(v)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			result[key] = escapeRaw(val)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = escapeRaw(item)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
 // evaluateExpression evaluates a DSL expression in the current context
 func (e *Evaluator) evaluateExpression(expr *dsl.Expression) (interface{}, error) {
 	return e.dslEvaluator.Evaluate(expr)
 }
 
-// copyContext creates a copy of the current context
-func (e *Evaluator) copyContext() map[string]interface{} {
-	newContext := make(map[string]interface{})
-	for k, v := range e.context {
+// copyContext creates a copy of a context
+func copyContext(context map[string]interface{}) map[string]interface{} {
+	newContext := make(map[string]interface{}, len(context)+1)
+	for k, v := range context {
 		newContext[k] = v
 	}
 	return newContext
@@ -386,7 +881,14 @@ func (e *Evaluator) GetResources() []map[string]interface{} {
 	return e.resources
 }
 
-// RegisterResource registers a resource in the DSL evaluator (for cross-resource references)
+// RegisterResource registers a cluster-scoped resource, or one in the default
+// namespace, in the DSL evaluator (for cross-resource references)
 func (e *Evaluator) RegisterResource(apiVersion, kind, name string, resource map[string]interface{}) {
 	e.dslEvaluator.RegisterResource(apiVersion, kind, name, resource)
 }
+
+// RegisterNamespacedResource registers a resource in namespace in the DSL
+// evaluator (for cross-resource references)
+func (e *Evaluator) RegisterNamespacedResource(apiVersion, kind, namespace, name string, resource map[string]interface{}) {
+	e.dslEvaluator.RegisterNamespacedResource(apiVersion, kind, namespace, name, resource)
+}
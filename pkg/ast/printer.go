@@ -47,7 +47,15 @@ func (p *Printer) VisitRoot(node *RootNode) (interface{}, error) {
 
 func (p *Printer) VisitForLoop(node *ForLoopNode) (interface{}, error) {
 	p.writeIndent()
-	p.output.WriteString(fmt.Sprintf("ForLoopNode(var=%s, iterable=%v", node.Variable, node.Iterable))
+	var clauses []string
+	for _, clause := range node.Clauses() {
+		variable := clause.Variable
+		if clause.Fields != nil {
+			variable = "{" + strings.Join(clause.Fields, ", ") + "}"
+		}
+		clauses = append(clauses, fmt.Sprintf("var=%s, iterable=%v", variable, clause.Iterable))
+	}
+	p.output.WriteString(fmt.Sprintf("ForLoopNode(%s", strings.Join(clauses, ", ")))
 	if node.WhereClause != nil {
 		p.output.WriteString(fmt.Sprintf(", where=%v", node.WhereClause))
 	}
@@ -87,6 +95,43 @@ func (p *Printer) VisitConditional(node *ConditionalNode) (interface{}, error) {
 	return nil, nil
 }
 
+func (p *Printer) VisitSwitch(node *SwitchNode) (interface{}, error) {
+	p.writeIndent()
+	p.output.WriteString(fmt.Sprintf("SwitchNode(value=%v):\n", node.Value))
+
+	p.indent++
+	for _, c := range node.Cases {
+		p.writeIndent()
+		p.output.WriteString(fmt.Sprintf("Case(%v):\n", c.Value))
+		p.indent++
+		for _, child := range c.Body {
+			child.Accept(p)
+		}
+		p.indent--
+	}
+
+	if len(node.Default) > 0 {
+		p.writeIndent()
+		p.output.WriteString("Default:\n")
+		p.indent++
+		for _, child := range node.Default {
+			child.Accept(p)
+		}
+		p.indent--
+	}
+	p.indent--
+	return nil, nil
+}
+
+func (p *Printer) VisitConditionalField(node *ConditionalFieldNode) (interface{}, error) {
+	p.writeIndent()
+	p.output.WriteString(fmt.Sprintf("ConditionalFieldNode(key=%s, condition=%v):\n", node.Key, node.Condition))
+	p.indent++
+	node.Value.Accept(p)
+	p.indent--
+	return nil, nil
+}
+
 func (p *Printer) VisitResource(node *ResourceNode) (interface{}, error) {
 	p.writeIndent()
 	p.output.WriteString("ResourceNode:\n")
@@ -113,6 +158,10 @@ func (p *Printer) VisitField(node *FieldNode) (interface{}, error) {
 
 func (p *Printer) VisitExpression(node *ExpressionNode) (interface{}, error) {
 	p.writeIndent()
+	if node.Coerce != "" {
+		p.output.WriteString(fmt.Sprintf("ExpressionNode(@%s %v)\n", node.Coerce, node.Expr))
+		return nil, nil
+	}
 	p.output.WriteString(fmt.Sprintf("ExpressionNode(%v)\n", node.Expr))
 	return nil, nil
 }
@@ -153,6 +202,18 @@ func (p *Printer) VisitMap(node *MapNode) (interface{}, error) {
 	return nil, nil
 }
 
+func (p *Printer) VisitRaw(node *RawNode) (interface{}, error) {
+	p.writeIndent()
+	p.output.WriteString(fmt.Sprintf("RawNode(%v)\n", node.Value))
+	return nil, nil
+}
+
+func (p *Printer) VisitSpread(node *SpreadNode) (interface{}, error) {
+	p.writeIndent()
+	p.output.WriteString(fmt.Sprintf("SpreadNode(%v)\n", node.Expr))
+	return nil, nil
+}
+
 func (p *Printer) VisitMultiControlFlow(node *MultiControlFlowNode) (interface{}, error) {
 	p.writeIndent()
 	p.output.WriteString("MultiControlFlowNode:\n")
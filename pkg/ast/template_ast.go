@@ -21,6 +21,10 @@ type Node interface {
 type RootNode struct {
 	Resources []Node
 	Pos       Position
+
+	// Imports are the paths of the templates pulled in with @import, nested
+	// imports included, in the order they were read
+	Imports []string
 }
 
 func (n *RootNode) Accept(visitor Visitor) (interface{}, error) {
@@ -33,13 +37,40 @@ func (n *RootNode) Position() Position {
 
 // ForLoopNode represents a for loop iteration
 type ForLoopNode struct {
-	Variable    string          // Loop variable name (e.g., "ws")
+	Variable    string          // Loop variable name (e.g., "ws"); empty when Fields is set
+	Fields      []string        // Destructured field names (e.g., {name, port}), each bound as a loop variable
 	Iterable    *dsl.Expression // Expression to iterate over
+	Product     []ForClause     // Further clauses iterated as a cartesian product, the last innermost
 	WhereClause *dsl.Expression // Optional filter condition
+	Limit       *int            // Optional maximum number of items, applied after filtering
+	Offset      int             // Number of items to skip, applied after filtering
 	Body        []Node          // Loop body nodes
 	Pos         Position
 }
 
+// ForClause is one "var in iterable" clause of a @for over the cartesian
+// product of several lists, such as "t in .spec.tiers" in
+// @for(r in .spec.regions, t in .spec.tiers)
+type ForClause struct {
+	Variable string          // Loop variable name; empty when Fields is set
+	Fields   []string        // Destructured field names
+	Iterable *dsl.Expression // Expression to iterate over, which may use the variables of earlier clauses
+}
+
+// Variables returns the names the clause binds
+func (c ForClause) Variables() []string {
+	if c.Fields != nil {
+		return c.Fields
+	}
+	return []string{c.Variable}
+}
+
+// Clauses returns every clause of the loop, starting with its own variable and iterable
+func (n *ForLoopNode) Clauses() []ForClause {
+	first := ForClause{Variable: n.Variable, Fields: n.Fields, Iterable: n.Iterable}
+	return append([]ForClause{first}, n.Product...)
+}
+
 func (n *ForLoopNode) Accept(visitor Visitor) (interface{}, error) {
 	return visitor.VisitForLoop(n)
 }
@@ -64,6 +95,45 @@ func (n *ConditionalNode) Position() Position {
 	return n.Pos
 }
 
+// SwitchNode represents a multi-way branch on a single value
+type SwitchNode struct {
+	Value   *dsl.Expression // Expression whose value selects the case
+	Cases   []*SwitchCase   // Cases sorted by their @case key; at most one may match
+	Default []Node          // Optional nodes to execute when no case matches
+	Pos     Position
+}
+
+// SwitchCase represents a single @case(...) branch of a switch
+type SwitchCase struct {
+	Value *dsl.Expression // Value to compare against the switch value
+	Body  []Node          // Nodes to execute when the case matches
+}
+
+func (n *SwitchNode) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitSwitch(n)
+}
+
+func (n *SwitchNode) Position() Position {
+	return n.Pos
+}
+
+// ConditionalFieldNode represents a single map field that is only emitted when
+// its condition holds, written as `field: {"@when(cond)": value}`
+type ConditionalFieldNode struct {
+	Key       string          // Field key
+	Condition *dsl.Expression // Condition expression
+	Value     Node            // Field value emitted when the condition is true
+	Pos       Position
+}
+
+func (n *ConditionalFieldNode) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitConditionalField(n)
+}
+
+func (n *ConditionalFieldNode) Position() Position {
+	return n.Pos
+}
+
 // ResourceNode represents a Kubernetes resource
 type ResourceNode struct {
 	Fields map[string]Node // Resource fields (apiVersion, kind, metadata, spec, etc.)
@@ -95,8 +165,9 @@ func (n *FieldNode) Position() Position {
 
 // ExpressionNode wraps a DSL expression for evaluation
 type ExpressionNode struct {
-	Expr *dsl.Expression // The expression to evaluate
-	Pos  Position
+	Expr   *dsl.Expression // The expression to evaluate
+	Coerce string          // Type the result is converted to with dsl.Coerce, from @int(...) and the like; empty for @expr
+	Pos    Position
 }
 
 func (n *ExpressionNode) Accept(visitor Visitor) (interface{}, error) {
@@ -137,8 +208,9 @@ func (n *ArrayNode) Position() Position {
 
 // MapNode represents a map of key-value pairs
 type MapNode struct {
-	Fields map[string]Node // Map fields
-	Pos    Position
+	Fields  map[string]Node            // Map fields
+	KeyExpr map[string]*dsl.Expression // Expressions of @expr(...) keys in Fields, evaluated to the actual key
+	Pos     Position
 }
 
 func (n *MapNode) Accept(visitor Visitor) (interface{}, error) {
@@ -149,10 +221,39 @@ func (n *MapNode) Position() Position {
 	return n.Pos
 }
 
+// RawNode represents an @raw subtree that is emitted without any DSL processing
+type RawNode struct {
+	Value interface{} // The subtree exactly as written in the template
+	Pos   Position
+}
+
+func (n *RawNode) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitRaw(n)
+}
+
+func (n *RawNode) Position() Position {
+	return n.Pos
+}
+
+// SpreadNode represents an @spread(...) list item whose list value is
+// flattened into the enclosing list
+type SpreadNode struct {
+	Expr *dsl.Expression // Expression that evaluates to a list
+	Pos  Position
+}
+
+func (n *SpreadNode) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitSpread(n)
+}
+
+func (n *SpreadNode) Position() Position {
+	return n.Pos
+}
+
 // MultiControlFlowNode represents multiple control flow nodes at the same level
-// This is used when a map contains only @for and/or @if keys
+// This is used when a map contains only @for, @if and/or @switch keys
 type MultiControlFlowNode struct {
-	Nodes []Node // The control flow nodes to execute
+	Nodes []Node // The control flow nodes to execute, in key order
 	Pos   Position
 }
 
@@ -2,16 +2,27 @@ package ast
 
 import (
 	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
+	"sigs.k8s.io/yaml"
 )
 
 // Parser parses YAML data into an AST
 type Parser struct {
 	currentFile string
 	currentLine int
+	baseDir     string   // Directory @import paths are resolved against
+	importChain []string // Absolute paths of templates currently being imported
+	fsys        fs.FS    // Filesystem imports are read from; nil reads from disk
+	loopVars    []string // Variables of the enclosing @for loops
+	inList      bool     // Whether the nearest enclosing value is a list inside a resource
 }
 
 // NewParser creates a new template parser
@@ -29,6 +40,33 @@ func ParseTemplate(yamlData interface{}) (*RootNode, error) {
 	return parser.parseRoot(yamlData)
 }
 
+// ParseTemplateFile parses the "resources" field of the template loaded from path.
+// @import directives are resolved relative to the directory of path.
+func ParseTemplateFile(yamlData interface{}, path string) (*RootNode, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve template path: %w", err)
+	}
+
+	parser := NewParser()
+	parser.currentFile = path
+	parser.baseDir = filepath.Dir(path)
+	parser.importChain = []string{absPath}
+	return parser.parseRoot(yamlData)
+}
+
+// ParseTemplateFS is like ParseTemplateFile for a template at path in fsys,
+// such as an embed.FS. @import directives are read from fsys relative to
+// the directory of path.
+func ParseTemplateFS(yamlData interface{}, fsys fs.FS, name string) (*RootNode, error) {
+	parser := NewParser()
+	parser.currentFile = name
+	parser.baseDir = path.Dir(name)
+	parser.importChain = []string{path.Clean(name)}
+	parser.fsys = fsys
+	return parser.parseRoot(yamlData)
+}
+
 // parseRoot parses the root resources node
 func (p *Parser) parseRoot(data interface{}) (*RootNode, error) {
 	root := &RootNode{
@@ -41,10 +79,32 @@ func (p *Parser) parseRoot(data interface{}) (*RootNode, error) {
 	case []interface{}:
 		// Array of resources
 		for _, item := range v {
+			// @import pulls another template's resources into this list
+			if directive, ok := item.(string); ok && strings.HasPrefix(directive, "@import(") {
+				imported, err := p.parseImport(directive)
+				if err != nil {
+					return nil, err
+				}
+				root.Resources = append(root.Resources, imported.Resources...)
+				root.Imports = append(root.Imports, imported.Imports...)
+				continue
+			}
+
+			// @else supplies the resources of the preceding @if when it is false
+			if body, ok := elseBody(item); ok {
+				if err := p.parseElse(root.Resources, body); err != nil {
+					return nil, err
+				}
+				continue
+			}
+
 			node, err := p.parseNode(item)
 			if err != nil {
 				return nil, err
 			}
+			if _, ok := node.(*RawNode); ok {
+				return nil, fmt.Errorf("@raw is only allowed inside a resource")
+			}
 			root.Resources = append(root.Resources, node)
 		}
 	case map[string]interface{}:
@@ -61,18 +121,135 @@ func (p *Parser) parseRoot(data interface{}) (*RootNode, error) {
 	return root, nil
 }
 
+// parseImport loads the template named by an @import("file.yaml") directive and
+// returns its parsed root, which lists the imported files
+func (p *Parser) parseImport(directive string) (*RootNode, error) {
+	if !strings.HasSuffix(directive, ")") {
+		return nil, fmt.Errorf("invalid @import syntax: %s", directive)
+	}
+
+	// Remove @import( prefix, ) suffix and optional quotes
+	name := strings.TrimSpace(directive[len("@import(") : len(directive)-1])
+	name = strings.Trim(name, "\"'")
+	if name == "" {
+		return nil, fmt.Errorf("invalid @import syntax: %s", directive)
+	}
+
+	importPath, absPath, err := p.resolveImport(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve import %s: %w", name, err)
+	}
+
+	// Guard against import cycles
+	for _, imported := range p.importChain {
+		if imported == absPath {
+			chain := append(append([]string{}, p.importChain...), absPath)
+			return nil, fmt.Errorf("import cycle detected: %s", strings.Join(chain, " -> "))
+		}
+	}
+
+	var data []byte
+	if p.fsys != nil {
+		data, err = fs.ReadFile(p.fsys, importPath)
+	} else {
+		data, err = ioutil.ReadFile(importPath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read import %s: %w", name, err)
+	}
+
+	var template struct {
+		Resources interface{} `json:"resources"`
+	}
+	if err := yaml.Unmarshal(data, &template); err != nil {
+		return nil, fmt.Errorf("failed to parse import %s: %w", name, err)
+	}
+
+	importParser := &Parser{
+		currentFile: importPath,
+		baseDir:     p.dir(importPath),
+		importChain: append(append([]string{}, p.importChain...), absPath),
+		fsys:        p.fsys,
+	}
+	root, err := importParser.parseRoot(template.Resources)
+	if err != nil {
+		return nil, fmt.Errorf("import %s: %w", name, err)
+	}
+
+	root.Imports = append([]string{importPath}, root.Imports...)
+	return root, nil
+}
+
+// resolveImport returns the path an @import name is read from and the key
+// identifying it in the import chain. Paths in a filesystem are slash
+// separated and relative to its root.
+func (p *Parser) resolveImport(name string) (string, string, error) {
+	if p.fsys != nil {
+		importPath := path.Join(p.baseDir, name)
+		if !fs.ValidPath(importPath) {
+			return "", "", fmt.Errorf("path %s is outside the template filesystem", importPath)
+		}
+		return importPath, importPath, nil
+	}
+
+	importPath := name
+	if !filepath.IsAbs(importPath) {
+		importPath = filepath.Join(p.baseDir, importPath)
+	}
+	absPath, err := filepath.Abs(importPath)
+	if err != nil {
+		return "", "", err
+	}
+	return importPath, absPath, nil
+}
+
+// dir returns the directory of a template path
+func (p *Parser) dir(name string) string {
+	if p.fsys != nil {
+		return path.Dir(name)
+	}
+	return filepath.Dir(name)
+}
+
 // parseNode parses any node in the AST
 func (p *Parser) parseNode(data interface{}) (Node, error) {
 	switch v := data.(type) {
 	case string:
+		if strings.HasPrefix(v, "@import(") {
+			return nil, fmt.Errorf("@import is only allowed as an item of the top-level resources list: %s", v)
+		}
+		if strings.HasPrefix(v, "@spread(") {
+			return nil, fmt.Errorf("@spread is only allowed as an item of a list inside a resource: %s", v)
+		}
 		// Check if it's an @expr(...) expression
 		if strings.HasPrefix(v, "@expr(") && strings.HasSuffix(v, ")") {
 			return p.parseExpressionNode(v)
 		}
+		// @int(...), @float(...), @bool(...) and @string(...) convert their result
+		for _, typeName := range coercionTypes {
+			if strings.HasPrefix(v, "@"+typeName+"(") && strings.HasSuffix(v, ")") {
+				return p.parseCoercionNode(v, typeName)
+			}
+		}
 		// Otherwise, it's a literal string
 		return &LiteralNode{Value: v, Pos: p.currentPos()}, nil
 
 	case map[string]interface{}:
+		// @raw passes its subtree through without any DSL processing
+		if raw, ok := v["@raw"]; ok {
+			if len(v) != 1 {
+				return nil, fmt.Errorf("@raw must be the only key in its map")
+			}
+			return &RawNode{Value: raw, Pos: p.currentPos()}, nil
+		}
+
+		// @else is only valid as a list item directly after an @if item
+		for _, key := range []string{"@else", "@else:"} {
+			if _, ok := v[key]; ok {
+				return nil, fmt.Errorf("@else must directly follow an @if item in the same list")
+			}
+		}
+
 		// Count control flow keys and regular keys
 		controlFlowCount := 0
 		regularKeyCount := 0
@@ -80,7 +257,7 @@ func (p *Parser) parseNode(data interface{}) (Node, error) {
 		var singleControlValue interface{}
 
 		for key, value := range v {
-			if strings.HasPrefix(key, "@for(") || strings.HasPrefix(key, "@if(") {
+			if strings.HasPrefix(key, "@for(") || strings.HasPrefix(key, "@if(") || strings.HasPrefix(key, "@switch(") {
 				controlFlowCount++
 				singleControlKey = key
 				singleControlValue = value
@@ -91,9 +268,17 @@ func (p *Parser) parseNode(data interface{}) (Node, error) {
 
 		// If we have MULTIPLE control flow keys AND no regular keys, treat as a special container
 		if controlFlowCount > 1 && regularKeyCount == 0 {
-			// Parse all control flow nodes and return a container
+			// Parse all control flow nodes and return a container. Keys are
+			// sorted so the resources are generated in a stable order.
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+
 			nodes := []Node{}
-			for key, value := range v {
+			for _, key := range keys {
+				value := v[key]
 				if strings.HasPrefix(key, "@for(") {
 					node, err := p.parseForLoop(key, value)
 					if err != nil {
@@ -106,6 +291,12 @@ func (p *Parser) parseNode(data interface{}) (Node, error) {
 						return nil, err
 					}
 					nodes = append(nodes, node)
+				} else if strings.HasPrefix(key, "@switch(") {
+					node, err := p.parseSwitch(key, value)
+					if err != nil {
+						return nil, err
+					}
+					nodes = append(nodes, node)
 				}
 			}
 			// Return a special container node that will execute all control flows
@@ -123,6 +314,9 @@ func (p *Parser) parseNode(data interface{}) (Node, error) {
 			if strings.HasPrefix(singleControlKey, "@if(") {
 				return p.parseConditional(singleControlKey, singleControlValue)
 			}
+			if strings.HasPrefix(singleControlKey, "@switch(") {
+				return p.parseSwitch(singleControlKey, singleControlValue)
+			}
 		}
 
 		// Regular map node (includes maps with control flow keys mixed with regular keys)
@@ -159,22 +353,32 @@ func (p *Parser) parseForLoop(key string, value interface{}) (*ForLoopNode, erro
 		exprStr = exprStr[:len(exprStr)-1]
 	}
 
-	// Parse the for loop expression (e.g., "ws in .spec.webservices where ws.disabled != true")
-	varName, iterPath, filterExpr, err := dsl.ParseForLoopWithFilter(exprStr)
+	// Parse the for loop expression (e.g., "ws in .spec.webservices where ws.disabled != true limit 5"),
+	// which may have several clauses iterated as a cartesian product
+	loopClauses, filterExpr, limit, offset, err := dsl.ParseForLoopClauses(exprStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse for loop expression: %w", err)
 	}
 
-	// Parse the iterable expression
-	iterExpr, err := dsl.ParseExpression(iterPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse iterable expression: %w", err)
+	// The variables of each clause are in scope for later clauses, the where
+	// clause and the body
+	outerLoopVars := p.loopVars
+	defer func() { p.loopVars = outerLoopVars }()
+
+	var clauses []ForClause
+	for _, loopClause := range loopClauses {
+		clause, err := p.parseForClause(loopClause)
+		if err != nil {
+			return nil, err
+		}
+		clauses = append(clauses, clause)
+		p.loopVars = append(append([]string{}, p.loopVars...), clause.Variables()...)
 	}
 
 	// Parse the where clause if present
 	var whereExpr *dsl.Expression
 	if filterExpr != "" {
-		whereExpr, err = dsl.ParseExpression(filterExpr)
+		whereExpr, err = p.parseExpression(filterExpr)
 		if err != nil {
 			return nil, fmt.Errorf("failed to parse where clause: %w", err)
 		}
@@ -184,12 +388,9 @@ func (p *Parser) parseForLoop(key string, value interface{}) (*ForLoopNode, erro
 	var body []Node
 	switch bodyValue := value.(type) {
 	case []interface{}:
-		for _, item := range bodyValue {
-			node, err := p.parseNode(item)
-			if err != nil {
-				return nil, err
-			}
-			body = append(body, node)
+		body, err = p.parseItems(bodyValue)
+		if err != nil {
+			return nil, err
 		}
 	case map[string]interface{}:
 		node, err := p.parseNode(bodyValue)
@@ -201,13 +402,42 @@ func (p *Parser) parseForLoop(key string, value interface{}) (*ForLoopNode, erro
 		return nil, fmt.Errorf("invalid for loop body type: %T", value)
 	}
 
-	return &ForLoopNode{
-		Variable:    varName,
-		Iterable:    iterExpr,
+	node := &ForLoopNode{
+		Variable:    clauses[0].Variable,
+		Fields:      clauses[0].Fields,
+		Iterable:    clauses[0].Iterable,
 		WhereClause: whereExpr,
+		Limit:       limit,
+		Offset:      offset,
 		Body:        body,
 		Pos:         p.currentPos(),
-	}, nil
+	}
+	if len(clauses) > 1 {
+		node.Product = clauses[1:]
+	}
+	return node, nil
+}
+
+// parseForClause parses the variable and iterable of one clause of a @for
+func (p *Parser) parseForClause(clause dsl.ForClause) (ForClause, error) {
+	// Parse the iterable expression
+	iterExpr, err := p.parseExpression(clause.IterPath)
+	if err != nil {
+		return ForClause{}, fmt.Errorf("failed to parse iterable expression: %w", err)
+	}
+	if err := checkIterable(iterExpr, clause.IterPath); err != nil {
+		return ForClause{}, err
+	}
+
+	// A destructure list such as {name, port} binds each field as a loop variable
+	fields, err := dsl.ParseDestructure(clause.VarName)
+	if err != nil {
+		return ForClause{}, err
+	}
+	if fields != nil {
+		return ForClause{Fields: fields, Iterable: iterExpr}, nil
+	}
+	return ForClause{Variable: clause.VarName, Iterable: iterExpr}, nil
 }
 
 // parseConditional parses a @if(...) control structure
@@ -226,38 +456,296 @@ func (p *Parser) parseConditional(key string, value interface{}) (*ConditionalNo
 	}
 
 	// Parse the condition expression
-	condExpr, err := dsl.ParseExpression(exprStr)
+	condExpr, err := p.parseExpression(exprStr)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse condition expression: %w", err)
 	}
+	if err := checkCondition(condExpr, exprStr); err != nil {
+		return nil, err
+	}
 
 	// Parse the then branch
-	var thenBranch []Node
-	switch thenValue := value.(type) {
+	thenBranch, err := p.parseBranch("if", value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConditionalNode{
+		Condition:  condExpr,
+		ThenBranch: thenBranch,
+		ElseBranch: []Node{}, // Set by a following @else item in a list
+		Pos:        p.currentPos(),
+	}, nil
+}
+
+// parseBranch parses the body of an @if or @else: a list of nodes or a single map
+func (p *Parser) parseBranch(kind string, value interface{}) ([]Node, error) {
+	var branch []Node
+	switch v := value.(type) {
 	case []interface{}:
-		for _, item := range thenValue {
-			node, err := p.parseNode(item)
+		var err error
+		branch, err = p.parseItems(v)
+		if err != nil {
+			return nil, err
+		}
+	case map[string]interface{}:
+		node, err := p.parseNode(v)
+		if err != nil {
+			return nil, err
+		}
+		branch = append(branch, node)
+	default:
+		return nil, fmt.Errorf("invalid %s branch type: %T", kind, value)
+	}
+	return branch, nil
+}
+
+// elseBody returns the body of a list item written as a map holding only an
+// @else key
+func elseBody(item interface{}) (interface{}, bool) {
+	m, ok := item.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	for key, body := range m {
+		if key == "@else" || key == "@else:" {
+			return body, true
+		}
+	}
+	return nil, false
+}
+
+// parseElse parses an @else list item into the else branch of the @if item
+// before it in nodes
+func (p *Parser) parseElse(nodes []Node, body interface{}) error {
+	var conditional *ConditionalNode
+	if len(nodes) > 0 {
+		conditional, _ = nodes[len(nodes)-1].(*ConditionalNode)
+	}
+	if conditional == nil || len(conditional.ElseBranch) > 0 {
+		return fmt.Errorf("@else must directly follow an @if item in the same list")
+	}
+
+	branch, err := p.parseBranch("else", body)
+	if err != nil {
+		return err
+	}
+	if len(branch) == 0 {
+		return fmt.Errorf("@else must not be empty")
+	}
+	conditional.ElseBranch = branch
+	return nil
+}
+
+// parseItems parses the items of a list or of an @if, @else, @case or @for
+// body list, pairing each @else item with the @if item before it. @spread
+// items are allowed when the items end up in a list inside a resource.
+func (p *Parser) parseItems(items []interface{}) ([]Node, error) {
+	var nodes []Node
+	for _, item := range items {
+		// @spread flattens a list-valued expression into the enclosing list
+		if directive, ok := item.(string); ok && p.inList && strings.HasPrefix(directive, "@spread(") {
+			node, err := p.parseSpreadNode(directive)
 			if err != nil {
 				return nil, err
 			}
-			thenBranch = append(thenBranch, node)
+			nodes = append(nodes, node)
+			continue
 		}
-	case map[string]interface{}:
-		node, err := p.parseNode(thenValue)
+
+		if body, ok := elseBody(item); ok {
+			if err := p.parseElse(nodes, body); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		node, err := p.parseNode(item)
 		if err != nil {
 			return nil, err
 		}
-		thenBranch = append(thenBranch, node)
-	default:
-		return nil, fmt.Errorf("invalid if branch type: %T", value)
+		nodes = append(nodes, node)
 	}
+	return nodes, nil
+}
 
-	return &ConditionalNode{
-		Condition:  condExpr,
-		ThenBranch: thenBranch,
-		ElseBranch: []Node{}, // TODO: Support else branches
-		Pos:        p.currentPos(),
-	}, nil
+// parseExpression parses a DSL expression and checks that every bare
+// identifier in it refers to a variable that is in scope
+func (p *Parser) parseExpression(raw string) (*dsl.Expression, error) {
+	expr, err := dsl.ParseExpression(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range rootIdentifiers(expr) {
+		if !p.isVariable(name) {
+			return nil, fmt.Errorf("unknown variable '%s' in %s: paths must start with '.' and string literals must be quoted (e.g. \"%s\")", name, strings.TrimSpace(raw), name)
+		}
+	}
+
+	return expr, nil
+}
+
+// isVariable reports whether name is an enclosing loop variable or $values
+func (p *Parser) isVariable(name string) bool {
+	if name == ValuesKey {
+		return true
+	}
+	for _, v := range p.loopVars {
+		if v == name {
+			return true
+		}
+	}
+	return false
+}
+
+// rootIdentifiers returns the root names of the paths in expr that do not
+// start with '.', such as item in item.name
+func rootIdentifiers(expr *dsl.Expression) []string {
+	if expr == nil {
+		return nil
+	}
+
+	var names []string
+	switch expr.Type {
+	case dsl.ExprPath, dsl.ExprArrayIndex:
+		if expr.Path != "" && !strings.HasPrefix(expr.Path, ".") {
+			names = append(names, strings.FieldsFunc(expr.Path, func(r rune) bool { return r == '.' || r == '[' })[0])
+		}
+		names = append(names, rootIdentifiers(expr.Index)...)
+	case dsl.ExprFunction:
+		// Function arguments are kept as source and parsed on evaluation
+		for _, arg := range expr.Args {
+			if argExpr, err := dsl.ParseExpression(arg); err == nil {
+				names = append(names, rootIdentifiers(argExpr)...)
+			}
+		}
+	case dsl.ExprBinary:
+		names = append(names, rootIdentifiers(expr.Left)...)
+		names = append(names, rootIdentifiers(expr.Right)...)
+	case dsl.ExprConcat, dsl.ExprArrayLiteral, dsl.ExprMapLiteral:
+		for _, element := range expr.Elements {
+			names = append(names, rootIdentifiers(element)...)
+		}
+	case dsl.ExprUnary:
+		names = append(names, rootIdentifiers(expr.Operand)...)
+	case dsl.ExprResourceRef:
+		names = append(names, rootIdentifiers(expr.ResourceRef.Name)...)
+		names = append(names, rootIdentifiers(expr.ResourceRef.Namespace)...)
+	}
+	return names
+}
+
+// checkIterable rejects @for iterables that can never evaluate to an array
+func checkIterable(expr *dsl.Expression, raw string) error {
+	if expr.Type == dsl.ExprLiteral {
+		return fmt.Errorf("invalid @for iterable %s: expected a path or expression, got a literal", strings.TrimSpace(raw))
+	}
+	return nil
+}
+
+// checkCondition rejects @if conditions that are bare string literals
+func checkCondition(expr *dsl.Expression, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if expr.Type == dsl.ExprLiteral && (strings.HasPrefix(raw, "\"") || strings.HasPrefix(raw, "'")) {
+		return fmt.Errorf("invalid @if condition %s: a string literal is always true", raw)
+	}
+	return nil
+}
+
+// parseSwitch parses a @switch(...) control structure with @case(...) and @default children
+func (p *Parser) parseSwitch(key string, value interface{}) (*SwitchNode, error) {
+	if !strings.HasPrefix(key, "@switch(") || !strings.HasSuffix(key, ")") && !strings.HasSuffix(key, "):") {
+		return nil, fmt.Errorf("invalid @switch syntax: %s", key)
+	}
+
+	// Remove @switch( prefix and ) or ): suffix
+	exprStr := key[8:] // Remove "@switch("
+	if strings.HasSuffix(exprStr, "):") {
+		exprStr = exprStr[:len(exprStr)-2]
+	} else if strings.HasSuffix(exprStr, ")") {
+		exprStr = exprStr[:len(exprStr)-1]
+	}
+
+	valueExpr, err := p.parseExpression(exprStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse switch expression: %w", err)
+	}
+
+	branches, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid switch body type: %T (expected @case/@default keys)", value)
+	}
+
+	// YAML maps are unordered, so the order cases are written in is lost.
+	// Cases are sorted by key, and since at most one case may match, the
+	// order never decides which branch is taken.
+	keys := make([]string, 0, len(branches))
+	for k := range branches {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	node := &SwitchNode{
+		Value: valueExpr,
+		Pos:   p.currentPos(),
+	}
+	literalCases := map[string]string{} // Value of each literal case to its key
+
+	for _, k := range keys {
+		body, err := p.parseSwitchBody(branches[k])
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case k == "@default" || k == "@default:":
+			node.Default = body
+		case strings.HasPrefix(k, "@case(") && (strings.HasSuffix(k, ")") || strings.HasSuffix(k, "):")):
+			caseStr := strings.TrimSuffix(strings.TrimSuffix(k[6:], ":"), ")")
+			caseExpr, err := p.parseExpression(caseStr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse case expression: %w", err)
+			}
+			if caseExpr.Type == dsl.ExprLiteral {
+				if value, err := NewEvaluator(nil).evaluateExpression(caseExpr); err == nil {
+					literal := fmt.Sprintf("%v", value)
+					if other, ok := literalCases[literal]; ok {
+						return nil, fmt.Errorf("@switch cases %s and %s both match %q", other, k, literal)
+					}
+					literalCases[literal] = k
+				}
+			}
+			node.Cases = append(node.Cases, &SwitchCase{
+				Value: caseExpr,
+				Body:  body,
+			})
+		default:
+			return nil, fmt.Errorf("invalid key in @switch: %s (expected @case(...) or @default)", k)
+		}
+	}
+
+	return node, nil
+}
+
+// parseSwitchBody parses the body of a @case or @default branch
+func (p *Parser) parseSwitchBody(value interface{}) ([]Node, error) {
+	var body []Node
+	switch bodyValue := value.(type) {
+	case []interface{}:
+		var err error
+		body, err = p.parseItems(bodyValue)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		node, err := p.parseNode(bodyValue)
+		if err != nil {
+			return nil, err
+		}
+		body = append(body, node)
+	}
+	return body, nil
 }
 
 // parseExpressionNode parses an @expr(...) expression
@@ -270,7 +758,7 @@ func (p *Parser) parseExpressionNode(exprStr string) (*ExpressionNode, error) {
 	inner := exprStr[6 : len(exprStr)-1] // Remove "@expr(" and ")"
 
 	// Parse the expression
-	expr, err := dsl.ParseExpression(inner)
+	expr, err := p.parseExpression(inner)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse expression: %w", err)
 	}
@@ -281,11 +769,68 @@ func (p *Parser) parseExpressionNode(exprStr string) (*ExpressionNode, error) {
 	}, nil
 }
 
+// coercionTypes are the types a value can be converted to with @<type>(...)
+var coercionTypes = []string{"int", "float", "bool", "string"}
+
+// parseCoercionNode parses an @int(...), @float(...), @bool(...) or
+// @string(...) expression, whose result is converted to the named type
+func (p *Parser) parseCoercionNode(exprStr, typeName string) (*ExpressionNode, error) {
+	inner := exprStr[len(typeName)+2 : len(exprStr)-1] // Remove "@<type>(" and ")"
+
+	expr, err := p.parseExpression(inner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse @%s expression: %w", typeName, err)
+	}
+
+	return &ExpressionNode{
+		Expr:   expr,
+		Coerce: typeName,
+		Pos:    p.currentPos(),
+	}, nil
+}
+
+// parseSpreadNode parses an @spread(...) list item
+func (p *Parser) parseSpreadNode(spreadStr string) (*SpreadNode, error) {
+	if !strings.HasSuffix(spreadStr, ")") {
+		return nil, fmt.Errorf("invalid @spread syntax: %s", spreadStr)
+	}
+
+	inner := spreadStr[8 : len(spreadStr)-1] // Remove "@spread(" and ")"
+
+	expr, err := p.parseExpression(inner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse @spread expression: %w", err)
+	}
+
+	return &SpreadNode{
+		Expr: expr,
+		Pos:  p.currentPos(),
+	}, nil
+}
+
 // parseMapNode parses a regular map (not a control structure)
 func (p *Parser) parseMapNode(data map[string]interface{}) (*MapNode, error) {
+	// Items of control structures in the map are merged into it, not a list
+	outerInList := p.inList
+	p.inList = false
+	defer func() { p.inList = outerInList }()
+
 	fields := make(map[string]Node)
+	var keyExpr map[string]*dsl.Expression
 
 	for key, value := range data {
+		// A key written as @expr(...) is evaluated to the actual key
+		if strings.HasPrefix(key, "@expr(") && strings.HasSuffix(key, ")") {
+			expr, err := p.parseExpression(key[6 : len(key)-1])
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse key %s: %w", key, err)
+			}
+			if keyExpr == nil {
+				keyExpr = make(map[string]*dsl.Expression)
+			}
+			keyExpr[key] = expr
+		}
+
 		// Check if the key itself is a control structure
 		if strings.HasPrefix(key, "@for(") {
 			// This is a for loop that should add fields to the parent map
@@ -307,6 +852,25 @@ func (p *Parser) parseMapNode(data map[string]interface{}) (*MapNode, error) {
 			fields[key] = ifNode
 			continue
 		}
+		if strings.HasPrefix(key, "@switch(") {
+			// A switch merges the matching branch into the parent map
+			switchNode, err := p.parseSwitch(key, value)
+			if err != nil {
+				return nil, err
+			}
+			fields[key] = switchNode
+			continue
+		}
+
+		// Field whose value is a single @when(...) key is emitted only when the condition holds
+		if condKey, condValue, ok := conditionalFieldValue(value); ok {
+			fieldNode, err := p.parseConditionalField(key, condKey, condValue)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse field %s: %w", key, err)
+			}
+			fields[key] = fieldNode
+			continue
+		}
 
 		// Regular field
 		node, err := p.parseNode(value)
@@ -317,21 +881,75 @@ func (p *Parser) parseMapNode(data map[string]interface{}) (*MapNode, error) {
 	}
 
 	return &MapNode{
-		Fields: fields,
-		Pos:    p.currentPos(),
+		Fields:  fields,
+		KeyExpr: keyExpr,
+		Pos:     p.currentPos(),
+	}, nil
+}
+
+// conditionalFieldValue reports whether a field value is a map holding exactly
+// one @when(...) key. A single @if(...) key is left to parseConditional, which
+// merges its body into the enclosing map as it always has.
+func conditionalFieldValue(value interface{}) (string, interface{}, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return "", nil, false
+	}
+	for key, body := range m {
+		if strings.HasPrefix(key, "@when(") {
+			return key, body, true
+		}
+	}
+	return "", nil, false
+}
+
+// parseConditionalField parses a field whose value is guarded by a @when(...) key
+func (p *Parser) parseConditionalField(fieldKey, condKey string, value interface{}) (*ConditionalFieldNode, error) {
+	if !strings.HasSuffix(condKey, ")") && !strings.HasSuffix(condKey, "):") {
+		return nil, fmt.Errorf("invalid @when syntax: %s", condKey)
+	}
+
+	// Remove @when( prefix and ) or ): suffix
+	exprStr := condKey[6:]
+	if strings.HasSuffix(exprStr, "):") {
+		exprStr = exprStr[:len(exprStr)-2]
+	} else {
+		exprStr = exprStr[:len(exprStr)-1]
+	}
+
+	condExpr, err := p.parseExpression(exprStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse condition expression: %w", err)
+	}
+	if err := checkCondition(condExpr, exprStr); err != nil {
+		return nil, err
+	}
+
+	valueNode, err := p.parseNode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConditionalFieldNode{
+		Key:       fieldKey,
+		Condition: condExpr,
+		Value:     valueNode,
+		Pos:       p.currentPos(),
 	}, nil
 }
 
 // parseArrayNode parses an array
 func (p *Parser) parseArrayNode(data []interface{}) (*ArrayNode, error) {
-	elements := make([]Node, 0, len(data))
+	outerInList := p.inList
+	p.inList = true
+	defer func() { p.inList = outerInList }()
 
-	for _, item := range data {
-		node, err := p.parseNode(item)
-		if err != nil {
-			return nil, err
-		}
-		elements = append(elements, node)
+	elements, err := p.parseItems(data)
+	if err != nil {
+		return nil, err
+	}
+	if elements == nil {
+		elements = []Node{}
 	}
 
 	return &ArrayNode{
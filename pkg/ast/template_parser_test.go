@@ -1,10 +1,18 @@
 package ast
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 
 	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
+	"sigs.k8s.io/yaml"
 )
 
 func TestParseSimpleTemplate(t *testing.T) {
@@ -186,6 +194,366 @@ func TestEvaluateForLoop(t *testing.T) {
 	}
 }
 
+func TestEvaluateForLoopOverList(t *testing.T) {
+	template := map[string]interface{}{
+		"@for(host in list(.spec.hosts))": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": "@expr(host)",
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		hosts interface{}
+		want  []string
+	}{
+		{name: "scalar", hosts: "a.example.com", want: []string{"a.example.com"}},
+		{name: "array", hosts: []interface{}{"a.example.com", "b.example.com"}, want: []string{"a.example.com", "b.example.com"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"spec": map[string]interface{}{"hosts": tt.hosts},
+			}
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			var names []string
+			for _, resource := range resources {
+				names = append(names, resource["metadata"].(map[string]interface{})["name"].(string))
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Expected names %v, got %v", tt.want, names)
+			}
+		})
+	}
+}
+
+func TestEvaluateTraceInLoops(t *testing.T) {
+	template := map[string]interface{}{
+		"@for(host in .spec.hosts)": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": "@expr(upper(host))",
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{"hosts": []interface{}{"a", "b"}},
+	}
+	var trace []string
+	evaluator := NewEvaluator(instance)
+	evaluator.SetTrace(func(expr string, result interface{}, err error) {
+		trace = append(trace, fmt.Sprintf("%s -> %v", expr, result))
+	})
+	if _, err := evaluator.Evaluate(root); err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	for _, want := range []string{"upper(host) -> A", "upper(host) -> B"} {
+		found := false
+		for _, line := range trace {
+			if line == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected trace to contain %q, got %v", want, trace)
+		}
+	}
+}
+
+func TestEvaluateMaxDepth(t *testing.T) {
+	// A ConfigMap whose data nests 150 maps deep
+	var nested interface{} = "leaf"
+	for i := 0; i < 150; i++ {
+		nested = map[string]interface{}{"level": nested}
+	}
+	template := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "deep"},
+		"data":       nested,
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	evaluator := NewEvaluator(map[string]interface{}{})
+	if _, err := evaluator.Evaluate(root); !errors.Is(err, dsl.ErrMaxDepthExceeded) {
+		t.Fatalf("Expected maximum depth error at the default limit, got %v", err)
+	}
+
+	evaluator = NewEvaluator(map[string]interface{}{})
+	evaluator.SetMaxDepth(200)
+	resources, err := evaluator.Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() with a raised limit error = %v", err)
+	}
+	if len(resources) != 1 {
+		t.Errorf("Expected 1 resource, got %d", len(resources))
+	}
+
+	// Nested loops count towards the limit too
+	loops := map[string]interface{}{
+		"@for(a in .items)": map[string]interface{}{
+			"@for(b in .items)": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "@expr(a + b)"},
+			},
+		},
+	}
+	root, err = ParseTemplate(loops)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	evaluator = NewEvaluator(map[string]interface{}{"items": []interface{}{"x", "y"}})
+	evaluator.SetMaxDepth(3)
+	if _, err := evaluator.Evaluate(root); !errors.Is(err, dsl.ErrMaxDepthExceeded) {
+		t.Errorf("Expected nested loops to exceed a limit of 3, got %v", err)
+	}
+}
+
+func TestEvaluateMultiControlFlow(t *testing.T) {
+	template := map[string]interface{}{
+		"@for(app in .spec.apps)": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "@expr(app)"},
+		},
+		"@for(job in .spec.jobs)": map[string]interface{}{
+			"apiVersion": "batch/v1",
+			"kind":       "Job",
+			"metadata":   map[string]interface{}{"name": "@expr(job)"},
+		},
+		"@if(.spec.shared)": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "shared"},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	if _, ok := root.Resources[0].(*MultiControlFlowNode); !ok {
+		t.Fatalf("Expected a MultiControlFlowNode, got %T", root.Resources[0])
+	}
+
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"apps":   []interface{}{"web", "api"},
+			"jobs":   []interface{}{"migrate"},
+			"shared": true,
+		},
+	}
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	// Every loop contributes its resources, in the order of the keys
+	var got []string
+	for _, resource := range resources {
+		got = append(got, resource["kind"].(string)+"/"+resource["metadata"].(map[string]interface{})["name"].(string))
+	}
+	expected := "ConfigMap/web,ConfigMap/api,Job/migrate,Secret/shared"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("Expected resources %s, got %s", expected, strings.Join(got, ","))
+	}
+
+	// A failing loop fails the whole block
+	instance["spec"].(map[string]interface{})["jobs"] = "migrate"
+	if _, err := NewEvaluator(instance).Evaluate(root); err == nil {
+		t.Error("Expected an error for a loop over a non-list")
+	}
+}
+
+func TestEvaluateElseInArray(t *testing.T) {
+	template := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "web"},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{"name": "app", "image": "app:1.0"},
+				map[string]interface{}{
+					"@if(.spec.sidecar)": map[string]interface{}{"name": "sidecar", "image": "@expr(.spec.sidecar)"},
+				},
+				map[string]interface{}{
+					"@else": map[string]interface{}{"name": "default", "image": "pause:3.9"},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate([]interface{}{template})
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     map[string]interface{}
+		expected []string
+	}{
+		{name: "condition true", spec: map[string]interface{}{"sidecar": "envoy:1.30"}, expected: []string{"app", "sidecar"}},
+		{name: "condition false", spec: map[string]interface{}{"sidecar": ""}, expected: []string{"app", "default"}},
+		{name: "condition missing", spec: map[string]interface{}{}, expected: []string{"app", "default"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := NewEvaluator(map[string]interface{}{"spec": tt.spec}).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			containers := resources[0]["spec"].(map[string]interface{})["containers"].([]interface{})
+			var names []string
+			for _, container := range containers {
+				names = append(names, container.(map[string]interface{})["name"].(string))
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("containers = %v, want %v", names, tt.expected)
+			}
+		})
+	}
+
+	// @else in the resources list picks between resources
+	root, err = ParseTemplate([]interface{}{
+		map[string]interface{}{
+			"@if(.spec.external)": map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"name": "external"}},
+		},
+		map[string]interface{}{
+			"@else": []interface{}{
+				map[string]interface{}{"apiVersion": "v1", "kind": "Service", "metadata": map[string]interface{}{"name": "internal"}},
+				map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "internal"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	resources, err := NewEvaluator(map[string]interface{}{"spec": map[string]interface{}{"external": false}}).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(resources) != 2 || resources[0]["kind"] != "Service" || resources[1]["kind"] != "ConfigMap" {
+		t.Errorf("Expected the else resources, got %v", resources)
+	}
+
+	// @else in a loop body list and in an @if branch list picks the items
+	// added for each element
+	root, err = ParseTemplate([]interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "test"},
+			"ports": []interface{}{
+				map[string]interface{}{
+					"@for(p in .spec.ports)": []interface{}{
+						map[string]interface{}{"@if(p.name)": map[string]interface{}{"name": "@expr(p.name)"}},
+						map[string]interface{}{"@else": map[string]interface{}{"name": "unnamed"}},
+					},
+				},
+			},
+			"tls": []interface{}{
+				map[string]interface{}{
+					"@if(.spec.tls)": []interface{}{
+						map[string]interface{}{"@if(.spec.tls.secret)": map[string]interface{}{"secret": "@expr(.spec.tls.secret)"}},
+						map[string]interface{}{"@else": map[string]interface{}{"secret": "generated"}},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	resources, err = NewEvaluator(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"name": "http"},
+				map[string]interface{}{"port": 9090},
+			},
+			"tls": map[string]interface{}{"enabled": true},
+		},
+	}).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	expectedPorts := []interface{}{
+		map[string]interface{}{"name": "http"},
+		map[string]interface{}{"name": "unnamed"},
+	}
+	if !reflect.DeepEqual(resources[0]["ports"], expectedPorts) {
+		t.Errorf("ports = %v, want %v", resources[0]["ports"], expectedPorts)
+	}
+	expectedTLS := []interface{}{map[string]interface{}{"secret": "generated"}}
+	if !reflect.DeepEqual(resources[0]["tls"], expectedTLS) {
+		t.Errorf("tls = %v, want %v", resources[0]["tls"], expectedTLS)
+	}
+}
+
+func TestParseElseErrors(t *testing.T) {
+	ifItem := map[string]interface{}{"@if(.a)": map[string]interface{}{"name": "a"}}
+	elseItem := map[string]interface{}{"@else": map[string]interface{}{"name": "b"}}
+
+	tests := []struct {
+		name  string
+		items []interface{}
+	}{
+		{name: "else first", items: []interface{}{elseItem}},
+		{name: "else after a regular item", items: []interface{}{map[string]interface{}{"name": "a"}, elseItem}},
+		{name: "two elses", items: []interface{}{ifItem, elseItem, elseItem}},
+		{name: "empty else", items: []interface{}{ifItem, map[string]interface{}{"@else": []interface{}{}}}},
+		{name: "scalar else", items: []interface{}{ifItem, map[string]interface{}{"@else": "b"}}},
+		{name: "else key beside if key", items: []interface{}{map[string]interface{}{"@if(.a)": map[string]interface{}{"name": "a"}, "@else": map[string]interface{}{"name": "b"}}}},
+		{name: "else key in an element", items: []interface{}{map[string]interface{}{"name": "a", "@else": map[string]interface{}{"name": "b"}}}},
+		{name: "else first in a loop body", items: []interface{}{map[string]interface{}{"@for(i in .items)": []interface{}{elseItem}}}},
+		{name: "else first in an if branch", items: []interface{}{map[string]interface{}{"@if(.a)": []interface{}{elseItem}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate([]interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata":   map[string]interface{}{"name": "test"},
+					"items":      tt.items,
+				},
+			})
+			if err == nil {
+				t.Error("Expected parse error")
+			}
+		})
+	}
+}
+
 func TestEvaluateForLoopWithWhere(t *testing.T) {
 	// Test evaluating a for loop with where clause
 	template := map[string]interface{}{
@@ -247,6 +615,105 @@ func TestEvaluateForLoopWithWhere(t *testing.T) {
 	}
 }
 
+func TestEvaluateForLoopLimitOffset(t *testing.T) {
+	var items []interface{}
+	for i := 1; i <= 8; i++ {
+		items = append(items, map[string]interface{}{
+			"name":    fmt.Sprintf("config%d", i),
+			"enabled": i%2 == 1,
+		})
+	}
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{"items": items},
+	}
+
+	tests := []struct {
+		name     string
+		loop     string
+		expected []string
+	}{
+		{
+			name:     "limit",
+			loop:     "@for(item in .spec.items limit 2)",
+			expected: []string{"config1", "config2"},
+		},
+		{
+			name:     "offset",
+			loop:     "@for(item in .spec.items offset 6)",
+			expected: []string{"config7", "config8"},
+		},
+		{
+			name:     "where with limit and offset",
+			loop:     "@for(item in .spec.items where item.enabled limit 2 offset 1)",
+			expected: []string{"config3", "config5"},
+		},
+		{
+			name:     "offset before limit",
+			loop:     "@for(item in .spec.items where item.enabled offset 1 limit 2)",
+			expected: []string{"config3", "config5"},
+		},
+		{
+			name:     "limit past the end",
+			loop:     "@for(item in .spec.items where item.enabled offset 3 limit 5)",
+			expected: []string{"config7"},
+		},
+		{
+			name:     "zero limit",
+			loop:     "@for(item in .spec.items limit 0)",
+			expected: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := map[string]interface{}{
+				tt.loop: []interface{}{
+					map[string]interface{}{
+						"apiVersion": "v1",
+						"kind":       "ConfigMap",
+						"metadata": map[string]interface{}{
+							"name": "@expr(item.name)",
+						},
+					},
+				},
+			}
+
+			root, err := ParseTemplate(template)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			var names []string
+			for _, resource := range resources {
+				names = append(names, resource["metadata"].(map[string]interface{})["name"].(string))
+			}
+			if !reflect.DeepEqual(names, tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, names)
+			}
+		})
+	}
+}
+
+func TestParseForLoopInvalidPaging(t *testing.T) {
+	for _, loop := range []string{
+		"@for(item in .spec.items limit -1)",
+		"@for(item in .spec.items offset two)",
+		"@for(item in .spec.items limit 1 limit 2)",
+	} {
+		template := map[string]interface{}{
+			loop: map[string]interface{}{"name": "@expr(item)"},
+		}
+		if _, err := ParseTemplate(template); err == nil {
+			t.Errorf("Expected error for %s", loop)
+		}
+	}
+}
+
 func TestParseConditional(t *testing.T) {
 	// Test parsing a conditional
 	template := map[string]interface{}{
@@ -310,3 +777,1845 @@ func TestPrinter(t *testing.T) {
 		t.Error("Print() output missing 'ForLoop'")
 	}
 }
+
+func TestEvaluateConditionalField(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name": "@expr(.metadata.name)",
+			},
+			"spec": map[string]interface{}{
+				"replicas": map[string]interface{}{
+					"@when(.spec.replicas)": "@expr(.spec.replicas)",
+				},
+				"nodeSelector": map[string]interface{}{
+					"@when(.spec.gpu)": map[string]interface{}{
+						"accelerator": "nvidia",
+					},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name             string
+		spec             map[string]interface{}
+		wantReplicas     bool
+		wantNodeSelector bool
+	}{
+		{
+			name:             "both fields included",
+			spec:             map[string]interface{}{"replicas": int64(3), "gpu": true},
+			wantReplicas:     true,
+			wantNodeSelector: true,
+		},
+		{
+			name:             "scalar excluded",
+			spec:             map[string]interface{}{"gpu": true},
+			wantReplicas:     false,
+			wantNodeSelector: true,
+		},
+		{
+			name:             "object excluded",
+			spec:             map[string]interface{}{"replicas": int64(3), "gpu": false},
+			wantReplicas:     true,
+			wantNodeSelector: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "my-app"},
+				"spec":     tt.spec,
+			}
+
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if len(resources) != 1 {
+				t.Fatalf("Expected 1 resource, got %d", len(resources))
+			}
+
+			spec := resources[0]["spec"].(map[string]interface{})
+
+			replicas, hasReplicas := spec["replicas"]
+			if hasReplicas != tt.wantReplicas {
+				t.Errorf("Expected replicas present=%v, got %v", tt.wantReplicas, hasReplicas)
+			}
+			if hasReplicas && replicas != int64(3) {
+				t.Errorf("Expected replicas 3, got %v", replicas)
+			}
+
+			nodeSelector, hasNodeSelector := spec["nodeSelector"]
+			if hasNodeSelector != tt.wantNodeSelector {
+				t.Errorf("Expected nodeSelector present=%v, got %v", tt.wantNodeSelector, hasNodeSelector)
+			}
+			if hasNodeSelector {
+				selector := nodeSelector.(map[string]interface{})
+				if selector["accelerator"] != "nvidia" {
+					t.Errorf("Expected accelerator 'nvidia', got '%v'", selector["accelerator"])
+				}
+			}
+
+			// The guarded fields must not leak into the parent map
+			if _, ok := spec["accelerator"]; ok {
+				t.Error("Conditional field body was merged into parent map")
+			}
+		})
+	}
+}
+
+func TestEvaluateSingleKeyIfMerges(t *testing.T) {
+	// A field holding a single @if key keeps its original meaning: the body is
+	// merged into the enclosing map and the field key itself is not emitted
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"spec": map[string]interface{}{
+				"nodeSelector": map[string]interface{}{
+					"@if(.spec.gpu)": map[string]interface{}{
+						"accelerator": "nvidia",
+					},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		gpu  bool
+		want map[string]interface{}
+	}{
+		{
+			name: "condition true",
+			gpu:  true,
+			want: map[string]interface{}{"accelerator": "nvidia"},
+		},
+		{
+			name: "condition false",
+			gpu:  false,
+			want: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"spec": map[string]interface{}{"gpu": tt.gpu},
+			}
+
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if len(resources) != 1 {
+				t.Fatalf("Expected 1 resource, got %d", len(resources))
+			}
+
+			spec := resources[0]["spec"]
+			if !reflect.DeepEqual(spec, tt.want) {
+				t.Errorf("spec = %v, want %v", spec, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSwitch(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name": "@expr(.metadata.name)",
+			},
+			"data": map[string]interface{}{
+				"@switch(.spec.tier)": map[string]interface{}{
+					`@case("gold")`: map[string]interface{}{
+						"replicas": "5",
+					},
+					`@case("silver")`: map[string]interface{}{
+						"replicas": "3",
+					},
+					"@default": map[string]interface{}{
+						"replicas": "1",
+					},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		spec     map[string]interface{}
+		expected string
+	}{
+		{
+			name:     "matching case",
+			spec:     map[string]interface{}{"tier": "gold"},
+			expected: "5",
+		},
+		{
+			name:     "second case",
+			spec:     map[string]interface{}{"tier": "silver"},
+			expected: "3",
+		},
+		{
+			name:     "default branch",
+			spec:     map[string]interface{}{"tier": "bronze"},
+			expected: "1",
+		},
+		{
+			name:     "missing value uses default",
+			spec:     map[string]interface{}{},
+			expected: "1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "my-app"},
+				"spec":     tt.spec,
+			}
+
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if len(resources) != 1 {
+				t.Fatalf("Expected 1 resource, got %d", len(resources))
+			}
+
+			data := resources[0]["data"].(map[string]interface{})
+			if data["replicas"] != tt.expected {
+				t.Errorf("Expected replicas '%s', got '%v'", tt.expected, data["replicas"])
+			}
+		})
+	}
+}
+
+func TestEvaluateSwitchWithoutDefault(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"@switch(.spec.tier)": map[string]interface{}{
+				`@case("gold")`: map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata": map[string]interface{}{
+						"name": "gold-config",
+					},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{"tier": "bronze"},
+	}
+
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(resources) != 0 {
+		t.Errorf("Expected no resources, got %d", len(resources))
+	}
+}
+
+func TestParseSwitchInvalidKey(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"@switch(.spec.tier)": map[string]interface{}{
+				"gold": map[string]interface{}{"kind": "ConfigMap"},
+			},
+		},
+	}
+
+	if _, err := ParseTemplate(template); err == nil {
+		t.Error("Expected error for non-case key in @switch")
+	}
+}
+
+func TestSwitchOverlappingCases(t *testing.T) {
+	duplicate := []interface{}{
+		map[string]interface{}{
+			"@switch(.spec.replicas)": map[string]interface{}{
+				"@case(3)":   map[string]interface{}{"kind": "ConfigMap"},
+				`@case("3")`: map[string]interface{}{"kind": "Secret"},
+			},
+		},
+	}
+	if _, err := ParseTemplate(duplicate); err == nil || !strings.Contains(err.Error(), "both match") {
+		t.Errorf("Expected error for two cases with the same value, got %v", err)
+	}
+
+	computed := []interface{}{
+		map[string]interface{}{
+			"@switch(.spec.tier)": map[string]interface{}{
+				"@case(.spec.primary)":   map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "primary"}},
+				"@case(.spec.secondary)": map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMap", "metadata": map[string]interface{}{"name": "secondary"}},
+			},
+		},
+	}
+	root, err := ParseTemplate(computed)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	resources, err := NewEvaluator(map[string]interface{}{
+		"spec": map[string]interface{}{"tier": "gold", "primary": "gold", "secondary": "silver"},
+	}).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(resources) != 1 || resources[0]["metadata"].(map[string]interface{})["name"] != "primary" {
+		t.Errorf("Expected the single matching case, got %v", resources)
+	}
+
+	_, err = NewEvaluator(map[string]interface{}{
+		"spec": map[string]interface{}{"tier": "gold", "primary": "gold", "secondary": "gold"},
+	}).Evaluate(root)
+	if err == nil || !strings.Contains(err.Error(), "matches more than one @case") {
+		t.Errorf("Expected error for a value matching several cases, got %v", err)
+	}
+}
+
+func TestEvaluateWithValues(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name": "@expr(.metadata.name)",
+			},
+			"spec": map[string]interface{}{
+				"replicas": "@expr($values.spec.replicas)",
+				"registry": "@expr($values.registry)",
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	values := map[string]interface{}{
+		"registry": "registry.example.com",
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+		},
+	}
+
+	tests := []struct {
+		name     string
+		spec     map[string]interface{}
+		expected int64
+	}{
+		{
+			name:     "default from values",
+			spec:     map[string]interface{}{},
+			expected: 2,
+		},
+		{
+			name:     "instance overrides values",
+			spec:     map[string]interface{}{"replicas": int64(5)},
+			expected: 5,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"metadata": map[string]interface{}{"name": "my-app"},
+				"spec":     tt.spec,
+			}
+
+			resources, err := NewEvaluatorWithValues(instance, values).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if len(resources) != 1 {
+				t.Fatalf("Expected 1 resource, got %d", len(resources))
+			}
+
+			spec := resources[0]["spec"].(map[string]interface{})
+			if spec["replicas"] != tt.expected {
+				t.Errorf("Expected replicas %d, got %v", tt.expected, spec["replicas"])
+			}
+			if spec["registry"] != "registry.example.com" {
+				t.Errorf("Expected registry from values, got %v", spec["registry"])
+			}
+		})
+	}
+
+	// Values must not be modified by the instance override
+	if values["spec"].(map[string]interface{})["replicas"] != int64(2) {
+		t.Error("Values map was modified during evaluation")
+	}
+}
+
+func TestParseRejectsLiteralControlFlow(t *testing.T) {
+	tests := []struct {
+		name     string
+		template []interface{}
+		wantErr  bool
+	}{
+		{
+			name: "for over string literal",
+			template: []interface{}{
+				map[string]interface{}{
+					`@for(item in "items")`: map[string]interface{}{"kind": "ConfigMap"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "for over boolean literal",
+			template: []interface{}{
+				map[string]interface{}{
+					"@for(item in true)": map[string]interface{}{"kind": "ConfigMap"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "if on string literal",
+			template: []interface{}{
+				map[string]interface{}{
+					`@if("enabled")`: map[string]interface{}{"kind": "ConfigMap"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "conditional field on string literal",
+			template: []interface{}{
+				map[string]interface{}{
+					"kind": "ConfigMap",
+					"data": map[string]interface{}{
+						`@if('yes')`: map[string]interface{}{"key": "value"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "when on string literal",
+			template: []interface{}{
+				map[string]interface{}{
+					"kind": "ConfigMap",
+					"data": map[string]interface{}{
+						`@when('yes')`: map[string]interface{}{"key": "value"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "if on boolean literal",
+			template: []interface{}{
+				map[string]interface{}{
+					"@if(false)": map[string]interface{}{"kind": "ConfigMap"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "for over path",
+			template: []interface{}{
+				map[string]interface{}{
+					"@for(item in .spec.items)": map[string]interface{}{"kind": "ConfigMap"},
+				},
+			},
+			wantErr: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseBareIdentifiers(t *testing.T) {
+	tests := []struct {
+		name     string
+		template []interface{}
+		wantErr  string
+	}{
+		{
+			name: "bare word in expr",
+			template: []interface{}{
+				map[string]interface{}{"env": "@expr(production)"},
+			},
+			wantErr: `unknown variable 'production' in production: paths must start with '.' and string literals must be quoted (e.g. "production")`,
+		},
+		{
+			name: "bare word in comparison",
+			template: []interface{}{
+				map[string]interface{}{
+					"@if(.spec.env == production)": map[string]interface{}{"kind": "ConfigMap"},
+				},
+			},
+			wantErr: "unknown variable 'production'",
+		},
+		{
+			name: "loop variable outside its loop",
+			template: []interface{}{
+				map[string]interface{}{
+					"@for(item in .spec.items)": map[string]interface{}{"kind": "ConfigMap"},
+				},
+				map[string]interface{}{"name": "@expr(item.name)"},
+			},
+			wantErr: "unknown variable 'item'",
+		},
+		{
+			name: "bare word in function argument",
+			template: []interface{}{
+				map[string]interface{}{"name": "@expr(upper(production))"},
+			},
+			wantErr: "unknown variable 'production'",
+		},
+		{
+			name: "quoted literal",
+			template: []interface{}{
+				map[string]interface{}{"env": `@expr("production")`},
+			},
+		},
+		{
+			name: "loop variables and where clause",
+			template: []interface{}{
+				map[string]interface{}{
+					"@for(item in .spec.items where item.enabled)": map[string]interface{}{
+						"@for(port in item.ports)": map[string]interface{}{
+							"name": "@expr(item.name + port.name)",
+							"port": "@expr(item.ports[0])",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "values",
+			template: []interface{}{
+				map[string]interface{}{"region": "@expr($values.region)"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate(tt.template)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ParseTemplate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ParseTemplate() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseRaw(t *testing.T) {
+	tests := []struct {
+		name     string
+		template []interface{}
+		wantErr  bool
+	}{
+		{
+			name: "raw field value",
+			template: []interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"data": map[string]interface{}{
+						"@raw": map[string]interface{}{
+							"name":                 "@expr(unknown)",
+							"@for(x in not valid)": "kept",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "raw with sibling keys",
+			template: []interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"data": map[string]interface{}{
+						"@raw":  "value",
+						"other": "value",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "raw resource",
+			template: []interface{}{
+				map[string]interface{}{"@raw": map[string]interface{}{"kind": "ConfigMap"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTemplate(tt.template)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTemplateFileWithImport(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "template-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	redis := `resources:
+  - apiVersion: apps/v1
+    kind: StatefulSet
+    metadata:
+      name: "@expr(.metadata.name + \"-redis\")"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "redis_template.yaml"), []byte(redis), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name": "@expr(.metadata.name)",
+			},
+		},
+		`@import("redis_template.yaml")`,
+	}
+
+	root, err := ParseTemplateFile(template, filepath.Join(tempDir, "database_v1.yaml"))
+	if err != nil {
+		t.Fatalf("ParseTemplateFile() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "orders"},
+	}
+
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(resources))
+	}
+
+	expected := []struct{ kind, name string }{
+		{"Service", "orders"},
+		{"StatefulSet", "orders-redis"},
+	}
+	for i, want := range expected {
+		metadata := resources[i]["metadata"].(map[string]interface{})
+		if resources[i]["kind"] != want.kind || metadata["name"] != want.name {
+			t.Errorf("Expected resource %d to be %s/%s, got %v/%v", i, want.kind, want.name, resources[i]["kind"], metadata["name"])
+		}
+	}
+}
+
+func TestParseTemplateFileImportCycle(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "template-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"a.yaml": "resources:\n  - \"@import(b.yaml)\"\n",
+		"b.yaml": "resources:\n  - \"@import(a.yaml)\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write template: %v", err)
+		}
+	}
+
+	template := []interface{}{"@import(b.yaml)"}
+	_, err = ParseTemplateFile(template, filepath.Join(tempDir, "a.yaml"))
+	if err == nil {
+		t.Fatal("Expected import cycle error")
+	}
+	if !strings.Contains(err.Error(), "import cycle detected") {
+		t.Errorf("Expected import cycle error, got: %v", err)
+	}
+}
+
+func TestParseTemplateFSWithImport(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/v1/shared/redis.yaml": &fstest.MapFile{Data: []byte(`resources:
+  - apiVersion: apps/v1
+    kind: StatefulSet
+    metadata:
+      name: "@expr(.metadata.name + \"-redis\")"
+`)},
+		"api/v1/loop.yaml": &fstest.MapFile{Data: []byte("resources:\n  - \"@import(database_v1.yaml)\"\n")},
+	}
+
+	template := []interface{}{`@import("shared/redis.yaml")`}
+	root, err := ParseTemplateFS(template, fsys, "api/v1/database_v1.yaml")
+	if err != nil {
+		t.Fatalf("ParseTemplateFS() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "orders"},
+	}
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(resources) != 1 || resources[0]["metadata"].(map[string]interface{})["name"] != "orders-redis" {
+		t.Errorf("Expected the imported orders-redis StatefulSet, got %v", resources)
+	}
+
+	_, err = ParseTemplateFS([]interface{}{"@import(loop.yaml)"}, fsys, "api/v1/database_v1.yaml")
+	if err == nil || !strings.Contains(err.Error(), "import cycle detected") {
+		t.Errorf("Expected import cycle error, got: %v", err)
+	}
+
+	_, err = ParseTemplateFS([]interface{}{"@import(../../../etc/passwd)"}, fsys, "api/v1/database_v1.yaml")
+	if err == nil || !strings.Contains(err.Error(), "outside the template filesystem") {
+		t.Errorf("Expected error for an import outside the filesystem, got: %v", err)
+	}
+}
+
+func TestParseImportOutsideResourcesList(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"kind": "ConfigMap",
+			"data": map[string]interface{}{
+				"shared": "@import(shared.yaml)",
+			},
+		},
+	}
+
+	if _, err := ParseTemplate(template); err == nil {
+		t.Error("Expected error for @import inside a resource")
+	}
+}
+
+func TestEvaluatorCloneConcurrent(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"@for(item in .spec.items where item.enabled)": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name": "@expr(.metadata.name + \"-\" + item.name)",
+				},
+				"data": map[string]interface{}{
+					"tier": "@expr($values.tier)",
+				},
+			},
+		},
+	}
+
+	// The AST is parsed once and shared by every goroutine
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "app"},
+		"spec": map[string]interface{}{
+			"items": []interface{}{
+				map[string]interface{}{"name": "a", "enabled": true},
+				map[string]interface{}{"name": "b", "enabled": false},
+				map[string]interface{}{"name": "c", "enabled": true},
+			},
+		},
+	}
+	base := NewEvaluatorWithValues(instance, map[string]interface{}{"tier": "web"})
+
+	const workers = 32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			resources, err := base.Clone().Evaluate(root)
+			if err != nil {
+				t.Errorf("Evaluate() error = %v", err)
+				return
+			}
+			if len(resources) != 2 {
+				t.Errorf("Expected 2 resources, got %d", len(resources))
+				return
+			}
+			for j, expected := range []string{"app-a", "app-c"} {
+				if name := resources[j]["metadata"].(map[string]interface{})["name"]; name != expected {
+					t.Errorf("Expected resource %d name '%s', got '%v'", j, expected, name)
+				}
+				if tier := resources[j]["data"].(map[string]interface{})["tier"]; tier != "web" {
+					t.Errorf("Expected tier 'web', got '%v'", tier)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Clones start empty and do not share collected resources with the original
+	if len(base.GetResources()) != 0 {
+		t.Errorf("Expected base evaluator to have no resources, got %d", len(base.GetResources()))
+	}
+}
+
+func TestEvaluateSpread(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "web"},
+			"spec": map[string]interface{}{
+				"ports": []interface{}{
+					"@spread(.spec.extraPorts)",
+					map[string]interface{}{"port": 80},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		extraPorts interface{}
+		want       []interface{}
+		wantErr    bool
+	}{
+		{
+			name: "list is flattened",
+			extraPorts: []interface{}{
+				map[string]interface{}{"port": 8080},
+				map[string]interface{}{"port": 8443},
+			},
+			want: []interface{}{
+				map[string]interface{}{"port": 8080},
+				map[string]interface{}{"port": 8443},
+				map[string]interface{}{"port": 80},
+			},
+		},
+		{
+			name:       "empty list adds nothing",
+			extraPorts: []interface{}{},
+			want:       []interface{}{map[string]interface{}{"port": 80}},
+		},
+		{
+			name:       "null adds nothing",
+			extraPorts: nil,
+			want:       []interface{}{map[string]interface{}{"port": 80}},
+		},
+		{
+			name:       "non-list value",
+			extraPorts: "8080",
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"spec": map[string]interface{}{"extraPorts": tt.extraPorts},
+			}
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			spec := resources[0]["spec"].(map[string]interface{})
+			if !reflect.DeepEqual(spec["ports"], tt.want) {
+				t.Errorf("ports = %v, want %v", spec["ports"], tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateSpreadInBodyLists(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "web"},
+			"spec": map[string]interface{}{
+				"ports": []interface{}{
+					map[string]interface{}{
+						"@if(.spec.extra)": []interface{}{"@spread(.spec.extraPorts)"},
+					},
+					map[string]interface{}{
+						"@switch(.spec.tier)": map[string]interface{}{
+							"@case(\"web\")": []interface{}{"@spread(.spec.webPorts)"},
+						},
+					},
+					map[string]interface{}{
+						"@for(group in .spec.groups)": []interface{}{"@spread(group.ports)"},
+					},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"extra":      true,
+			"extraPorts": []interface{}{int64(8080)},
+			"tier":       "web",
+			"webPorts":   []interface{}{int64(80), int64(443)},
+			"groups": []interface{}{
+				map[string]interface{}{"ports": []interface{}{int64(9090)}},
+				map[string]interface{}{"ports": []interface{}{int64(9091), int64(9092)}},
+			},
+		},
+	}
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	want := []interface{}{int64(8080), int64(80), int64(443), int64(9090), int64(9091), int64(9092)}
+	ports := resources[0]["spec"].(map[string]interface{})["ports"]
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("ports = %v, want %v", ports, want)
+	}
+}
+
+func TestParseSpreadOutsideList(t *testing.T) {
+	tests := []struct {
+		name     string
+		template []interface{}
+	}{
+		{
+			name: "field value",
+			template: []interface{}{
+				map[string]interface{}{
+					"kind": "Service",
+					"spec": map[string]interface{}{
+						"ports": "@spread(.spec.extraPorts)",
+					},
+				},
+			},
+		},
+		{
+			name: "body merged into a map",
+			template: []interface{}{
+				map[string]interface{}{
+					"kind": "Service",
+					"spec": map[string]interface{}{
+						"@if(.spec.extra)": []interface{}{"@spread(.spec.extraPorts)"},
+					},
+				},
+			},
+		},
+		{
+			name: "loop body in the resources list",
+			template: []interface{}{
+				map[string]interface{}{
+					"@for(group in .spec.groups)": []interface{}{"@spread(group.resources)"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseTemplate(tt.template); err == nil {
+				t.Error("Expected error for @spread outside a list")
+			}
+		})
+	}
+}
+
+func TestEvaluateBindsLoopVariablesInResourceRefs(t *testing.T) {
+	template := map[string]interface{}{
+		"@for(svc in .spec.services)": map[string]interface{}{
+			"@for(port in svc.ports)": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name": "@expr(svc.name + \"-\" + port)",
+				},
+				"data": map[string]interface{}{
+					"host":    `$(resource("v1", "Service", svc.name).spec.clusterIP):$(port)`,
+					"escaped": `$$(svc.name) $(resource("v1", "Service", .metadata.name).spec.clusterIP)`,
+					"plain":   "$(HOME)",
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "app"},
+		"spec": map[string]interface{}{
+			"services": []interface{}{
+				map[string]interface{}{"name": "web", "ports": []interface{}{int64(80)}},
+				map[string]interface{}{"name": "api", "ports": []interface{}{int64(8080)}},
+			},
+		},
+	}
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(resources))
+	}
+
+	for i, want := range []string{`$(resource("v1", "Service", "web").spec.clusterIP):$(80)`, `$(resource("v1", "Service", "api").spec.clusterIP):$(8080)`} {
+		data := resources[i]["data"].(map[string]interface{})
+		if data["host"] != want {
+			t.Errorf("resource %d: host = %q, want %q", i, data["host"], want)
+		}
+		// Escapes, references without loop variables and other strings are left for pass 2
+		if data["escaped"] != `$$(svc.name) $(resource("v1", "Service", .metadata.name).spec.clusterIP)` {
+			t.Errorf("resource %d: escaped = %q", i, data["escaped"])
+		}
+		if data["plain"] != "$(HOME)" {
+			t.Errorf("resource %d: plain = %q", i, data["plain"])
+		}
+	}
+}
+
+func TestEvaluateForLoopDestructuring(t *testing.T) {
+	template := map[string]interface{}{
+		"@for({name, port} in .spec.services)": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "@expr(name)"},
+			"spec": map[string]interface{}{
+				"ports": []interface{}{
+					map[string]interface{}{"port": "@expr(port)"},
+				},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		services   []interface{}
+		nilMissing bool
+		want       map[string]interface{}
+		wantErr    bool
+	}{
+		{
+			name: "both fields bound",
+			services: []interface{}{
+				map[string]interface{}{"name": "web", "port": int64(80), "protocol": "TCP"},
+				map[string]interface{}{"name": "api", "port": int64(8080)},
+			},
+			want: map[string]interface{}{"web": int64(80), "api": int64(8080)},
+		},
+		{
+			name: "missing field",
+			services: []interface{}{
+				map[string]interface{}{"name": "web"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "missing field bound to nil",
+			services: []interface{}{
+				map[string]interface{}{"name": "web"},
+			},
+			nilMissing: true,
+			want:       map[string]interface{}{"web": nil},
+		},
+		{
+			name:     "element is not a map",
+			services: []interface{}{"web"},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"spec": map[string]interface{}{"services": tt.services},
+			}
+			evaluator := NewEvaluator(instance)
+			evaluator.SetNilMissingFields(tt.nilMissing)
+			resources, err := evaluator.Evaluate(root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			got := map[string]interface{}{}
+			for _, resource := range resources {
+				name := resource["metadata"].(map[string]interface{})["name"].(string)
+				ports := resource["spec"].(map[string]interface{})["ports"].([]interface{})
+				got[name] = ports[0].(map[string]interface{})["port"]
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ports = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// A field named like a top-level instance field would hide it from .kind
+	root, err = ParseTemplate(map[string]interface{}{
+		"@for({name, kind} in .spec.services)": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "@expr(name)"},
+			"data":       map[string]interface{}{"owner": "@expr(.kind)"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+	instance := map[string]interface{}{
+		"kind": "WebService",
+		"spec": map[string]interface{}{
+			"services": []interface{}{map[string]interface{}{"name": "web", "kind": "http"}},
+		},
+	}
+	if _, err := NewEvaluator(instance).Evaluate(root); err == nil || !strings.Contains(err.Error(), "would hide the instance's .kind") {
+		t.Errorf("Evaluate() error = %v, want an error for the kind field", err)
+	}
+}
+
+func TestParseForLoopDestructuring(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		body    interface{}
+		wantErr bool
+	}{
+		{
+			name: "fields are loop variables",
+			key:  "@for({name, port} in .spec.services where port > 0)",
+			body: map[string]interface{}{"name": "@expr(name)"},
+		},
+		{
+			name:    "duplicate field",
+			key:     "@for({name, name} in .spec.services)",
+			body:    map[string]interface{}{"name": "@expr(name)"},
+			wantErr: true,
+		},
+		{
+			name:    "empty list",
+			key:     "@for({} in .spec.services)",
+			body:    map[string]interface{}{"name": "x"},
+			wantErr: true,
+		},
+		{
+			name:    "unclosed list",
+			key:     "@for({name, port in .spec.services)",
+			body:    map[string]interface{}{"name": "x"},
+			wantErr: true,
+		},
+		{
+			name:    "only listed fields are bound",
+			key:     "@for({name} in .spec.services)",
+			body:    map[string]interface{}{"@for(x in port)": map[string]interface{}{"a": "b"}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node, err := ParseTemplate(map[string]interface{}{tt.key: tt.body})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			loop := node.Resources[0].(*ForLoopNode)
+			if loop.Variable != "" || !reflect.DeepEqual(loop.Fields, []string{"name", "port"}) {
+				t.Errorf("Variable = %q, Fields = %v", loop.Variable, loop.Fields)
+			}
+		})
+	}
+}
+
+func TestEvaluateForLoopProduct(t *testing.T) {
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"regions": []interface{}{"us-east", "eu-west", "ap-south"},
+			"tiers":   []interface{}{"web", "db"},
+			"zones": []interface{}{
+				map[string]interface{}{"name": "a", "racks": []interface{}{"r1", "r2"}},
+				map[string]interface{}{"name": "b", "racks": []interface{}{"r3"}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		nameArg string
+		want    []string
+	}{
+		{
+			name:    "outer then inner",
+			key:     "@for(r in .spec.regions, t in .spec.tiers)",
+			nameArg: `r + "-" + t`,
+			want: []string{
+				"us-east-web", "us-east-db",
+				"eu-west-web", "eu-west-db",
+				"ap-south-web", "ap-south-db",
+			},
+		},
+		{
+			name:    "where and limit apply to combinations",
+			key:     `@for(r in .spec.regions, t in .spec.tiers where t == "db" limit 2)`,
+			nameArg: `r + "-" + t`,
+			want:    []string{"us-east-db", "eu-west-db"},
+		},
+		{
+			name:    "inner clause uses the outer variable",
+			key:     "@for({name, racks} in .spec.zones, rack in racks)",
+			nameArg: `name + "-" + rack`,
+			want:    []string{"a-r1", "a-r2", "b-r3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			template := map[string]interface{}{
+				tt.key: map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata":   map[string]interface{}{"name": "@expr(" + tt.nameArg + ")"},
+				},
+			}
+
+			root, err := ParseTemplate(template)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			var names []string
+			for _, resource := range resources {
+				names = append(names, resource["metadata"].(map[string]interface{})["name"].(string))
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, names)
+			}
+		})
+	}
+
+	// A clause can only use the variables of the clauses before it
+	_, err := ParseTemplate(map[string]interface{}{
+		"@for(rack in racks, {name, racks} in .spec.zones)": map[string]interface{}{"name": "x"},
+	})
+	if err == nil {
+		t.Error("Expected an error for a clause using a later variable")
+	}
+}
+
+func TestEvaluateForLoopNilIterable(t *testing.T) {
+	template := map[string]interface{}{
+		"@for(svc in .spec.services)": map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "@expr(svc)"},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		spec    map[string]interface{}
+		strict  bool
+		wantErr bool
+	}{
+		{name: "absent list", spec: map[string]interface{}{}},
+		{name: "null list", spec: map[string]interface{}{"services": nil}},
+		{name: "absent list in strict mode", spec: map[string]interface{}{}, strict: true, wantErr: true},
+		{name: "null list in strict mode", spec: map[string]interface{}{"services": nil}, strict: true, wantErr: true},
+		{name: "non-list value", spec: map[string]interface{}{"services": "web"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evaluator := NewEvaluator(map[string]interface{}{"spec": tt.spec})
+			evaluator.SetStrictLoops(tt.strict)
+			resources, err := evaluator.Evaluate(root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(resources) != 0 {
+				t.Errorf("Expected no resources, got %v", resources)
+			}
+		})
+	}
+}
+
+func TestEvaluateCoercion(t *testing.T) {
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"port":     "8080",
+			"replicas": float64(3),
+			"ratio":    "0.5",
+			"enabled":  "true",
+			"version":  1.2,
+			"name":     "web",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		value    string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "int from string", value: "@int(.spec.port)", expected: int64(8080)},
+		{name: "int from whole float", value: "@int(.spec.replicas)", expected: int64(3)},
+		{name: "int from arithmetic", value: "@int(.spec.port + 1)", expected: int64(8081)},
+		{name: "float from string", value: "@float(.spec.ratio)", expected: 0.5},
+		{name: "bool from string", value: "@bool(.spec.enabled)", expected: true},
+		{name: "string from number", value: "@string(.spec.version)", expected: "1.2"},
+		{name: "int from fraction", value: "@int(.spec.ratio)", wantErr: true},
+		{name: "int from word", value: "@int(.spec.name)", wantErr: true},
+		{name: "bool from word", value: "@bool(.spec.name)", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ParseTemplate([]interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata":   map[string]interface{}{"name": "test"},
+					"value":      tt.value,
+				},
+			})
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+
+			resources, err := NewEvaluator(instance).Evaluate(root)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := resources[0]["value"]; got != tt.expected {
+				t.Errorf("value = %v (%T), want %v (%T)", got, got, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluateConditionalResourceList(t *testing.T) {
+	gated := []interface{}{
+		map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ServiceAccount",
+			"metadata":   map[string]interface{}{"name": "@expr(.metadata.name)"},
+		},
+		map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "Role",
+			"metadata":   map[string]interface{}{"name": "@expr(.metadata.name)"},
+		},
+		map[string]interface{}{
+			"apiVersion": "rbac.authorization.k8s.io/v1",
+			"kind":       "RoleBinding",
+			"metadata":   map[string]interface{}{"name": "@expr(.metadata.name)"},
+		},
+	}
+	always := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "@expr(.metadata.name)"},
+	}
+
+	templates := map[string]interface{}{
+		"root map": map[string]interface{}{
+			"@if(.spec.rbac)": gated,
+		},
+		"item of the resources list": []interface{}{
+			map[string]interface{}{"@if(.spec.rbac)": gated},
+			always,
+		},
+	}
+
+	for name, template := range templates {
+		root, err := ParseTemplate(template)
+		if err != nil {
+			t.Fatalf("%s: ParseTemplate() error = %v", name, err)
+		}
+
+		for _, rbac := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/rbac=%v", name, rbac), func(t *testing.T) {
+				instance := map[string]interface{}{
+					"metadata": map[string]interface{}{"name": "my-app"},
+					"spec":     map[string]interface{}{"rbac": rbac},
+				}
+				resources, err := NewEvaluator(instance).Evaluate(root)
+				if err != nil {
+					t.Fatalf("Evaluate() error = %v", err)
+				}
+
+				var kinds []string
+				for _, resource := range resources {
+					kinds = append(kinds, resource["kind"].(string))
+				}
+
+				var want []string
+				if rbac {
+					want = append(want, "ServiceAccount", "Role", "RoleBinding")
+				}
+				if _, ok := template.([]interface{}); ok {
+					want = append(want, "Deployment")
+				}
+				if !reflect.DeepEqual(kinds, want) {
+					t.Errorf("Expected kinds %v, got %v", want, kinds)
+				}
+			})
+		}
+	}
+}
+
+func TestEvaluateConditionalFunctionCalls(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"@if(contains(.spec.features, \"tls\"))": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "tls"},
+			},
+		},
+		map[string]interface{}{
+			"@if(len(.spec.items) > 0)": map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "items"},
+			},
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		spec      map[string]interface{}
+		wantKinds []string
+	}{
+		{
+			name: "both conditions true",
+			spec: map[string]interface{}{
+				"features": []interface{}{"tls"},
+				"items":    []interface{}{"a"},
+			},
+			wantKinds: []string{"Secret", "ConfigMap"},
+		},
+		{
+			name: "both conditions false",
+			spec: map[string]interface{}{
+				"features": []interface{}{"gzip"},
+				"items":    []interface{}{},
+			},
+		},
+		{
+			name: "absent fields",
+			spec: map[string]interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resources, err := NewEvaluator(map[string]interface{}{"spec": tt.spec}).Evaluate(root)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			var kinds []string
+			for _, resource := range resources {
+				kinds = append(kinds, resource["kind"].(string))
+			}
+			if !reflect.DeepEqual(kinds, tt.wantKinds) {
+				t.Errorf("kinds = %v, want %v", kinds, tt.wantKinds)
+			}
+		})
+	}
+}
+
+func TestEvaluateExpressionKeys(t *testing.T) {
+	resource := func(annotations map[string]interface{}) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata": map[string]interface{}{
+				"name":        "app",
+				"annotations": annotations,
+			},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		template map[string]interface{}
+		want     map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name: "dynamic annotation key",
+			template: resource(map[string]interface{}{
+				"@expr(.spec.team + \".example.com/owner\")": "@expr(.spec.owner)",
+				"static": "yes",
+			}),
+			want: map[string]interface{}{"web.example.com/owner": "alice", "static": "yes"},
+		},
+		{
+			name: "dynamic key with conditional value",
+			template: resource(map[string]interface{}{
+				"@expr(.spec.team)": map[string]interface{}{"@when(.spec.missing)": "x"},
+			}),
+			want: map[string]interface{}{},
+		},
+		{
+			name: "collision with a literal key",
+			template: resource(map[string]interface{}{
+				"@expr(.spec.team)": "dynamic",
+				"web":               "literal",
+			}),
+			wantErr: true,
+		},
+		{
+			name: "collision between dynamic keys",
+			template: resource(map[string]interface{}{
+				"@expr(.spec.team)":     "a",
+				"@expr(lower(\"WEB\"))": "b",
+			}),
+			wantErr: true,
+		},
+		{
+			name: "key is not a scalar",
+			template: resource(map[string]interface{}{
+				"@expr(.spec.ports)": "x",
+			}),
+			wantErr: true,
+		},
+	}
+
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"team":  "web",
+			"owner": "alice",
+			"ports": []interface{}{int64(80)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, err := ParseTemplate(tt.template)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+
+			// Collisions fail the same way on every run
+			for run := 0; run < 5; run++ {
+				resources, err := NewEvaluator(instance).Evaluate(root)
+				if (err != nil) != tt.wantErr {
+					t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr {
+					continue
+				}
+
+				annotations := resources[0]["metadata"].(map[string]interface{})["annotations"]
+				if !reflect.DeepEqual(annotations, tt.want) {
+					t.Errorf("annotations = %v, want %v", annotations, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestEvaluateExpressionKeysInLoop(t *testing.T) {
+	template := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   map[string]interface{}{"name": "app"},
+		"data": map[string]interface{}{
+			"@for(svc in .spec.services)": map[string]interface{}{
+				"@expr(svc.name + \".port\")": "@expr(svc.port)",
+			},
+			"protocol": "TCP",
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"services": []interface{}{
+				map[string]interface{}{"name": "web", "port": int64(80)},
+				map[string]interface{}{"name": "api", "port": int64(8080)},
+			},
+		},
+	}
+	resources, err := NewEvaluator(instance).Evaluate(root)
+	if err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	want := map[string]interface{}{"web.port": int64(80), "api.port": int64(8080), "protocol": "TCP"}
+	if data := resources[0]["data"]; !reflect.DeepEqual(data, want) {
+		t.Errorf("data = %v, want %v", data, want)
+	}
+
+	// Keys are checked for unknown variables like any other expression
+	bad := map[string]interface{}{"data": map[string]interface{}{"@expr(svc.name)": "x"}}
+	if _, err := ParseTemplate(bad); err == nil {
+		t.Error("Expected error for an unknown variable in a key")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	template := []interface{}{
+		map[string]interface{}{
+			"@if(.spec.enabled)": []interface{}{
+				map[string]interface{}{
+					"kind": "Service",
+					"spec": map[string]interface{}{"ports": []interface{}{"80", "443"}},
+				},
+			},
+		},
+		map[string]interface{}{
+			"kind": "ConfigMap",
+		},
+	}
+
+	root, err := ParseTemplate(template)
+	if err != nil {
+		t.Fatalf("ParseTemplate() error = %v", err)
+	}
+
+	var literals []string
+	Inspect(root, func(node Node) bool {
+		if literal, ok := node.(*LiteralNode); ok {
+			literals = append(literals, fmt.Sprint(literal.Value))
+		}
+		return true
+	})
+	want := []string{"Service", "80", "443", "ConfigMap"}
+	if !reflect.DeepEqual(literals, want) {
+		t.Errorf("Expected literals %v in order, got %v", want, literals)
+	}
+
+	// Returning false skips the children of a node
+	var kinds []string
+	Inspect(root, func(node Node) bool {
+		if m, ok := node.(*MapNode); ok {
+			kinds = append(kinds, fmt.Sprint(m.Fields["kind"].(*LiteralNode).Value))
+			return false
+		}
+		return true
+	})
+	if !reflect.DeepEqual(kinds, []string{"Service", "ConfigMap"}) {
+		t.Errorf("Expected only the resource maps, got %v", kinds)
+	}
+}
+
+func TestUnusedLoopVariables(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources string
+		want      []string
+	}{
+		{
+			name: "variable used in the body",
+			resources: `
+- "@for(item in .spec.items)":
+    apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(item.name)"
+`,
+		},
+		{
+			name: "unused loop variable",
+			resources: `
+- "@for(item in .spec.items)":
+    apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+			want: []string{"loop variable 'item' of @for(item in ...) is never used"},
+		},
+		{
+			name: "variable used only in the where clause",
+			resources: `
+- "@for(item in .spec.items where item.enabled)":
+    apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+		},
+		{
+			name: "variables used in function arguments, keys and $(...)",
+			resources: `
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: config
+  data:
+    "@for(entry in .spec.entries)":
+      "@expr(lower(entry.key))": plain
+    "@for(env in .spec.envs)":
+      greeting: hello $(env.name)
+`,
+		},
+		{
+			name: "outer variable used by an inner loop",
+			resources: `
+- "@for(app in .spec.apps)":
+    - "@for(port in app.ports)":
+        apiVersion: v1
+        kind: Service
+        metadata:
+          name: "@expr(port.name)"
+`,
+		},
+		{
+			name: "inner loop shadows the outer variable",
+			resources: `
+- "@for(item in .spec.groups)":
+    - "@for(item in .spec.items)":
+        apiVersion: v1
+        kind: ConfigMap
+        metadata:
+          name: "@expr(item.name)"
+`,
+			want: []string{"loop variable 'item' of @for(item in ...) is never used"},
+		},
+		{
+			name: "later clause reads an earlier variable",
+			resources: `
+- "@for(region in .spec.regions, zone in region.zones)":
+    apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(zone)"
+`,
+		},
+		{
+			name: "unused destructured field and underscore variable",
+			resources: `
+- "@for({name, port} in .spec.ports)":
+    apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(name)"
+- "@for(_ in .spec.replicas)":
+    apiVersion: v1
+    kind: Pod
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+			want: []string{"loop variable 'port' of @for({name, port} in ...) is never used"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resources interface{}
+			if err := yaml.Unmarshal([]byte(tt.resources), &resources); err != nil {
+				t.Fatalf("failed to parse resources: %v", err)
+			}
+			root, err := ParseTemplate(resources)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+
+			if got := UnusedLoopVariables(root); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("UnusedLoopVariables() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package ast
 
+import "sort"
+
 // Visitor defines the interface for visiting AST nodes
 type Visitor interface {
 	VisitRoot(node *RootNode) (interface{}, error)
 	VisitForLoop(node *ForLoopNode) (interface{}, error)
 	VisitConditional(node *ConditionalNode) (interface{}, error)
+	VisitConditionalField(node *ConditionalFieldNode) (interface{}, error)
+	VisitSwitch(node *SwitchNode) (interface{}, error)
 	VisitResource(node *ResourceNode) (interface{}, error)
 	VisitField(node *FieldNode) (interface{}, error)
 	VisitExpression(node *ExpressionNode) (interface{}, error)
@@ -12,6 +16,8 @@ type Visitor interface {
 	VisitArray(node *ArrayNode) (interface{}, error)
 	VisitMap(node *MapNode) (interface{}, error)
 	VisitMultiControlFlow(node *MultiControlFlowNode) (interface{}, error)
+	VisitRaw(node *RawNode) (interface{}, error)
+	VisitSpread(node *SpreadNode) (interface{}, error)
 }
 
 // Walk traverses an AST node and all its children
@@ -19,3 +25,55 @@ func Walk(node Node, visitor Visitor) error {
 	_, err := node.Accept(visitor)
 	return err
 }
+
+// Inspect traverses an AST in depth-first order, calling fn for each node
+// before its children, and skips the children of nodes for which fn returns
+// false. Map fields are visited in key order.
+func Inspect(node Node, fn func(Node) bool) {
+	if node == nil || !fn(node) {
+		return
+	}
+
+	inspectAll := func(nodes []Node) {
+		for _, child := range nodes {
+			Inspect(child, fn)
+		}
+	}
+	inspectFields := func(fields map[string]Node) {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			Inspect(fields[key], fn)
+		}
+	}
+
+	switch n := node.(type) {
+	case *RootNode:
+		inspectAll(n.Resources)
+	case *ForLoopNode:
+		inspectAll(n.Body)
+	case *ConditionalNode:
+		inspectAll(n.ThenBranch)
+		inspectAll(n.ElseBranch)
+	case *ConditionalFieldNode:
+		Inspect(n.Value, fn)
+	case *SwitchNode:
+		for _, c := range n.Cases {
+			inspectAll(c.Body)
+		}
+		inspectAll(n.Default)
+	case *ResourceNode:
+		inspectFields(n.Fields)
+	case *FieldNode:
+		Inspect(n.Value, fn)
+	case *ArrayNode:
+		inspectAll(n.Elements)
+	case *MapNode:
+		inspectFields(n.Fields)
+	case *MultiControlFlowNode:
+		inspectAll(n.Nodes)
+	}
+}
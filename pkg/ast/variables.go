@@ -0,0 +1,147 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
+)
+
+// UnusedLoopVariables statically checks the @for loops of a parsed template
+// and returns a warning for each loop variable that neither the loop's body,
+// its where clause nor its later clauses read, which usually means the body
+// uses the wrong variable. Variables starting with an underscore may go unused.
+func UnusedLoopVariables(root *RootNode) []string {
+	var warnings []string
+	freeVariables(root, &warnings)
+	return warnings
+}
+
+// freeVariables returns the variables node reads that it does not bind
+// itself, appending a warning for each unused variable of the loops in node
+func freeVariables(node Node, warnings *[]string) map[string]bool {
+	read := map[string]bool{}
+	addExpr := func(expr *dsl.Expression) {
+		for _, name := range rootIdentifiers(expr) {
+			read[name] = true
+		}
+	}
+	addNodes := func(nodes []Node) {
+		for _, child := range nodes {
+			for name := range freeVariables(child, warnings) {
+				read[name] = true
+			}
+		}
+	}
+	addFields := func(fields map[string]Node) {
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			addNodes([]Node{fields[key]})
+		}
+	}
+
+	switch n := node.(type) {
+	case *RootNode:
+		addNodes(n.Resources)
+	case *ForLoopNode:
+		return loopFreeVariables(n, warnings)
+	case *ConditionalNode:
+		addExpr(n.Condition)
+		addNodes(n.ThenBranch)
+		addNodes(n.ElseBranch)
+	case *ConditionalFieldNode:
+		addExpr(n.Condition)
+		addNodes([]Node{n.Value})
+	case *SwitchNode:
+		addExpr(n.Value)
+		for _, c := range n.Cases {
+			addExpr(c.Value)
+			addNodes(c.Body)
+		}
+		addNodes(n.Default)
+	case *ResourceNode:
+		addFields(n.Fields)
+	case *MapNode:
+		addFields(n.Fields)
+		for _, key := range n.KeyExpr {
+			addExpr(key)
+		}
+	case *FieldNode:
+		addNodes([]Node{n.Value})
+	case *ArrayNode:
+		addNodes(n.Elements)
+	case *MultiControlFlowNode:
+		addNodes(n.Nodes)
+	case *ExpressionNode:
+		addExpr(n.Expr)
+	case *SpreadNode:
+		addExpr(n.Expr)
+	case *LiteralNode:
+		// $(...) expressions in strings are evaluated after the template is parsed
+		if value, ok := n.Value.(string); ok {
+			exprs, _ := dsl.Substitutions(value)
+			for _, raw := range exprs {
+				if expr, err := dsl.ParseExpression(raw); err == nil {
+					addExpr(expr)
+				}
+			}
+		}
+	}
+
+	return read
+}
+
+// loopFreeVariables returns the variables a loop reads from outside it,
+// appending a warning for each of its own variables that it never reads
+func loopFreeVariables(loop *ForLoopNode, warnings *[]string) map[string]bool {
+	free := map[string]bool{}
+	bound := map[string]bool{}
+	used := map[string]bool{}
+	read := func(names []string) {
+		for _, name := range names {
+			if bound[name] {
+				used[name] = true
+			} else {
+				free[name] = true
+			}
+		}
+	}
+
+	// Each clause's iterable may read the variables of the earlier clauses
+	var variables []string
+	for _, clause := range loop.Clauses() {
+		read(rootIdentifiers(clause.Iterable))
+		for _, name := range clause.Variables() {
+			if !bound[name] {
+				bound[name] = true
+				variables = append(variables, name)
+			}
+		}
+	}
+	read(rootIdentifiers(loop.WhereClause))
+	for _, child := range loop.Body {
+		for name := range freeVariables(child, warnings) {
+			read([]string{name})
+		}
+	}
+
+	for _, name := range variables {
+		if !used[name] && !strings.HasPrefix(name, "_") {
+			*warnings = append(*warnings, fmt.Sprintf("loop variable '%s' of @for(%s in ...) is never used", name, loopVariableList(loop)))
+		}
+	}
+	return free
+}
+
+// loopVariableList formats the variables of a loop's first clause as written in the template
+func loopVariableList(loop *ForLoopNode) string {
+	if loop.Fields == nil {
+		return loop.Variable
+	}
+	return "{" + strings.Join(loop.Fields, ", ") + "}"
+}
@@ -0,0 +1,104 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// GeneratedManifestFile is the file in the output directory that records the
+// files written by the last run with --clean
+const GeneratedManifestFile = ".krm-sdk-generated.json"
+
+// generatedManifest lists generated files, relative to the output directory
+type generatedManifest struct {
+	Files []string `json:"files"`
+}
+
+// cleanOutputDir removes the files that the previous run with --clean wrote
+// to outputDir but this run did not, and records the files of this run for
+// the next one. Only recorded files are ever removed, so files the generator
+// did not write are left alone; the first run with --clean removes nothing.
+func (g *Generator) cleanOutputDir(resources []map[string]interface{}, outputDir, layout string) error {
+	if layout == "" {
+		layout = OutputLayoutFlat
+	}
+
+	current := make(map[string]bool, len(resources))
+	files := make([]string, 0, len(resources))
+	for i, resource := range resources {
+		file := g.outputFilename(resource, i, layout)
+		if !current[file] {
+			current[file] = true
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	previous, err := loadGeneratedManifest(outputDir)
+	if err != nil {
+		return err
+	}
+
+	removed := 0
+	for _, file := range previous.Files {
+		// The manifest could have been edited, so never leave the output directory
+		if current[file] || !filepath.IsLocal(file) || file == GeneratedManifestFile {
+			continue
+		}
+
+		path := filepath.Join(outputDir, file)
+		if err := os.Remove(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return fmt.Errorf("failed to remove stale file: %w", err)
+		}
+		removed++
+		if g.verbose {
+			fmt.Printf("Removed: %s\n", path)
+		}
+
+		// Remove kind directories left empty; removing a non-empty directory fails
+		for dir := filepath.Dir(path); dir != filepath.Clean(outputDir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	if removed > 0 {
+		fmt.Printf("✓ Removed %d stale files from %s\n", removed, outputDir)
+	}
+
+	data, err := json.MarshalIndent(generatedManifest{Files: files}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal generated manifest: %w", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(outputDir, GeneratedManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write generated manifest: %w", err)
+	}
+
+	return nil
+}
+
+// loadGeneratedManifest reads the manifest from outputDir, returning an empty manifest if there is none
+func loadGeneratedManifest(outputDir string) (*generatedManifest, error) {
+	manifest := &generatedManifest{}
+
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, GeneratedManifestFile))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read generated manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse generated manifest: %w", err)
+	}
+
+	return manifest, nil
+}
@@ -1,10 +1,17 @@
 package cli
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"os/exec"
+	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+	"github.com/zachaller/k8s-client-api-builder/pkg/validation"
 )
 
 // BuildRootCommand builds the root command for a generated project
@@ -22,7 +29,9 @@ It validates and hydrates custom abstractions into Kubernetes resources.`, proje
 	// Add subcommands
 	rootCmd.AddCommand(BuildGenerateCommand())
 	rootCmd.AddCommand(BuildValidateCommand())
+	rootCmd.AddCommand(BuildLintCommand())
 	rootCmd.AddCommand(BuildApplyCommand())
+	rootCmd.AddCommand(BuildListCommand())
 
 	return rootCmd
 }
@@ -30,9 +39,46 @@ It validates and hydrates custom abstractions into Kubernetes resources.`, proje
 // BuildGenerateCommand builds the generate command
 func BuildGenerateCommand() *cobra.Command {
 	var (
-		outputDir string
-		overlay   string
-		validate  bool
+		outputDir          string
+		outputLayout       string
+		overlay            string
+		baseDir            string
+		baseNamespace      string
+		baseLabels         map[string]string
+		emitKustomize      string
+		valuesFile         string
+		secretsFile        string
+		crdDir             string
+		commonLabels       map[string]string
+		commonAnnotations  map[string]string
+		labelsFrom         []string
+		expandGenerateName bool
+		allowDuplicates    bool
+		incremental        bool
+		clean              bool
+		validate           bool
+		validateReferences bool
+		sortOutput         bool
+		specOnly           bool
+		trimEmpty          bool
+		keepEmpty          []string
+		namespace          string
+		jsonPatch          string
+		strictLoops        bool
+		nilMissingFields   bool
+		onUnresolved       string
+		placeholder        string
+		freezeTime         string
+		report             bool
+		showNotes          bool
+		preserveComments   bool
+		clusterScopedKinds []string
+		yamlIndent         int
+		yamlStyle          string
+		maxDepth           int
+		profile            bool
+		diff               bool
+		diffExitCode       bool
 	)
 
 	cmd := &cobra.Command{
@@ -41,51 +87,150 @@ func BuildGenerateCommand() *cobra.Command {
 		Long: `Generate Kubernetes resources from abstraction instances.
 
 This command reads abstraction instances, validates them (optionally),
-and hydrates them into standard Kubernetes resources.`,
+and hydrates them into standard Kubernetes resources.
+
+With --diff, it instead generates resources from two versions of an instance
+and prints a unified diff of the output:
+
+  generate --diff old.yaml new.yaml
+
+With --diff-exit-code, the command exits with code 2 when the output differs,
+0 when it does not and 1 on error, like kubectl diff, to detect drift in CI.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			inputFiles, err := cmd.Flags().GetStringSlice("file")
-			if err != nil || len(inputFiles) == 0 {
+			inputFiles, _ := cmd.Flags().GetStringSlice("file")
+			if diff {
+				if len(args) != 2 || len(inputFiles) > 0 {
+					return fmt.Errorf("--diff takes exactly two instance files as arguments: old and new")
+				}
+			} else if len(inputFiles) == 0 {
 				return fmt.Errorf("--file/-f is required")
 			}
+			if diffExitCode && !diff {
+				return fmt.Errorf("--diff-exit-code requires --diff")
+			}
 
 			verbose, _ := cmd.Flags().GetBool("verbose")
 
-			generator := NewGenerator(GeneratorOptions{
-				InputFiles: inputFiles,
-				OutputDir:  outputDir,
-				Overlay:    overlay,
-				Validate:   validate,
-				Verbose:    verbose,
-			})
-
-			return generator.Generate(GeneratorOptions{
-				InputFiles: inputFiles,
-				OutputDir:  outputDir,
-				Overlay:    overlay,
-				Validate:   validate,
-				Verbose:    verbose,
-			})
+			opts := GeneratorOptions{
+				InputFiles:         inputFiles,
+				OutputDir:          outputDir,
+				OutputLayout:       outputLayout,
+				Overlay:            overlay,
+				BaseDir:            baseDir,
+				BaseNamespace:      baseNamespace,
+				BaseLabels:         baseLabels,
+				EmitKustomize:      emitKustomize,
+				JSONPatch:          jsonPatch,
+				ValuesFile:         valuesFile,
+				SecretsFile:        secretsFile,
+				CRDDir:             crdDir,
+				CommonLabels:       commonLabels,
+				CommonAnnotations:  commonAnnotations,
+				LabelsFrom:         labelsFrom,
+				Namespace:          namespace,
+				ClusterScopedKinds: clusterScopedKinds,
+				ExpandGenerateName: expandGenerateName,
+				AllowDuplicates:    allowDuplicates,
+				Incremental:        incremental,
+				Clean:              clean,
+				Validate:           validate,
+				ValidateReferences: validateReferences,
+				Verbose:            verbose,
+				SortOutput:         sortOutput,
+				SpecOnly:           specOnly,
+				TrimEmpty:          trimEmpty,
+				KeepEmpty:          keepEmpty,
+				YAMLIndent:         yamlIndent,
+				YAMLStyle:          yamlStyle,
+				MaxDepth:           maxDepth,
+				StrictLoops:        strictLoops,
+				NilMissingFields:   nilMissingFields,
+				OnUnresolved:       onUnresolved,
+				Placeholder:        placeholder,
+				FreezeTime:         freezeTime,
+				Profile:            profile,
+				Report:             report,
+				ShowNotes:          showNotes,
+				PreserveComments:   preserveComments,
+				DiffExitCode:       diffExitCode,
+				PostProcessors:     postProcessors,
+			}
+			generator := NewGenerator(opts)
+
+			if diff {
+				err := generator.Diff(args[0], args[1], opts, os.Stdout)
+				if errors.Is(err, ErrDiffFound) {
+					// Differences are a result, not a failure to report
+					cmd.SilenceErrors = true
+					cmd.SilenceUsage = true
+				}
+				return err
+			}
+			return generator.Generate(opts)
 		},
 	}
 
-	cmd.Flags().StringSliceP("file", "f", []string{}, "input file or directory (required)")
+	cmd.Flags().StringSliceP("file", "f", []string{}, "input file or directory, or - for stdin (required unless --diff)")
 	cmd.Flags().StringVarP(&outputDir, "output", "o", "", "output directory (default: stdout)")
+	cmd.Flags().StringVar(&outputLayout, "output-layout", OutputLayoutFlat, "output directory layout: flat or by-kind")
 	cmd.Flags().StringVar(&overlay, "overlay", "", "kustomize overlay path (directory or kustomization.yaml file)")
+	cmd.Flags().StringVar(&baseDir, "base-dir", "", "directory to write and keep the overlay's kustomize base in (default: a temporary directory that overlays see as base/)")
+	cmd.Flags().StringVar(&baseNamespace, "base-namespace", "", "namespace set in the kustomize base for overlays to inherit")
+	cmd.Flags().StringToStringVar(&baseLabels, "base-labels", nil, "labels set in the kustomize base for overlays to inherit (key=value,...)")
+	cmd.Flags().StringVar(&emitKustomize, "emit-kustomize", "", "write a kustomize base and empty dev/staging/prod overlays to this directory instead of rendering resources")
+	cmd.Flags().StringVar(&jsonPatch, "json-patch", "", "write an RFC 6902 JSON Patch from each object in this file of current objects (e.g. kubectl get -o yaml) to the generated resource, instead of the resources; patches only touch generated fields, resources without a namespace are matched in --namespace or default, and resources with no current object are reported under \"create\"")
+	cmd.Flags().StringVar(&valuesFile, "values", "", "values file exposed to templates as $values")
+	cmd.Flags().StringVar(&secretsFile, "secrets-file", "", "YAML file of secret name to key to value read by secret(name, key), for rendering without real secrets")
+	cmd.Flags().StringVar(&crdDir, "crd-dir", DefaultCRDDir, "directory containing CRD schemas used for validation")
+	cmd.Flags().StringToStringVar(&commonLabels, "common-labels", nil, "labels added to every generated resource (key=value,...); values may use $(...) expressions")
+	cmd.Flags().StringToStringVar(&commonAnnotations, "common-annotations", nil, "annotations added to every generated resource (key=value,...); values may use $(...) expressions")
+	cmd.Flags().StringSliceVar(&labelsFrom, "labels-from", nil, "instance fields promoted to labels on every generated resource (e.g. .spec.team,.spec.tier), named after the last field and sanitized like k8sName")
+	cmd.Flags().StringVar(&namespace, "namespace", "", "namespace set on every namespaced resource that does not set one; cluster-scoped kinds are left without")
+	cmd.Flags().StringSliceVar(&clusterScopedKinds, "cluster-scoped-kinds", nil, "additional kinds that are not namespaced, such as cluster-scoped custom resources")
+	cmd.Flags().BoolVar(&expandGenerateName, "expand-generate-name", false, "name resources that only set metadata.generateName with a stable content hash suffix")
+	cmd.Flags().BoolVar(&allowDuplicates, "allow-duplicates", false, "warn instead of failing when two generated resources share apiVersion, kind, namespace and name")
+	cmd.Flags().BoolVar(&incremental, "incremental", false, "skip directory instances whose outputs are newer than the instance and every file it was generated from")
+	cmd.Flags().BoolVar(&clean, "clean", false, "remove files a previous --clean run wrote to the output directory that this run no longer generates; other files are never removed")
 	cmd.Flags().BoolVar(&validate, "validate", true, "validate instances before hydration")
-	cmd.MarkFlagRequired("file")
+	cmd.Flags().BoolVar(&validateReferences, "validate-references", false, "warn about resource() references to kinds a template never creates")
+	cmd.Flags().BoolVar(&sortOutput, "sort-output", false, "sort generated resources by kind, namespace and name")
+	cmd.Flags().BoolVar(&specOnly, "spec-only", false, "emit only apiVersion, kind, metadata and spec of each resource, e.g. for patch workflows")
+	cmd.Flags().BoolVar(&trimEmpty, "trim-empty", false, "remove empty maps and lists from generated resources")
+	cmd.Flags().StringSliceVar(&keepEmpty, "keep-empty", nil, "field names kept by --trim-empty even when empty, in addition to emptyDir, podSelector, namespaceSelector, ingress and egress")
+	cmd.Flags().IntVar(&yamlIndent, "yaml-indent", 0, "indent output YAML by N spaces, from 2 to 9 (default: standard formatting)")
+	cmd.Flags().StringVar(&yamlStyle, "yaml-style", YAMLStyleBlock, "output YAML style: block, or flow to write every map and list inline")
+	cmd.Flags().BoolVar(&diff, "diff", false, "compare the output generated from two instance files given as arguments")
+	cmd.Flags().BoolVar(&diffExitCode, "diff-exit-code", false, "with --diff, exit with code 2 when the output differs, 0 when it does not and 1 on error")
+	cmd.Flags().BoolVar(&profile, "profile", false, "print the time spent in each generation phase to stderr")
+	cmd.Flags().BoolVar(&preserveComments, "preserve-comments", false, "copy comments written on template fields to the matching fields of generated resources")
+	cmd.Flags().BoolVar(&showNotes, "show-notes", false, "print the notes of each instance's template, rendered against the instance, to stderr after generation")
+	cmd.Flags().BoolVar(&report, "report", false, "print the number of instances processed, resources produced by kind and warnings to stderr")
+	cmd.Flags().IntVar(&maxDepth, "max-depth", dsl.DefaultMaxDepth, "maximum nesting of maps, lists and loops in a template before hydration fails")
+	cmd.Flags().BoolVar(&strictLoops, "strict-loops", false, "fail when a @for iterates over a null or absent list instead of producing no items")
+	cmd.Flags().BoolVar(&nilMissingFields, "nil-missing-fields", false, "bind null for fields of a @for destructure list that are missing from an element instead of failing")
+	cmd.Flags().StringVar(&onUnresolved, "on-unresolved", hydrator.UnresolvedKeep, "handling of resource() references that cannot be resolved: keep the expression, blank it with --unresolved-placeholder, or error")
+	cmd.Flags().StringVar(&freezeTime, "freeze-time", "", "RFC 3339 time returned by now() in templates, for reproducible output (default: $"+FreezeTimeEnv+" or the current time)")
+	cmd.Flags().StringVar(&placeholder, "unresolved-placeholder", "", "text substituted for unresolved resource() references with --on-unresolved=blank")
 
 	return cmd
 }
 
 // BuildValidateCommand builds the validate command
 func BuildValidateCommand() *cobra.Command {
+	var (
+		crdDir string
+		check  bool
+	)
+
 	cmd := &cobra.Command{
 		Use:   "validate -f <file|directory>",
 		Short: "Validate abstraction instances",
 		Long: `Validate abstraction instances against their CRD schemas.
 
 This command checks that instances conform to the defined schemas
-without generating any resources.`,
+without writing any resources. By default each instance is also hydrated
+to catch template errors; with --check only the schemas are used, so no
+templates are needed.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			inputFiles, err := cmd.Flags().GetStringSlice("file")
 			if err != nil || len(inputFiles) == 0 {
@@ -96,6 +241,8 @@ without generating any resources.`,
 
 			validator := NewValidator(ValidatorOptions{
 				InputFiles: inputFiles,
+				CRDDir:     crdDir,
+				Check:      check,
 				Verbose:    verbose,
 			})
 
@@ -103,7 +250,43 @@ without generating any resources.`,
 		},
 	}
 
-	cmd.Flags().StringSliceP("file", "f", []string{}, "input file or directory (required)")
+	cmd.Flags().StringSliceP("file", "f", []string{}, "input file or directory, or - for stdin (required)")
+	cmd.Flags().StringVar(&crdDir, "crd-dir", DefaultCRDDir, "directory containing CRD schemas used for validation")
+	cmd.Flags().BoolVar(&check, "check", false, "only validate instances against their schemas, without hydrating them; templates are not needed")
+	cmd.MarkFlagRequired("file")
+
+	return cmd
+}
+
+// BuildLintCommand builds the lint command
+func BuildLintCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lint -f <template|directory>",
+		Short: "Check hydration templates for mistakes",
+		Long: `Check hydration templates for mistakes without hydrating them.
+
+This command parses templates and reports invalid control flow, such as
+@for loops over literals or @if conditions that are string literals. It
+also warns about resource() references to kinds a template never creates
+and @for loop variables that are never used.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			templateFiles, err := cmd.Flags().GetStringSlice("file")
+			if err != nil || len(templateFiles) == 0 {
+				return fmt.Errorf("--file/-f is required")
+			}
+
+			verbose, _ := cmd.Flags().GetBool("verbose")
+
+			linter := NewLinter(LinterOptions{
+				TemplateFiles: templateFiles,
+				Verbose:       verbose,
+			})
+
+			return linter.Lint()
+		},
+	}
+
+	cmd.Flags().StringSliceP("file", "f", []string{}, "template file or directory (required)")
 	cmd.MarkFlagRequired("file")
 
 	return cmd
@@ -112,8 +295,13 @@ without generating any resources.`,
 // BuildApplyCommand builds the apply command
 func BuildApplyCommand() *cobra.Command {
 	var (
-		overlay string
-		dryRun  bool
+		overlay       string
+		baseDir       string
+		baseNamespace string
+		baseLabels    map[string]string
+		kubeconfig    string
+		kubeContext   string
+		dryRun        bool
 	)
 
 	cmd := &cobra.Command{
@@ -131,10 +319,15 @@ This command combines generation and kubectl apply in one step.`,
 			verbose, _ := cmd.Flags().GetBool("verbose")
 
 			applier := NewApplier(ApplierOptions{
-				InputFiles: inputFiles,
-				Overlay:    overlay,
-				DryRun:     dryRun,
-				Verbose:    verbose,
+				InputFiles:    inputFiles,
+				Overlay:       overlay,
+				BaseDir:       baseDir,
+				BaseNamespace: baseNamespace,
+				BaseLabels:    baseLabels,
+				Kubeconfig:    kubeconfig,
+				Context:       kubeContext,
+				DryRun:        dryRun,
+				Verbose:       verbose,
 			})
 
 			return applier.Apply()
@@ -143,15 +336,60 @@ This command combines generation and kubectl apply in one step.`,
 
 	cmd.Flags().StringSliceP("file", "f", []string{}, "input file or directory (required)")
 	cmd.Flags().StringVar(&overlay, "overlay", "", "kustomize overlay path (directory or kustomization.yaml file)")
+	cmd.Flags().StringVar(&baseDir, "base-dir", "", "directory to write and keep the overlay's kustomize base in (default: a temporary directory that overlays see as base/)")
+	cmd.Flags().StringVar(&baseNamespace, "base-namespace", "", "namespace set in the kustomize base for overlays to inherit")
+	cmd.Flags().StringToStringVar(&baseLabels, "base-labels", nil, "labels set in the kustomize base for overlays to inherit (key=value,...)")
+	cmd.Flags().StringVar(&kubeconfig, "kubeconfig", "", "path to the kubeconfig file (default: standard KUBECONFIG resolution)")
+	cmd.Flags().StringVar(&kubeContext, "context", "", "kubeconfig context to use (default: current context)")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "perform a dry run")
 	cmd.MarkFlagRequired("file")
 
 	return cmd
 }
 
+// BuildListCommand builds the list command and its subcommands
+func BuildListCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List what this binary can work with",
+		Long: `List what this binary can work with.
+
+Available subcommands:
+  kinds   List the kinds and versions that have a hydration template`,
+	}
+
+	cmd.AddCommand(BuildListKindsCommand())
+
+	return cmd
+}
+
+// BuildListKindsCommand builds the list kinds command
+func BuildListKindsCommand() *cobra.Command {
+	var templateDir string
+
+	cmd := &cobra.Command{
+		Use:   "kinds",
+		Short: "List the kinds that can be hydrated",
+		Long: `List the kinds and versions that have a hydration template.
+
+Templates are found by filename: <kind>_<version>.yaml for one version,
+or <kind>_template.yaml for every version, listed with version "*".`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return ListKinds(templateDir, os.Stdout)
+		},
+	}
+
+	cmd.Flags().StringVar(&templateDir, "template-dir", DefaultTemplateDir, "directory containing hydration templates")
+
+	return cmd
+}
+
 // ValidatorOptions contains options for validation
 type ValidatorOptions struct {
 	InputFiles []string
+	CRDDir     string
+	Check      bool // Only validate against schemas, without hydrating
 	Verbose    bool
 }
 
@@ -165,42 +403,100 @@ func NewValidator(opts ValidatorOptions) *Validator {
 	return &Validator{opts: opts}
 }
 
-// Validate validates input files
+// Validate validates every instance in the input files. It keeps going after
+// a failure and reports all failures together at the end.
 func (v *Validator) Validate() error {
-	validator := NewGenerator(GeneratorOptions{
-		Validate: true,
-		Verbose:  v.opts.Verbose,
-	})
+	validate := v.instanceValidator()
 
-	// Use the generator with validation only
+	var failures []string
 	for _, inputFile := range v.opts.InputFiles {
 		if v.opts.Verbose {
 			fmt.Printf("Validating: %s\n", inputFile)
 		}
 
-		_, err := validator.processFile(inputFile, GeneratorOptions{
-			Validate: true,
-			Verbose:  v.opts.Verbose,
-		})
-
+		inputs, err := readInputDocuments(inputFile, os.Stdin)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "✗ %s: %v\n", inputFile, err)
-			return err
+			failures = append(failures, fmt.Sprintf("%s: %v", inputFile, err))
+			continue
+		}
+
+		for _, input := range inputs {
+			if input.err != nil {
+				fmt.Fprintf(os.Stderr, "✗ %s: %v\n", input.path, input.err)
+				failures = append(failures, fmt.Sprintf("%s: %v", input.path, input.err))
+				continue
+			}
+
+			for i, instance := range input.instances {
+				location := input.path
+				if len(input.instances) > 1 {
+					location = fmt.Sprintf("%s (document %d)", input.path, i+1)
+				}
+
+				if err := validate(instance); err != nil {
+					fmt.Fprintf(os.Stderr, "✗ %s: %v\n", location, err)
+					failures = append(failures, fmt.Sprintf("%s: %v", location, err))
+					continue
+				}
+
+				fmt.Printf("✓ %s\n", location)
+			}
 		}
+	}
 
-		fmt.Printf("✓ %s\n", inputFile)
+	if len(failures) > 0 {
+		fmt.Fprintf(os.Stderr, "\nValidation failed for %d instance(s):\n", len(failures))
+		for _, failure := range failures {
+			fmt.Fprintf(os.Stderr, "  - %s\n", failure)
+		}
+		return fmt.Errorf("validation failed for %d instance(s)", len(failures))
 	}
 
 	fmt.Println("\nAll files validated successfully!")
 	return nil
 }
 
+// instanceValidator returns the check run on each instance. With Check it
+// only validates the structure and schema, never loading templates;
+// otherwise the instance is also hydrated.
+func (v *Validator) instanceValidator() func(instance map[string]interface{}) error {
+	if v.opts.Check {
+		schemas := validation.NewValidator(crdDirOrDefault(v.opts.CRDDir), v.opts.Verbose)
+		return func(instance map[string]interface{}) error {
+			if err := validation.ValidateStructure(instance); err != nil {
+				return err
+			}
+			return validateSchema(schemas, instance, v.opts.Verbose)
+		}
+	}
+
+	generator := NewGenerator(GeneratorOptions{
+		CRDDir:   v.opts.CRDDir,
+		Validate: true,
+		Verbose:  v.opts.Verbose,
+	})
+	opts := GeneratorOptions{
+		Validate: true,
+		Verbose:  v.opts.Verbose,
+	}
+	return func(instance map[string]interface{}) error {
+		_, _, err := generator.processInstance(instance, opts)
+		return err
+	}
+}
+
 // ApplierOptions contains options for applying resources
 type ApplierOptions struct {
-	InputFiles []string
-	Overlay    string
-	DryRun     bool
-	Verbose    bool
+	InputFiles    []string
+	Overlay       string
+	BaseDir       string
+	BaseNamespace string
+	BaseLabels    map[string]string
+	Kubeconfig    string
+	Context       string
+	DryRun        bool
+	Verbose       bool
 }
 
 // Applier handles resource application
@@ -213,16 +509,62 @@ func NewApplier(opts ApplierOptions) *Applier {
 	return &Applier{opts: opts}
 }
 
-// Apply generates and applies resources
+// Apply generates resources and applies them with kubectl
 func (a *Applier) Apply() error {
-	// For now, this is a placeholder
-	// In a full implementation, this would:
-	// 1. Generate resources
-	// 2. Apply overlays
-	// 3. Use kubectl or client-go to apply to cluster
+	genOpts := GeneratorOptions{
+		InputFiles:     a.opts.InputFiles,
+		Overlay:        a.opts.Overlay,
+		BaseDir:        a.opts.BaseDir,
+		BaseNamespace:  a.opts.BaseNamespace,
+		BaseLabels:     a.opts.BaseLabels,
+		Validate:       true,
+		Verbose:        a.opts.Verbose,
+		PostProcessors: postProcessors,
+	}
+	generator := NewGenerator(genOpts)
 
-	fmt.Println("Apply functionality coming soon!")
-	fmt.Println("For now, use: generate -f <file> -o output/ | kubectl apply -f -")
+	resources, err := generator.generateResources(genOpts)
+	if err != nil {
+		return err
+	}
+
+	var manifest bytes.Buffer
+	if err := generator.printResources(resources, &manifest); err != nil {
+		return err
+	}
+
+	args := []string{"apply", "-f", "-"}
+	if a.opts.DryRun {
+		args = append(args, "--dry-run=client")
+	}
+
+	kubectl := a.kubectlCommand(args...)
+	kubectl.Stdin = &manifest
+	kubectl.Stdout = os.Stdout
+	kubectl.Stderr = os.Stderr
+
+	if a.opts.Verbose {
+		fmt.Printf("Running: %s\n", strings.Join(kubectl.Args, " "))
+	}
+
+	if err := kubectl.Run(); err != nil {
+		return fmt.Errorf("kubectl apply failed: %w", err)
+	}
 
 	return nil
 }
+
+// kubectlCommand builds a kubectl invocation targeting the configured cluster.
+// Without --kubeconfig/--context kubectl uses the standard KUBECONFIG resolution.
+func (a *Applier) kubectlCommand(args ...string) *exec.Cmd {
+	var kubectlArgs []string
+	if a.opts.Kubeconfig != "" {
+		kubectlArgs = append(kubectlArgs, "--kubeconfig", a.opts.Kubeconfig)
+	}
+	if a.opts.Context != "" {
+		kubectlArgs = append(kubectlArgs, "--context", a.opts.Context)
+	}
+	kubectlArgs = append(kubectlArgs, args...)
+
+	return exec.Command("kubectl", kubectlArgs...)
+}
@@ -0,0 +1,393 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestKubectlCommandForwardsClusterFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     ApplierOptions
+		expected []string
+	}{
+		{
+			name:     "default resolution",
+			opts:     ApplierOptions{},
+			expected: []string{"kubectl", "apply", "-f", "-"},
+		},
+		{
+			name:     "kubeconfig only",
+			opts:     ApplierOptions{Kubeconfig: "/tmp/kubeconfig"},
+			expected: []string{"kubectl", "--kubeconfig", "/tmp/kubeconfig", "apply", "-f", "-"},
+		},
+		{
+			name:     "kubeconfig and context",
+			opts:     ApplierOptions{Kubeconfig: "/tmp/kubeconfig", Context: "staging"},
+			expected: []string{"kubectl", "--kubeconfig", "/tmp/kubeconfig", "--context", "staging", "apply", "-f", "-"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := NewApplier(tt.opts).kubectlCommand("apply", "-f", "-")
+			if !reflect.DeepEqual(cmd.Args, tt.expected) {
+				t.Errorf("kubectlCommand() args = %v, want %v", cmd.Args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestBuildApplyCommandClusterFlags(t *testing.T) {
+	cmd := BuildApplyCommand()
+
+	for _, name := range []string{"kubeconfig", "context"} {
+		flag := cmd.Flags().Lookup(name)
+		if flag == nil {
+			t.Errorf("Expected apply command to have --%s flag", name)
+			continue
+		}
+		if flag.DefValue != "" {
+			t.Errorf("Expected --%s to default to empty, got '%s'", name, flag.DefValue)
+		}
+	}
+}
+
+func TestValidateWithCustomCRDDir(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "commands-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	crdDir := filepath.Join(tempDir, "schemas", "crds")
+	if err := os.MkdirAll(crdDir, 0755); err != nil {
+		t.Fatalf("failed to create crd dir: %v", err)
+	}
+
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: webservices.platform.example.com
+spec:
+  group: platform.example.com
+  names:
+    kind: WebService
+    plural: webservices
+  scope: Namespaced
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              replicas:
+                type: integer
+                maximum: 10
+`
+	if err := os.WriteFile(filepath.Join(crdDir, "webservice.yaml"), []byte(crd), 0644); err != nil {
+		t.Fatalf("failed to write CRD: %v", err)
+	}
+
+	// validate also hydrates, so the kind needs a template
+	template := "resources:\n  - apiVersion: v1\n    kind: ConfigMap\n    metadata:\n      name: \"@expr(.metadata.name)\"\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	tests := []struct {
+		name     string
+		replicas int
+		wantErr  bool
+	}{
+		{name: "valid instance", replicas: 3},
+		{name: "schema violation", replicas: 50, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instancePath := filepath.Join(tempDir, "instance.yaml")
+			instance := fmt.Sprintf("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  replicas: %d\n", tt.replicas)
+			if err := os.WriteFile(instancePath, []byte(instance), 0644); err != nil {
+				t.Fatalf("failed to write instance: %v", err)
+			}
+
+			err := NewValidator(ValidatorOptions{
+				InputFiles: []string{instancePath},
+				CRDDir:     crdDir,
+			}).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateCheckWithoutTemplate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "commands-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	crdDir := filepath.Join(tempDir, "crds")
+	if err := os.MkdirAll(crdDir, 0755); err != nil {
+		t.Fatalf("failed to create crd dir: %v", err)
+	}
+
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: webservices.platform.example.com
+spec:
+  group: platform.example.com
+  names:
+    kind: WebService
+    plural: webservices
+  scope: Namespaced
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+        properties:
+          spec:
+            type: object
+            properties:
+              replicas:
+                type: integer
+                maximum: 10
+`
+	if err := os.WriteFile(filepath.Join(crdDir, "webservice.yaml"), []byte(crd), 0644); err != nil {
+		t.Fatalf("failed to write CRD: %v", err)
+	}
+
+	// No template exists for WebService
+	t.Chdir(tempDir)
+
+	tests := []struct {
+		name     string
+		instance string
+		check    bool
+		wantErr  bool
+	}{
+		{
+			name:     "valid instance",
+			instance: "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  replicas: 3\n",
+			check:    true,
+		},
+		{
+			name:     "schema violation",
+			instance: "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  replicas: 50\n",
+			check:    true,
+			wantErr:  true,
+		},
+		{
+			name:     "invalid name",
+			instance: "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: Not_Valid\nspec:\n  replicas: 3\n",
+			check:    true,
+			wantErr:  true,
+		},
+		{
+			name:     "hydrating needs a template",
+			instance: "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  replicas: 3\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instancePath := filepath.Join(tempDir, "instance.yaml")
+			if err := os.WriteFile(instancePath, []byte(tt.instance), 0644); err != nil {
+				t.Fatalf("failed to write instance: %v", err)
+			}
+
+			err := NewValidator(ValidatorOptions{
+				InputFiles: []string{instancePath},
+				CRDDir:     crdDir,
+				Check:      tt.check,
+			}).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCRDDirFlagDefault(t *testing.T) {
+	for _, cmd := range []*cobra.Command{BuildGenerateCommand(), BuildValidateCommand()} {
+		flag := cmd.Flags().Lookup("crd-dir")
+		if flag == nil {
+			t.Errorf("Expected %s command to have --crd-dir flag", cmd.Name())
+			continue
+		}
+		if flag.DefValue != DefaultCRDDir {
+			t.Errorf("Expected --crd-dir to default to '%s', got '%s'", DefaultCRDDir, flag.DefValue)
+		}
+	}
+}
+
+func TestBuildGenerateCommandCommonMetadataFlags(t *testing.T) {
+	cmd := BuildGenerateCommand()
+	if err := cmd.ParseFlags([]string{
+		"--common-labels", "team=platform,app.kubernetes.io/managed-by=krm-sdk",
+		"--common-annotations", "owner=$(.metadata.name)",
+	}); err != nil {
+		t.Fatalf("ParseFlags() error = %v", err)
+	}
+
+	labels, err := cmd.Flags().GetStringToString("common-labels")
+	if err != nil {
+		t.Fatalf("GetStringToString() error = %v", err)
+	}
+	expected := map[string]string{"team": "platform", "app.kubernetes.io/managed-by": "krm-sdk"}
+	if !reflect.DeepEqual(labels, expected) {
+		t.Errorf("Expected labels %v, got %v", expected, labels)
+	}
+
+	annotations, _ := cmd.Flags().GetStringToString("common-annotations")
+	if annotations["owner"] != "$(.metadata.name)" {
+		t.Errorf("Expected annotation expression to be kept verbatim, got %v", annotations)
+	}
+}
+
+func TestBaseDirFlagDefault(t *testing.T) {
+	for _, cmd := range []*cobra.Command{BuildGenerateCommand(), BuildApplyCommand()} {
+		flag := cmd.Flags().Lookup("base-dir")
+		if flag == nil {
+			t.Errorf("Expected %s command to have --base-dir flag", cmd.Name())
+			continue
+		}
+		// Without --base-dir the base is written to a temporary directory
+		if flag.DefValue != "" {
+			t.Errorf("Expected --base-dir to default to a temporary directory, got '%s'", flag.DefValue)
+		}
+	}
+}
+
+func TestBaseKustomizationFlags(t *testing.T) {
+	for _, cmd := range []*cobra.Command{BuildGenerateCommand(), BuildApplyCommand()} {
+		if err := cmd.ParseFlags([]string{"--base-namespace", "team-a", "--base-labels", "team=platform,tier=web"}); err != nil {
+			t.Fatalf("ParseFlags() error = %v", err)
+		}
+
+		namespace, _ := cmd.Flags().GetString("base-namespace")
+		if namespace != "team-a" {
+			t.Errorf("Expected %s --base-namespace team-a, got '%s'", cmd.Name(), namespace)
+		}
+		labels, _ := cmd.Flags().GetStringToString("base-labels")
+		expected := map[string]string{"team": "platform", "tier": "web"}
+		if !reflect.DeepEqual(labels, expected) {
+			t.Errorf("Expected %s --base-labels %v, got %v", cmd.Name(), expected, labels)
+		}
+	}
+}
+
+func TestValidateReportsAllFailures(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "commands-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	crdDir := filepath.Join(tempDir, "config", "crd")
+	if err := os.MkdirAll(crdDir, 0755); err != nil {
+		t.Fatalf("failed to create crd dir: %v", err)
+	}
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: webservices.platform.example.com
+spec:
+  group: platform.example.com
+  names:
+    kind: WebService
+    plural: webservices
+  scope: Namespaced
+  versions:
+  - name: v1alpha1
+    served: true
+    storage: true
+    schema:
+      openAPIV3Schema:
+        type: object
+`
+	if err := os.WriteFile(filepath.Join(crdDir, "webservice.yaml"), []byte(crd), 0644); err != nil {
+		t.Fatalf("failed to write CRD: %v", err)
+	}
+
+	template := "resources:\n  - apiVersion: v1\n    kind: ConfigMap\n    metadata:\n      name: \"@expr(.metadata.name)\"\n"
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := func(name string) string {
+		return "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: " + name + "\n"
+	}
+
+	inputDir := filepath.Join(tempDir, "instances")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("failed to create input dir: %v", err)
+	}
+	files := map[string]string{
+		"a.yaml": instance("valid-a") + "---\n" + instance("Invalid_A") + "---\n" + instance("also_invalid"),
+		"b.yaml": "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata: [\n",
+		"c.yaml": instance("Invalid_C"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	validPath := filepath.Join(tempDir, "valid.yaml")
+	if err := os.WriteFile(validPath, []byte(instance("valid-d")), 0644); err != nil {
+		t.Fatalf("failed to write instance: %v", err)
+	}
+
+	// Capture the combined report written to stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %v", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	err = NewValidator(ValidatorOptions{
+		InputFiles: []string{inputDir, validPath},
+	}).Validate()
+	os.Stderr = stderr
+	w.Close()
+	report, _ := io.ReadAll(r)
+
+	if err == nil || !strings.Contains(err.Error(), "validation failed for 4 instance(s)") {
+		t.Fatalf("Validate() error = %v, want 4 failures", err)
+	}
+	for _, want := range []string{
+		"a.yaml (document 2)",
+		"a.yaml (document 3)",
+		"b.yaml",
+		"c.yaml",
+	} {
+		if !strings.Contains(string(report), want) {
+			t.Errorf("Expected report to mention %s, got:\n%s", want, report)
+		}
+	}
+	if strings.Contains(string(report), "valid.yaml") || strings.Contains(string(report), "document 1") {
+		t.Errorf("Expected valid instances not to be reported, got:\n%s", report)
+	}
+}
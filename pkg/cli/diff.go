@@ -0,0 +1,261 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+// ErrDiffFound is returned by Diff with GeneratorOptions.DiffExitCode set
+// when the generated resources differ
+var ErrDiffFound = errors.New("generated resources differ")
+
+// ExitCode returns the process exit code for an error returned by a command:
+// 0 for none, 2 for ErrDiffFound, like diff(1) and kubectl diff, and 1 for
+// any other error
+func ExitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, ErrDiffFound):
+		return 2
+	default:
+		return 1
+	}
+}
+
+// Diff generates resources from the old and new instance files and writes a
+// unified diff of their YAML to w. Resources are aligned by identity, so
+// reordering does not show up as a change; added and removed resources are
+// diffed against /dev/null. With opts.DiffExitCode set, Diff returns
+// ErrDiffFound if any resource differs.
+func (g *Generator) Diff(oldPath, newPath string, opts GeneratorOptions, w io.Writer) error {
+	if opts.OutputDir != "" || opts.EmitKustomize != "" {
+		return fmt.Errorf("--diff cannot be combined with --output or --emit-kustomize")
+	}
+	opts.Incremental = false
+
+	oldResources, err := g.renderForDiff(oldPath, opts)
+	if err != nil {
+		return err
+	}
+	newResources, err := g.renderForDiff(newPath, opts)
+	if err != nil {
+		return err
+	}
+
+	changed := false
+	for _, key := range mergeKeys(oldResources.keys, newResources.keys) {
+		oldName, newName := "a/"+key, "b/"+key
+		oldYAML, inOld := oldResources.yaml[key]
+		newYAML, inNew := newResources.yaml[key]
+		if !inOld {
+			oldName = "/dev/null"
+		}
+		if !inNew {
+			newName = "/dev/null"
+		}
+
+		if diff := unifiedDiff(oldName, newName, oldYAML, newYAML); diff != "" {
+			fmt.Fprint(w, diff)
+			changed = true
+		}
+	}
+
+	if changed && opts.DiffExitCode {
+		return ErrDiffFound
+	}
+	return nil
+}
+
+// renderedResources holds the YAML of generated resources by identity, with
+// the identities in output order
+type renderedResources struct {
+	keys []string
+	yaml map[string]string
+}
+
+// renderForDiff generates the resources of a single instance file and renders
+// each one as YAML. Resources without an identity are keyed by position.
+func (g *Generator) renderForDiff(path string, opts GeneratorOptions) (*renderedResources, error) {
+	opts.InputFiles = []string{path}
+	resources, err := g.generateResources(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.SpecOnly {
+		resources = specOnly(resources)
+	}
+	if opts.TrimEmpty {
+		resources = trimEmpty(resources, opts.KeepEmpty)
+	}
+
+	rendered := &renderedResources{yaml: make(map[string]string, len(resources))}
+	for i, resource := range resources {
+		key, ok := hydrator.ResourceIdentity(resource)
+		if !ok {
+			key = fmt.Sprintf("resource #%d", i+1)
+		}
+
+		data, err := g.marshalResource(resource)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal resource: %w", err)
+		}
+
+		if _, seen := rendered.yaml[key]; !seen {
+			rendered.keys = append(rendered.keys, key)
+		}
+		rendered.yaml[key] = string(data)
+	}
+
+	return rendered, nil
+}
+
+// mergeKeys returns the keys of old followed by the keys only in new
+func mergeKeys(old, new []string) []string {
+	seen := make(map[string]bool, len(old))
+	keys := append([]string{}, old...)
+	for _, key := range old {
+		seen[key] = true
+	}
+	for _, key := range new {
+		if !seen[key] {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// diffLine is a single line of a diff, prefixed by ' ', '-' or '+'
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the unified diff between two texts, or "" if they are equal
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	lines := diffLines(splitLines(oldText), splitLines(newText))
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", oldName, newName)
+
+	// Line numbers before each entry of lines, in the old and new text
+	oldLine, newLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, line := range lines {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if line.op != '+' {
+			oldLine[i+1]++
+		}
+		if line.op != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	for start := 0; start < len(lines); {
+		// Find the next change and extend the hunk while changes are close together
+		first := start
+		for first < len(lines) && lines[first].op == ' ' {
+			first++
+		}
+		if first == len(lines) {
+			break
+		}
+		last := first
+		for next := first + 1; next < len(lines) && next <= last+2*diffContext; next++ {
+			if lines[next].op != ' ' {
+				last = next
+			}
+		}
+
+		from := first - diffContext
+		if from < start {
+			from = start
+		}
+		to := last + diffContext + 1
+		if to > len(lines) {
+			to = len(lines)
+		}
+
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[from], oldLine[to]-oldLine[from]),
+			hunkRange(newLine[from], newLine[to]-newLine[from]))
+		for _, line := range lines[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", line.op, line.text)
+		}
+
+		start = to
+	}
+
+	return out.String()
+}
+
+// hunkRange formats the range of a hunk starting after line before and
+// spanning count lines
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits text into lines without their trailing newlines
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a line diff of a and b from their longest common subsequence
+func diffLines(a, b []string) []diffLine {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			lines = append(lines, diffLine{' ', a[i]})
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			lines = append(lines, diffLine{'-', a[i]})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		lines = append(lines, diffLine{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		lines = append(lines, diffLine{'+', b[j]})
+	}
+	return lines
+}
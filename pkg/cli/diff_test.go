@@ -0,0 +1,196 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		oldText  string
+		newText  string
+		expected string
+	}{
+		{
+			name:     "equal",
+			oldText:  "a\nb\n",
+			newText:  "a\nb\n",
+			expected: "",
+		},
+		{
+			name:     "changed line with context",
+			oldText:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			newText:  "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: "--- old\n+++ new\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:     "distant changes get separate hunks",
+			oldText:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			newText:  "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\n",
+			expected: "--- old\n+++ new\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,4 @@\n 7\n 8\n 9\n-10\n+ten\n",
+		},
+		{
+			name:     "added text",
+			oldText:  "",
+			newText:  "a\nb\n",
+			expected: "--- old\n+++ new\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name:     "removed text",
+			oldText:  "a\n",
+			newText:  "",
+			expected: "--- old\n+++ new\n@@ -1,1 +0,0 @@\n-a\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := unifiedDiff("old", "new", tt.oldText, tt.newText); got != tt.expected {
+				t.Errorf("unifiedDiff() =\n%s\nwant:\n%s", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateDiff(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      replicas: "@expr(.spec.replicas)"
+      template:
+        spec:
+          containers:
+            - name: app
+              image: nginx
+  - "@if(.spec.expose)":
+      apiVersion: v1
+      kind: Service
+      metadata:
+        name: "@expr(.metadata.name)"
+  - "@if(!.spec.expose)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	oldPath := filepath.Join(tempDir, "old.yaml")
+	newPath := filepath.Join(tempDir, "new.yaml")
+	instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  replicas: %d\n  expose: %t\n"
+	if err := os.WriteFile(oldPath, []byte(fmt.Sprintf(instance, 2, false)), 0644); err != nil {
+		t.Fatalf("failed to write old instance: %v", err)
+	}
+	if err := os.WriteFile(newPath, []byte(fmt.Sprintf(instance, 3, true)), 0644); err != nil {
+		t.Fatalf("failed to write new instance: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	var out strings.Builder
+	opts := GeneratorOptions{}
+	if err := NewGenerator(opts).Diff(oldPath, newPath, opts, &out); err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	diff := out.String()
+
+	for _, want := range []string{
+		"--- a/apps/v1/Deployment/app\n+++ b/apps/v1/Deployment/app\n",
+		"-  replicas: 2\n+  replicas: 3\n",
+		"--- a/v1/ConfigMap/app\n+++ /dev/null\n",
+		"--- /dev/null\n+++ b/v1/Service/app\n",
+	} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("Expected diff to contain %q, got:\n%s", want, diff)
+		}
+	}
+	// Unchanged lines far from the change are left out
+	if strings.Contains(diff, "image: nginx") {
+		t.Errorf("Expected a focused diff without distant context, got:\n%s", diff)
+	}
+
+	// Identical inputs produce no output
+	out.Reset()
+	if err := NewGenerator(opts).Diff(oldPath, oldPath, opts, &out); err != nil {
+		t.Fatalf("Diff() error = %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("Expected no diff for identical inputs, got:\n%s", out.String())
+	}
+}
+
+func TestDiffExitCode(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      size: "@expr(.spec.size)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "cache_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	instances := map[string]string{
+		"small.yaml": "apiVersion: platform.example.com/v1alpha1\nkind: Cache\nmetadata:\n  name: cache\nspec:\n  size: small\n",
+		// The same instance with its fields reordered generates the same resources
+		"reordered.yaml": "kind: Cache\napiVersion: platform.example.com/v1alpha1\nspec:\n  size: small\nmetadata:\n  name: cache\n",
+		"large.yaml":     "apiVersion: platform.example.com/v1alpha1\nkind: Cache\nmetadata:\n  name: cache\nspec:\n  size: large\n",
+	}
+	for name, content := range instances {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	t.Chdir(tempDir)
+
+	tests := []struct {
+		name         string
+		oldPath      string
+		newPath      string
+		diffExitCode bool
+		expected     int
+	}{
+		{name: "no differences", oldPath: "small.yaml", newPath: "reordered.yaml", diffExitCode: true, expected: 0},
+		{name: "differences", oldPath: "small.yaml", newPath: "large.yaml", diffExitCode: true, expected: 2},
+		{name: "differences without --diff-exit-code", oldPath: "small.yaml", newPath: "large.yaml", expected: 0},
+		{name: "error", oldPath: "small.yaml", newPath: "missing.yaml", diffExitCode: true, expected: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			opts := GeneratorOptions{DiffExitCode: tt.diffExitCode}
+			err := NewGenerator(opts).Diff(tt.oldPath, tt.newPath, opts, &out)
+			if got := ExitCode(err); got != tt.expected {
+				t.Errorf("ExitCode() = %d, want %d (error: %v)", got, tt.expected, err)
+			}
+			if tt.expected == 2 && !strings.Contains(out.String(), "-  size: small\n+  size: large\n") {
+				t.Errorf("Expected the diff to be printed, got:\n%s", out.String())
+			}
+		})
+	}
+
+	if got := ExitCode(fmt.Errorf("failed to generate: %w", ErrDiffFound)); got != 2 {
+		t.Errorf("ExitCode() of a wrapped ErrDiffFound = %d, want 2", got)
+	}
+}
@@ -1,54 +1,342 @@
 package cli
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
 	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
 	"github.com/zachaller/k8s-client-api-builder/pkg/overlay"
 	"github.com/zachaller/k8s-client-api-builder/pkg/validation"
+	yamlv3 "gopkg.in/yaml.v3"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/yaml"
 )
 
+// StdinPath is the input path that reads instances from stdin
+const StdinPath = "-"
+
+// DefaultCRDDir is where validation looks for CRD schemas by default
+const DefaultCRDDir = "config/crd"
+
+// FreezeTimeEnv names the environment variable read as --freeze-time when the
+// flag is not set
+const FreezeTimeEnv = "KRM_FREEZE_TIME"
+
+// Profile phases recorded by the generator, in addition to the hydrator's
+const (
+	// PhaseKustomize covers writing the base and building the overlay
+	PhaseKustomize = "kustomize"
+	// PhaseOutput covers writing or printing the generated resources
+	PhaseOutput = "output"
+)
+
+// EmittedOverlays are the overlays scaffolded by --emit-kustomize
+var EmittedOverlays = []string{"dev", "staging", "prod"}
+
+// MinYAMLIndent and MaxYAMLIndent bound the indents --yaml-indent accepts
+const (
+	MinYAMLIndent = 2
+	MaxYAMLIndent = 9
+)
+
+// DefaultBaseDir is where overlays reference generated resources as their
+// kustomize base. Without --base-dir the base is written to a temporary
+// directory that overlays see at this path.
+const DefaultBaseDir = "base"
+
 // Generator handles resource generation
 type Generator struct {
 	validator *validation.Validator
 	hydrator  *hydrator.Hydrator
+	stdin     io.Reader
+	stderr    io.Writer
 	verbose   bool
+
+	// profile is only set when profiling
+	profile *hydrator.Profile
+
+	// report is only set when reporting
+	report *Report
+
+	// yamlIndent is the output indent in spaces; 0 keeps the default formatting
+	yamlIndent int
+
+	// yamlStyle is YAMLStyleBlock or YAMLStyleFlow; empty means block
+	yamlStyle string
+
+	// checkedTemplates holds the templates already checked by --validate-references
+	checkedTemplates map[string]bool
+
+	// notes holds the rendered notes of each instance for --show-notes
+	notes []string
+
+	// comments holds the comments of the template each resource was generated
+	// from, by resource identity; only set with --preserve-comments
+	comments map[string]hydrator.TemplateComments
+
+	// manifest and outputs are only set in incremental mode
+	manifest *incrementalManifest
+	outputs  []instanceOutput
 }
 
+// Output layouts supported when writing resources to an output directory
+const (
+	// OutputLayoutFlat writes every resource to <outputDir>/<kind>-<name>.yaml
+	OutputLayoutFlat = "flat"
+	// OutputLayoutByKind writes every resource to <outputDir>/<kind>/<name>.yaml
+	OutputLayoutByKind = "by-kind"
+)
+
+// YAML styles supported for generated output
+const (
+	// YAMLStyleBlock writes maps and lists one entry per line
+	YAMLStyleBlock = "block"
+	// YAMLStyleFlow writes every map and list inline, as in {name: web, ports: [80]}
+	YAMLStyleFlow = "flow"
+)
+
 // GeneratorOptions contains options for the generator
 type GeneratorOptions struct {
-	InputFiles []string
-	OutputDir  string
-	Overlay    string
-	Validate   bool
-	DryRun     bool
-	Verbose    bool
+	InputFiles         []string
+	OutputDir          string
+	OutputLayout       string
+	Overlay            string
+	BaseDir            string
+	BaseNamespace      string
+	BaseLabels         map[string]string
+	EmitKustomize      string
+	JSONPatch          string
+	ValuesFile         string
+	SecretsFile        string
+	CRDDir             string
+	CommonLabels       map[string]string
+	CommonAnnotations  map[string]string
+	LabelsFrom         []string
+	Namespace          string
+	ClusterScopedKinds []string
+	ExpandGenerateName bool
+	AllowDuplicates    bool
+	Incremental        bool
+	Clean              bool
+	Validate           bool
+	ValidateReferences bool
+	DryRun             bool
+	Verbose            bool
+	SortOutput         bool
+	SpecOnly           bool
+	TrimEmpty          bool
+	KeepEmpty          []string
+	YAMLIndent         int
+	YAMLStyle          string
+	MaxDepth           int
+	StrictLoops        bool
+	NilMissingFields   bool
+	OnUnresolved       string
+	Placeholder        string
+	FreezeTime         string
+	Profile            bool
+	Report             bool
+	ShowNotes          bool
+	PreserveComments   bool
+	DiffExitCode       bool
+
+	// PostProcessors are applied to hydrated resources of the matching kind
+	PostProcessors map[string]PostProcessor
+}
+
+// PostProcessor rewrites a hydrated resource before it is emitted, for
+// example to sort a Deployment's environment variables. Returning nil or an
+// empty map drops the resource from the output.
+type PostProcessor func(resource map[string]interface{}) (map[string]interface{}, error)
+
+// postProcessors holds the post-processors registered by the project
+var postProcessors = map[string]PostProcessor{}
+
+// RegisterPostProcessor registers a post-processor for resources of kind that
+// the generate and apply commands run after hydration. Generated projects call
+// it before executing the root command.
+func RegisterPostProcessor(kind string, processor PostProcessor) {
+	postProcessors[kind] = processor
 }
 
 // NewGenerator creates a new generator
 func NewGenerator(opts GeneratorOptions) *Generator {
 	return &Generator{
-		validator: validation.NewValidator("config/crd", opts.Verbose),
-		hydrator:  hydrator.NewHydrator("", opts.Verbose),
-		verbose:   opts.Verbose,
+		validator:  validation.NewValidator(crdDirOrDefault(opts.CRDDir), opts.Verbose),
+		hydrator:   hydrator.NewHydrator("", opts.Verbose),
+		stdin:      os.Stdin,
+		stderr:     os.Stderr,
+		verbose:    opts.Verbose,
+		yamlIndent: opts.YAMLIndent,
+		yamlStyle:  opts.YAMLStyle,
 	}
 }
 
+// crdDirOrDefault returns crdDir, or DefaultCRDDir if it is empty
+func crdDirOrDefault(crdDir string) string {
+	if crdDir == "" {
+		return DefaultCRDDir
+	}
+	return crdDir
+}
+
 // Generate processes input files and generates K8s resources
-func (g *Generator) Generate(opts GeneratorOptions) error {
+func (g *Generator) Generate(opts GeneratorOptions) (err error) {
+	// yaml.v3 silently writes any other indent as 2 spaces
+	if opts.YAMLIndent != 0 && (opts.YAMLIndent < MinYAMLIndent || opts.YAMLIndent > MaxYAMLIndent) {
+		return fmt.Errorf("--yaml-indent must be between %d and %d, or 0 for the default formatting, got %d", MinYAMLIndent, MaxYAMLIndent, opts.YAMLIndent)
+	}
+	if opts.YAMLStyle != "" && opts.YAMLStyle != YAMLStyleBlock && opts.YAMLStyle != YAMLStyleFlow {
+		return fmt.Errorf("unknown --yaml-style '%s' (expected '%s' or '%s')", opts.YAMLStyle, YAMLStyleBlock, YAMLStyleFlow)
+	}
+	if opts.MaxDepth < 0 {
+		return fmt.Errorf("--max-depth must not be negative, got %d", opts.MaxDepth)
+	}
+	if opts.EmitKustomize != "" && (opts.Overlay != "" || opts.OutputDir != "") {
+		return fmt.Errorf("--emit-kustomize cannot be combined with --overlay or --output")
+	}
+	if opts.JSONPatch != "" && (opts.EmitKustomize != "" || opts.OutputDir != "") {
+		return fmt.Errorf("--json-patch cannot be combined with --emit-kustomize or --output")
+	}
+	if opts.Clean && (opts.OutputDir == "" || opts.Incremental) {
+		return fmt.Errorf("--clean requires --output and cannot be combined with --incremental")
+	}
+
+	if opts.Profile {
+		g.profile = hydrator.NewProfile()
+		g.hydrator.SetProfile(g.profile)
+		defer g.profile.Write(g.stderr)
+	}
+	if opts.Report {
+		g.report = NewReport()
+		defer func() {
+			if err == nil {
+				err = g.report.Write(g.stderr)
+			}
+		}()
+	}
+
+	if opts.ShowNotes {
+		defer func() {
+			if err == nil {
+				g.writeNotes()
+			}
+		}()
+	}
+
+	allResources, err := g.generateResources(opts)
+	if err != nil {
+		return err
+	}
+
+	if opts.SpecOnly {
+		allResources = specOnly(allResources)
+	}
+	if opts.TrimEmpty {
+		allResources = trimEmpty(allResources, opts.KeepEmpty)
+	}
+	g.report.AddResources(allResources)
+
+	// Output resources
+	defer g.profile.Track(PhaseOutput, time.Now())
+	if opts.JSONPatch != "" {
+		return writeJSONPatches(allResources, opts.JSONPatch, opts.Namespace, g.hydrator.IsClusterScoped, os.Stdout)
+	}
+	if opts.EmitKustomize != "" {
+		return g.emitKustomize(allResources, opts)
+	}
+	if opts.OutputDir != "" {
+		if err := g.writeResources(allResources, opts.OutputDir, opts.OutputLayout); err != nil {
+			return err
+		}
+		if opts.Clean {
+			return g.cleanOutputDir(allResources, opts.OutputDir, opts.OutputLayout)
+		}
+		if g.manifest != nil {
+			return g.saveManifest(allResources, opts.OutputDir, opts.OutputLayout)
+		}
+		return nil
+	}
+
+	return g.printResources(allResources, os.Stdout)
+}
+
+// generateResources hydrates all input files and applies the overlay, if any
+func (g *Generator) generateResources(opts GeneratorOptions) ([]map[string]interface{}, error) {
 	// Load validation schemas if validation is enabled
 	if opts.Validate {
 		if g.verbose {
-			fmt.Println("Loading validation schemas...")
+			fmt.Fprintln(g.stderr, "Loading validation schemas...")
 		}
 		if err := g.validator.LoadSchemas(); err != nil {
-			fmt.Printf("Warning: failed to load schemas: %v\n", err)
+			g.warnf("failed to load schemas: %v", err)
+		}
+	}
+
+	// Load external values exposed to templates as $values
+	if opts.ValuesFile != "" {
+		values, err := loadValues(opts.ValuesFile)
+		if err != nil {
+			return nil, err
+		}
+		g.hydrator.SetValues(values)
+	}
+
+	// Load local secret values read with secret()
+	if opts.SecretsFile != "" {
+		secrets, err := loadSecrets(opts.SecretsFile)
+		if err != nil {
+			return nil, err
+		}
+		g.hydrator.SetSecretResolver(secrets)
+	}
+
+	g.hydrator.SetVerboseOutput(g.stderr)
+	g.hydrator.SetExpandGenerateName(opts.ExpandGenerateName)
+	g.hydrator.SetMaxDepth(opts.MaxDepth)
+	g.hydrator.SetStrictLoops(opts.StrictLoops)
+	g.hydrator.SetNilMissingFields(opts.NilMissingFields)
+	if err := g.hydrator.SetOnUnresolved(opts.OnUnresolved, opts.Placeholder); err != nil {
+		return nil, err
+	}
+	clock, err := frozenClock(opts.FreezeTime)
+	if err != nil {
+		return nil, err
+	}
+	g.hydrator.SetClock(clock)
+	g.hydrator.SetCommonLabels(opts.CommonLabels)
+	g.hydrator.SetCommonAnnotations(opts.CommonAnnotations)
+	if err := g.hydrator.SetLabelsFrom(opts.LabelsFrom); err != nil {
+		return nil, err
+	}
+	g.hydrator.SetNamespace(opts.Namespace)
+	g.hydrator.AddClusterScopedKinds(opts.ClusterScopedKinds...)
+	if opts.PreserveComments {
+		g.hydrator.SetPreserveComments(true)
+		g.comments = map[string]hydrator.TemplateComments{}
+	}
+
+	// Incremental mode tracks outputs per instance in the output directory.
+	// Overlays transform the combined output, so they always regenerate.
+	if opts.Incremental {
+		if opts.OutputDir == "" || opts.Overlay != "" {
+			g.warnf("--incremental requires --output and no --overlay, generating everything")
+		} else {
+			manifest, err := loadIncrementalManifest(opts.OutputDir)
+			if err != nil {
+				return nil, err
+			}
+			g.manifest = manifest
 		}
 	}
 
@@ -57,28 +345,52 @@ func (g *Generator) Generate(opts GeneratorOptions) error {
 
 	for _, inputPath := range opts.InputFiles {
 		if g.verbose {
-			fmt.Printf("Processing: %s\n", inputPath)
+			fmt.Fprintf(g.stderr, "Processing: %s\n", inputPath)
 		}
 
 		resources, err := g.processFile(inputPath, opts)
 		if err != nil {
-			return fmt.Errorf("failed to process %s: %w", inputPath, err)
+			return nil, fmt.Errorf("failed to process %s: %w", inputPath, err)
 		}
 
 		allResources = append(allResources, resources...)
 	}
 
+	// Two resources with the same identity would silently overwrite each other on apply
+	if duplicates := hydrator.DuplicateResources(allResources); len(duplicates) > 0 {
+		if !opts.AllowDuplicates {
+			return nil, fmt.Errorf("duplicate resources generated (use --allow-duplicates to ignore):\n  %s", strings.Join(duplicates, "\n  "))
+		}
+		for _, duplicate := range duplicates {
+			g.warnf("duplicate resource %s", duplicate)
+		}
+	}
+
 	// Apply kustomize overlay if specified
 	if opts.Overlay != "" {
 		if g.verbose {
-			fmt.Printf("Applying overlay: %s\n", opts.Overlay)
+			fmt.Fprintf(g.stderr, "Applying overlay: %s\n", opts.Overlay)
 		}
 
-		kustomizer := overlay.NewKustomizeEngine("base", "overlays", opts.Verbose)
+		var kustomizer *overlay.KustomizeEngine
+		if opts.BaseDir != "" {
+			// An explicit base directory is kept for later runs and other tools
+			kustomizer = overlay.NewKustomizeEngine(opts.BaseDir, "overlays", opts.Verbose)
+			kustomizer.SetPersistentBase(true)
+		} else {
+			kustomizer = overlay.NewKustomizeEngine(DefaultBaseDir, "overlays", opts.Verbose)
+			if err := kustomizer.UseTempBase(); err != nil {
+				return nil, err
+			}
+		}
+		kustomizer.SetBaseNamespace(opts.BaseNamespace)
+		kustomizer.SetBaseLabels(opts.BaseLabels)
 
 		// Write base resources
+		start := time.Now()
 		if err := kustomizer.WriteBase(allResources); err != nil {
-			return fmt.Errorf("failed to write base: %w", err)
+			kustomizer.Cleanup()
+			return nil, fmt.Errorf("failed to write base: %w", err)
 		}
 
 		// Apply kustomize overlay
@@ -86,29 +398,278 @@ func (g *Generator) Generate(opts GeneratorOptions) error {
 		if err != nil {
 			// Clean up base directory
 			kustomizer.Cleanup()
-			return fmt.Errorf("failed to apply overlay '%s': %w", opts.Overlay, err)
+			return nil, fmt.Errorf("failed to apply overlay '%s': %w", opts.Overlay, err)
 		}
 
 		// Clean up base directory
 		defer kustomizer.Cleanup()
+		g.profile.Track(PhaseKustomize, start)
 
 		allResources = kustomized
 
 		if g.verbose {
-			fmt.Printf("✓ Applied overlay: %s\n", opts.Overlay)
+			fmt.Fprintf(g.stderr, "✓ Applied overlay: %s\n", opts.Overlay)
 		}
 	}
 
-	// Output resources
-	if opts.OutputDir != "" {
-		return g.writeResources(allResources, opts.OutputDir)
+	if opts.SortOutput {
+		sortResources(allResources)
+	}
+	if err := sortByWeight(allResources); err != nil {
+		return nil, err
 	}
 
-	return g.printResources(allResources, os.Stdout)
+	return allResources, nil
+}
+
+// specOnlyFields are the top-level fields kept by --spec-only
+var specOnlyFields = []string{"apiVersion", "kind", "metadata", "spec"}
+
+// removeEmptyResources drops nil and empty resources, such as ones a
+// post-processor cleared, which would otherwise be emitted as empty documents
+func removeEmptyResources(resources []map[string]interface{}) []map[string]interface{} {
+	kept := make([]map[string]interface{}, 0, len(resources))
+	for _, resource := range resources {
+		if len(resource) > 0 {
+			kept = append(kept, resource)
+		}
+	}
+	return kept
+}
+
+// specOnly reduces each resource to its apiVersion, kind, metadata and spec,
+// dropping status and any other top-level fields
+func specOnly(resources []map[string]interface{}) []map[string]interface{} {
+	reduced := make([]map[string]interface{}, len(resources))
+	for i, resource := range resources {
+		reduced[i] = make(map[string]interface{}, len(specOnlyFields))
+		for _, field := range specOnlyFields {
+			if value, ok := resource[field]; ok {
+				reduced[i][field] = value
+			}
+		}
+	}
+	return reduced
+}
+
+// keepEmptyFields are the fields --trim-empty keeps as written, because an
+// empty value means something, e.g. an emptyDir volume or a NetworkPolicy
+// podSelector that selects every pod
+var keepEmptyFields = []string{"emptyDir", "podSelector", "namespaceSelector", "ingress", "egress"}
+
+// trimEmpty recursively removes empty maps and lists from each resource,
+// except under keepEmptyFields and the extra field names in keep
+func trimEmpty(resources []map[string]interface{}, keep []string) []map[string]interface{} {
+	kept := make(map[string]bool, len(keepEmptyFields)+len(keep))
+	for _, field := range append(append([]string{}, keepEmptyFields...), keep...) {
+		kept[field] = true
+	}
+
+	trimmed := make([]map[string]interface{}, len(resources))
+	for i, resource := range resources {
+		value, _ := trimEmptyValue(resource, kept)
+		trimmed[i] = value.(map[string]interface{})
+	}
+	return trimmed
+}
+
+// trimEmptyValue returns a copy of value without empty maps and lists, and
+// whether the copy itself is an empty map or list
+func trimEmptyValue(value interface{}, kept map[string]bool) (interface{}, bool) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			if kept[key] {
+				result[key] = val
+				continue
+			}
+			if trimmed, empty := trimEmptyValue(val, kept); !empty {
+				result[key] = trimmed
+			}
+		}
+		return result, len(result) == 0
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			if trimmed, empty := trimEmptyValue(item, kept); !empty {
+				result = append(result, trimmed)
+			}
+		}
+		return result, len(result) == 0
+	default:
+		return v, false
+	}
+}
+
+// emitKustomize writes resources as a kustomize base under opts.EmitKustomize along with
+// empty overlays that reference it, ready for further editing
+func (g *Generator) emitKustomize(resources []map[string]interface{}, opts GeneratorOptions) error {
+	dir := opts.EmitKustomize
+	kustomizer := overlay.NewKustomizeEngine(filepath.Join(dir, DefaultBaseDir), filepath.Join(dir, "overlays"), g.verbose)
+	kustomizer.SetBaseNamespace(opts.BaseNamespace)
+	kustomizer.SetBaseLabels(opts.BaseLabels)
+
+	// Existing overlays are checked first, and WriteBase refuses an existing
+	// base before writing, so a failed run leaves nothing behind to block a rerun
+	if err := kustomizer.CheckOverlays(EmittedOverlays...); err != nil {
+		return fmt.Errorf("failed to write overlays: %w", err)
+	}
+	if err := kustomizer.WriteBase(resources); err != nil {
+		return fmt.Errorf("failed to write base: %w", err)
+	}
+	if err := kustomizer.WriteOverlays(EmittedOverlays...); err != nil {
+		return fmt.Errorf("failed to write overlays: %w", err)
+	}
+
+	if g.verbose {
+		fmt.Printf("✓ Wrote kustomize base and overlays to %s\n", dir)
+	}
+	return nil
+}
+
+// sortResources orders resources by kind, namespace and name so the output
+// does not depend on the order input files were read in
+func sortResources(resources []map[string]interface{}) {
+	sort.SliceStable(resources, func(i, j int) bool {
+		a, b := resourceSortKey(resources[i]), resourceSortKey(resources[j])
+		for k := range a {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return false
+	})
+}
+
+// WeightAnnotation orders generated resources: lower weights are emitted
+// first and resources without it have weight 0. It is removed from the output.
+const WeightAnnotation = "krm.sdk/weight"
+
+// sortByWeight stably orders resources by their WeightAnnotation, so resources
+// of equal weight keep their generated or --sort-output order, and strips the
+// annotation
+func sortByWeight(resources []map[string]interface{}) error {
+	type weighted struct {
+		resource map[string]interface{}
+		weight   int
+	}
+
+	sorted := make([]weighted, len(resources))
+	for i, resource := range resources {
+		weight, err := resourceWeight(resource)
+		if err != nil {
+			return err
+		}
+		sorted[i] = weighted{resource, weight}
+	}
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].weight < sorted[j].weight
+	})
+	for i := range sorted {
+		resources[i] = sorted[i].resource
+	}
+	return nil
+}
+
+// resourceWeight removes the WeightAnnotation from resource and returns its
+// value, or 0 if it is not set
+func resourceWeight(resource map[string]interface{}) (int, error) {
+	metadata, ok := resource["metadata"].(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+	annotations, ok := metadata["annotations"].(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+	value, ok := annotations[WeightAnnotation]
+	if !ok {
+		return 0, nil
+	}
+
+	delete(annotations, WeightAnnotation)
+	if len(annotations) == 0 {
+		delete(metadata, "annotations")
+	}
+
+	weight, err := strconv.Atoi(fmt.Sprintf("%v", value))
+	if err != nil {
+		key, _ := hydrator.ResourceIdentity(resource)
+		return 0, fmt.Errorf("invalid %s annotation %q on %s: must be an integer", WeightAnnotation, value, key)
+	}
+	return weight, nil
+}
+
+// resourceSortKey returns the kind, namespace and name of a resource
+func resourceSortKey(resource map[string]interface{}) [3]string {
+	var key [3]string
+	key[0], _ = resource["kind"].(string)
+	if metadata, ok := resource["metadata"].(map[string]interface{}); ok {
+		key[1], _ = metadata["namespace"].(string)
+		key[2], _ = metadata["name"].(string)
+	}
+	return key
+}
+
+// loadValues reads a values file into a map
+func loadValues(path string) (map[string]interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read values file: %w", err)
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("failed to parse values file %s: %w", path, err)
+	}
+
+	return values, nil
+}
+
+// loadSecrets reads a secrets file mapping secret names to keys to values
+func loadSecrets(path string) (dsl.SecretMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	secrets := dsl.SecretMap{}
+	if err := yaml.UnmarshalStrict(data, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets file %s (expected a map of secret name to key to value): %w", path, err)
+	}
+
+	return secrets, nil
+}
+
+// frozenClock returns a clock that always reads the RFC 3339 time freezeTime,
+// or the time in $KRM_FREEZE_TIME when freezeTime is empty. It returns nil,
+// the real clock, when neither is set.
+func frozenClock(freezeTime string) (func() time.Time, error) {
+	if freezeTime == "" {
+		freezeTime = os.Getenv(FreezeTimeEnv)
+	}
+	if freezeTime == "" {
+		return nil, nil
+	}
+
+	frozen, err := time.Parse(time.RFC3339, freezeTime)
+	if err != nil {
+		return nil, fmt.Errorf("invalid freeze time '%s' (expected RFC 3339, e.g. 2024-01-02T15:04:05Z): %w", freezeTime, err)
+	}
+	return func() time.Time { return frozen }, nil
 }
 
 // processFile processes a single input file
 func (g *Generator) processFile(path string, opts GeneratorOptions) ([]map[string]interface{}, error) {
+	// "-" reads one or more instances from stdin
+	if path == StdinPath {
+		resources, err := g.processReader(g.stdin, opts)
+		g.trackOutputs(path, resources, nil, opts)
+		return resources, err
+	}
+
 	// Check if path is a directory
 	info, err := os.Stat(path)
 	if err != nil {
@@ -125,44 +686,325 @@ func (g *Generator) processFile(path string, opts GeneratorOptions) ([]map[strin
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	// Parse YAML
 	var instance map[string]interface{}
-	if err := yaml.Unmarshal(data, &instance); err != nil {
+	if isJSONFile(path) {
+		if instance, err = decodeJSONInstance(data); err != nil {
+			return nil, err
+		}
+	} else if instance, err = decodeYAMLInstance(data); err != nil {
+		return nil, err
+	}
+
+	resources, inputs, err := g.processInstance(instance, opts)
+	g.trackOutputs(path, resources, inputs, opts)
+	return resources, err
+}
+
+// processReader processes every instance document read from r
+func (g *Generator) processReader(r io.Reader, opts GeneratorOptions) ([]map[string]interface{}, error) {
+	instances, err := readInstances(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var allResources []map[string]interface{}
+	for _, instance := range instances {
+		resources, _, err := g.processInstance(instance, opts)
+		if err != nil {
+			return nil, err
+		}
+		allResources = append(allResources, resources...)
+	}
+
+	return allResources, nil
+}
+
+// readInstances decodes all YAML (or JSON) documents from r, skipping empty documents.
+// Whole numbers are kept as int64, like in JSON instances, so large integers
+// do not lose precision in float64.
+func readInstances(r io.Reader) ([]map[string]interface{}, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+
+	var instances []map[string]interface{}
+	for {
+		// Each document is converted to JSON, then decoded with exact numbers
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+
+		instance, err := decodeJSONObject(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		if len(instance) == 0 {
+			continue
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances, nil
+}
+
+// isInstanceFile reports whether a file in an input directory holds instances
+func isInstanceFile(name string) bool {
+	return strings.HasSuffix(name, ".yaml") || strings.HasSuffix(name, ".yml") || isJSONFile(name)
+}
+
+// isJSONFile reports whether an instance file is JSON rather than YAML
+func isJSONFile(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".json")
+}
+
+// decodeJSONInstance decodes a JSON instance, keeping whole numbers as int64
+// so they match what templates expect of integer fields like replicas
+func decodeJSONInstance(data []byte) (map[string]interface{}, error) {
+	instance, err := decodeJSONObject(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JSON: %w", err)
+	}
+	return instance, nil
+}
+
+// decodeYAMLInstance decodes a YAML instance like decodeJSONInstance, so
+// whole numbers are kept as int64 rather than float64
+func decodeYAMLInstance(data []byte) (map[string]interface{}, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
-	// Validate if requested
-	if opts.Validate {
-		result, err := g.validator.Validate(instance)
+	instance, err := decodeJSONObject(jsonData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	return instance, nil
+}
+
+// decodeJSONObject decodes a single JSON object, keeping whole numbers as
+// int64 and other numbers as float64 rather than converting every number to
+// float64, so large integers do not lose precision
+func decodeJSONObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var object map[string]interface{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("unexpected data after the object")
+	}
+
+	overlay.NormalizeNumbers(object)
+	return object, nil
+}
+
+// inputDocuments holds the instances read from one input file, or the error
+// that prevented reading them
+type inputDocuments struct {
+	path      string
+	instances []map[string]interface{}
+	err       error
+}
+
+// readInputDocuments reads every instance document of an input file, of each
+// YAML or JSON file in an input directory, or of stdin for "-". A file that cannot be
+// read or parsed does not stop the others from being read.
+func readInputDocuments(path string, stdin io.Reader) ([]inputDocuments, error) {
+	if path == StdinPath {
+		instances, err := readInstances(stdin)
+		return []inputDocuments{{path: path, instances: instances, err: err}}, nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := []string{path}
+	if info.IsDir() {
+		files, err := ioutil.ReadDir(path)
 		if err != nil {
-			return nil, fmt.Errorf("validation error: %w", err)
+			return nil, err
 		}
 
-		if !result.Valid {
-			return nil, fmt.Errorf("validation failed:\n  %s", strings.Join(result.Errors, "\n  "))
+		paths = nil
+		for _, file := range files {
+			if file.IsDir() {
+				continue
+			}
+			if !isInstanceFile(file.Name()) {
+				continue
+			}
+			paths = append(paths, filepath.Join(path, file.Name()))
 		}
+	}
 
-		if g.verbose {
-			fmt.Println("✓ Validation passed")
+	var inputs []inputDocuments
+	for _, p := range paths {
+		if isJSONFile(p) {
+			data, err := ioutil.ReadFile(p)
+			if err != nil {
+				inputs = append(inputs, inputDocuments{path: p, err: fmt.Errorf("failed to read file: %w", err)})
+				continue
+			}
+			instance, err := decodeJSONInstance(data)
+			if err != nil {
+				inputs = append(inputs, inputDocuments{path: p, err: err})
+				continue
+			}
+			inputs = append(inputs, inputDocuments{path: p, instances: []map[string]interface{}{instance}})
+			continue
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			inputs = append(inputs, inputDocuments{path: p, err: fmt.Errorf("failed to read file: %w", err)})
+			continue
+		}
+		instances, err := readInstances(f)
+		f.Close()
+		inputs = append(inputs, inputDocuments{path: p, instances: instances, err: err})
+	}
+
+	return inputs, nil
+}
+
+// processInstance checks the structure of a single instance, validates it
+// against its schema (optionally) and hydrates it. It also returns the
+// template files the resources were generated from.
+func (g *Generator) processInstance(instance map[string]interface{}, opts GeneratorOptions) ([]map[string]interface{}, []string, error) {
+	// Structural checks run even without --validate or CRD schemas
+	if err := validation.ValidateStructure(instance); err != nil {
+		return nil, nil, err
+	}
+
+	// Validate if requested
+	if opts.Validate {
+		if err := validateSchema(g.validator, instance, g.verbose); err != nil {
+			return nil, nil, err
 		}
 	}
 
+	if opts.ValidateReferences {
+		g.validateReferences(instance)
+	}
+
 	// Hydrate
 	hydrateResult, err := g.hydrator.Hydrate(instance)
 	if err != nil {
-		return nil, fmt.Errorf("hydration error: %w", err)
+		return nil, nil, fmt.Errorf("hydration error: %w", err)
 	}
 
-	if len(hydrateResult.Errors) > 0 {
-		for _, err := range hydrateResult.Errors {
-			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	for _, err := range hydrateResult.Errors {
+		g.warnf("%v", err)
+	}
+	g.report.AddInstance()
+	if opts.ShowNotes && hydrateResult.Notes != "" {
+		g.notes = append(g.notes, formatNotes(instance, hydrateResult.Notes))
+	}
+	resources, err := postProcess(hydrateResult.Resources, opts.PostProcessors)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Each resource keeps the comments of its own template
+	if g.comments != nil {
+		for _, resource := range resources {
+			if identity, ok := hydrator.ResourceIdentity(resource); ok {
+				g.comments[identity] = hydrateResult.Comments
+			}
+		}
+	}
+
+	return resources, hydrateResult.Files, nil
+}
+
+// validateSchema validates instance against its CRD schema
+func validateSchema(validator *validation.Validator, instance map[string]interface{}, verbose bool) error {
+	result, err := validator.Validate(instance)
+	if err != nil {
+		return fmt.Errorf("validation error: %w", err)
+	}
+
+	if !result.Valid {
+		return fmt.Errorf("validation failed:\n  %s", strings.Join(result.Errors, "\n  "))
+	}
+
+	if verbose {
+		fmt.Println("✓ Validation passed")
+	}
+	return nil
+}
+
+// validateReferences warns about resource() references to kinds that the
+// template of instance never creates. Each template is checked once, and
+// templates that cannot be found or parsed are left for hydration to report.
+func (g *Generator) validateReferences(instance map[string]interface{}) {
+	path, err := g.hydrator.TemplatePath(instance)
+	if err != nil || g.checkedTemplates[path] {
+		return
+	}
+	if g.checkedTemplates == nil {
+		g.checkedTemplates = map[string]bool{}
+	}
+	g.checkedTemplates[path] = true
+
+	root, err := hydrator.ParseTemplateFile(path)
+	if err != nil {
+		return
+	}
+	for _, warning := range hydrator.DanglingReferences(root) {
+		g.warnf("%s: %s", path, warning)
+	}
+}
+
+// formatNotes heads the rendered notes of instance with its kind and name
+func formatNotes(instance map[string]interface{}, notes string) string {
+	kind, _ := instance["kind"].(string)
+	name := ""
+	if metadata, ok := instance["metadata"].(map[string]interface{}); ok {
+		name, _ = metadata["name"].(string)
+	}
+	return fmt.Sprintf("NOTES for %s %s:\n%s\n", kind, name, strings.TrimRight(notes, "\n"))
+}
+
+// writeNotes prints the notes collected for --show-notes to stderr,
+// separated by blank lines
+func (g *Generator) writeNotes() {
+	fmt.Fprint(g.stderr, strings.Join(g.notes, "\n"))
+}
+
+// postProcess runs the post-processor registered for each resource's kind
+// and drops the resources left empty, so each instance's resources are final
+// before they are tracked for incremental output
+func postProcess(resources []map[string]interface{}, processors map[string]PostProcessor) ([]map[string]interface{}, error) {
+	if len(processors) == 0 {
+		return removeEmptyResources(resources), nil
+	}
+
+	for i, resource := range resources {
+		kind, _ := resource["kind"].(string)
+		processor, ok := processors[kind]
+		if !ok {
+			continue
+		}
+
+		processed, err := processor(resource)
+		if err != nil {
+			return nil, fmt.Errorf("post-processor for %s failed: %w", kind, err)
 		}
+		resources[i] = processed
 	}
 
-	return hydrateResult.Resources, nil
+	return removeEmptyResources(resources), nil
 }
 
-// processDirectory processes all YAML files in a directory
+// processDirectory processes all YAML and JSON files in a directory
 func (g *Generator) processDirectory(dirPath string, opts GeneratorOptions) ([]map[string]interface{}, error) {
 	var allResources []map[string]interface{}
 
@@ -176,11 +1018,18 @@ func (g *Generator) processDirectory(dirPath string, opts GeneratorOptions) ([]m
 			continue
 		}
 
-		if !strings.HasSuffix(file.Name(), ".yaml") && !strings.HasSuffix(file.Name(), ".yml") {
+		if !isInstanceFile(file.Name()) {
 			continue
 		}
 
 		path := filepath.Join(dirPath, file.Name())
+		if g.manifest != nil && g.isUpToDate(path, opts.OutputDir) {
+			if g.verbose {
+				fmt.Printf("Skipping unchanged: %s\n", path)
+			}
+			continue
+		}
+
 		resources, err := g.processFile(path, opts)
 		if err != nil {
 			return nil, err
@@ -193,7 +1042,14 @@ func (g *Generator) processDirectory(dirPath string, opts GeneratorOptions) ([]m
 }
 
 // writeResources writes resources to files in the output directory
-func (g *Generator) writeResources(resources []map[string]interface{}, outputDir string) error {
+func (g *Generator) writeResources(resources []map[string]interface{}, outputDir, layout string) error {
+	if layout == "" {
+		layout = OutputLayoutFlat
+	}
+	if layout != OutputLayoutFlat && layout != OutputLayoutByKind {
+		return fmt.Errorf("unknown output layout '%s' (expected '%s' or '%s')", layout, OutputLayoutFlat, OutputLayoutByKind)
+	}
+
 	// Create output directory
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
 		return fmt.Errorf("failed to create output directory: %w", err)
@@ -201,15 +1057,19 @@ func (g *Generator) writeResources(resources []map[string]interface{}, outputDir
 
 	for i, resource := range resources {
 		// Generate filename from resource metadata
-		filename := g.generateFilename(resource, i)
-		path := filepath.Join(outputDir, filename)
+		path := filepath.Join(outputDir, g.outputFilename(resource, i, layout))
+
+		// Create kind subdirectory if needed
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create directory: %w", err)
+		}
 
 		if g.verbose {
 			fmt.Printf("Writing: %s\n", path)
 		}
 
 		// Marshal to YAML
-		data, err := yaml.Marshal(resource)
+		data, err := g.marshalResource(resource)
 		if err != nil {
 			return fmt.Errorf("failed to marshal resource: %w", err)
 		}
@@ -224,14 +1084,20 @@ func (g *Generator) writeResources(resources []map[string]interface{}, outputDir
 	return nil
 }
 
-// printResources prints resources to stdout
+// printResources prints resources to w as a multi-document stream. Empty
+// resources are skipped, so a separator is only written between documents.
 func (g *Generator) printResources(resources []map[string]interface{}, w io.Writer) error {
-	for i, resource := range resources {
-		if i > 0 {
+	printed := 0
+	for _, resource := range resources {
+		if len(resource) == 0 {
+			continue
+		}
+		if printed > 0 {
 			fmt.Fprintln(w, "---")
 		}
+		printed++
 
-		data, err := yaml.Marshal(resource)
+		data, err := g.marshalResource(resource)
 		if err != nil {
 			return fmt.Errorf("failed to marshal resource: %w", err)
 		}
@@ -242,6 +1108,70 @@ func (g *Generator) printResources(resources []map[string]interface{}, w io.Writ
 	return nil
 }
 
+// marshalResource renders a resource as YAML. sigs.k8s.io/yaml has no
+// indent or style setting or comments, so a custom indent, flow style or
+// template comments are rendered with yaml.v3 instead.
+func (g *Generator) marshalResource(resource map[string]interface{}) ([]byte, error) {
+	flow := g.yamlStyle == YAMLStyleFlow
+	if g.yamlIndent == 0 && g.comments == nil && !flow {
+		return yaml.Marshal(resource)
+	}
+
+	var value interface{} = resource
+	if g.comments != nil || flow {
+		node := &yamlv3.Node{}
+		if g.comments != nil {
+			identity, _ := hydrator.ResourceIdentity(resource)
+			commented, err := g.comments[identity].Node(resource)
+			if err != nil {
+				return nil, err
+			}
+			node = commented
+		} else if err := node.Encode(resource); err != nil {
+			return nil, err
+		}
+		if flow {
+			setFlowStyle(node)
+		}
+		value = node
+	}
+
+	indent := g.yamlIndent
+	if indent == 0 {
+		indent = 2
+	}
+
+	var buf bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buf)
+	encoder.SetIndent(indent)
+	if err := encoder.Encode(value); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// setFlowStyle writes every map and list under node inline
+func setFlowStyle(node *yamlv3.Node) {
+	if node.Kind == yamlv3.MappingNode || node.Kind == yamlv3.SequenceNode {
+		node.Style |= yamlv3.FlowStyle
+	}
+	for _, child := range node.Content {
+		setFlowStyle(child)
+	}
+}
+
+// outputFilename returns the path of a resource relative to the output directory
+func (g *Generator) outputFilename(resource map[string]interface{}, index int, layout string) string {
+	if layout == OutputLayoutByKind {
+		return g.generateKindPath(resource, index)
+	}
+	return g.generateFilename(resource, index)
+}
+
 // generateFilename generates a filename for a resource
 func (g *Generator) generateFilename(resource map[string]interface{}, index int) string {
 	kind := "resource"
@@ -259,3 +1189,26 @@ func (g *Generator) generateFilename(resource map[string]interface{}, index int)
 
 	return fmt.Sprintf("%s-%s.yaml", kind, name)
 }
+
+// generateKindPath generates a <kind>/<name>.yaml path for a resource.
+// Namespaced resources are written as <kind>/<namespace>_<name>.yaml so that
+// resources sharing a name across namespaces do not overwrite each other.
+func (g *Generator) generateKindPath(resource map[string]interface{}, index int) string {
+	kind := "resource"
+	name := fmt.Sprintf("%d", index)
+
+	if k, ok := resource["kind"].(string); ok {
+		kind = strings.ToLower(k)
+	}
+
+	if metadata, ok := resource["metadata"].(map[string]interface{}); ok {
+		if n, ok := metadata["name"].(string); ok {
+			name = n
+		}
+		if ns, ok := metadata["namespace"].(string); ok && ns != "" {
+			name = ns + "_" + name
+		}
+	}
+
+	return filepath.Join(kind, name+".yaml")
+}
@@ -0,0 +1,2054 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteResourcesByKind(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "my-app",
+				"namespace": "default",
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name":      "my-app",
+				"namespace": "default",
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name":      "my-app",
+				"namespace": "prod",
+			},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata": map[string]interface{}{
+				"name": "prod",
+			},
+		},
+	}
+
+	g := NewGenerator(GeneratorOptions{})
+	if err := g.writeResources(resources, tempDir, OutputLayoutByKind); err != nil {
+		t.Fatalf("writeResources() error = %v", err)
+	}
+
+	var got []string
+	err = filepath.Walk(tempDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, _ := filepath.Rel(tempDir, path)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to walk output: %v", err)
+	}
+	sort.Strings(got)
+
+	expected := []string{
+		"deployment/default_my-app.yaml",
+		"namespace/prod.yaml",
+		"service/default_my-app.yaml",
+		"service/prod_my-app.yaml",
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("Expected files %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Errorf("Expected file %s, got %s", expected[i], got[i])
+		}
+	}
+}
+
+func TestWriteResourcesFlatLayout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name": "my-app",
+			},
+		},
+	}
+
+	g := NewGenerator(GeneratorOptions{})
+	if err := g.writeResources(resources, tempDir, ""); err != nil {
+		t.Fatalf("writeResources() error = %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tempDir, "service-my-app.yaml")); err != nil {
+		t.Errorf("Expected flat file service-my-app.yaml: %v", err)
+	}
+}
+
+func TestWriteResourcesUnknownLayout(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	g := NewGenerator(GeneratorOptions{})
+	if err := g.writeResources(nil, tempDir, "nested"); err == nil {
+		t.Error("Expected error for unknown output layout")
+	}
+}
+
+func TestReadInstancesMultiDocument(t *testing.T) {
+	input := `apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: first
+---
+apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: second
+---
+`
+
+	instances, err := readInstances(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readInstances() error = %v", err)
+	}
+
+	if len(instances) != 2 {
+		t.Fatalf("Expected 2 instances, got %d", len(instances))
+	}
+
+	for i, expected := range []string{"first", "second"} {
+		metadata := instances[i]["metadata"].(map[string]interface{})
+		if metadata["name"] != expected {
+			t.Errorf("Expected instance %d name '%s', got '%v'", i, expected, metadata["name"])
+		}
+	}
+}
+
+func TestReadInstancesKeepsIntegers(t *testing.T) {
+	input := `spec:
+  bytes: 9007199254740993
+  replicas: 3
+  ratio: 1.5
+  ports: [80, 443]
+`
+
+	instances, err := readInstances(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("readInstances() error = %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"bytes":    int64(9007199254740993),
+		"replicas": int64(3),
+		"ratio":    1.5,
+		"ports":    []interface{}{int64(80), int64(443)},
+	}
+	if spec := instances[0]["spec"]; !reflect.DeepEqual(spec, expected) {
+		t.Errorf("readInstances() spec = %#v, want %#v", spec, expected)
+	}
+}
+
+func TestProcessFileKeepsIntegers(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Chdir(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    num: "@expr(.spec.num)"
+`
+	if err := os.WriteFile("counter_v1alpha1.yaml", []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	instance := `apiVersion: platform.example.com/v1alpha1
+kind: Counter
+metadata:
+  name: counter
+spec:
+  num: 9007199254740993
+`
+	if err := os.WriteFile("instance.yaml", []byte(instance), 0644); err != nil {
+		t.Fatalf("failed to write instance: %v", err)
+	}
+
+	g := NewGenerator(GeneratorOptions{})
+	resources, err := g.processFile("instance.yaml", GeneratorOptions{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+	if num := resources[0]["num"]; num != int64(9007199254740993) {
+		t.Errorf("num = %#v, want int64(9007199254740993)", num)
+	}
+}
+
+func TestProcessFileFromStdin(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	input := `apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: first
+---
+apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: second
+`
+
+	g := NewGenerator(GeneratorOptions{})
+	g.stdin = strings.NewReader(input)
+
+	resources, err := g.processFile(StdinPath, GeneratorOptions{})
+	if err != nil {
+		t.Fatalf("processFile() error = %v", err)
+	}
+
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(resources))
+	}
+
+	for i, expected := range []string{"first", "second"} {
+		metadata := resources[i]["metadata"].(map[string]interface{})
+		if metadata["name"] != expected {
+			t.Errorf("Expected resource %d name '%s', got '%v'", i, expected, metadata["name"])
+		}
+	}
+}
+
+func TestGenerateWithValuesFile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      image: "@expr($values.spec.image)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	valuesPath := filepath.Join(tempDir, "values.yaml")
+	if err := os.WriteFile(valuesPath, []byte("spec:\n  image: nginx:1.25\n"), 0644); err != nil {
+		t.Fatalf("failed to write values: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	input := `apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: defaulted
+---
+apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: overridden
+spec:
+  image: nginx:1.27
+`
+
+	outputDir := filepath.Join(tempDir, "out")
+	opts := GeneratorOptions{
+		InputFiles: []string{StdinPath},
+		OutputDir:  outputDir,
+		ValuesFile: valuesPath,
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader(input)
+
+	if err := g.Generate(opts); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	for name, expected := range map[string]string{"defaulted": "nginx:1.25", "overridden": "nginx:1.27"} {
+		data, err := os.ReadFile(filepath.Join(outputDir, "configmap-"+name+".yaml"))
+		if err != nil {
+			t.Fatalf("failed to read output for %s: %v", name, err)
+		}
+		if !strings.Contains(string(data), "image: "+expected) {
+			t.Errorf("Expected %s to have image %s, got:\n%s", name, expected, data)
+		}
+	}
+}
+
+func TestGenerateWithSecretsFile(t *testing.T) {
+	secretsPath, err := filepath.Abs(filepath.Join("testdata", "secrets.yaml"))
+	if err != nil {
+		t.Fatalf("failed to resolve secrets fixture: %v", err)
+	}
+
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      username: "@expr(secret(.spec.secretName, \"username\"))"
+      password: "@expr(secret(.spec.secretName, \"password\"))"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "database_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := func(secretName string) string {
+		return "apiVersion: platform.example.com/v1alpha1\nkind: Database\nmetadata:\n  name: orders\nspec:\n  secretName: " + secretName + "\n"
+	}
+
+	var out strings.Builder
+	opts := GeneratorOptions{
+		InputFiles:  []string{StdinPath},
+		SecretsFile: secretsPath,
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader(instance("db-credentials"))
+	resources, err := g.generateResources(opts)
+	if err != nil {
+		t.Fatalf("generateResources() error = %v", err)
+	}
+	if err := g.printResources(resources, &out); err != nil {
+		t.Fatalf("printResources() error = %v", err)
+	}
+	for _, want := range []string{"password: czNjcjN0IQ==\n", "username: YXBw\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+
+	// A missing secret or key names what is missing
+	for name, want := range map[string]string{
+		"missing-secret": "no secret named 'missing-secret'",
+		"api-token":      "secret 'api-token' has no key",
+	} {
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(instance(name))
+		if _, err := g.generateResources(opts); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", name, want, err)
+		}
+	}
+
+	// Without a secrets file, secret() fails
+	opts.SecretsFile = ""
+	g = NewGenerator(opts)
+	g.stdin = strings.NewReader(instance("db-credentials"))
+	if _, err := g.generateResources(opts); err == nil || !strings.Contains(err.Error(), "requires a secrets file") {
+		t.Errorf("Expected error without a secrets file, got %v", err)
+	}
+}
+
+func TestLoadSecretsErrors(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	if _, err := loadSecrets(filepath.Join(tempDir, "missing.yaml")); err == nil {
+		t.Error("Expected error for missing secrets file")
+	}
+
+	path := filepath.Join(tempDir, "secrets.yaml")
+	if err := os.WriteFile(path, []byte("db: hunter2\n"), 0644); err != nil {
+		t.Fatalf("failed to write secrets file: %v", err)
+	}
+	if _, err := loadSecrets(path); err == nil {
+		t.Error("Expected error for a secret that is not a map of keys to values")
+	}
+}
+
+func TestGenerateFreezeTime(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+      annotations:
+        generatedAt: "@expr(now())"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	input := `apiVersion: platform.example.com/v1alpha1
+kind: WebService
+metadata:
+  name: web
+`
+
+	tests := []struct {
+		name       string
+		freezeTime string
+		env        string
+		want       string
+		wantErr    bool
+	}{
+		{name: "flag", freezeTime: "2024-01-02T15:04:05Z", want: "2024-01-02T15:04:05Z"},
+		{name: "environment", env: "2023-06-01T00:00:00+02:00", want: "2023-05-31T22:00:00Z"},
+		{name: "flag overrides environment", freezeTime: "2024-01-02T15:04:05Z", env: "2023-06-01T00:00:00Z", want: "2024-01-02T15:04:05Z"},
+		{name: "invalid", freezeTime: "yesterday", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(FreezeTimeEnv, tt.env)
+
+			outputDir := filepath.Join(tempDir, "out-"+strings.ReplaceAll(tt.name, " ", "-"))
+			opts := GeneratorOptions{
+				InputFiles: []string{StdinPath},
+				OutputDir:  outputDir,
+				FreezeTime: tt.freezeTime,
+			}
+			g := NewGenerator(opts)
+			g.stdin = strings.NewReader(input)
+
+			err := g.Generate(opts)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Generate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			data, err := os.ReadFile(filepath.Join(outputDir, "configmap-web.yaml"))
+			if err != nil {
+				t.Fatalf("failed to read output: %v", err)
+			}
+			if !strings.Contains(string(data), "generatedAt: \""+tt.want+"\"") {
+				t.Errorf("Expected generatedAt %s, got:\n%s", tt.want, data)
+			}
+		})
+	}
+}
+
+func TestGenerateValidateReferences(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      ip: $(resource("v1", "Sevice", "web").spec.clusterIP)
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	input := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: a\n" +
+		"---\n" +
+		"apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: b\n"
+
+	for _, validate := range []bool{true, false} {
+		opts := GeneratorOptions{
+			InputFiles:         []string{StdinPath},
+			OutputDir:          filepath.Join(tempDir, "out"),
+			ValidateReferences: validate,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(input)
+		var stderr strings.Builder
+		g.stderr = &stderr
+		if err := g.Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		// Each template is checked once, however many instances use it
+		want := 0
+		if validate {
+			want = 1
+		}
+		if got := strings.Count(stderr.String(), "reference to v1/Sevice/web: the template creates no Sevice"); got != want {
+			t.Errorf("validate=%v: expected %d dangling reference warnings, got %d:\n%s", validate, want, got, stderr.String())
+		}
+	}
+}
+
+func TestGenerateShowNotes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+notes: |
+  Open https://$(.spec.host) to get started.
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	input := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: a\nspec:\n  host: a.example.com\n" +
+		"---\n" +
+		"apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: b\nspec:\n  host: b.example.com\n"
+
+	for _, showNotes := range []bool{true, false} {
+		opts := GeneratorOptions{
+			InputFiles: []string{StdinPath},
+			OutputDir:  filepath.Join(tempDir, "out"),
+			ShowNotes:  showNotes,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(input)
+		var stderr strings.Builder
+		g.stderr = &stderr
+		if err := g.Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		expected := ""
+		if showNotes {
+			expected = "NOTES for WebService a:\nOpen https://a.example.com to get started.\n\n" +
+				"NOTES for WebService b:\nOpen https://b.example.com to get started.\n"
+		}
+		if stderr.String() != expected {
+			t.Errorf("showNotes=%v: stderr = %q, want %q", showNotes, stderr.String(), expected)
+		}
+	}
+}
+
+func TestGenerateVerboseTraceToStderr(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	opts := GeneratorOptions{
+		InputFiles: []string{StdinPath},
+		OutputDir:  filepath.Join(tempDir, "out"),
+		Verbose:    true,
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: a\n")
+	var stderr strings.Builder
+	g.stderr = &stderr
+	if err := g.Generate(opts); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if !strings.Contains(stderr.String(), "  .metadata.name -> a\n") {
+		t.Errorf("Expected the expression trace on stderr, got:\n%s", stderr.String())
+	}
+}
+
+func TestGeneratePreserveComments(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      # Read by the application at startup
+      host: "@expr(.spec.host)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	input := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: a\nspec:\n  host: a.example.com\n"
+
+	for _, preserve := range []bool{true, false} {
+		outputDir := filepath.Join(tempDir, fmt.Sprintf("out-%v", preserve))
+		opts := GeneratorOptions{
+			InputFiles:       []string{StdinPath},
+			OutputDir:        outputDir,
+			PreserveComments: preserve,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(input)
+		if err := g.Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(outputDir, "configmap-a.yaml"))
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		comment := "  # Read by the application at startup\n  host: a.example.com\n"
+		if got := strings.Contains(string(data), comment); got != preserve {
+			t.Errorf("preserve=%v: output contains comment = %v, got:\n%s", preserve, got, data)
+		}
+	}
+}
+
+func TestGeneratePreserveCommentsPerTemplate(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Both templates emit a ConfigMap, each with its own comment on the same field
+	for _, kind := range []string{"webservice", "worker"} {
+		template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      # Read by the ` + kind + `
+      host: "@expr(.spec.host)"
+`
+		if err := os.WriteFile(filepath.Join(tempDir, kind+"_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+			t.Fatalf("failed to write template: %v", err)
+		}
+	}
+	t.Chdir(tempDir)
+
+	input := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: web\nspec:\n  host: web.example.com\n" +
+		"---\n" +
+		"apiVersion: platform.example.com/v1alpha1\nkind: Worker\nmetadata:\n  name: jobs\nspec:\n  host: jobs.example.com\n"
+
+	opts := GeneratorOptions{InputFiles: []string{StdinPath}, PreserveComments: true}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader(input)
+	resources, err := g.generateResources(opts)
+	if err != nil {
+		t.Fatalf("generateResources() error = %v", err)
+	}
+
+	for _, resource := range resources {
+		data, err := g.marshalResource(resource)
+		if err != nil {
+			t.Fatalf("marshalResource() error = %v", err)
+		}
+		name := resource["metadata"].(map[string]interface{})["name"].(string)
+		kind := map[string]string{"web": "webservice", "jobs": "worker"}[name]
+		if comment := "# Read by the " + kind + "\n"; !strings.Contains(string(data), comment) {
+			t.Errorf("Expected ConfigMap %s to have its template's comment %q, got:\n%s", name, comment, data)
+		}
+	}
+}
+
+func TestGenerateSkipsEmptyDocuments(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - "@if(.spec.enabled)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(.metadata.name)"
+  - "@if(.spec.secret)":
+      apiVersion: v1
+      kind: Secret
+      metadata:
+        name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := func(name string, enabled, secret bool) string {
+		return fmt.Sprintf("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: %s\nspec:\n  enabled: %v\n  secret: %v\n", name, enabled, secret)
+	}
+
+	// A post-processor that clears a resource leaves an empty map behind
+	clearSecrets := map[string]PostProcessor{
+		"Secret": func(resource map[string]interface{}) (map[string]interface{}, error) { return nil, nil },
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "false top-level conditional",
+			input:    instance("a", false, false),
+			expected: "",
+		},
+		{
+			name:     "conditionals false in every instance",
+			input:    instance("a", false, false) + "---\n" + instance("b", false, false),
+			expected: "",
+		},
+		{
+			name:     "cleared resources between documents",
+			input:    instance("a", true, true) + "---\n" + instance("b", false, true) + "---\n" + instance("c", true, false),
+			expected: "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n---\napiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: c\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := GeneratorOptions{InputFiles: []string{StdinPath}, PostProcessors: clearSecrets}
+			g := NewGenerator(opts)
+			g.stdin = strings.NewReader(tt.input)
+
+			resources, err := g.generateResources(opts)
+			if err != nil {
+				t.Fatalf("generateResources() error = %v", err)
+			}
+			// Cleared resources are dropped with each instance's post-processing
+			for _, resource := range resources {
+				if len(resource) == 0 {
+					t.Fatalf("Expected no empty resources from generateResources(), got %v", resources)
+				}
+			}
+			var out strings.Builder
+			if err := g.printResources(resources, &out); err != nil {
+				t.Fatalf("printResources() error = %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("output = %q, want %q", out.String(), tt.expected)
+			}
+
+			// printResources skips empty resources on its own too
+			out.Reset()
+			if err := g.printResources(append(resources, nil, map[string]interface{}{}), &out); err != nil {
+				t.Fatalf("printResources() error = %v", err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("unfiltered output = %q, want %q", out.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoadValuesMissingFile(t *testing.T) {
+	if _, err := loadValues("does-not-exist.yaml"); err == nil {
+		t.Error("Expected error for missing values file")
+	}
+}
+
+func TestGenerateClean(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - "@for(name in .spec.names)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	for _, layout := range []string{OutputLayoutFlat, OutputLayoutByKind} {
+		outputDir := filepath.Join(tempDir, "out-"+layout)
+		outputPath := func(name string) string {
+			if layout == OutputLayoutByKind {
+				return filepath.Join(outputDir, "configmap", name+".yaml")
+			}
+			return filepath.Join(outputDir, "configmap-"+name+".yaml")
+		}
+		generate := func(clean bool, names ...string) {
+			t.Helper()
+			input := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  names: [" + strings.Join(names, ", ") + "]\n"
+			opts := GeneratorOptions{
+				InputFiles:   []string{StdinPath},
+				OutputDir:    outputDir,
+				OutputLayout: layout,
+				Clean:        clean,
+			}
+			g := NewGenerator(opts)
+			g.stdin = strings.NewReader(input)
+			if err := g.Generate(opts); err != nil {
+				t.Fatalf("%s: Generate() error = %v", layout, err)
+			}
+		}
+		exists := func(path string) bool {
+			_, err := os.Stat(path)
+			return err == nil
+		}
+
+		// Files from a run without --clean are not recorded, so they are never pruned
+		generate(false, "legacy")
+		generate(true, "a", "b")
+		if !exists(outputPath("legacy")) {
+			t.Errorf("%s: expected file from a run without --clean to be kept", layout)
+		}
+
+		// A file the generator did not write is never removed
+		userFile := filepath.Join(outputDir, "README.md")
+		if err := os.WriteFile(userFile, []byte("hand written\n"), 0644); err != nil {
+			t.Fatalf("failed to write user file: %v", err)
+		}
+
+		generate(true, "a")
+		if !exists(outputPath("a")) {
+			t.Errorf("%s: expected current file to be kept", layout)
+		}
+		if exists(outputPath("b")) {
+			t.Errorf("%s: expected stale file of removed resource to be pruned", layout)
+		}
+		if !exists(userFile) || !exists(outputPath("legacy")) {
+			t.Errorf("%s: expected files not written by --clean runs to be kept", layout)
+		}
+
+		data, err := os.ReadFile(filepath.Join(outputDir, GeneratedManifestFile))
+		if err != nil {
+			t.Fatalf("%s: failed to read generated manifest: %v", layout, err)
+		}
+		if strings.Contains(string(data), "/b.yaml") || strings.Contains(string(data), "-b.yaml") {
+			t.Errorf("%s: expected manifest without the pruned file, got %s", layout, data)
+		}
+
+		// Kind directories left empty are removed
+		if layout == OutputLayoutByKind {
+			if err := os.Remove(outputPath("legacy")); err != nil {
+				t.Fatalf("failed to remove legacy file: %v", err)
+			}
+			generate(true, "c")
+			generate(true)
+			if exists(filepath.Join(outputDir, "configmap")) {
+				t.Errorf("expected empty kind directory to be removed")
+			}
+		}
+	}
+
+	for _, opts := range []GeneratorOptions{
+		{InputFiles: []string{StdinPath}, Clean: true},
+		{InputFiles: []string{StdinPath}, OutputDir: filepath.Join(tempDir, "out"), Clean: true, Incremental: true},
+	} {
+		if err := NewGenerator(opts).Generate(opts); err == nil {
+			t.Errorf("Expected error for --clean with output %q and incremental %v", opts.OutputDir, opts.Incremental)
+		}
+	}
+}
+
+func TestGenerateIncremental(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	templatePath := filepath.Join(tempDir, "webservice_v1alpha1.yaml")
+	if err := os.WriteFile(templatePath, []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instanceDir := filepath.Join(tempDir, "instances")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("failed to create instance dir: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	for _, name := range []string{"first", "second"} {
+		path := filepath.Join(instanceDir, name+".yaml")
+		instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: " + name + "\n"
+		if err := os.WriteFile(path, []byte(instance), 0644); err != nil {
+			t.Fatalf("failed to write instance: %v", err)
+		}
+		if err := os.Chtimes(path, past, past); err != nil {
+			t.Fatalf("failed to set instance time: %v", err)
+		}
+	}
+	if err := os.Chtimes(templatePath, past, past); err != nil {
+		t.Fatalf("failed to set template time: %v", err)
+	}
+
+	outputDir := filepath.Join(tempDir, "out")
+	opts := GeneratorOptions{
+		InputFiles:  []string{instanceDir},
+		OutputDir:   outputDir,
+		Incremental: true,
+	}
+	generate := func() {
+		if err := NewGenerator(opts).Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+	}
+	outputPath := func(name string) string {
+		return filepath.Join(outputDir, "configmap-"+name+".yaml")
+	}
+	// markOutputs replaces the outputs with a marker so regeneration is observable
+	markOutputs := func() {
+		for _, name := range []string{"first", "second"} {
+			if err := os.WriteFile(outputPath(name), []byte("marker\n"), 0644); err != nil {
+				t.Fatalf("failed to mark output: %v", err)
+			}
+		}
+	}
+	regenerated := func(name string) bool {
+		data, err := os.ReadFile(outputPath(name))
+		if err != nil {
+			t.Fatalf("failed to read output for %s: %v", name, err)
+		}
+		return string(data) != "marker\n"
+	}
+
+	// Without a manifest everything is generated
+	generate()
+	if _, err := os.Stat(filepath.Join(outputDir, IncrementalManifestFile)); err != nil {
+		t.Fatalf("Expected incremental manifest to be written: %v", err)
+	}
+
+	// Unchanged instances are skipped
+	markOutputs()
+	generate()
+	if regenerated("first") || regenerated("second") {
+		t.Error("Expected unchanged instances to be skipped")
+	}
+
+	// A changed instance is regenerated
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(instanceDir, "second.yaml"), future, future); err != nil {
+		t.Fatalf("failed to touch instance: %v", err)
+	}
+	generate()
+	if regenerated("first") {
+		t.Error("Expected unchanged instance 'first' to be skipped")
+	}
+	if !regenerated("second") {
+		t.Error("Expected changed instance 'second' to be regenerated")
+	}
+
+	// A changed template regenerates every instance
+	markOutputs()
+	future = future.Add(time.Hour)
+	if err := os.Chtimes(templatePath, future, future); err != nil {
+		t.Fatalf("failed to touch template: %v", err)
+	}
+	generate()
+	if !regenerated("first") || !regenerated("second") {
+		t.Error("Expected a changed template to regenerate every instance")
+	}
+
+	// Missing outputs fall back to generation
+	if err := os.Remove(outputPath("first")); err != nil {
+		t.Fatalf("failed to remove output: %v", err)
+	}
+	generate()
+	if _, err := os.Stat(outputPath("first")); err != nil {
+		t.Errorf("Expected missing output to be regenerated: %v", err)
+	}
+}
+
+func TestGenerateIncrementalInputs(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	t.Chdir(tempDir)
+
+	files := map[string]string{
+		"webservice_v1alpha1.yaml": "extends: base.yaml\nresources:\n  - \"@import(service.yaml)\"\n",
+		"base.yaml": `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      config: "@expr(file(\"config.txt\"))"
+  - apiVersion: v1
+    kind: Secret
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+		"service.yaml": `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+		"config.txt":  "key=value\n",
+		"values.yaml": "replicas: 1\n",
+	}
+	past := time.Now().Add(-time.Hour)
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	instanceDir := filepath.Join(tempDir, "instances")
+	if err := os.MkdirAll(instanceDir, 0755); err != nil {
+		t.Fatalf("failed to create instance dir: %v", err)
+	}
+	names := []string{"first", "second"}
+	for _, name := range names {
+		instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: " + name + "\n"
+		if err := os.WriteFile(filepath.Join(instanceDir, name+".yaml"), []byte(instance), 0644); err != nil {
+			t.Fatalf("failed to write instance: %v", err)
+		}
+		files[filepath.Join(instanceDir, name+".yaml")] = instance
+	}
+	for name := range files {
+		if err := os.Chtimes(name, past, past); err != nil {
+			t.Fatalf("failed to set time of %s: %v", name, err)
+		}
+	}
+
+	// Dropped and reordered resources must still be attributed to their instance
+	outputDir := filepath.Join(tempDir, "out")
+	opts := GeneratorOptions{
+		InputFiles:  []string{instanceDir},
+		OutputDir:   outputDir,
+		ValuesFile:  "values.yaml",
+		Incremental: true,
+		SortOutput:  true,
+		PostProcessors: map[string]PostProcessor{
+			"Secret": func(map[string]interface{}) (map[string]interface{}, error) {
+				return map[string]interface{}{}, nil
+			},
+		},
+	}
+	generate := func() {
+		if err := NewGenerator(opts).Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+	}
+	outputPath := func(name string) string {
+		return filepath.Join(outputDir, "configmap-"+name+".yaml")
+	}
+	markOutputs := func() {
+		for _, name := range names {
+			if err := os.WriteFile(outputPath(name), []byte("marker\n"), 0644); err != nil {
+				t.Fatalf("failed to mark output: %v", err)
+			}
+		}
+	}
+	regenerated := func(name string) bool {
+		data, err := os.ReadFile(outputPath(name))
+		if err != nil {
+			t.Fatalf("failed to read output for %s: %v", name, err)
+		}
+		return string(data) != "marker\n"
+	}
+
+	generate()
+	manifest, err := loadIncrementalManifest(outputDir)
+	if err != nil {
+		t.Fatalf("loadIncrementalManifest() error = %v", err)
+	}
+	for _, name := range names {
+		path := filepath.Join(instanceDir, name+".yaml")
+		want := []string{"configmap-" + name + ".yaml", "service-" + name + ".yaml"}
+		if !reflect.DeepEqual(manifest.Outputs[path], want) {
+			t.Errorf("Outputs[%s] = %v, want %v", name, manifest.Outputs[path], want)
+		}
+	}
+
+	markOutputs()
+	generate()
+	if regenerated("first") || regenerated("second") {
+		t.Fatal("Expected unchanged instances to be skipped")
+	}
+
+	// A change to any file the template depends on regenerates every instance
+	future := time.Now().Add(time.Hour)
+	for _, input := range []string{"base.yaml", "service.yaml", "config.txt", "values.yaml"} {
+		markOutputs()
+		if err := os.Chtimes(input, future, future); err != nil {
+			t.Fatalf("failed to touch %s: %v", input, err)
+		}
+		generate()
+		for _, name := range names {
+			if !regenerated(name) {
+				t.Errorf("Expected a change to %s to regenerate %s", input, name)
+			}
+		}
+		if err := os.Chtimes(input, past, past); err != nil {
+			t.Fatalf("failed to reset %s: %v", input, err)
+		}
+	}
+}
+
+func TestProcessFileStructuralValidation(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tests := []struct {
+		name     string
+		instance string
+		wantErr  string
+	}{
+		{
+			name:     "missing name",
+			instance: "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  namespace: default\n",
+			wantErr:  "missing 'metadata.name' field",
+		},
+		{
+			name:     "missing kind",
+			instance: "apiVersion: platform.example.com/v1alpha1\nmetadata:\n  name: my-app\n",
+			wantErr:  "missing 'kind' field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(tempDir, "instance.yaml")
+			if err := os.WriteFile(path, []byte(tt.instance), 0644); err != nil {
+				t.Fatalf("failed to write instance: %v", err)
+			}
+
+			// Schema validation is off, so only the structural check can fail
+			_, err := NewGenerator(GeneratorOptions{}).processFile(path, GeneratorOptions{Validate: false})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("processFile() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPrintResourcesYAMLIndent(t *testing.T) {
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name": "my-app",
+			},
+			"spec": map[string]interface{}{
+				"ports": []interface{}{
+					map[string]interface{}{"port": int64(80), "name": "http"},
+				},
+				"weight": 0.5,
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		indent   int
+		expected string
+	}{
+		{
+			name:   "indent 2",
+			indent: 2,
+			expected: `apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+spec:
+  ports:
+    - name: http
+      port: 80
+  weight: 0.5
+`,
+		},
+		{
+			name:   "indent 4",
+			indent: 4,
+			expected: `apiVersion: v1
+kind: Service
+metadata:
+    name: my-app
+spec:
+    ports:
+        - name: http
+          port: 80
+    weight: 0.5
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGenerator(GeneratorOptions{YAMLIndent: tt.indent})
+
+			var out strings.Builder
+			if err := g.printResources(resources, &out); err != nil {
+				t.Fatalf("printResources() error = %v", err)
+			}
+
+			if out.String() != tt.expected {
+				t.Errorf("printResources() =\n%s\nwant:\n%s", out.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrintResourcesYAMLStyle(t *testing.T) {
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "my-app"},
+			"spec": map[string]interface{}{
+				"ports": []interface{}{
+					map[string]interface{}{"port": int64(80), "name": "http"},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		opts     GeneratorOptions
+		expected string
+	}{
+		{
+			name: "block",
+			opts: GeneratorOptions{YAMLStyle: YAMLStyleBlock},
+			expected: `apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+spec:
+  ports:
+  - name: http
+    port: 80
+`,
+		},
+		{
+			name:     "flow",
+			opts:     GeneratorOptions{YAMLStyle: YAMLStyleFlow},
+			expected: "{apiVersion: v1, kind: Service, metadata: {name: my-app}, spec: {ports: [{name: http, port: 80}]}}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewGenerator(tt.opts)
+
+			var out strings.Builder
+			if err := g.printResources(resources, &out); err != nil {
+				t.Fatalf("printResources() error = %v", err)
+			}
+
+			if out.String() != tt.expected {
+				t.Errorf("printResources() =\n%s\nwant:\n%s", out.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateUnknownYAMLStyle(t *testing.T) {
+	g := NewGenerator(GeneratorOptions{})
+	err := g.Generate(GeneratorOptions{YAMLStyle: "compact"})
+	if err == nil || !strings.Contains(err.Error(), "unknown --yaml-style") {
+		t.Errorf("Expected error for unknown --yaml-style, got %v", err)
+	}
+}
+
+func TestGenerateNegativeYAMLIndent(t *testing.T) {
+	g := NewGenerator(GeneratorOptions{})
+	if err := g.Generate(GeneratorOptions{YAMLIndent: -1}); err == nil {
+		t.Error("Expected error for negative --yaml-indent")
+	}
+}
+
+func TestGenerateYAMLIndentRange(t *testing.T) {
+	// yaml.v3 would silently ignore these, so they are rejected
+	for _, indent := range []int{1, 10, 16} {
+		g := NewGenerator(GeneratorOptions{})
+		err := g.Generate(GeneratorOptions{YAMLIndent: indent})
+		if err == nil || !strings.Contains(err.Error(), "between 2 and 9") {
+			t.Errorf("Expected range error for --yaml-indent %d, got %v", indent, err)
+		}
+	}
+}
+
+func TestSortResources(t *testing.T) {
+	resource := func(kind, namespace, name string) map[string]interface{} {
+		metadata := map[string]interface{}{"name": name}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		return map[string]interface{}{"kind": kind, "metadata": metadata}
+	}
+
+	canonical := []map[string]interface{}{
+		resource("ConfigMap", "default", "b"),
+		resource("Deployment", "default", "a"),
+		resource("Deployment", "prod", "a"),
+		resource("Namespace", "", "prod"),
+		resource("Service", "default", "a"),
+		resource("Service", "default", "b"),
+	}
+
+	// Every rotation of the reversed list must sort back to the same order
+	for shift := 0; shift < len(canonical); shift++ {
+		shuffled := make([]map[string]interface{}, 0, len(canonical))
+		for i := range canonical {
+			shuffled = append(shuffled, canonical[len(canonical)-1-(i+shift)%len(canonical)])
+		}
+
+		sortResources(shuffled)
+
+		if !reflect.DeepEqual(shuffled, canonical) {
+			var got []string
+			for _, r := range shuffled {
+				key := resourceSortKey(r)
+				got = append(got, strings.Join(key[:], "/"))
+			}
+			t.Errorf("shift %d: unexpected order %v", shift, got)
+		}
+	}
+}
+
+func TestSortByWeight(t *testing.T) {
+	resource := func(kind, name string, weight interface{}) map[string]interface{} {
+		metadata := map[string]interface{}{"name": name}
+		if weight != nil {
+			metadata["annotations"] = map[string]interface{}{WeightAnnotation: weight, "team": "web"}
+		}
+		return map[string]interface{}{"kind": kind, "metadata": metadata}
+	}
+
+	resources := []map[string]interface{}{
+		resource("Deployment", "web", nil),
+		resource("Service", "web", "5"),
+		resource("Namespace", "prod", "-10"),
+		resource("ConfigMap", "web", nil),
+		resource("CustomResourceDefinition", "widgets", int64(-10)),
+	}
+
+	if err := sortByWeight(resources); err != nil {
+		t.Fatalf("sortByWeight() error = %v", err)
+	}
+
+	// Equal weights keep their relative order
+	var got []string
+	for _, r := range resources {
+		got = append(got, r["kind"].(string))
+		metadata := r["metadata"].(map[string]interface{})
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			if _, ok := annotations[WeightAnnotation]; ok {
+				t.Errorf("%s: weight annotation not stripped", r["kind"])
+			}
+			if annotations["team"] != "web" {
+				t.Errorf("%s: other annotations not kept: %v", r["kind"], annotations)
+			}
+		}
+	}
+	want := []string{"Namespace", "CustomResourceDefinition", "Deployment", "ConfigMap", "Service"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortByWeight() order = %v, want %v", got, want)
+	}
+
+	invalid := []map[string]interface{}{resource("Service", "web", "high")}
+	if err := sortByWeight(invalid); err == nil {
+		t.Error("Expected error for a non-integer weight")
+	}
+}
+
+func TestGenerateSortOutput(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := func(name string) string {
+		return "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: " + name + "\n"
+	}
+
+	var outputs []string
+	for _, input := range []string{
+		instance("zeta") + "---\n" + instance("alpha"),
+		instance("alpha") + "---\n" + instance("zeta"),
+	} {
+		opts := GeneratorOptions{
+			InputFiles: []string{StdinPath},
+			SortOutput: true,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(input)
+
+		resources, err := g.generateResources(opts)
+		if err != nil {
+			t.Fatalf("generateResources() error = %v", err)
+		}
+
+		var out strings.Builder
+		if err := g.printResources(resources, &out); err != nil {
+			t.Fatalf("printResources() error = %v", err)
+		}
+		outputs = append(outputs, out.String())
+	}
+
+	if outputs[0] != outputs[1] {
+		t.Errorf("Expected identical output regardless of input order:\n%s\nvs:\n%s", outputs[0], outputs[1])
+	}
+	if !strings.HasPrefix(outputs[0], "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: alpha\n") {
+		t.Errorf("Expected ConfigMap alpha first, got:\n%s", outputs[0])
+	}
+}
+
+func TestGeneratePostProcessors(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      template:
+        spec:
+          containers:
+            - name: app
+              env:
+                - name: ZETA
+                  value: "1"
+                - name: ALPHA
+                  value: "2"
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	sortEnv := func(resource map[string]interface{}) (map[string]interface{}, error) {
+		podSpec := resource["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+		for _, c := range podSpec["containers"].([]interface{}) {
+			env := c.(map[string]interface{})["env"].([]interface{})
+			sort.Slice(env, func(i, j int) bool {
+				return env[i].(map[string]interface{})["name"].(string) < env[j].(map[string]interface{})["name"].(string)
+			})
+		}
+		return resource, nil
+	}
+
+	opts := GeneratorOptions{
+		InputFiles:     []string{StdinPath},
+		PostProcessors: map[string]PostProcessor{"Deployment": sortEnv},
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: my-app\n")
+
+	resources, err := g.generateResources(opts)
+	if err != nil {
+		t.Fatalf("generateResources() error = %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(resources))
+	}
+
+	podSpec := resources[0]["spec"].(map[string]interface{})["template"].(map[string]interface{})["spec"].(map[string]interface{})
+	env := podSpec["containers"].([]interface{})[0].(map[string]interface{})["env"].([]interface{})
+	var names []string
+	for _, e := range env {
+		names = append(names, e.(map[string]interface{})["name"].(string))
+	}
+	if !reflect.DeepEqual(names, []string{"ALPHA", "ZETA"}) {
+		t.Errorf("Expected sorted env, got %v", names)
+	}
+
+	// A failing post-processor aborts generation
+	opts.PostProcessors = map[string]PostProcessor{
+		"ConfigMap": func(map[string]interface{}) (map[string]interface{}, error) {
+			return nil, fmt.Errorf("boom")
+		},
+	}
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: my-app\n")
+	if _, err := g.generateResources(opts); err == nil || !strings.Contains(err.Error(), "post-processor for ConfigMap failed") {
+		t.Errorf("Expected post-processor error, got %v", err)
+	}
+}
+
+func TestGenerateEmitKustomize(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	opts := GeneratorOptions{
+		InputFiles:    []string{StdinPath},
+		EmitKustomize: "deploy",
+		BaseNamespace: "team-a",
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\n")
+	if err := g.Generate(opts); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	base, err := os.ReadFile(filepath.Join("deploy", "base", "kustomization.yaml"))
+	if err != nil {
+		t.Fatalf("Expected base kustomization to be written: %v", err)
+	}
+	if !strings.Contains(string(base), "- configmap-app.yaml") || !strings.Contains(string(base), "namespace: team-a") {
+		t.Errorf("Expected base to list the generated resource in namespace team-a, got:\n%s", base)
+	}
+	if _, err := os.Stat(filepath.Join("deploy", "base", "configmap-app.yaml")); err != nil {
+		t.Errorf("Expected generated resource in base: %v", err)
+	}
+
+	for _, name := range EmittedOverlays {
+		data, err := os.ReadFile(filepath.Join("deploy", "overlays", name, "kustomization.yaml"))
+		if err != nil {
+			t.Fatalf("Expected %s overlay to be written: %v", name, err)
+		}
+		if !strings.Contains(string(data), "- ../../base") {
+			t.Errorf("Expected %s overlay to reference the base, got:\n%s", name, data)
+		}
+	}
+
+	// The emitted tree is left for editing, so a second run must not clobber it
+	g = NewGenerator(opts)
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\n")
+	if err := g.Generate(opts); err == nil {
+		t.Error("Expected Generate to refuse an existing emitted base")
+	}
+
+	// An existing overlay fails the run before the base is written, so the
+	// run can be repeated once the overlay is moved away
+	if err := os.RemoveAll("deploy"); err != nil {
+		t.Fatalf("failed to remove emitted tree: %v", err)
+	}
+	prod := filepath.Join("deploy", "overlays", "prod")
+	if err := os.MkdirAll(prod, 0755); err != nil {
+		t.Fatalf("failed to create overlay: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(prod, "kustomization.yaml"), []byte("resources: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write overlay: %v", err)
+	}
+	g = NewGenerator(opts)
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\n")
+	if err := g.Generate(opts); err == nil {
+		t.Error("Expected Generate to refuse an existing overlay")
+	}
+	if _, err := os.Stat(filepath.Join("deploy", "base")); !os.IsNotExist(err) {
+		t.Errorf("Expected no base to be written, got %v", err)
+	}
+}
+
+func TestGenerateEmitKustomizeConflicts(t *testing.T) {
+	for _, opts := range []GeneratorOptions{
+		{EmitKustomize: "deploy", Overlay: "overlays/prod"},
+		{EmitKustomize: "deploy", OutputDir: "out"},
+	} {
+		if err := NewGenerator(opts).Generate(opts); err == nil {
+			t.Errorf("Expected Generate(%+v) to fail", opts)
+		}
+	}
+}
+
+func TestGenerateFromJSONInstance(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      replicas: "@expr(.spec.replicas)"
+      minReadySeconds: "@expr(.spec.replicas * 10)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	inputDir := filepath.Join(tempDir, "instances")
+	if err := os.MkdirAll(inputDir, 0755); err != nil {
+		t.Fatalf("failed to create input dir: %v", err)
+	}
+	files := map[string]string{
+		"api.json":   `{"apiVersion": "platform.example.com/v1alpha1", "kind": "WebService", "metadata": {"name": "api"}, "spec": {"replicas": 3}}`,
+		"web.yaml":   "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: web\nspec:\n  replicas: 2\n",
+		"notes.txt":  "not an instance",
+		"broken.txt": "{",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(inputDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	opts := GeneratorOptions{InputFiles: []string{inputDir}}
+	resources, err := NewGenerator(opts).generateResources(opts)
+	if err != nil {
+		t.Fatalf("generateResources() error = %v", err)
+	}
+	if len(resources) != 2 {
+		t.Fatalf("Expected 2 resources from the JSON and YAML instances, got %d", len(resources))
+	}
+
+	api := resources[0]
+	if name := api["metadata"].(map[string]interface{})["name"]; name != "api" {
+		t.Fatalf("Expected the JSON instance first, got %v", name)
+	}
+	spec := api["spec"].(map[string]interface{})
+	if replicas, ok := spec["replicas"].(int64); !ok || replicas != 3 {
+		t.Errorf("Expected replicas int64(3), got %#v", spec["replicas"])
+	}
+	if spec["minReadySeconds"] != int64(30) {
+		t.Errorf("Expected minReadySeconds int64(30), got %#v", spec["minReadySeconds"])
+	}
+}
+
+func TestDecodeJSONInstance(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected map[string]interface{}
+		wantErr  bool
+	}{
+		{
+			name:  "whole and fractional numbers",
+			input: `{"replicas": 3, "ratio": 0.5, "ports": [80, 443], "nested": {"big": 1e3}}`,
+			expected: map[string]interface{}{
+				"replicas": int64(3),
+				"ratio":    0.5,
+				"ports":    []interface{}{int64(80), int64(443)},
+				"nested":   map[string]interface{}{"big": float64(1000)},
+			},
+		},
+		{
+			name:    "invalid JSON",
+			input:   `{"replicas": }`,
+			wantErr: true,
+		},
+		{
+			name:    "trailing data",
+			input:   `{"a": 1} {"b": 2}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := decodeJSONInstance([]byte(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("decodeJSONInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("decodeJSONInstance() = %#v, want %#v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateDuplicateResources(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The loop forgets to use its variable, so every iteration emits the same name
+	template := `resources:
+  - "@for(port in .spec.ports)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(.metadata.name)"
+      data:
+        port: "@expr(port)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  ports: [\"80\", \"443\"]\n"
+
+	opts := GeneratorOptions{InputFiles: []string{StdinPath}}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader(instance)
+	_, err = g.generateResources(opts)
+	if err == nil || !strings.Contains(err.Error(), "v1/ConfigMap/app") {
+		t.Fatalf("Expected duplicate ConfigMap app to be reported, got %v", err)
+	}
+
+	opts.AllowDuplicates = true
+	g = NewGenerator(opts)
+	g.stdin = strings.NewReader(instance)
+	resources, err := g.generateResources(opts)
+	if err != nil {
+		t.Fatalf("generateResources() with AllowDuplicates error = %v", err)
+	}
+	if len(resources) != 2 {
+		t.Errorf("Expected both resources to be kept, got %d", len(resources))
+	}
+}
+
+func TestGenerateSpecOnly(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      replicas: "@expr(.spec.replicas)"
+    status: "@expr(.status)"
+    extra: value
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  replicas: 2\nstatus:\n  ready: true\n"
+
+	for _, spec := range []bool{false, true} {
+		outputDir := filepath.Join(tempDir, fmt.Sprintf("out-%t", spec))
+		opts := GeneratorOptions{
+			InputFiles: []string{StdinPath},
+			OutputDir:  outputDir,
+			SpecOnly:   spec,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(instance)
+		if err := g.Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(outputDir, "deployment-app.yaml"))
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		output := string(data)
+
+		for _, field := range []string{"apiVersion:", "kind:", "metadata:", "spec:"} {
+			if !strings.Contains(output, field) {
+				t.Errorf("SpecOnly=%t: expected output to keep %s, got:\n%s", spec, field, output)
+			}
+		}
+		for _, field := range []string{"status:", "extra:"} {
+			if strings.Contains(output, field) == spec {
+				t.Errorf("SpecOnly=%t: unexpected presence of %s in output:\n%s", spec, field, output)
+			}
+		}
+	}
+}
+
+func TestSpecOnlyDoesNotModifyInput(t *testing.T) {
+	resources := []map[string]interface{}{
+		{"apiVersion": "v1", "kind": "ConfigMap", "data": map[string]interface{}{"a": "b"}},
+	}
+	reduced := specOnly(resources)
+
+	if _, ok := reduced[0]["data"]; ok {
+		t.Errorf("Expected data to be dropped, got %v", reduced[0])
+	}
+	if _, ok := resources[0]["data"]; !ok {
+		t.Error("Expected the original resource to keep its data")
+	}
+}
+
+func TestGenerateProfile(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\n"
+
+	for _, profile := range []bool{false, true} {
+		opts := GeneratorOptions{
+			InputFiles: []string{StdinPath},
+			OutputDir:  filepath.Join(tempDir, fmt.Sprintf("out-%t", profile)),
+			Profile:    profile,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(instance)
+		var stderr strings.Builder
+		g.stderr = &stderr
+		if err := g.Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		output := stderr.String()
+		for _, phase := range []string{"Profile:", "template parse", "pass 1", "pass 2", PhaseOutput} {
+			if strings.Contains(output, phase) != profile {
+				t.Errorf("Profile=%t: unexpected presence of %q in stderr:\n%s", profile, phase, output)
+			}
+		}
+	}
+}
+
+func TestTrimEmpty(t *testing.T) {
+	tests := []struct {
+		name     string
+		resource map[string]interface{}
+		keep     []string
+		expected map[string]interface{}
+	}{
+		{
+			name: "empty containers are pruned",
+			resource: map[string]interface{}{
+				"kind": "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":        "app",
+					"labels":      map[string]interface{}{},
+					"annotations": map[string]interface{}{"nested": map[string]interface{}{}},
+				},
+				"data": map[string]interface{}{"a": "", "b": []interface{}{}},
+			},
+			expected: map[string]interface{}{
+				"kind":     "ConfigMap",
+				"metadata": map[string]interface{}{"name": "app"},
+				"data":     map[string]interface{}{"a": ""},
+			},
+		},
+		{
+			name: "empty list items are pruned",
+			resource: map[string]interface{}{
+				"kind": "Service",
+				"spec": map[string]interface{}{
+					"ports": []interface{}{map[string]interface{}{}, map[string]interface{}{"port": 80}},
+				},
+			},
+			expected: map[string]interface{}{
+				"kind": "Service",
+				"spec": map[string]interface{}{
+					"ports": []interface{}{map[string]interface{}{"port": 80}},
+				},
+			},
+		},
+		{
+			name: "required empty fields are kept",
+			resource: map[string]interface{}{
+				"kind": "NetworkPolicy",
+				"spec": map[string]interface{}{
+					"podSelector": map[string]interface{}{},
+					"ingress":     []interface{}{map[string]interface{}{}},
+				},
+			},
+			expected: map[string]interface{}{
+				"kind": "NetworkPolicy",
+				"spec": map[string]interface{}{
+					"podSelector": map[string]interface{}{},
+					"ingress":     []interface{}{map[string]interface{}{}},
+				},
+			},
+		},
+		{
+			name: "extra kept fields",
+			resource: map[string]interface{}{
+				"kind": "Widget",
+				"spec": map[string]interface{}{"config": map[string]interface{}{}, "other": []interface{}{}},
+			},
+			keep: []string{"config"},
+			expected: map[string]interface{}{
+				"kind": "Widget",
+				"spec": map[string]interface{}{"config": map[string]interface{}{}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trimmed := trimEmpty([]map[string]interface{}{tt.resource}, tt.keep)
+			if !reflect.DeepEqual(trimmed[0], tt.expected) {
+				t.Errorf("trimEmpty() = %v, want %v", trimmed[0], tt.expected)
+			}
+		})
+	}
+}
+
+func TestGenerateTrimEmpty(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+      labels: "@expr(.spec.labels)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instance := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: app\nspec:\n  labels: {}\n"
+
+	for _, trim := range []bool{false, true} {
+		outputDir := filepath.Join(tempDir, fmt.Sprintf("out-%t", trim))
+		opts := GeneratorOptions{
+			InputFiles: []string{StdinPath},
+			OutputDir:  outputDir,
+			TrimEmpty:  trim,
+		}
+		g := NewGenerator(opts)
+		g.stdin = strings.NewReader(instance)
+		if err := g.Generate(opts); err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+
+		data, err := os.ReadFile(filepath.Join(outputDir, "configmap-app.yaml"))
+		if err != nil {
+			t.Fatalf("failed to read output: %v", err)
+		}
+		if strings.Contains(string(data), "labels:") == trim {
+			t.Errorf("TrimEmpty=%t: unexpected labels in output:\n%s", trim, data)
+		}
+	}
+}
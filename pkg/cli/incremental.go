@@ -0,0 +1,174 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+)
+
+// IncrementalManifestFile is the file in the output directory that records
+// which outputs each instance produced
+const IncrementalManifestFile = ".krm-sdk-incremental.json"
+
+// incrementalManifest maps instance paths to their output files, relative to
+// the output directory, and to the other files they were generated from
+type incrementalManifest struct {
+	Outputs map[string][]string `json:"outputs"`
+	Inputs  map[string][]string `json:"inputs,omitempty"`
+}
+
+// instanceOutput records the resources an instance contributed to the
+// generated output and the files they were generated from
+type instanceOutput struct {
+	path       string
+	identities []string
+	inputs     []string
+	complete   bool // Every resource has an identity
+}
+
+// loadIncrementalManifest reads the manifest from outputDir, returning an empty manifest if there is none
+func loadIncrementalManifest(outputDir string) (*incrementalManifest, error) {
+	manifest := &incrementalManifest{Outputs: map[string][]string{}, Inputs: map[string][]string{}}
+
+	data, err := ioutil.ReadFile(filepath.Join(outputDir, IncrementalManifestFile))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read incremental manifest: %w", err)
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse incremental manifest: %w", err)
+	}
+	if manifest.Outputs == nil {
+		manifest.Outputs = map[string][]string{}
+	}
+	if manifest.Inputs == nil {
+		manifest.Inputs = map[string][]string{}
+	}
+
+	return manifest, nil
+}
+
+// trackOutputs records the resources produced by an instance in incremental
+// mode, along with the files they were generated from: the template files in
+// inputs and the values and secrets files
+func (g *Generator) trackOutputs(path string, resources []map[string]interface{}, inputs []string, opts GeneratorOptions) {
+	if g.manifest == nil {
+		return
+	}
+
+	output := instanceOutput{path: path, complete: true}
+	for _, resource := range resources {
+		identity, ok := hydrator.ResourceIdentity(resource)
+		if !ok {
+			output.complete = false
+			continue
+		}
+		output.identities = append(output.identities, identity)
+	}
+
+	output.inputs = append(output.inputs, inputs...)
+	for _, file := range []string{opts.ValuesFile, opts.SecretsFile} {
+		if file != "" {
+			output.inputs = append(output.inputs, file)
+		}
+	}
+	g.outputs = append(g.outputs, output)
+}
+
+// isUpToDate reports whether every recorded output of an instance is newer
+// than the instance and every file it was generated from
+func (g *Generator) isUpToDate(path, outputDir string) bool {
+	outputs, ok := g.manifest.Outputs[path]
+	if !ok || len(outputs) == 0 {
+		return false
+	}
+	// Manifests written before inputs were recorded cannot tell
+	inputs, ok := g.manifest.Inputs[path]
+	if !ok {
+		return false
+	}
+
+	var newest time.Time
+	for _, input := range append([]string{path}, inputs...) {
+		info, err := os.Stat(input)
+		if err != nil {
+			return false
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+
+	for _, output := range outputs {
+		outputInfo, err := os.Stat(filepath.Join(outputDir, output))
+		if err != nil {
+			return false
+		}
+		if outputInfo.ModTime().Before(newest) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// saveManifest records the outputs written for each generated instance.
+// Outputs are matched to instances by resource identity, as resources may
+// have been reordered since. An instance with a resource that cannot be
+// matched is left out, so it is always regenerated.
+func (g *Generator) saveManifest(resources []map[string]interface{}, outputDir, layout string) error {
+	if layout == "" {
+		layout = OutputLayoutFlat
+	}
+
+	filenames := make(map[string]string, len(resources))
+	for i, resource := range resources {
+		if identity, ok := hydrator.ResourceIdentity(resource); ok {
+			filenames[identity] = g.outputFilename(resource, i, layout)
+		}
+	}
+
+	for _, output := range g.outputs {
+		if output.path == StdinPath {
+			continue
+		}
+
+		var files []string
+		complete := output.complete
+		for _, identity := range output.identities {
+			filename, ok := filenames[identity]
+			if !ok {
+				complete = false
+				break
+			}
+			files = append(files, filename)
+		}
+
+		if !complete {
+			delete(g.manifest.Outputs, output.path)
+			delete(g.manifest.Inputs, output.path)
+			continue
+		}
+		g.manifest.Outputs[output.path] = files
+		g.manifest.Inputs[output.path] = output.inputs
+	}
+
+	data, err := json.MarshalIndent(g.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal incremental manifest: %w", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(outputDir, IncrementalManifestFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write incremental manifest: %w", err)
+	}
+
+	return nil
+}
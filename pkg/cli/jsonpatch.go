@@ -0,0 +1,282 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+)
+
+// PatchOperation is a single RFC 6902 JSON Patch operation
+type PatchOperation struct {
+	Op    string
+	Path  string
+	Value interface{}
+}
+
+// MarshalJSON omits the value of remove operations only, so add and replace
+// operations can set a field to null
+func (o PatchOperation) MarshalJSON() ([]byte, error) {
+	if o.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{o.Op, o.Path})
+	}
+	return json.Marshal(struct {
+		Op    string      `json:"op"`
+		Path  string      `json:"path"`
+		Value interface{} `json:"value"`
+	}{o.Op, o.Path, o.Value})
+}
+
+// JSONPatch returns the operations that turn current into desired. Objects are
+// compared key by key and arrays index by index; values of different types
+// are replaced. It returns nil if the values are equal.
+func JSONPatch(current, desired interface{}) []PatchOperation {
+	return appendPatch(nil, "", current, desired, true)
+}
+
+// JSONPatchFields is like JSONPatch but only patches the fields desired sets:
+// object fields that exist only in current, such as defaults filled in by the
+// API server, are left alone instead of removed
+func JSONPatchFields(current, desired interface{}) []PatchOperation {
+	return appendPatch(nil, "", current, desired, false)
+}
+
+// appendPatch appends the operations that turn current into desired at path.
+// Object fields missing from desired are removed only if prune is set.
+func appendPatch(ops []PatchOperation, path string, current, desired interface{}, prune bool) []PatchOperation {
+	switch desiredValue := desired.(type) {
+	case map[string]interface{}:
+		currentValue, ok := current.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		for _, key := range sortedKeys(currentValue) {
+			if _, ok := desiredValue[key]; !ok && prune {
+				ops = append(ops, PatchOperation{Op: "remove", Path: path + "/" + pointerToken(key)})
+			}
+		}
+		for _, key := range sortedKeys(desiredValue) {
+			childPath := path + "/" + pointerToken(key)
+			if currentChild, ok := currentValue[key]; ok {
+				ops = appendPatch(ops, childPath, currentChild, desiredValue[key], prune)
+			} else {
+				ops = append(ops, PatchOperation{Op: "add", Path: childPath, Value: desiredValue[key]})
+			}
+		}
+		return ops
+
+	case []interface{}:
+		currentValue, ok := current.([]interface{})
+		if !ok {
+			break
+		}
+
+		common := len(currentValue)
+		if len(desiredValue) < common {
+			common = len(desiredValue)
+		}
+		for i := 0; i < common; i++ {
+			ops = appendPatch(ops, fmt.Sprintf("%s/%d", path, i), currentValue[i], desiredValue[i], prune)
+		}
+		// Remove from the end so the remaining indexes stay valid
+		for i := len(currentValue) - 1; i >= len(desiredValue); i-- {
+			ops = append(ops, PatchOperation{Op: "remove", Path: fmt.Sprintf("%s/%d", path, i)})
+		}
+		for i := len(currentValue); i < len(desiredValue); i++ {
+			ops = append(ops, PatchOperation{Op: "add", Path: fmt.Sprintf("%s/%d", path, i), Value: desiredValue[i]})
+		}
+		return ops
+	}
+
+	if !reflect.DeepEqual(current, desired) {
+		ops = append(ops, PatchOperation{Op: "replace", Path: path, Value: desired})
+	}
+	return ops
+}
+
+// sortedKeys returns the keys of m in order, so patches are deterministic
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// pointerEscaper escapes a key for use as a JSON Pointer (RFC 6901) token
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// pointerToken escapes key for use in a JSON Patch path
+func pointerToken(key string) string {
+	return pointerEscaper.Replace(key)
+}
+
+// serverMetadataFields are set by the API server and never generated, so
+// they are ignored rather than removed by patches
+var serverMetadataFields = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "selfLink"}
+
+// resourcePatch is the JSON Patch that updates one current object to a
+// generated resource, or the whole resource if there is no current object
+type resourcePatch struct {
+	APIVersion string           `json:"apiVersion"`
+	Kind       string           `json:"kind"`
+	Namespace  string           `json:"namespace,omitempty"`
+	Name       string           `json:"name"`
+	Patch      []PatchOperation `json:"patch,omitempty"`
+	Create     interface{}      `json:"create,omitempty"`
+}
+
+// writeJSONPatches writes, as one JSON object per line, the JSON Patch from
+// each object in currentPath to the generated resource with the same
+// identity. Patches only touch the fields the generated resource sets.
+// Resources without a current object are reported under "create" rather than
+// as a patch; unchanged resources are skipped. Namespaced resources without a
+// namespace are matched in namespace, or "default" if it is empty, since that
+// is where they are created; clusterScoped reports the kinds that are not
+// namespaced.
+func writeJSONPatches(resources []map[string]interface{}, currentPath, namespace string, clusterScoped func(kind string) bool, w io.Writer) error {
+	current, err := loadCurrentObjects(currentPath)
+	if err != nil {
+		return err
+	}
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	for i, resource := range resources {
+		kind, _ := resource["kind"].(string)
+		metadata, _ := resource["metadata"].(map[string]interface{})
+		resourceNamespace, _ := metadata["namespace"].(string)
+		if resourceNamespace == "" && !clusterScoped(kind) {
+			resourceNamespace = namespace
+		}
+
+		key, ok := hydrator.ResourceIdentity(withNamespace(resource, resourceNamespace))
+		if !ok {
+			return fmt.Errorf("resource #%d has no apiVersion, kind or name to match a current object", i+1)
+		}
+
+		desired, err := normalizeResource(resource)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %w", key, err)
+		}
+
+		var patch resourcePatch
+		if object, ok := current[key]; ok {
+			patch.Patch = JSONPatchFields(object, desired)
+			if len(patch.Patch) == 0 {
+				continue
+			}
+		} else {
+			patch.Create = desired
+		}
+
+		patch.APIVersion, _ = resource["apiVersion"].(string)
+		patch.Kind = kind
+		patch.Namespace = resourceNamespace
+		patch.Name, _ = metadata["name"].(string)
+
+		data, err := json.Marshal(patch)
+		if err != nil {
+			return fmt.Errorf("failed to encode patch for %s: %w", key, err)
+		}
+		if _, err := fmt.Fprintf(w, "%s\n", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// withNamespace returns resource with metadata.namespace set to namespace,
+// copying the resource and its metadata rather than modifying them
+func withNamespace(resource map[string]interface{}, namespace string) map[string]interface{} {
+	metadata, ok := resource["metadata"].(map[string]interface{})
+	if !ok || namespace == "" {
+		return resource
+	}
+
+	copied := make(map[string]interface{}, len(resource))
+	for key, value := range resource {
+		copied[key] = value
+	}
+	copiedMetadata := make(map[string]interface{}, len(metadata)+1)
+	for key, value := range metadata {
+		copiedMetadata[key] = value
+	}
+	copiedMetadata["namespace"] = namespace
+	copied["metadata"] = copiedMetadata
+	return copied
+}
+
+// loadCurrentObjects reads the current objects in path by identity, without
+// the status and metadata fields maintained by the API server. The items of
+// a List, as written by kubectl get -o yaml, are read as separate objects.
+func loadCurrentObjects(path string) (map[string]map[string]interface{}, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open current objects: %w", err)
+	}
+	defer file.Close()
+
+	documents, err := readInstances(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read current objects from %s: %w", path, err)
+	}
+
+	var objects []map[string]interface{}
+	for _, document := range documents {
+		kind, _ := document["kind"].(string)
+		items, ok := document["items"].([]interface{})
+		if !ok || !strings.HasSuffix(kind, "List") {
+			objects = append(objects, document)
+			continue
+		}
+		for i, item := range items {
+			object, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("failed to read current objects from %s: %s item #%d is not an object", path, kind, i+1)
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	current := make(map[string]map[string]interface{}, len(objects))
+	for _, object := range objects {
+		delete(object, "status")
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			for _, field := range serverMetadataFields {
+				delete(metadata, field)
+			}
+		}
+		if key, ok := hydrator.ResourceIdentity(object); ok {
+			current[key] = object
+		}
+	}
+	return current, nil
+}
+
+// normalizeResource converts a generated resource to the JSON representation
+// current objects are read in, so equal values compare equal
+func normalizeResource(resource map[string]interface{}) (interface{}, error) {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return nil, err
+	}
+
+	normalized, err := decodeJSONObject(data)
+	if err != nil {
+		return nil, err
+	}
+	return normalized, nil
+}
@@ -0,0 +1,318 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		current interface{}
+		desired interface{}
+		want    []PatchOperation
+	}{
+		{
+			name:    "equal objects",
+			current: map[string]interface{}{"a": int64(1), "b": []interface{}{"x"}},
+			desired: map[string]interface{}{"a": int64(1), "b": []interface{}{"x"}},
+			want:    nil,
+		},
+		{
+			name: "add, remove and replace fields",
+			current: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": int64(1), "paused": true},
+			},
+			desired: map[string]interface{}{
+				"spec": map[string]interface{}{"replicas": int64(3), "minReadySeconds": int64(10)},
+			},
+			want: []PatchOperation{
+				{Op: "remove", Path: "/spec/paused"},
+				{Op: "add", Path: "/spec/minReadySeconds", Value: int64(10)},
+				{Op: "replace", Path: "/spec/replicas", Value: int64(3)},
+			},
+		},
+		{
+			name:    "array grows",
+			current: map[string]interface{}{"args": []interface{}{"a"}},
+			desired: map[string]interface{}{"args": []interface{}{"b", "c", "d"}},
+			want: []PatchOperation{
+				{Op: "replace", Path: "/args/0", Value: "b"},
+				{Op: "add", Path: "/args/1", Value: "c"},
+				{Op: "add", Path: "/args/2", Value: "d"},
+			},
+		},
+		{
+			name:    "array shrinks from the end",
+			current: []interface{}{"a", "b", "c"},
+			desired: []interface{}{"a"},
+			want: []PatchOperation{
+				{Op: "remove", Path: "/2"},
+				{Op: "remove", Path: "/1"},
+			},
+		},
+		{
+			name:    "type change is replaced",
+			current: map[string]interface{}{"ports": "80"},
+			desired: map[string]interface{}{"ports": []interface{}{int64(80)}},
+			want:    []PatchOperation{{Op: "replace", Path: "/ports", Value: []interface{}{int64(80)}}},
+		},
+		{
+			name:    "keys are escaped",
+			current: map[string]interface{}{"labels": map[string]interface{}{"app.kubernetes.io/name": "old", "a~b": "x"}},
+			desired: map[string]interface{}{"labels": map[string]interface{}{"app.kubernetes.io/name": "new"}},
+			want: []PatchOperation{
+				{Op: "remove", Path: "/labels/a~0b"},
+				{Op: "replace", Path: "/labels/app.kubernetes.io~1name", Value: "new"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JSONPatch(tt.current, tt.desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("JSONPatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONPatchFields(t *testing.T) {
+	tests := []struct {
+		name    string
+		current interface{}
+		desired interface{}
+		want    []PatchOperation
+	}{
+		{
+			name: "server defaults are kept",
+			current: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas":             int64(1),
+					"revisionHistoryLimit": int64(10),
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:v1", "imagePullPolicy": "IfNotPresent"},
+					},
+				},
+			},
+			desired: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": int64(3),
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:v2"},
+					},
+				},
+			},
+			want: []PatchOperation{
+				{Op: "replace", Path: "/spec/containers/0/image", Value: "app:v2"},
+				{Op: "replace", Path: "/spec/replicas", Value: int64(3)},
+			},
+		},
+		{
+			name:    "generated arrays still shrink",
+			current: map[string]interface{}{"args": []interface{}{"a", "b"}},
+			desired: map[string]interface{}{"args": []interface{}{"a"}},
+			want:    []PatchOperation{{Op: "remove", Path: "/args/1"}},
+		},
+		{
+			name:    "new fields are added",
+			current: map[string]interface{}{"data": map[string]interface{}{"a": "1"}},
+			desired: map[string]interface{}{"data": map[string]interface{}{"b": "2"}},
+			want:    []PatchOperation{{Op: "add", Path: "/data/b", Value: "2"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := JSONPatchFields(tt.current, tt.desired)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("JSONPatchFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchOperationMarshalJSON(t *testing.T) {
+	data, err := json.Marshal([]PatchOperation{
+		{Op: "remove", Path: "/a"},
+		{Op: "replace", Path: "/b", Value: nil},
+	})
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	want := `[{"op":"remove","path":"/a"},{"op":"replace","path":"/b","value":null}]`
+	if string(data) != want {
+		t.Errorf("Marshal() = %s, want %s", data, want)
+	}
+}
+
+func TestWriteJSONPatches(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "jsonpatch-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	current := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: prod
+  uid: 1234
+  resourceVersion: "42"
+spec:
+  replicas: 1
+  progressDeadlineSeconds: 600
+status:
+  readyReplicas: 1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: web-config
+  namespace: prod
+data:
+  mode: fast
+`
+	currentPath := filepath.Join(tempDir, "current.yaml")
+	if err := os.WriteFile(currentPath, []byte(current), 0644); err != nil {
+		t.Fatalf("failed to write current objects: %v", err)
+	}
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "prod"},
+			"spec":       map[string]interface{}{"replicas": 3},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "web-config", "namespace": "prod"},
+			"data":       map[string]interface{}{"mode": "fast"},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata":   map[string]interface{}{"name": "web", "namespace": "prod"},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeJSONPatches(resources, currentPath, "", neverClusterScoped, &out); err != nil {
+		t.Fatalf("writeJSONPatches() error = %v", err)
+	}
+
+	want := []string{
+		`{"apiVersion":"apps/v1","kind":"Deployment","namespace":"prod","name":"web","patch":[{"op":"replace","path":"/spec/replicas","value":3}]}`,
+		`{"apiVersion":"v1","kind":"Service","namespace":"prod","name":"web","create":{"apiVersion":"v1","kind":"Service","metadata":{"name":"web","namespace":"prod"}}}`,
+	}
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("writeJSONPatches() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func neverClusterScoped(string) bool {
+	return false
+}
+
+func TestWriteJSONPatchesFromList(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "jsonpatch-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// kubectl get -o yaml wraps the objects in a List, and every namespaced
+	// object carries its namespace
+	current := `apiVersion: v1
+kind: List
+metadata:
+  resourceVersion: ""
+items:
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: web-config
+    namespace: default
+  data:
+    mode: slow
+- apiVersion: v1
+  kind: ConfigMap
+  metadata:
+    name: web-config
+    namespace: staging
+  data:
+    mode: slow
+- apiVersion: v1
+  kind: Namespace
+  metadata:
+    name: web
+  spec:
+    finalizers: [kubernetes]
+`
+	currentPath := filepath.Join(tempDir, "current.yaml")
+	if err := os.WriteFile(currentPath, []byte(current), 0644); err != nil {
+		t.Fatalf("failed to write current objects: %v", err)
+	}
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "web-config"},
+			"data":       map[string]interface{}{"mode": "fast"},
+		},
+		{
+			"apiVersion": "v1",
+			"kind":       "Namespace",
+			"metadata":   map[string]interface{}{"name": "web", "labels": map[string]interface{}{"team": "web"}},
+		},
+	}
+	clusterScoped := func(kind string) bool { return kind == "Namespace" }
+
+	tests := []struct {
+		name      string
+		namespace string
+		want      []string
+	}{
+		{
+			name: "default namespace",
+			want: []string{
+				`{"apiVersion":"v1","kind":"ConfigMap","namespace":"default","name":"web-config","patch":[{"op":"replace","path":"/data/mode","value":"fast"}]}`,
+				`{"apiVersion":"v1","kind":"Namespace","name":"web","patch":[{"op":"add","path":"/metadata/labels","value":{"team":"web"}}]}`,
+			},
+		},
+		{
+			name:      "namespace flag",
+			namespace: "staging",
+			want: []string{
+				`{"apiVersion":"v1","kind":"ConfigMap","namespace":"staging","name":"web-config","patch":[{"op":"replace","path":"/data/mode","value":"fast"}]}`,
+				`{"apiVersion":"v1","kind":"Namespace","name":"web","patch":[{"op":"add","path":"/metadata/labels","value":{"team":"web"}}]}`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := writeJSONPatches(resources, currentPath, tt.namespace, clusterScoped, &out); err != nil {
+				t.Fatalf("writeJSONPatches() error = %v", err)
+			}
+
+			got := strings.Split(strings.TrimSpace(out.String()), "\n")
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("writeJSONPatches() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
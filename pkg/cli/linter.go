@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/ast"
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+)
+
+// LinterOptions contains options for linting templates
+type LinterOptions struct {
+	TemplateFiles []string
+	Verbose       bool
+}
+
+// Linter checks templates for mistakes without hydrating them
+type Linter struct {
+	opts   LinterOptions
+	stderr io.Writer
+}
+
+// NewLinter creates a new linter
+func NewLinter(opts LinterOptions) *Linter {
+	return &Linter{opts: opts, stderr: os.Stderr}
+}
+
+// Lint parses every template and reports the ones that fail to parse. It
+// also warns about resource() references to kinds a template never creates
+// and about unused @for loop variables, which do not fail the lint.
+// Expressions reading undefined variables, such as a typo in a where clause,
+// fail to parse.
+func (l *Linter) Lint() error {
+	var files []string
+	for _, path := range l.opts.TemplateFiles {
+		expanded, err := expandTemplateFiles(path)
+		if err != nil {
+			return err
+		}
+		files = append(files, expanded...)
+	}
+
+	failed := 0
+	for _, file := range files {
+		if l.opts.Verbose {
+			fmt.Printf("Linting: %s\n", file)
+		}
+
+		warnings, err := lintTemplateFile(file)
+		if err != nil {
+			fmt.Fprintf(l.stderr, "✗ %s: %v\n", file, err)
+			failed++
+			continue
+		}
+		for _, warning := range warnings {
+			fmt.Fprintf(l.stderr, "⚠ %s: %s\n", file, warning)
+		}
+
+		fmt.Printf("✓ %s\n", file)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d templates failed lint", failed, len(files))
+	}
+
+	fmt.Println("\nAll templates passed lint!")
+	return nil
+}
+
+// lintTemplateFile parses a single template file to an AST and returns
+// warnings about likely dangling resource references and loop variable mistakes
+func lintTemplateFile(path string) ([]string, error) {
+	root, err := hydrator.ParseTemplateFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	warnings := hydrator.DanglingReferences(root)
+	return append(warnings, ast.UnusedLoopVariables(root)...), nil
+}
+
+// expandTemplateFiles returns path itself, or the YAML files in path if it is a directory
+func expandTemplateFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if ext == ".yaml" || ext == ".yml" {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
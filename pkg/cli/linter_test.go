@@ -0,0 +1,144 @@
+package cli
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "linter-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	valid := `resources:
+  - "@for(item in .spec.items)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(item.name)"
+`
+	invalid := `resources:
+  - "@if(\"enabled\")":
+      apiVersion: v1
+      kind: ConfigMap
+`
+
+	validPath := filepath.Join(tempDir, "valid.yaml")
+	invalidPath := filepath.Join(tempDir, "invalid.yaml")
+	if err := os.WriteFile(validPath, []byte(valid), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	if err := os.WriteFile(invalidPath, []byte(invalid), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	if err := NewLinter(LinterOptions{TemplateFiles: []string{validPath}}).Lint(); err != nil {
+		t.Errorf("Lint() on valid template error = %v", err)
+	}
+
+	if err := NewLinter(LinterOptions{TemplateFiles: []string{invalidPath}}).Lint(); err == nil {
+		t.Error("Expected lint error for string literal condition")
+	}
+
+	if err := NewLinter(LinterOptions{TemplateFiles: []string{tempDir}}).Lint(); err == nil {
+		t.Error("Expected lint error when linting a directory containing an invalid template")
+	}
+}
+
+func TestLintDanglingReferences(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "linter-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: web
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: config
+    data:
+      ip: $(resource("v1", "Service", "web").spec.clusterIP)
+      secret: $(resource("v1", "Secret", "web-tls").metadata.name)
+`
+	path := filepath.Join(tempDir, "webservice_v1.yaml")
+	if err := os.WriteFile(path, []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	var stderr bytes.Buffer
+	linter := NewLinter(LinterOptions{TemplateFiles: []string{path}})
+	linter.stderr = &stderr
+	if err := linter.Lint(); err != nil {
+		t.Errorf("Expected dangling references to warn without failing, got %v", err)
+	}
+
+	if !strings.Contains(stderr.String(), "reference to v1/Secret/web-tls") {
+		t.Errorf("Expected a warning for the Secret reference, got:\n%s", stderr.String())
+	}
+	if strings.Contains(stderr.String(), "v1/Service/web") {
+		t.Errorf("Expected no warning for the Service reference, got:\n%s", stderr.String())
+	}
+}
+
+func TestLintLoopVariables(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "linter-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	unused := `resources:
+  - "@for(item in .spec.items)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(.metadata.name)"
+`
+	typo := `resources:
+  - "@for(item in .spec.items where itme.enabled)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(item.name)"
+`
+	unusedPath := filepath.Join(tempDir, "unused.yaml")
+	typoPath := filepath.Join(tempDir, "typo.yaml")
+	if err := os.WriteFile(unusedPath, []byte(unused), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	if err := os.WriteFile(typoPath, []byte(typo), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	// An unused loop variable warns without failing
+	var stderr bytes.Buffer
+	linter := NewLinter(LinterOptions{TemplateFiles: []string{unusedPath}})
+	linter.stderr = &stderr
+	if err := linter.Lint(); err != nil {
+		t.Errorf("Expected an unused loop variable to warn without failing, got %v", err)
+	}
+	if !strings.Contains(stderr.String(), "loop variable 'item' of @for(item in ...) is never used") {
+		t.Errorf("Expected a warning for the unused loop variable, got:\n%s", stderr.String())
+	}
+
+	// A where clause reading an undefined variable fails
+	stderr.Reset()
+	linter = NewLinter(LinterOptions{TemplateFiles: []string{typoPath}})
+	linter.stderr = &stderr
+	if err := linter.Lint(); err == nil {
+		t.Error("Expected lint error for an undefined variable in a where clause")
+	}
+	if !strings.Contains(stderr.String(), "unknown variable 'itme'") {
+		t.Errorf("Expected the undefined variable to be reported, got:\n%s", stderr.String())
+	}
+}
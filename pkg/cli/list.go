@@ -0,0 +1,32 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+)
+
+// DefaultTemplateDir is the directory the generator looks up templates in
+const DefaultTemplateDir = "."
+
+// ListKinds writes the kinds and versions that have a template in dir to w,
+// one per line. Templates used by every version are listed with version "*".
+func ListKinds(dir string, w io.Writer) error {
+	kinds, err := hydrator.ListTemplates(dir)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tVERSION\tTEMPLATE")
+	for _, kind := range kinds {
+		version := kind.Version
+		if version == "" {
+			version = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", kind.Kind, version, kind.Path)
+	}
+	return tw.Flush()
+}
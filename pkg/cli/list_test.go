@@ -0,0 +1,53 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListKinds(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "list-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := []string{
+		"webservice_v1alpha1.yaml",
+		"webservice_v1beta1.yaml",
+		"web_database_template.yaml",
+		"instance.yaml",      // Bare names are not listed
+		"notes_draft.yaml",   // Not a version
+		"cronjob_v1.yml",     // Not a template extension
+		"kustomization.yaml", // Not a template
+	}
+	for _, file := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, file), []byte("resources: []\n"), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", file, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(tempDir, "queue_v1.yaml"), 0755); err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	var out strings.Builder
+	if err := ListKinds(tempDir, &out); err != nil {
+		t.Fatalf("ListKinds() error = %v", err)
+	}
+
+	var listed []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n")[1:] {
+		fields := strings.Fields(line)
+		listed = append(listed, fields[0]+" "+fields[1])
+	}
+	expected := []string{"web_database *", "webservice v1alpha1", "webservice v1beta1"}
+	if strings.Join(listed, ",") != strings.Join(expected, ",") {
+		t.Errorf("ListKinds() listed %v, want %v\n%s", listed, expected, out.String())
+	}
+
+	if err := ListKinds(filepath.Join(tempDir, "missing"), &out); err == nil {
+		t.Error("Expected error for a missing template directory")
+	}
+}
@@ -0,0 +1,92 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Report summarizes a generation run: the instances processed, the resources
+// produced by kind and the warnings raised. A nil Report records nothing.
+type Report struct {
+	Instances int
+	Kinds     map[string]int
+	Warnings  []string
+}
+
+// NewReport creates an empty report
+func NewReport() *Report {
+	return &Report{Kinds: make(map[string]int)}
+}
+
+// AddInstance counts one more processed instance
+func (r *Report) AddInstance() {
+	if r == nil {
+		return
+	}
+	r.Instances++
+}
+
+// AddResources counts resources by kind
+func (r *Report) AddResources(resources []map[string]interface{}) {
+	if r == nil {
+		return
+	}
+	for _, resource := range resources {
+		kind, _ := resource["kind"].(string)
+		r.Kinds[kind]++
+	}
+}
+
+// AddWarning records a warning
+func (r *Report) AddWarning(warning string) {
+	if r == nil {
+		return
+	}
+	r.Warnings = append(r.Warnings, warning)
+}
+
+// Resources returns the number of resources counted
+func (r *Report) Resources() int {
+	total := 0
+	for _, count := range r.Kinds {
+		total += count
+	}
+	return total
+}
+
+// Write prints the totals, a table of resources by kind and the warnings
+func (r *Report) Write(w io.Writer) error {
+	fmt.Fprintf(w, "Processed %d instances, produced %d resources\n", r.Instances, r.Resources())
+
+	kinds := make([]string, 0, len(r.Kinds))
+	for kind := range r.Kinds {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tCOUNT")
+	for _, kind := range kinds {
+		fmt.Fprintf(tw, "%s\t%d\n", kind, r.Kinds[kind])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Warnings: %d\n", len(r.Warnings))
+	for _, warning := range r.Warnings {
+		if _, err := fmt.Fprintf(w, "  %s\n", warning); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// warnf prints a warning to stderr and records it in the report, if any
+func (g *Generator) warnf(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	fmt.Fprintf(g.stderr, "Warning: %s\n", warning)
+	g.report.AddWarning(warning)
+}
@@ -0,0 +1,162 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateReport(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: shared
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+  - "@for(port in .spec.ports)":
+      apiVersion: v1
+      kind: Service
+      metadata:
+        name: "@expr(.metadata.name + \"-\" + port)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	instances := "apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: web\nspec:\n  ports: [80, 443]\n" +
+		"---\n" +
+		"apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: api\nspec:\n  ports: []\n"
+
+	outputDir := filepath.Join(tempDir, "out")
+	opts := GeneratorOptions{
+		InputFiles:      []string{StdinPath},
+		OutputDir:       outputDir,
+		OutputLayout:    OutputLayoutByKind,
+		AllowDuplicates: true,
+		Report:          true,
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader(instances)
+	var stderr strings.Builder
+	g.stderr = &stderr
+	if err := g.Generate(opts); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// The report counts every produced resource, including both copies of the duplicate
+	want := map[string]int{"ConfigMap": 2, "Service": 4}
+	if !reflect.DeepEqual(g.report.Kinds, want) {
+		t.Errorf("report kinds = %v, want %v", g.report.Kinds, want)
+	}
+	if g.report.Instances != 2 {
+		t.Errorf("report instances = %d, want 2", g.report.Instances)
+	}
+
+	services, err := os.ReadDir(filepath.Join(outputDir, "service"))
+	if err != nil {
+		t.Fatalf("failed to read output: %v", err)
+	}
+	if len(services) != want["Service"] {
+		t.Errorf("wrote %d services, report counted %d", len(services), want["Service"])
+	}
+
+	output := stderr.String()
+	for _, line := range []string{
+		"Processed 2 instances, produced 6 resources",
+		"ConfigMap  2",
+		"Service    4",
+		"Warnings: 1",
+		"duplicate resource v1/ConfigMap/shared",
+	} {
+		if !strings.Contains(output, line) {
+			t.Errorf("Expected report to contain %q, got:\n%s", line, output)
+		}
+	}
+}
+
+func TestGenerateReportSchemaWarning(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "generator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`
+	if err := os.WriteFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+	t.Chdir(tempDir)
+
+	// The WebService schema loads, but the broken CRD after it fails LoadSchemas
+	crdDir := filepath.Join(tempDir, "config", "crd")
+	if err := os.MkdirAll(crdDir, 0755); err != nil {
+		t.Fatalf("failed to create CRD dir: %v", err)
+	}
+	crd := `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: webservices.platform.example.com
+spec:
+  group: platform.example.com
+  names:
+    kind: WebService
+  versions:
+    - name: v1alpha1
+      schema:
+        openAPIV3Schema:
+          type: object
+          x-kubernetes-preserve-unknown-fields: true
+`
+	if err := os.WriteFile(filepath.Join(crdDir, "a_webservice.yaml"), []byte(crd), 0644); err != nil {
+		t.Fatalf("failed to write CRD: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(crdDir, "b_broken.yaml"), []byte("spec: ["), 0644); err != nil {
+		t.Fatalf("failed to write CRD: %v", err)
+	}
+
+	opts := GeneratorOptions{
+		InputFiles:   []string{StdinPath},
+		OutputDir:    filepath.Join(tempDir, "out"),
+		OutputLayout: OutputLayoutByKind,
+		Validate:     true,
+		Report:       true,
+	}
+	g := NewGenerator(opts)
+	g.stdin = strings.NewReader("apiVersion: platform.example.com/v1alpha1\nkind: WebService\nmetadata:\n  name: web\n")
+	var stderr strings.Builder
+	g.stderr = &stderr
+	if err := g.Generate(opts); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if len(g.report.Warnings) != 1 || !strings.HasPrefix(g.report.Warnings[0], "failed to load schemas") {
+		t.Errorf("report warnings = %v, want the schema loading failure", g.report.Warnings)
+	}
+	if !strings.Contains(stderr.String(), "Warning: failed to load schemas") {
+		t.Errorf("Expected the warning on stderr, got:\n%s", stderr.String())
+	}
+}
+
+func TestReportNil(t *testing.T) {
+	var report *Report
+	report.AddInstance()
+	report.AddResources([]map[string]interface{}{{"kind": "Service"}})
+	report.AddWarning("ignored")
+}
@@ -1,9 +1,15 @@
 package dsl
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestArrayIndexing(t *testing.T) {
@@ -227,6 +233,81 @@ func TestStringConcatenation(t *testing.T) {
 	}
 }
 
+func TestArithmeticErrors(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"name":    "abc",
+			"port":    "8080",
+			"offset":  int64(1),
+			"enabled": true,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  string
+	}{
+		{
+			name:    "non-numeric left operand",
+			expr:    ".spec.name - 1",
+			wantErr: `cannot subtract: left operand "abc" is not numeric`,
+		},
+		{
+			name:    "non-numeric right operand",
+			expr:    "10 * .spec.name",
+			wantErr: `cannot multiply: right operand "abc" is not numeric`,
+		},
+		{
+			name:    "boolean operand",
+			expr:    ".spec.enabled / 2",
+			wantErr: `cannot divide: left operand true is not numeric`,
+		},
+		{
+			name:     "numeric string plus number adds",
+			expr:     ".spec.port + .spec.offset",
+			expected: int64(8081),
+		},
+		{
+			name:     "non-numeric string plus number concatenates",
+			expr:     ".spec.name + .spec.offset",
+			expected: "abc1",
+		},
+		{
+			name:     "two strings concatenate",
+			expr:     ".spec.port + \"1\"",
+			expected: "80801",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if tt.wantErr != "" {
+				if err == nil {
+					t.Fatalf("Expected error %q, got result %v", tt.wantErr, result)
+				}
+				if err.Error() != tt.wantErr {
+					t.Errorf("Evaluate() error = %q, want %q", err.Error(), tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Evaluate() = %v (%T), want %v (%T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
 func TestCombinedFeatures(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -318,6 +399,18 @@ func TestParseForLoop(t *testing.T) {
 			wantIterPath: "container.ports",
 			wantErr:      false,
 		},
+		{
+			name:         "function call",
+			expr:         "host in list(.spec.hosts)",
+			wantVarName:  "host",
+			wantIterPath: "list(.spec.hosts)",
+			wantErr:      false,
+		},
+		{
+			name:    "string literal",
+			expr:    `item in "items"`,
+			wantErr: true,
+		},
 		{
 			name:         "loop with spaces",
 			expr:         "  item  in  .spec.items  ",
@@ -354,6 +447,86 @@ func TestParseForLoop(t *testing.T) {
 	}
 }
 
+func TestParseForLoopClauses(t *testing.T) {
+	tests := []struct {
+		name        string
+		expr        string
+		wantClauses []ForClause
+		wantFilter  string
+		wantErr     bool
+	}{
+		{
+			name:        "single clause",
+			expr:        "item in .spec.items",
+			wantClauses: []ForClause{{"item", ".spec.items"}},
+		},
+		{
+			name:        "product",
+			expr:        "r in .spec.regions, t in .spec.tiers where r != t",
+			wantClauses: []ForClause{{"r", ".spec.regions"}, {"t", ".spec.tiers"}},
+			wantFilter:  "r != t",
+		},
+		{
+			name:        "commas in destructure lists and calls",
+			expr:        `{name, port} in .spec.services, h in list(.spec.a, ",")`,
+			wantClauses: []ForClause{{"{name, port}", ".spec.services"}, {"h", `list(.spec.a, ",")`}},
+		},
+		{
+			name:    "empty clause",
+			expr:    "r in .spec.regions,",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clauses, filter, _, _, err := ParseForLoopClauses(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseForLoopClauses() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(clauses, tt.wantClauses) {
+				t.Errorf("clauses = %v, want %v", clauses, tt.wantClauses)
+			}
+			if filter != tt.wantFilter {
+				t.Errorf("filter = %q, want %q", filter, tt.wantFilter)
+			}
+		})
+	}
+
+	if _, _, _, _, _, err := ParseForLoopWithPaging("r in .spec.regions, t in .spec.tiers"); err == nil {
+		t.Error("Expected ParseForLoopWithPaging() to reject several clauses")
+	}
+	if _, _, _, err := ParseForLoopWithFilter("r in .spec.regions, t in .spec.tiers"); err == nil {
+		t.Error("Expected ParseForLoopWithFilter() to reject several clauses")
+	}
+}
+
+func TestParseForLoopWithFilter(t *testing.T) {
+	varName, iterPath, filter, err := ParseForLoopWithFilter("item in .spec.items where item.enabled")
+	if err != nil {
+		t.Fatalf("ParseForLoopWithFilter() error = %v", err)
+	}
+	if varName != "item" || iterPath != ".spec.items" || filter != "item.enabled" {
+		t.Errorf("ParseForLoopWithFilter() = %q, %q, %q", varName, iterPath, filter)
+	}
+
+	// Paging clauses are only understood by ParseForLoopWithPaging
+	if _, _, _, err := ParseForLoopWithFilter("item in .spec.items limit 5"); err == nil {
+		t.Error("Expected ParseForLoopWithFilter() to reject a limit clause")
+	}
+
+	varName, iterPath, filter, limit, offset, err := ParseForLoopWithPaging("item in .spec.items where item.enabled limit 5 offset 2")
+	if err != nil {
+		t.Fatalf("ParseForLoopWithPaging() error = %v", err)
+	}
+	if varName != "item" || iterPath != ".spec.items" || filter != "item.enabled" || limit == nil || *limit != 5 || offset != 2 {
+		t.Errorf("ParseForLoopWithPaging() = %q, %q, %q, %v, %d", varName, iterPath, filter, limit, offset)
+	}
+}
+
 func TestInlineIfFunction(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -486,6 +659,78 @@ func TestInlineIfFunction(t *testing.T) {
 	}
 }
 
+func TestEvaluateStringEscapes(t *testing.T) {
+	data := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "my-app",
+		},
+		"spec": map[string]interface{}{
+			"ha":      true,
+			"command": "$(whoami)",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "escaped literal only",
+			input:    "echo started at $$(date)",
+			expected: "echo started at $(date)",
+		},
+		{
+			name:     "substitution and escape on the same line",
+			input:    "echo $(.metadata.name) started at $$(date +%s)",
+			expected: "echo my-app started at $(date +%s)",
+		},
+		{
+			name:     "escape before substitution",
+			input:    "$$(hostname)-$(.metadata.name)",
+			expected: "$(hostname)-my-app",
+		},
+		{
+			name:     "escape consumed exactly once",
+			input:    "$$(echo $$(date)) $$$$(date)",
+			expected: "$(echo $(date)) $$$(date)",
+		},
+		{
+			name:     "escape next to inline if",
+			input:    "$if(.spec.ha, \"ha\", \"single\") $$(uptime)",
+			expected: "ha $(uptime)",
+		},
+		{
+			name:     "escaped inline if",
+			input:    "$$if(.spec.ha, \"ha\", \"single\") $if(.spec.ha, \"ha\", \"single\")",
+			expected: "$if(.spec.ha, \"ha\", \"single\") ha",
+		},
+		{
+			name:     "substituted values are not re-evaluated",
+			input:    "run: $(.spec.command)",
+			expected: "run: $(whoami)",
+		},
+		{
+			name:    "unescaped shell substitution still fails",
+			input:   "echo $(.metadata.name) at $(date)",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewEvaluator(data).EvaluateString(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvaluateString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("EvaluateString() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestEvaluateStringWithInlineIf(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -804,312 +1049,286 @@ func TestComparisonOperators(t *testing.T) {
 	}
 }
 
-func TestEdgeCases(t *testing.T) {
+func TestCollectionEquality(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"labels":  map[string]interface{}{"app": "web", "tier": "frontend"},
+			"same":    map[string]interface{}{"tier": "frontend", "app": "web"},
+			"other":   map[string]interface{}{"app": "web", "tier": "backend"},
+			"nested":  map[string]interface{}{"ports": []interface{}{int64(80), int64(443)}},
+			"ports":   []interface{}{int64(80), int64(443)},
+			"joined":  []interface{}{"a b"},
+			"split":   []interface{}{"a", "b"},
+			"strings": []string{"a", "b"},
+		},
+	}
+
 	tests := []struct {
-		name    string
-		expr    string
-		data    interface{}
-		wantErr bool
-		errMsg  string
+		name     string
+		expr     string
+		expected bool
 	}{
-		{
-			name: "division by zero",
-			expr: ".spec.value / 0",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"value": 10,
-				},
-			},
-			wantErr: true,
-			errMsg:  "division by zero",
-		},
-		{
-			name: "modulo by zero",
-			expr: ".spec.value % 0",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"value": 10,
-				},
-			},
-			wantErr: true,
-			errMsg:  "modulo by zero",
-		},
-		{
-			name: "negative array index",
-			expr: ".items[-1]",
-			data: map[string]interface{}{
-				"items": []interface{}{"a", "b", "c"},
-			},
-			wantErr: true,
-		},
-		{
-			name: "unknown function",
-			expr: "unknownFunc(.spec.name)",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"name": "test",
-				},
-			},
-			wantErr: true,
-			errMsg:  "unknown function",
-		},
+		{name: "equal maps", expr: `.spec.labels == .spec.same`, expected: true},
+		{name: "unequal maps", expr: `.spec.labels == .spec.other`, expected: false},
+		{name: "unequal maps with !=", expr: `.spec.labels != .spec.other`, expected: true},
+		{name: "map and literal", expr: `.spec.labels == {tier: "frontend", app: "web"}`, expected: true},
+		{name: "map with missing key", expr: `.spec.labels == {app: "web"}`, expected: false},
+		{name: "equal arrays", expr: `.spec.ports == [80, 443]`, expected: true},
+		{name: "order matters", expr: `.spec.ports == [443, 80]`, expected: false},
+		{name: "different lengths", expr: `.spec.ports == [80]`, expected: false},
+		{name: "elements compared as strings", expr: `.spec.ports == ["80", "443"]`, expected: true},
+		{name: "same string form but different elements", expr: `.spec.joined == .spec.split`, expected: false},
+		{name: "typed slice", expr: `.spec.strings == .spec.split`, expected: true},
+		{name: "nested collections", expr: `.spec.nested == {ports: [80, 443]}`, expected: true},
+		{name: "map and its string form", expr: `.spec.labels == "map[app:web tier:frontend]"`, expected: false},
+		{name: "array and missing field", expr: `.spec.ports != .spec.missing`, expected: true},
+		{name: "empty collections", expr: `[] == []`, expected: true},
+		{name: "empty map and array", expr: `{} == []`, expected: false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			expr, err := ParseExpression(tt.expr)
-			if err != nil && !tt.wantErr {
+			if err != nil {
 				t.Fatalf("ParseExpression() error = %v", err)
 			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
 			if err != nil {
-				return // Parse error is expected for some tests
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
 			}
+		})
+	}
+}
 
-			evaluator := NewEvaluator(tt.data)
-			_, err = evaluator.Evaluate(expr)
+func TestOptionalChaining(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"tls":     map[string]interface{}{"enabled": true, "secret": "web-tls"},
+			"ingress": nil,
+			"name":    "web",
+			"backup":  map[string]interface{}{"secret": nil},
+		},
+		"item": map[string]interface{}{"port": int64(80)},
+	}
 
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
-				return
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "full path present", expr: `.spec?.tls?.enabled`, expected: true},
+		{name: "missing root field", expr: `.status?.ready`, expected: nil},
+		{name: "missing intermediate map", expr: `.spec?.auth?.enabled`, expected: nil},
+		{name: "missing final field", expr: `.spec.tls?.port`, expected: nil},
+		{name: "null intermediate map", expr: `.spec.ingress?.host`, expected: nil},
+		{name: "rest of path skipped", expr: `.spec?.auth.oidc.issuer`, expected: nil},
+		{name: "null final optional field skips rest of path", expr: `.spec.backup?.secret.name`, expected: nil},
+		{name: "required access after present optional", expr: `.spec?.tls.port`, wantErr: true},
+		{name: "required access still errors", expr: `.spec.auth.enabled`, wantErr: true},
+		{name: "non-map intermediate", expr: `.spec.name?.first`, wantErr: true},
+		{name: "loop variable", expr: `item?.port`, expected: int64(80)},
+		{name: "missing loop variable field", expr: `item?.protocol`, expected: nil},
+		{name: "with default", expr: `default(.spec?.auth?.issuer, "none")`, expected: "none"},
+		{name: "in comparison", expr: `.spec?.auth?.enabled == true`, expected: false},
+		{name: "in concatenation", expr: `.spec.name + "-" + .spec?.tls?.secret`, expected: "web-web-tls"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
 			}
 
-			if tt.wantErr && err != nil && tt.errMsg != "" {
-				if !strings.Contains(err.Error(), tt.errMsg) {
-					t.Errorf("Evaluate() error = %v, want error containing %q", err, tt.errMsg)
-				}
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
+
+	if _, err := ParseExpression(`.spec ? .tls`); err == nil {
+		t.Error("Expected parse error for '?' without '.'")
+	}
 }
 
-func TestNestedFunctions(t *testing.T) {
+func TestNegation(t *testing.T) {
+	data := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "my-app",
+			"namespace": "default",
+		},
+		"spec": map[string]interface{}{
+			"disabled": false,
+			"replicas": int64(3),
+		},
+	}
+
 	tests := []struct {
 		name     string
 		expr     string
-		data     interface{}
 		expected interface{}
-		wantErr  bool
 	}{
 		{
-			name: "lower of trim",
-			expr: "lower(trim(.spec.value))",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"value": "  HELLO  ",
-				},
-			},
-			expected: "hello",
+			name:     "not boolean",
+			expr:     "!.spec.disabled",
+			expected: true,
 		},
 		{
-			name: "upper of replace",
-			expr: "upper(replace(.spec.name, \"test\", \"prod\"))",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"name": "test-app",
-				},
-			},
-			expected: "PROD-APP",
+			name:     "not keyword",
+			expr:     "not .spec.disabled",
+			expected: true,
 		},
 		{
-			name: "trim of lower of value",
-			expr: "trim(lower(.metadata.name))",
-			data: map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"name": "  MyAPP  ",
-				},
-			},
-			expected: "myapp",
+			name:     "not missing field",
+			expr:     "!.spec.missing",
+			expected: true,
 		},
 		{
-			name: "default with lower",
-			expr: "default(lower(.spec.env), \"development\")",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"env": "PRODUCTION",
-				},
-			},
-			expected: "production",
-		},
-		{
-			name: "if with nested functions",
-			expr: "if(.spec.enabled, upper(.spec.type), lower(.spec.type))",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"enabled": true,
-					"type":    "ClusterIP",
-				},
-			},
-			expected: "CLUSTERIP",
+			name:     "not comparison",
+			expr:     "!(.spec.replicas > 2)",
+			expected: false,
 		},
 		{
-			name: "if with nested functions false",
-			expr: "if(.spec.enabled, upper(.spec.type), lower(.spec.type))",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"enabled": false,
-					"type":    "LoadBalancer",
-				},
-			},
-			expected: "loadbalancer",
+			name:     "not resource reference",
+			expr:     `!resource("v1", "Service", .metadata.name).spec.headless`,
+			expected: false,
 		},
 		{
-			name: "triple nested functions",
-			expr: "upper(trim(replace(.spec.url, \"http://\", \"\")))",
-			data: map[string]interface{}{
-				"spec": map[string]interface{}{
-					"url": "http://example.com  ",
-				},
-			},
-			expected: "EXAMPLE.COM",
+			name:     "not keyword resource reference",
+			expr:     `not resource("v1", "Service", "my-app").spec.headless`,
+			expected: false,
 		},
 	}
 
+	evaluator := NewEvaluator(data)
+	evaluator.RegisterNamespacedResource("v1", "Service", "default", "my-app", map[string]interface{}{
+		"spec": map[string]interface{}{"headless": true},
+	})
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			expr, err := ParseExpression(tt.expr)
 			if err != nil {
 				t.Fatalf("ParseExpression() error = %v", err)
 			}
+			if expr.Type != ExprUnary {
+				t.Errorf("Expected ExprUnary, got %d", expr.Type)
+			}
 
-			evaluator := NewEvaluator(tt.data)
 			result, err := evaluator.Evaluate(expr)
-
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
-				return
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
 			}
 
-			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+			if result != tt.expected {
 				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
 			}
 		})
 	}
 }
 
-func TestResourceReferencesWithComplexNames(t *testing.T) {
+func TestArrayLiterals(t *testing.T) {
+	data := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "app",
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"tier":     "gold",
+			"ports":    []interface{}{int64(80), int64(443)},
+			"a":        int64(7),
+		},
+	}
+
 	tests := []struct {
-		name      string
-		expr      string
-		data      interface{}
-		resources map[string]map[string]interface{}
-		expected  interface{}
-		wantErr   bool
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
 	}{
 		{
-			name: "resource reference with concatenated name",
-			expr: "resource(\"v1\", \"Service\", .metadata.name + \"-svc\").spec.clusterIP",
-			data: map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"name": "my-app",
-				},
-			},
-			resources: map[string]map[string]interface{}{
-				"v1/Service/my-app-svc": {
-					"apiVersion": "v1",
-					"kind":       "Service",
-					"metadata": map[string]interface{}{
-						"name": "my-app-svc",
-					},
-					"spec": map[string]interface{}{
-						"clusterIP": "10.0.0.1",
-					},
-				},
-			},
-			expected: "10.0.0.1",
+			name:     "empty",
+			expr:     `[]`,
+			expected: []interface{}{},
 		},
 		{
-			name: "resource reference with lowercase function",
-			expr: "resource(\"v1\", \"ConfigMap\", lower(.metadata.name)).data.key",
-			data: map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"name": "MY-APP",
-				},
-			},
-			resources: map[string]map[string]interface{}{
-				"v1/ConfigMap/my-app": {
-					"apiVersion": "v1",
-					"kind":       "ConfigMap",
-					"metadata": map[string]interface{}{
-						"name": "my-app",
-					},
-					"data": map[string]interface{}{
-						"key": "value123",
-					},
-				},
-			},
-			expected: "value123",
+			name:     "numbers",
+			expr:     `[1, 2, 3]`,
+			expected: []interface{}{int64(1), int64(2), int64(3)},
 		},
 		{
-			name: "resource reference with trim and concat",
-			expr: "resource(\"v1\", \"Secret\", trim(.metadata.namespace) + \"-secret\").data.token",
-			data: map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"namespace": "  prod  ",
-				},
-			},
-			resources: map[string]map[string]interface{}{
-				"v1/Secret/prod-secret": {
-					"apiVersion": "v1",
-					"kind":       "Secret",
-					"metadata": map[string]interface{}{
-						"name": "prod-secret",
-					},
-					"data": map[string]interface{}{
-						"token": "abc123",
-					},
-				},
-			},
-			expected: "abc123",
+			name:     "strings",
+			expr:     `["a", "b"]`,
+			expected: []interface{}{"a", "b"},
 		},
 		{
-			name: "resource reference with conditional expression in name",
-			expr: "resource(\"v1\", \"Service\", if(.spec.ha, .metadata.name + \"-ha\", .metadata.name)).spec.type",
-			data: map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"name": "app",
-				},
-				"spec": map[string]interface{}{
-					"ha": true,
-				},
-			},
-			resources: map[string]map[string]interface{}{
-				"v1/Service/app-ha": {
-					"apiVersion": "v1",
-					"kind":       "Service",
-					"metadata": map[string]interface{}{
-						"name": "app-ha",
-					},
-					"spec": map[string]interface{}{
-						"type": "LoadBalancer",
-					},
-				},
-			},
-			expected: "LoadBalancer",
+			name:     "mixed",
+			expr:     `[1, "two", true]`,
+			expected: []interface{}{int64(1), "two", true},
 		},
 		{
-			name: "resource reference accessing array element",
-			expr: "resource(\"v1\", \"Service\", .metadata.name).spec.ports[0].port",
-			data: map[string]interface{}{
-				"metadata": map[string]interface{}{
-					"name": "web-app",
-				},
-			},
-			resources: map[string]map[string]interface{}{
-				"v1/Service/web-app": {
-					"apiVersion": "v1",
-					"kind":       "Service",
-					"metadata": map[string]interface{}{
-						"name": "web-app",
-					},
-					"spec": map[string]interface{}{
-						"ports": []interface{}{
-							map[string]interface{}{"port": int64(80)},
-							map[string]interface{}{"port": int64(443)},
-						},
-					},
-				},
-			},
-			expected: int64(80),
+			name:     "expression elements",
+			expr:     `[.metadata.name, .spec.replicas * 2, upper(.spec.tier), .spec.ports[1]]`,
+			expected: []interface{}{"app", int64(6), "GOLD", int64(443)},
+		},
+		{
+			name:     "nested arrays",
+			expr:     `[[1, 2], []]`,
+			expected: []interface{}{[]interface{}{int64(1), int64(2)}, []interface{}{}},
+		},
+		{
+			name:     "trailing comma",
+			expr:     `["a", "b",]`,
+			expected: []interface{}{"a", "b"},
+		},
+		{
+			name:     "function argument",
+			expr:     `concat(.spec.ports, [8080, 8443])`,
+			expected: []interface{}{int64(80), int64(443), int64(8080), int64(8443)},
+		},
+		{
+			name:     "nested function argument",
+			expr:     `if(.spec.tier in ["gold", "platinum"], "high", "low")`,
+			expected: "high",
+		},
+		{
+			name:     "max of array literal",
+			expr:     `max([1, 2, 3])`,
+			expected: int64(3),
+		},
+		{
+			name:     "min of array literal with path",
+			expr:     `min([.spec.a, 4])`,
+			expected: int64(4),
+		},
+		{
+			name:     "max of array field",
+			expr:     `max(.spec.ports)`,
+			expected: int64(443),
+		},
+		{
+			name:    "max of empty array",
+			expr:    `max([])`,
+			wantErr: true,
+		},
+		{
+			name:    "min of non-numeric element",
+			expr:    `min([1, "two"])`,
+			wantErr: true,
+		},
+		{
+			name:    "missing field element",
+			expr:    `[.spec.missing]`,
+			wantErr: true,
 		},
 	}
 
@@ -1120,93 +1339,181 @@ func TestResourceReferencesWithComplexNames(t *testing.T) {
 				t.Fatalf("ParseExpression() error = %v", err)
 			}
 
-			evaluator := NewEvaluator(tt.data)
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
 
-			// Register resources
-			for _, resource := range tt.resources {
-				apiVersion := resource["apiVersion"].(string)
-				kind := resource["kind"].(string)
-				name := resource["metadata"].(map[string]interface{})["name"].(string)
-				evaluator.RegisterResource(apiVersion, kind, name, resource)
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %#v, want %#v", result, tt.expected)
 			}
+		})
+	}
+}
 
-			result, err := evaluator.Evaluate(expr)
+func TestNumberLiterals(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"items":    []interface{}{"a", "b", "c"},
+		},
+	}
 
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "negative integer", expr: `-1`, expected: int64(-1)},
+		{name: "negative float", expr: `-1.5`, expected: -1.5},
+		{name: "whole float", expr: `1.0`, expected: 1.0},
+		{name: "large integer", expr: `1000000`, expected: int64(1000000)},
+		{name: "integer beyond float precision", expr: `9007199254740993`, expected: int64(9007199254740993)},
+		{name: "subtraction without spaces", expr: `.spec.replicas -1`, expected: int64(2)},
+		{name: "subtract negative", expr: `.spec.replicas - -1`, expected: int64(4)},
+		{name: "negated path", expr: `-.spec.replicas`, expected: int64(-3)},
+		{name: "multiply by negative", expr: `2 * -3`, expected: int64(-6)},
+		{name: "max of negatives", expr: `max(-1, -3)`, expected: int64(-1)},
+		{name: "min of negatives", expr: `min(-1, -3, .spec.replicas)`, expected: int64(-3)},
+		{name: "max of float and integer", expr: `max(1.5, 1)`, expected: 1.5},
+		{name: "round float", expr: `round(2.5)`, expected: int64(3)},
+		{name: "round negative float", expr: `round(-1.5)`, expected: int64(-2)},
+		{name: "round grouped expression", expr: `round((.spec.replicas - 1) * 1.5)`, expected: int64(3)},
+		{name: "negated grouped argument", expr: `max(-(1 + 2), -4)`, expected: int64(-3)},
+		{name: "fallback to negative", expr: `try(.spec.missing, -1)`, expected: int64(-1)},
+		{name: "fallback to float", expr: `try(.spec.missing, 1.0)`, expected: 1.0},
+		{name: "index", expr: `.spec.items[1]`, expected: "b"},
+		{name: "index expression", expr: `.spec.items[.spec.replicas -1]`, expected: "c"},
+		{name: "negative index", expr: `.spec.items[-1]`, wantErr: true},
+		{name: "round non-numeric", expr: `round("a")`, wantErr: true},
+		{name: "max without arguments", expr: `max()`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
-				return
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
-				t.Errorf("Evaluate() = %v (type %T), want %v (type %T)", result, result, tt.expected, tt.expected)
+				t.Errorf("Evaluate() = %#v, want %#v", result, tt.expected)
 			}
 		})
 	}
 }
 
-func TestLoopVariablesInExpressions(t *testing.T) {
+func TestIntegerArithmetic(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			// 2^53 + 1 is the first integer float64 cannot represent
+			"bytes": int64(9007199254740993),
+			"max":   int64(math.MaxInt64),
+			"min":   int64(math.MinInt64),
+		},
+	}
+
 	tests := []struct {
 		name     string
 		expr     string
-		data     interface{}
 		expected interface{}
 		wantErr  bool
 	}{
-		{
-			name: "loop variable concatenation",
-			expr: "component.name + \"-role\"",
-			data: map[string]interface{}{
-				"component": map[string]interface{}{
-					"name": "my-component",
-				},
-			},
-			expected: "my-component-role",
-		},
-		{
-			name: "loop variable with multiple concatenations",
-			expr: "item.prefix + \"-\" + item.name + \"-\" + item.suffix",
-			data: map[string]interface{}{
-				"item": map[string]interface{}{
-					"prefix": "app",
-					"name":   "service",
-					"suffix": "v1",
-				},
-			},
-			expected: "app-service-v1",
-		},
-		{
-			name: "loop variable in conditional",
-			expr: "if(component.enabled, component.name + \"-enabled\", component.name + \"-disabled\")",
-			data: map[string]interface{}{
-				"component": map[string]interface{}{
-					"name":    "web",
-					"enabled": true,
-				},
-			},
-			expected: "web-enabled",
+		{name: "add", expr: `.spec.bytes + 2`, expected: int64(9007199254740995)},
+		{name: "subtract", expr: `.spec.bytes - 2`, expected: int64(9007199254740991)},
+		{name: "multiply", expr: `.spec.bytes * 3`, expected: int64(27021597764222979)},
+		{name: "exact division", expr: `(.spec.bytes + 3) / 4`, expected: int64(2251799813685249)},
+		{name: "modulo", expr: `.spec.bytes % 10`, expected: int64(3)},
+		{name: "inexact division", expr: `7 / 2`, expected: 3.5},
+		{name: "mixed with float", expr: `3 * 1.5`, expected: 4.5},
+		{name: "add overflow falls back to float", expr: `.spec.max + 1`, expected: float64(math.MaxInt64) + 1},
+		{name: "subtract overflow falls back to float", expr: `.spec.min - 1`, expected: float64(math.MinInt64) - 1},
+		{name: "multiply overflow falls back to float", expr: `.spec.max * 2`, expected: float64(math.MaxInt64) * 2},
+		{name: "largest integer", expr: `.spec.max - 0`, expected: int64(math.MaxInt64)},
+		{name: "division by zero", expr: `.spec.bytes / 0`, wantErr: true},
+		{name: "modulo by zero", expr: `.spec.bytes % 0`, wantErr: true},
+		{name: "modulo by fraction", expr: `5 % 0.5`, expected: int64(0)},
+		{name: "negative modulo by fraction", expr: `-7 % 2.5`, expected: int64(-2)},
+		{name: "fractional modulo", expr: `7.5 % 2`, expected: 1.5},
+		{name: "modulo by zero fraction", expr: `7.5 % 0.0`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %#v, want %#v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMapLiterals(t *testing.T) {
+	data := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "app",
+		},
+		"spec": map[string]interface{}{
+			"port": int64(8080),
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "empty",
+			expr:     `{}`,
+			expected: map[string]interface{}{},
 		},
 		{
-			name: "nested loop variable",
-			expr: "container.port.number",
-			data: map[string]interface{}{
-				"container": map[string]interface{}{
-					"port": map[string]interface{}{
-						"number": int64(8080),
-					},
-				},
+			name:     "identifier and quoted keys",
+			expr:     `{name: "web", "app.kubernetes.io/name": "web", 'tier': 1}`,
+			expected: map[string]interface{}{"name": "web", "app.kubernetes.io/name": "web", "tier": int64(1)},
+		},
+		{
+			name:     "expression values",
+			expr:     `{name: .metadata.name + "-svc", port: .spec.port + 1, upper: upper(.metadata.name)}`,
+			expected: map[string]interface{}{"name": "app-svc", "port": int64(8081), "upper": "APP"},
+		},
+		{
+			name: "nested maps and arrays",
+			expr: `{selector: {app: .metadata.name}, ports: [{port: .spec.port,}],}`,
+			expected: map[string]interface{}{
+				"selector": map[string]interface{}{"app": "app"},
+				"ports":    []interface{}{map[string]interface{}{"port": int64(8080)}},
 			},
-			expected: int64(8080),
 		},
 		{
-			name: "loop variable with arithmetic",
-			expr: "item.base + item.increment",
-			data: map[string]interface{}{
-				"item": map[string]interface{}{
-					"base":      int64(100),
-					"increment": int64(50),
-				},
+			name: "function argument",
+			expr: `toEnvList({PORT: .spec.port, NAME: .metadata.name})`,
+			expected: []interface{}{
+				map[string]interface{}{"name": "NAME", "value": "app"},
+				map[string]interface{}{"name": "PORT", "value": "8080"},
 			},
-			expected: int64(150),
+		},
+		{
+			name:    "missing field value",
+			expr:    `{name: .spec.missing}`,
+			wantErr: true,
 		},
 	}
 
@@ -1217,16 +1524,1878 @@ func TestLoopVariablesInExpressions(t *testing.T) {
 				t.Fatalf("ParseExpression() error = %v", err)
 			}
 
-			evaluator := NewEvaluator(tt.data)
-			result, err := evaluator.Evaluate(expr)
-
+			result, err := NewEvaluator(data).Evaluate(expr)
 			if (err != nil) != tt.wantErr {
-				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
-				return
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
-				t.Errorf("Evaluate() = %v (type %T), want %v (type %T)", result, result, tt.expected, tt.expected)
+				t.Errorf("Evaluate() = %#v, want %#v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestMapLiteralParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		`{name: "a", name: "b"}`,
+		`{name "a"}`,
+		`{.spec.name: "a"}`,
+		`{name: "a"`,
+	} {
+		if _, err := ParseExpression(expr); err == nil {
+			t.Errorf("ParseExpression(%q) expected error", expr)
+		}
+	}
+}
+
+func TestEvaluateStrings(t *testing.T) {
+	evaluator := NewEvaluator(map[string]interface{}{
+		"metadata": map[string]interface{}{"name": "app"},
+		"spec":     map[string]interface{}{"port": int64(8080)},
+	})
+
+	input := map[string]interface{}{
+		"name": "$(.metadata.name)",
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"ports": []interface{}{
+				map[string]interface{}{"port": "$(.spec.port)", "name": "http"},
+				"$(.metadata.name)-$(.spec.port)",
+			},
+			"escaped": "$$(.metadata.name)",
+		},
+		"empty": nil,
+	}
+
+	result, err := evaluator.EvaluateStrings(input)
+	if err != nil {
+		t.Fatalf("EvaluateStrings() error = %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"name": "app",
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"ports": []interface{}{
+				map[string]interface{}{"port": "8080", "name": "http"},
+				"app-8080",
+			},
+			"escaped": "$(.metadata.name)",
+		},
+		"empty": nil,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("EvaluateStrings() = %#v, want %#v", result, expected)
+	}
+	if input["name"] != "$(.metadata.name)" {
+		t.Errorf("Expected input to be left unchanged, got %v", input["name"])
+	}
+
+	// Only matching strings are evaluated
+	result, err = evaluator.EvaluateStringsMatching(input, func(s string) bool {
+		return strings.Contains(s, "$$(")
+	})
+	if err != nil {
+		t.Fatalf("EvaluateStringsMatching() error = %v", err)
+	}
+	spec := result.(map[string]interface{})["spec"].(map[string]interface{})
+	if spec["escaped"] != "$(.metadata.name)" || result.(map[string]interface{})["name"] != "$(.metadata.name)" {
+		t.Errorf("Expected only the escaped string to be evaluated, got %#v", result)
+	}
+
+	// Errors name the failing string's path
+	_, err = evaluator.EvaluateStrings(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ports": []interface{}{"ok", "$(unknownFunc())"},
+		},
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "spec.ports[1]: ") {
+		t.Errorf("Expected error prefixed with spec.ports[1], got %v", err)
+	}
+}
+
+func TestEvaluateStringsMaxDepth(t *testing.T) {
+	var nested interface{} = "$(.name)"
+	for i := 0; i < 10; i++ {
+		nested = []interface{}{nested}
+	}
+
+	evaluator := NewEvaluator(map[string]interface{}{"name": "app"})
+	evaluator.SetMaxDepth(5)
+	_, err := evaluator.EvaluateStrings(nested)
+	if !errors.Is(err, ErrMaxDepthExceeded) {
+		t.Fatalf("Expected ErrMaxDepthExceeded, got %v", err)
+	}
+
+	evaluator.SetMaxDepth(0)
+	if evaluator.MaxDepth() != DefaultMaxDepth {
+		t.Errorf("Expected SetMaxDepth(0) to restore %d, got %d", DefaultMaxDepth, evaluator.MaxDepth())
+	}
+	if _, err := evaluator.EvaluateStrings(nested); err != nil {
+		t.Errorf("EvaluateStrings() with the default limit error = %v", err)
+	}
+}
+
+func TestEvaluatorTrace(t *testing.T) {
+	data := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "app",
+		},
+		"spec": map[string]interface{}{
+			"tier": "gold",
+		},
+	}
+
+	expr, err := ParseExpression(`upper(.metadata.name) + "-" + .spec.tier`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+
+	var trace []string
+	evaluator := NewEvaluator(data)
+	evaluator.SetTrace(func(expr string, result interface{}, err error) {
+		trace = append(trace, fmt.Sprintf("%s -> %v", expr, result))
+	})
+
+	if _, err := evaluator.Evaluate(expr); err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+
+	expected := []string{
+		`.metadata.name -> app`,
+		`upper(.metadata.name) -> APP`,
+		`"-" -> -`,
+		`upper(.metadata.name) + "-" -> APP-`,
+		`.spec.tier -> gold`,
+		`upper(.metadata.name) + "-" + .spec.tier -> APP-gold`,
+	}
+	if !reflect.DeepEqual(trace, expected) {
+		t.Errorf("Expected trace:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(trace, "\n"))
+	}
+
+	// Clones keep tracing; clearing the callback stops it
+	trace = nil
+	clone := evaluator.Clone()
+	if _, err := clone.Evaluate(expr); err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(trace) != len(expected) {
+		t.Errorf("Expected clone to trace %d expressions, got %d", len(expected), len(trace))
+	}
+
+	trace = nil
+	clone.SetTrace(nil)
+	if _, err := clone.Evaluate(expr); err != nil {
+		t.Fatalf("Evaluate() error = %v", err)
+	}
+	if len(trace) != 0 {
+		t.Errorf("Expected no trace after SetTrace(nil), got %v", trace)
+	}
+}
+
+func TestSubstitutionFallback(t *testing.T) {
+	evaluator := NewEvaluator(map[string]interface{}{"name": "app"})
+
+	var failed []string
+	evaluator.SetSubstitutionFallback(func(expr string, err error) (string, error) {
+		failed = append(failed, expr)
+		return "<unresolved>", nil
+	})
+
+	result, err := evaluator.EvaluateString("$(.name)-$(.missing)")
+	if err != nil {
+		t.Fatalf("EvaluateString() error = %v", err)
+	}
+	if result != "app-<unresolved>" {
+		t.Errorf("Expected 'app-<unresolved>', got '%s'", result)
+	}
+	if !reflect.DeepEqual(failed, []string{".missing"}) {
+		t.Errorf("Expected fallback for [.missing], got %v", failed)
+	}
+
+	// Parse errors are not evaluation failures and still fail
+	if _, err := evaluator.EvaluateString("$(.name +)"); err == nil {
+		t.Error("Expected parse error to bypass the fallback")
+	}
+
+	// A fallback error fails the evaluation
+	evaluator.SetSubstitutionFallback(func(expr string, err error) (string, error) {
+		return "", fmt.Errorf("cannot resolve %s: %w", expr, err)
+	})
+	if _, err := evaluator.EvaluateString("$(.missing)"); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("Expected fallback error wrapping ErrKeyNotFound, got %v", err)
+	}
+
+	evaluator.SetSubstitutionFallback(nil)
+	if _, err := evaluator.EvaluateString("$(.missing)"); err == nil {
+		t.Error("Expected error after SetSubstitutionFallback(nil)")
+	}
+}
+
+func TestNowFunction(t *testing.T) {
+	frozen := time.Date(2024, 1, 2, 15, 4, 5, 0, time.FixedZone("CET", 3600))
+	evaluator := NewEvaluator(map[string]interface{}{})
+	evaluator.SetClock(func() time.Time { return frozen })
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{input: "$(now())", want: "2024-01-02T14:04:05Z"},
+		{input: `$(now("2006-01-02"))`, want: "2024-01-02"},
+		{input: `generated-$(now("20060102150405"))`, want: "generated-20240102140405"},
+		{input: `$(now("2006", "01"))`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := evaluator.EvaluateString(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("EvaluateString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("EvaluateString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// Clones keep the frozen clock, and resetting it uses the real time
+	if got, _ := evaluator.Clone().EvaluateString("$(now())"); got != "2024-01-02T14:04:05Z" {
+		t.Errorf("Expected clone to keep the frozen clock, got %q", got)
+	}
+	evaluator.SetClock(nil)
+	if got, _ := evaluator.EvaluateString(`$(now("2006"))`); got == "2024" && time.Now().Year() != 2024 {
+		t.Errorf("Expected the real clock after SetClock(nil), got %q", got)
+	}
+}
+
+func TestRegisterFunctionReplacesBuiltin(t *testing.T) {
+	evaluator := NewEvaluator(map[string]interface{}{})
+	for _, name := range []string{"now", "secret", "file", "try"} {
+		evaluator.RegisterFunction(name, func(args ...interface{}) (interface{}, error) {
+			return "custom " + name, nil
+		})
+	}
+
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{input: "$(now())", want: "custom now"},
+		{input: `$(secret("db", "password"))`, want: "custom secret"},
+		{input: `$(file("config.txt"))`, want: "custom file"},
+		{input: `$(try("a", "b"))`, want: "custom try"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := evaluator.EvaluateString(tt.input)
+			if err != nil {
+				t.Fatalf("EvaluateString() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("EvaluateString() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMembershipOperators(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"tier":     "gold",
+			"replicas": int64(3),
+			"allowed":  []interface{}{"gold", "silver"},
+			"in":       "silver",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "in array literal",
+			expr:     `.spec.tier in ["gold", "platinum"]`,
+			expected: true,
+		},
+		{
+			name:     "not in array literal",
+			expr:     `.spec.tier in ["bronze", "platinum"]`,
+			expected: false,
+		},
+		{
+			name:     "not in operator",
+			expr:     `.spec.tier not in ["bronze", "platinum"]`,
+			expected: true,
+		},
+		{
+			name:     "empty array",
+			expr:     `.spec.tier in []`,
+			expected: false,
+		},
+		{
+			name:     "numbers compared as strings",
+			expr:     `.spec.replicas in [1, 3, 5]`,
+			expected: true,
+		},
+		{
+			name:     "array from path",
+			expr:     `.spec.tier in .spec.allowed`,
+			expected: true,
+		},
+		{
+			name:     "missing field",
+			expr:     `.spec.missing in ["gold"]`,
+			expected: false,
+		},
+		{
+			name:     "field named in",
+			expr:     `.spec.in`,
+			expected: "silver",
+		},
+		{
+			name:     "field named in as operand",
+			expr:     `.spec.in in .spec.allowed`,
+			expected: true,
+		},
+		{
+			name:     "optional field named in",
+			expr:     `.spec?.in not in ["gold"]`,
+			expected: true,
+		},
+		{
+			name:     "keyword not negates the membership test",
+			expr:     `not .spec.replicas in [5]`,
+			expected: true,
+		},
+		{
+			name:     "keyword not negates the comparison",
+			expr:     `not .spec.tier == "silver"`,
+			expected: true,
+		},
+		{
+			name:    "right operand not an array",
+			expr:    `.spec.tier in .spec.replicas`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseNotPrecedence(t *testing.T) {
+	tests := []struct {
+		expr string
+		// want is the expected tree, with unary operators written as op(operand)
+		want string
+	}{
+		{expr: `not .a in [5]`, want: `!(in(.a, [5]))`},
+		{expr: `not .a == "gold"`, want: `!(==(.a, "gold"))`},
+		{expr: `not .a not in [5]`, want: `!(not in(.a, [5]))`},
+		{expr: `not .a and .b`, want: `&&(!(.a), .b)`},
+		{expr: `!.a == true`, want: `==(!(.a), true)`},
+		{expr: `!.a in [5]`, want: `in(!(.a), [5])`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			if got := formatTree(expr); got != tt.want {
+				t.Errorf("ParseExpression(%q) = %s, want %s", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+// formatTree renders the operator structure of expr for TestParseNotPrecedence
+func formatTree(expr *Expression) string {
+	switch expr.Type {
+	case ExprUnary:
+		return expr.Operator + "(" + formatTree(expr.Operand) + ")"
+	case ExprBinary:
+		return expr.Operator + "(" + formatTree(expr.Left) + ", " + formatTree(expr.Right) + ")"
+	case ExprArrayLiteral:
+		elements := make([]string, len(expr.Elements))
+		for i, element := range expr.Elements {
+			elements[i] = formatTree(element)
+		}
+		return "[" + strings.Join(elements, ", ") + "]"
+	default:
+		// Paths and literals keep their text in Path
+		return expr.Path
+	}
+}
+
+func TestEdgeCases(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		data    interface{}
+		wantErr bool
+		errMsg  string
+	}{
+		{
+			name: "division by zero",
+			expr: ".spec.value / 0",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"value": 10,
+				},
+			},
+			wantErr: true,
+			errMsg:  "division by zero",
+		},
+		{
+			name: "modulo by zero",
+			expr: ".spec.value % 0",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"value": 10,
+				},
+			},
+			wantErr: true,
+			errMsg:  "modulo by zero",
+		},
+		{
+			name: "negative array index",
+			expr: ".items[-1]",
+			data: map[string]interface{}{
+				"items": []interface{}{"a", "b", "c"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown function",
+			expr: "unknownFunc(.spec.name)",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"name": "test",
+				},
+			},
+			wantErr: true,
+			errMsg:  "unknown function",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil && !tt.wantErr {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			if err != nil {
+				return // Parse error is expected for some tests
+			}
+
+			evaluator := NewEvaluator(tt.data)
+			_, err = evaluator.Evaluate(expr)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if tt.wantErr && err != nil && tt.errMsg != "" {
+				if !strings.Contains(err.Error(), tt.errMsg) {
+					t.Errorf("Evaluate() error = %v, want error containing %q", err, tt.errMsg)
+				}
+			}
+		})
+	}
+}
+
+func TestNestedFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		data     interface{}
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name: "lower of trim",
+			expr: "lower(trim(.spec.value))",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"value": "  HELLO  ",
+				},
+			},
+			expected: "hello",
+		},
+		{
+			name: "upper of replace",
+			expr: "upper(replace(.spec.name, \"test\", \"prod\"))",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"name": "test-app",
+				},
+			},
+			expected: "PROD-APP",
+		},
+		{
+			name: "trim of lower of value",
+			expr: "trim(lower(.metadata.name))",
+			data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "  MyAPP  ",
+				},
+			},
+			expected: "myapp",
+		},
+		{
+			name: "default with lower",
+			expr: "default(lower(.spec.env), \"development\")",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"env": "PRODUCTION",
+				},
+			},
+			expected: "production",
+		},
+		{
+			name: "if with nested functions",
+			expr: "if(.spec.enabled, upper(.spec.type), lower(.spec.type))",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"enabled": true,
+					"type":    "ClusterIP",
+				},
+			},
+			expected: "CLUSTERIP",
+		},
+		{
+			name: "if with nested functions false",
+			expr: "if(.spec.enabled, upper(.spec.type), lower(.spec.type))",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"enabled": false,
+					"type":    "LoadBalancer",
+				},
+			},
+			expected: "loadbalancer",
+		},
+		{
+			name: "triple nested functions",
+			expr: "upper(trim(replace(.spec.url, \"http://\", \"\")))",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"url": "http://example.com  ",
+				},
+			},
+			expected: "EXAMPLE.COM",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(tt.data)
+			result, err := evaluator.Evaluate(expr)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResourceReferencesWithComplexNames(t *testing.T) {
+	tests := []struct {
+		name      string
+		expr      string
+		data      interface{}
+		resources map[string]map[string]interface{}
+		expected  interface{}
+		wantErr   bool
+	}{
+		{
+			name: "resource reference with concatenated name",
+			expr: "resource(\"v1\", \"Service\", .metadata.name + \"-svc\").spec.clusterIP",
+			data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "my-app",
+				},
+			},
+			resources: map[string]map[string]interface{}{
+				"v1/Service/my-app-svc": {
+					"apiVersion": "v1",
+					"kind":       "Service",
+					"metadata": map[string]interface{}{
+						"name": "my-app-svc",
+					},
+					"spec": map[string]interface{}{
+						"clusterIP": "10.0.0.1",
+					},
+				},
+			},
+			expected: "10.0.0.1",
+		},
+		{
+			name: "resource reference with lowercase function",
+			expr: "resource(\"v1\", \"ConfigMap\", lower(.metadata.name)).data.key",
+			data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "MY-APP",
+				},
+			},
+			resources: map[string]map[string]interface{}{
+				"v1/ConfigMap/my-app": {
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"metadata": map[string]interface{}{
+						"name": "my-app",
+					},
+					"data": map[string]interface{}{
+						"key": "value123",
+					},
+				},
+			},
+			expected: "value123",
+		},
+		{
+			name: "resource reference with trim and concat",
+			expr: "resource(\"v1\", \"Secret\", trim(.metadata.namespace) + \"-secret\").data.token",
+			data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"namespace": "  prod  ",
+				},
+			},
+			resources: map[string]map[string]interface{}{
+				"v1/Secret/prod-secret": {
+					"apiVersion": "v1",
+					"kind":       "Secret",
+					"metadata": map[string]interface{}{
+						"name": "prod-secret",
+					},
+					"data": map[string]interface{}{
+						"token": "abc123",
+					},
+				},
+			},
+			expected: "abc123",
+		},
+		{
+			name: "resource reference with conditional expression in name",
+			expr: "resource(\"v1\", \"Service\", if(.spec.ha, .metadata.name + \"-ha\", .metadata.name)).spec.type",
+			data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "app",
+				},
+				"spec": map[string]interface{}{
+					"ha": true,
+				},
+			},
+			resources: map[string]map[string]interface{}{
+				"v1/Service/app-ha": {
+					"apiVersion": "v1",
+					"kind":       "Service",
+					"metadata": map[string]interface{}{
+						"name": "app-ha",
+					},
+					"spec": map[string]interface{}{
+						"type": "LoadBalancer",
+					},
+				},
+			},
+			expected: "LoadBalancer",
+		},
+		{
+			name: "resource reference accessing array element",
+			expr: "resource(\"v1\", \"Service\", .metadata.name).spec.ports[0].port",
+			data: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "web-app",
+				},
+			},
+			resources: map[string]map[string]interface{}{
+				"v1/Service/web-app": {
+					"apiVersion": "v1",
+					"kind":       "Service",
+					"metadata": map[string]interface{}{
+						"name": "web-app",
+					},
+					"spec": map[string]interface{}{
+						"ports": []interface{}{
+							map[string]interface{}{"port": int64(80)},
+							map[string]interface{}{"port": int64(443)},
+						},
+					},
+				},
+			},
+			expected: int64(80),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(tt.data)
+
+			// Register resources
+			for _, resource := range tt.resources {
+				apiVersion := resource["apiVersion"].(string)
+				kind := resource["kind"].(string)
+				name := resource["metadata"].(map[string]interface{})["name"].(string)
+				evaluator.RegisterResource(apiVersion, kind, name, resource)
+			}
+
+			result, err := evaluator.Evaluate(expr)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v (type %T), want %v (type %T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLoopVariablesInExpressions(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		data     interface{}
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name: "loop variable concatenation",
+			expr: "component.name + \"-role\"",
+			data: map[string]interface{}{
+				"component": map[string]interface{}{
+					"name": "my-component",
+				},
+			},
+			expected: "my-component-role",
+		},
+		{
+			name: "loop variable with multiple concatenations",
+			expr: "item.prefix + \"-\" + item.name + \"-\" + item.suffix",
+			data: map[string]interface{}{
+				"item": map[string]interface{}{
+					"prefix": "app",
+					"name":   "service",
+					"suffix": "v1",
+				},
+			},
+			expected: "app-service-v1",
+		},
+		{
+			name: "loop variable in conditional",
+			expr: "if(component.enabled, component.name + \"-enabled\", component.name + \"-disabled\")",
+			data: map[string]interface{}{
+				"component": map[string]interface{}{
+					"name":    "web",
+					"enabled": true,
+				},
+			},
+			expected: "web-enabled",
+		},
+		{
+			name: "nested loop variable",
+			expr: "container.port.number",
+			data: map[string]interface{}{
+				"container": map[string]interface{}{
+					"port": map[string]interface{}{
+						"number": int64(8080),
+					},
+				},
+			},
+			expected: int64(8080),
+		},
+		{
+			name: "loop variable with arithmetic",
+			expr: "item.base + item.increment",
+			data: map[string]interface{}{
+				"item": map[string]interface{}{
+					"base":      int64(100),
+					"increment": int64(50),
+				},
+			},
+			expected: int64(150),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(tt.data)
+			result, err := evaluator.Evaluate(expr)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v (type %T), want %v (type %T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestToEnvList(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		data     interface{}
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name: "sorted by key",
+			expr: "toEnvList(.spec.env)",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"env": map[string]interface{}{
+						"ZETA":  "last",
+						"ALPHA": "first",
+						"MID":   "middle",
+					},
+				},
+			},
+			expected: []interface{}{
+				map[string]interface{}{"name": "ALPHA", "value": "first"},
+				map[string]interface{}{"name": "MID", "value": "middle"},
+				map[string]interface{}{"name": "ZETA", "value": "last"},
+			},
+		},
+		{
+			name: "non-string values are stringified",
+			expr: "toEnvList(.spec.env)",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"env": map[string]interface{}{
+						"DEBUG": true,
+						"PORT":  int64(8080),
+						"RATIO": 0.5,
+					},
+				},
+			},
+			expected: []interface{}{
+				map[string]interface{}{"name": "DEBUG", "value": "true"},
+				map[string]interface{}{"name": "PORT", "value": "8080"},
+				map[string]interface{}{"name": "RATIO", "value": "0.5"},
+			},
+		},
+		{
+			name: "empty map",
+			expr: "toEnvList(.spec.env)",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"env": map[string]interface{}{},
+				},
+			},
+			expected: []interface{}{},
+		},
+		{
+			name: "non-map argument",
+			expr: "toEnvList(.spec.env)",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"env": "FOO=bar",
+				},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(tt.data)
+			result, err := evaluator.Evaluate(expr)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestListFunction(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"host":  "example.com",
+			"hosts": []interface{}{"a.example.com", "b.example.com"},
+			"port":  int64(80),
+			"empty": nil,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+	}{
+		{
+			name:     "scalar",
+			expr:     "list(.spec.host)",
+			expected: []interface{}{"example.com"},
+		},
+		{
+			name:     "number",
+			expr:     "list(.spec.port)",
+			expected: []interface{}{int64(80)},
+		},
+		{
+			name:     "slice",
+			expr:     "list(.spec.hosts)",
+			expected: []interface{}{"a.example.com", "b.example.com"},
+		},
+		{
+			name:     "nil",
+			expr:     "list(.spec.empty)",
+			expected: []interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFilterFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"name": "http", "protocol": "TCP"},
+				map[string]interface{}{"name": "dns", "protocol": "UDP"},
+				"not-a-map",
+				map[string]interface{}{"name": "https", "protocol": "TCP"},
+				map[string]interface{}{"name": "metrics"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name: "filter by field",
+			expr: `filter(.spec.ports, "protocol", "TCP")`,
+			expected: []interface{}{
+				map[string]interface{}{"name": "http", "protocol": "TCP"},
+				map[string]interface{}{"name": "https", "protocol": "TCP"},
+			},
+		},
+		{
+			name: "reject by field",
+			expr: `reject(.spec.ports, "protocol", "TCP")`,
+			expected: []interface{}{
+				map[string]interface{}{"name": "dns", "protocol": "UDP"},
+				map[string]interface{}{"name": "metrics"},
+			},
+		},
+		{
+			name:     "empty result",
+			expr:     `filter(.spec.ports, "protocol", "SCTP")`,
+			expected: []interface{}{},
+		},
+		{
+			name:    "not an array",
+			expr:    `filter(.spec, "protocol", "TCP")`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong argument count",
+			expr:    `reject(.spec.ports, "protocol")`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPrefixFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{
+				"my.domain/owner": "team-a",
+				"my.domain/tier":  "web",
+				"other.io/build":  "42",
+			},
+		},
+		"spec": map[string]interface{}{
+			"name": "my-app",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name: "pick prefix",
+			expr: `pickPrefix(.metadata.annotations, "my.domain/")`,
+			expected: map[string]interface{}{
+				"my.domain/owner": "team-a",
+				"my.domain/tier":  "web",
+			},
+		},
+		{
+			name: "omit prefix",
+			expr: `omitPrefix(.metadata.annotations, "my.domain/")`,
+			expected: map[string]interface{}{
+				"other.io/build": "42",
+			},
+		},
+		{
+			name:     "empty prefix picks all",
+			expr:     `pickPrefix(.metadata.annotations, "")`,
+			expected: data["metadata"].(map[string]interface{})["annotations"],
+		},
+		{
+			name:     "empty prefix omits all",
+			expr:     `omitPrefix(.metadata.annotations, "")`,
+			expected: map[string]interface{}{},
+		},
+		{
+			name:    "not a map",
+			expr:    `pickPrefix(.spec.name, "my")`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDurationFunctions(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     string
+		data     interface{}
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "toSeconds with seconds",
+			expr:     "toSeconds(\"30s\")",
+			expected: int64(30),
+		},
+		{
+			name:     "toSeconds with minutes",
+			expr:     "toSeconds(\"5m\")",
+			expected: int64(300),
+		},
+		{
+			name:     "toSeconds with hours",
+			expr:     "toSeconds(\"2h\")",
+			expected: int64(7200),
+		},
+		{
+			name:     "toSeconds with combined units",
+			expr:     "toSeconds(\"1h30m\")",
+			expected: int64(5400),
+		},
+		{
+			name: "toSeconds in arithmetic",
+			expr: "toSeconds(.spec.startup) + 10",
+			data: map[string]interface{}{
+				"spec": map[string]interface{}{"startup": "1m"},
+			},
+			expected: int64(70),
+		},
+		{
+			name:    "toSeconds with invalid duration",
+			expr:    "toSeconds(\"five minutes\")",
+			wantErr: true,
+		},
+		{
+			name:    "toSeconds without unit",
+			expr:    "toSeconds(\"30\")",
+			wantErr: true,
+		},
+		{
+			name:     "duration from seconds",
+			expr:     "duration(90)",
+			expected: "1m30s",
+		},
+		{
+			name:     "duration from hours",
+			expr:     "duration(7200)",
+			expected: "2h0m0s",
+		},
+		{
+			name:    "duration with non-numeric value",
+			expr:    "duration(\"abc\")",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(tt.data)
+			result, err := evaluator.Evaluate(expr)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %v (type %T), want %v (type %T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestTryFunction(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"name":     "app",
+			"database": map[string]interface{}{
+				"host": "db.internal",
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "value present",
+			expr:     `try(.spec.database.host, "localhost")`,
+			expected: "db.internal",
+		},
+		{
+			name:     "deep missing path",
+			expr:     `try(.spec.database.replica.primary.host, "localhost")`,
+			expected: "localhost",
+		},
+		{
+			name:     "missing parent map",
+			expr:     `try(.spec.cache.redis.port, 6379)`,
+			expected: int64(6379),
+		},
+		{
+			name:     "arithmetic error",
+			expr:     `try(.spec.name - 1, .spec.replicas)`,
+			expected: int64(3),
+		},
+		{
+			name:     "fallback expression",
+			expr:     `try(.spec.missing, .spec.name + "-default")`,
+			expected: "app-default",
+		},
+		{
+			name:    "fallback error is returned",
+			expr:    `try(.spec.missing, .spec.alsoMissing)`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong argument count",
+			expr:    `try(.spec.name)`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v (%T), want %v (%T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDefaultIfEmpty(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"zero":       int64(0),
+			"zeroFloat":  float64(0),
+			"disabled":   false,
+			"empty":      "",
+			"unset":      nil,
+			"emptyList":  []interface{}{},
+			"emptyMap":   map[string]interface{}{},
+			"name":       "app",
+			"ports":      []interface{}{int64(80)},
+			"replicas":   int64(3),
+			"annotation": map[string]interface{}{"a": "b"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "zero is kept", expr: `defaultIfEmpty(.spec.zero, 5)`, expected: int64(0)},
+		{name: "zero float is kept", expr: `defaultIfEmpty(.spec.zeroFloat, 5)`, expected: float64(0)},
+		{name: "false is kept", expr: `defaultIfEmpty(.spec.disabled, true)`, expected: false},
+		{name: "empty string falls back", expr: `defaultIfEmpty(.spec.empty, "fallback")`, expected: "fallback"},
+		{name: "nil falls back", expr: `defaultIfEmpty(.spec.unset, "fallback")`, expected: "fallback"},
+		{name: "empty list falls back", expr: `defaultIfEmpty(.spec.emptyList, "fallback")`, expected: "fallback"},
+		{name: "empty map falls back", expr: `defaultIfEmpty(.spec.emptyMap, "fallback")`, expected: "fallback"},
+		{name: "string is kept", expr: `defaultIfEmpty(.spec.name, "fallback")`, expected: "app"},
+		{name: "number is kept", expr: `defaultIfEmpty(.spec.replicas, 1)`, expected: int64(3)},
+		{name: "wrong argument count", expr: `defaultIfEmpty(.spec.name)`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v (%T), want %v (%T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEncodingFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"raw": "\x00\xffkey\x10\n",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "base32encode", expr: `base32encode("hi")`, expected: "NBUQ===="},
+		{name: "base32decode", expr: `base32decode("NBUQ====")`, expected: "hi"},
+		{name: "base32 round trip", expr: `base32decode(base32encode(.spec.raw))`, expected: "\x00\xffkey\x10\n"},
+		{name: "base32decode malformed", expr: `base32decode("not base32!")`, wantErr: true},
+		{name: "base32decode missing padding", expr: `base32decode("NBUQ")`, wantErr: true},
+		{name: "hexencode", expr: `hexencode("hi")`, expected: "6869"},
+		{name: "hexdecode", expr: `hexdecode("6869")`, expected: "hi"},
+		{name: "hexdecode uppercase", expr: `hexdecode("4A4b")`, expected: "JK"},
+		{name: "hex round trip", expr: `hexdecode(hexencode(.spec.raw))`, expected: "\x00\xffkey\x10\n"},
+		{name: "hexdecode invalid character", expr: `hexdecode("zz")`, wantErr: true},
+		{name: "hexdecode odd length", expr: `hexdecode("686")`, wantErr: true},
+		{name: "hexencode wrong argument count", expr: `hexencode("a", "b")`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestHashFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"image": "nginx:latest",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "sha1", expr: `sha1("hello")`, expected: "aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d"},
+		{name: "md5", expr: `md5("hello")`, expected: "5d41402abc4b2a76b9719d911017c592"},
+		{name: "adler32", expr: `adler32("hello")`, expected: "062c0215"},
+		{name: "shortHash", expr: `shortHash(8, "hello")`, expected: "2cf24dba"},
+		{name: "shortHash full length", expr: `shortHash(64, "hello")`, expected: "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"},
+		{name: "shortHash zero length", expr: `shortHash(0, "hello")`, wantErr: true},
+		{name: "shortHash too long", expr: `shortHash(65, "hello")`, wantErr: true},
+		{name: "shortHash non-numeric length", expr: `shortHash("abc", "hello")`, wantErr: true},
+		{name: "md5 wrong argument count", expr: `md5("a", "b")`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+
+	// Hashes of paths are stable across evaluators and have fixed lengths
+	lengths := map[string]int{"sha1": 40, "md5": 32, "adler32": 8, "sha256": 64}
+	for fn, length := range lengths {
+		expr, err := ParseExpression(fn + "(.spec.image)")
+		if err != nil {
+			t.Fatalf("ParseExpression() error = %v", err)
+		}
+		first, err := NewEvaluator(data).Evaluate(expr)
+		if err != nil {
+			t.Fatalf("%s() error = %v", fn, err)
+		}
+		second, _ := NewEvaluator(data).Evaluate(expr)
+		if first != second {
+			t.Errorf("%s() is not stable: %v then %v", fn, first, second)
+		}
+		if str, ok := first.(string); !ok || len(str) != length {
+			t.Errorf("%s() = %v, want a %d character hex string", fn, first, length)
+		}
+	}
+}
+
+func TestBindVariables(t *testing.T) {
+	data := map[string]interface{}{
+		"item": map[string]interface{}{
+			"name":   "web",
+			"quoted": `say "hi"`,
+			"mixed":  `it's "odd"`,
+			"weight": 1.0,
+			"tags":   []interface{}{"a"},
+		},
+		"enabled": true,
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "resource name",
+			input:    `ip: $(resource("v1", "Service", item.name).spec.clusterIP)`,
+			expected: `ip: $(resource("v1", "Service", "web").spec.clusterIP)`,
+		},
+		{
+			name:     "function arguments",
+			input:    `$(if(enabled, upper(item.name), "none"))`,
+			expected: `$(if(true, upper("web"), "none"))`,
+		},
+		{
+			name:     "value with double quotes",
+			input:    `$(item.quoted)`,
+			expected: `$('say "hi"')`,
+		},
+		{
+			name:     "whole float",
+			input:    `$(item.weight * 2)`,
+			expected: `$(1.0 * 2)`,
+		},
+		{
+			name:     "unbound paths and escapes are kept",
+			input:    `$(.metadata.name) $$(item.name) $(HOME)`,
+			expected: `$(.metadata.name) $$(item.name) $(HOME)`,
+		},
+		{
+			name:    "list value",
+			input:   `$(item.tags)`,
+			wantErr: true,
+		},
+		{
+			name:    "value with both quotes",
+			input:   `$(item.mixed)`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewEvaluator(data).BindVariables(tt.input, []string{"item", "enabled"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("BindVariables() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("BindVariables() = %s, want %s", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDedentFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"config":  "\n\n    server {\n      listen 80;\n\n    }\n  \n",
+			"tabbed":  "\t\tfoo\n\t\t\tbar\n",
+			"mixed":   "    a\n  \tb\n",
+			"flat":    "a\n  b\n",
+			"padding": "  \n\t\n",
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "dedent spaces", expr: `dedent(.spec.config)`, expected: "\n\nserver {\n  listen 80;\n\n}\n\n"},
+		{name: "dedent tabs", expr: `dedent(.spec.tabbed)`, expected: "foo\n\tbar\n"},
+		{name: "dedent mixed indent keeps common prefix only", expr: `dedent(.spec.mixed)`, expected: "  a\n\tb\n"},
+		{name: "dedent without common indent", expr: `dedent(.spec.flat)`, expected: "a\n  b\n"},
+		{name: "trimEmptyLines", expr: `trimEmptyLines(.spec.config)`, expected: "    server {\n      listen 80;\n\n    }"},
+		{name: "trimEmptyLines blank input", expr: `trimEmptyLines(.spec.padding)`, expected: ""},
+		{name: "trimEmptyLines then dedent", expr: `dedent(trimEmptyLines(.spec.config))`, expected: "server {\n  listen 80;\n\n}"},
+		{name: "dedent wrong argument count", expr: `dedent("a", "b")`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFunctionConditions(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"features": []interface{}{"tls", "gzip"},
+			"items":    []interface{}{"a", "b"},
+			"empty":    []interface{}{},
+			"host":     "api.example.com",
+			"labels":   map[string]interface{}{"tier": "web"},
+			"opt":      nil,
+			"s":        "text",
+			"list":     []interface{}{"a"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		wantType ExprType
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "bare function call", expr: `contains(.spec.features, "tls")`, wantType: ExprFunction, expected: true},
+		{name: "bare function call false", expr: `contains(.spec.features, "http2")`, wantType: ExprFunction, expected: false},
+		{name: "substring", expr: `contains(.spec.host, "example")`, wantType: ExprFunction, expected: true},
+		{name: "map key", expr: `contains(.spec.labels, "tier")`, wantType: ExprFunction, expected: true},
+		{name: "comparison of function call", expr: `len(.spec.items) > 0`, wantType: ExprBinary, expected: true},
+		{name: "comparison of empty list", expr: `len(.spec.empty) > 0`, wantType: ExprBinary, expected: false},
+		{name: "function call on right", expr: `2 == len(.spec.items)`, wantType: ExprBinary, expected: true},
+		{name: "negated function call", expr: `!contains(.spec.features, "http2")`, wantType: ExprUnary, expected: true},
+		{name: "missing field is not greater", expr: `len(.spec.missing) > 0`, wantType: ExprBinary, expected: false},
+		{name: "missing field is not less", expr: `.spec.missing < 5`, wantType: ExprBinary, expected: false},
+		{name: "len of missing field is 0", expr: `len(.spec.missing) == 0`, wantType: ExprBinary, expected: true},
+		{name: "contains on missing field", expr: `contains(.spec.missing, "tls")`, wantType: ExprFunction, expected: false},
+		{name: "field of null equals", expr: `.spec.opt.enabled == true`, wantType: ExprBinary, expected: false},
+		{name: "field of null not equals", expr: `.spec.opt.enabled != true`, wantType: ExprBinary, expected: true},
+		{name: "negated field of null", expr: `!.spec.opt.enabled`, wantType: ExprUnary, expected: true},
+		{name: "field of string", expr: `.spec.s.x == 1`, wantType: ExprBinary, expected: false},
+		{name: "index past end of list", expr: `.spec.list[3] == "a"`, wantType: ExprBinary, expected: false},
+		{name: "field of null outside comparison is an error", expr: `.spec.opt.enabled`, wantType: ExprPath, wantErr: true},
+		{name: "unknown function is an error", expr: `size(.spec.items) > 0`, wantType: ExprBinary, wantErr: true},
+		{name: "negated unknown function is an error", expr: `!has_feature("tls")`, wantType: ExprUnary, wantErr: true},
+		{name: "len of a number", expr: `len(5)`, wantType: ExprFunction, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+			if expr.Type != tt.wantType {
+				t.Errorf("ParseExpression() type = %v, want %v", expr.Type, tt.wantType)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestK8sName(t *testing.T) {
+	long := strings.Repeat("a", 62) + "-bcdef"
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected string
+		wantErr  bool
+	}{
+		{name: "spaces", expr: `k8sName("my web app")`, expected: "my-web-app"},
+		{name: "uppercase", expr: `k8sName("MyWebApp")`, expected: "mywebapp"},
+		{name: "underscores and dots", expr: `k8sName("my_web.app")`, expected: "my-web-app"},
+		{name: "repeated invalid characters collapse", expr: `k8sName("web -- _ api")`, expected: "web-api"},
+		{name: "leading and trailing invalid characters", expr: `k8sName("--_Web App!_")`, expected: "web-app"},
+		{name: "non-ASCII characters", expr: `k8sName("café crème")`, expected: "caf-cr-me"},
+		{name: "digits are kept", expr: `k8sName("v1.2 Release")`, expected: "v1-2-release"},
+		{name: "number argument", expr: `k8sName(42)`, expected: "42"},
+		{name: "truncated to 63 characters", expr: `k8sName("` + strings.Repeat("x", 70) + `")`, expected: strings.Repeat("x", 63)},
+		{name: "no trailing dash after truncation", expr: `k8sName("` + long + `")`, expected: strings.Repeat("a", 62)},
+		{name: "nothing valid", expr: `k8sName("__ !!")`, wantErr: true},
+		{name: "wrong argument count", expr: `k8sName("a", "b")`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(map[string]interface{}{}).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCoerce(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    interface{}
+		typeName string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "int from string", value: "8080", typeName: "int", expected: int64(8080)},
+		{name: "int from padded string", value: " 42 ", typeName: "int", expected: int64(42)},
+		{name: "int from whole float", value: float64(3), typeName: "int", expected: int64(3)},
+		{name: "int from int", value: 7, typeName: "int", expected: int64(7)},
+		{name: "int from fraction", value: 1.5, typeName: "int", wantErr: true},
+		{name: "int from word", value: "many", typeName: "int", wantErr: true},
+		{name: "float from string", value: "2.5", typeName: "float", expected: 2.5},
+		{name: "float from int", value: int64(2), typeName: "float", expected: float64(2)},
+		{name: "float from word", value: "half", typeName: "float", wantErr: true},
+		{name: "bool from string", value: "true", typeName: "bool", expected: true},
+		{name: "bool from bool", value: false, typeName: "bool", expected: false},
+		{name: "bool from word", value: "yes", typeName: "bool", wantErr: true},
+		{name: "string from number", value: float64(8080), typeName: "string", expected: "8080"},
+		{name: "string from bool", value: true, typeName: "string", expected: "true"},
+		{name: "null", value: nil, typeName: "int", wantErr: true},
+		{name: "unknown type", value: "1", typeName: "uint", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Coerce(tt.value, tt.typeName)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Coerce() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Coerce() = %v (%T), want %v (%T)", result, result, tt.expected, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSecretFunction(t *testing.T) {
+	secrets := SecretMap{
+		"db": {"password": "s3cr3t!", "empty": ""},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		resolver SecretResolver
+		expected string
+		notFound bool
+		wantErr  bool
+	}{
+		{name: "value is base64-encoded", expr: `secret("db", "password")`, resolver: secrets, expected: "czNjcjN0IQ=="},
+		{name: "empty value", expr: `secret("db", "empty")`, resolver: secrets, expected: ""},
+		{name: "arguments are expressions", expr: `secret(.spec.secret, "pass" + "word")`, resolver: secrets, expected: "czNjcjN0IQ=="},
+		{name: "missing secret", expr: `secret("cache", "password")`, resolver: secrets, notFound: true, wantErr: true},
+		{name: "missing key", expr: `secret("db", "username")`, resolver: secrets, notFound: true, wantErr: true},
+		{name: "no secrets file", expr: `secret("db", "password")`, wantErr: true},
+		{name: "wrong argument count", expr: `secret("db")`, resolver: secrets, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(map[string]interface{}{
+				"spec": map[string]interface{}{"secret": "db"},
+			})
+			if tt.resolver != nil {
+				evaluator.SetSecretResolver(tt.resolver)
+			}
+
+			// Clones read the same secrets
+			result, err := evaluator.Clone().Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrSecretNotFound) != tt.notFound {
+				t.Errorf("errors.Is(err, ErrSecretNotFound) = %v, want %v (err = %v)", !tt.notFound, tt.notFound, err)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %q, want %q", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestScalingFunctions(t *testing.T) {
+	data := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"replicas": int64(3),
+			"load":     float64(250), // Numbers decoded from YAML are floats
+		},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{name: "ceilDiv rounds up", expr: `ceilDiv(10, 3)`, expected: int64(4)},
+		{name: "ceilDiv exact", expr: `ceilDiv(9, 3)`, expected: int64(3)},
+		{name: "ceilDiv zero dividend", expr: `ceilDiv(0, 3)`, expected: int64(0)},
+		{name: "ceilDiv negative dividend", expr: `ceilDiv(-7, 2)`, expected: int64(-3)},
+		{name: "ceilDiv negative operands", expr: `ceilDiv(-7, -2)`, expected: int64(4)},
+		{name: "ceilDiv whole float path", expr: `ceilDiv(.spec.load, 100)`, expected: int64(3)},
+		{name: "ceilDiv by zero", expr: `ceilDiv(1, 0)`, wantErr: true},
+		{name: "ceilDiv fraction", expr: `ceilDiv(1.5, 1)`, wantErr: true},
+		{name: "ceilDiv one argument", expr: `ceilDiv(1)`, wantErr: true},
+		{name: "clamp below range", expr: `clamp(1, 2, 10)`, expected: int64(2)},
+		{name: "clamp within range", expr: `clamp(.spec.replicas, 2, 10)`, expected: int64(3)},
+		{name: "clamp above range", expr: `clamp(25, 2, 10)`, expected: int64(10)},
+		{name: "clamp at bound", expr: `clamp(10, 2, 10)`, expected: int64(10)},
+		{name: "clamp float", expr: `clamp(0.5, 0, 1)`, expected: 0.5},
+		{name: "clamp replicas from load", expr: `clamp(ceilDiv(.spec.load, 50), 1, 5)`, expected: int64(5)},
+		{name: "clamp inverted range", expr: `clamp(5, 10, 2)`, wantErr: true},
+		{name: "clamp non-numeric", expr: `clamp("a", 1, 2)`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := NewEvaluator(data).Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !tt.wantErr && !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("Evaluate() = %#v, want %#v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFileFunction(t *testing.T) {
+	files := fstest.MapFS{
+		"nginx.conf":       {Data: []byte("server {\n  listen 80;\n}\n")},
+		"config/app.yaml":  {Data: []byte("debug: true\n")},
+		"config/empty.txt": {Data: []byte{}},
+	}
+
+	tests := []struct {
+		name     string
+		expr     string
+		files    fs.FS
+		expected string
+		wantErr  string
+	}{
+		{name: "file contents", expr: `file("nginx.conf")`, files: files, expected: "server {\n  listen 80;\n}\n"},
+		{name: "nested path", expr: `file("config/app.yaml")`, files: files, expected: "debug: true\n"},
+		{name: "path is cleaned", expr: `file("./config/../nginx.conf")`, files: files, expected: "server {\n  listen 80;\n}\n"},
+		{name: "path is an expression", expr: `file(.spec.dir + "/app.yaml")`, files: files, expected: "debug: true\n"},
+		{name: "empty file", expr: `file("config/empty.txt")`, files: files, expected: ""},
+		{name: "parent directory", expr: `file("../secrets.yaml")`, files: files, wantErr: "outside the template directory"},
+		{name: "escape through a subdirectory", expr: `file("config/../../nginx.conf")`, files: files, wantErr: "outside the template directory"},
+		{name: "absolute path", expr: `file("/etc/passwd")`, files: files, wantErr: "outside the template directory"},
+		{name: "missing file", expr: `file("missing.conf")`, files: files, wantErr: "failed to read missing.conf"},
+		{name: "no template directory", expr: `file("nginx.conf")`, wantErr: "requires a template directory"},
+		{name: "wrong argument count", expr: `file()`, files: files, wantErr: "requires 1 argument"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			evaluator := NewEvaluator(map[string]interface{}{
+				"spec": map[string]interface{}{"dir": "config"},
+			})
+			if tt.files != nil {
+				evaluator.SetFiles(tt.files)
+			}
+
+			// Clones read the same directory
+			result, err := evaluator.Clone().Evaluate(expr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Evaluate() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Evaluate() error = %v", err)
+			}
+			if result != tt.expected {
+				t.Errorf("Evaluate() = %q, want %q", result, tt.expected)
 			}
 		})
 	}
@@ -1,24 +1,126 @@
 package dsl
 
 import (
+	"crypto/md5"
+	"crypto/sha1"
 	"crypto/sha256"
+	"encoding/base32"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash/adler32"
+	"io/fs"
+	"math"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Evaluator evaluates DSL expressions against data
+// Evaluator evaluates DSL expressions against data.
+// Evaluation only reads the data, but RegisterResource and RegisterFunction
+// mutate the evaluator, so an Evaluator must not be shared across goroutines
+// while it is being configured. Use Clone to give each goroutine its own copy.
 type Evaluator struct {
 	data      interface{}
 	functions map[string]Function
 	resources map[string]map[string]interface{} // Resource registry for cross-resource references
+	resolver  ResourceResolver                  // Optional lookup used instead of the registry
+	trace     TraceFunc                         // Optional callback for every evaluated expression
+	maxDepth  int                               // Nesting limit for EvaluateStrings; 0 means DefaultMaxDepth
+	fallback  SubstitutionFallback              // Optional handler for $(...) expressions that fail to evaluate
+	clock     func() time.Time                  // Source of the current time for now(); nil means time.Now
+	secrets   SecretResolver                    // Source of secret() values; nil makes secret() fail
+	files     fs.FS                             // Directory file() reads from; nil makes file() fail
+}
+
+// DefaultMaxDepth is the default limit on how deeply evaluated structures may nest
+const DefaultMaxDepth = 100
+
+// ErrMaxDepthExceeded is returned when a structure nests deeper than the limit
+var ErrMaxDepthExceeded = errors.New("maximum depth exceeded")
+
+// ResourceResolver looks up the resources referenced with resource(). Resolve
+// returns an error wrapping ErrResourceNotFound when the resource does not exist.
+type ResourceResolver interface {
+	Resolve(apiVersion, kind, namespace, name string) (map[string]interface{}, error)
+}
+
+// ErrResourceNotFound is returned by a ResourceResolver for a missing resource
+var ErrResourceNotFound = errors.New("resource not found")
+
+// ErrKeyNotFound matches the error returned when a path names a map key that
+// does not exist
+var ErrKeyNotFound = errors.New("key not found")
+
+// keyNotFoundError reports a missing map key in a path
+type keyNotFoundError struct {
+	key string
+}
+
+func (e *keyNotFoundError) Error() string {
+	return fmt.Sprintf("key '%s' not found in map", e.key)
+}
+
+func (e *keyNotFoundError) Is(target error) bool {
+	return target == ErrKeyNotFound
+}
+
+// errNoValue matches the error returned when a path runs through a null or
+// scalar value, or indexes past the end of a list
+var errNoValue = errors.New("no value at path")
+
+// noValueError reports a path that cannot be followed to a value
+type noValueError struct {
+	msg string
+}
+
+func (e *noValueError) Error() string {
+	return e.msg
+}
+
+func (e *noValueError) Is(target error) bool {
+	return target == errNoValue
+}
+
+// isMissingValue reports whether err means a path has no value, which
+// comparisons, ! and len() treat as null
+func isMissingValue(err error) bool {
+	return errors.Is(err, ErrKeyNotFound) || errors.Is(err, errNoValue)
+}
+
+// registryResolver resolves resources from an evaluator's resource registry
+type registryResolver map[string]map[string]interface{}
+
+// Resolve implements ResourceResolver
+func (r registryResolver) Resolve(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	key := resourceKey(apiVersion, kind, namespace, name)
+	if resource, ok := r[key]; ok {
+		return resource, nil
+	}
+
+	// Provide helpful error message with available resources
+	available := []string{}
+	for k := range r {
+		available = append(available, k)
+	}
+	sort.Strings(available)
+	return nil, fmt.Errorf("%w: %s\nAvailable resources: %v", ErrResourceNotFound, key, available)
 }
 
 // Function represents a DSL function
 type Function func(args ...interface{}) (interface{}, error)
 
+// TraceFunc receives each evaluated expression, rendered back to source form,
+// along with its result or error
+type TraceFunc func(expr string, result interface{}, err error)
+
+// SubstitutionFallback is passed a $(...) expression that failed to evaluate
+// and its error, and returns the text substituted in its place or an error
+// to fail the evaluation
+type SubstitutionFallback func(expr string, err error) (string, error)
+
 // NewEvaluator creates a new evaluator with the given data
 func NewEvaluator(data interface{}) *Evaluator {
 	e := &Evaluator{
@@ -30,10 +132,75 @@ func NewEvaluator(data interface{}) *Evaluator {
 	return e
 }
 
-// RegisterResource adds a resource to the registry for cross-resource references
+// Clone returns a copy of the evaluator with its own function and resource
+// registries, so the copy can be configured and used independently.
+// The data and registered resources themselves are shared and must not be modified.
+func (e *Evaluator) Clone() *Evaluator {
+	clone := &Evaluator{
+		data:      e.data,
+		functions: make(map[string]Function, len(e.functions)),
+		resources: make(map[string]map[string]interface{}, len(e.resources)),
+		resolver:  e.resolver,
+		trace:     e.trace,
+		maxDepth:  e.maxDepth,
+		fallback:  e.fallback,
+		clock:     e.clock,
+		secrets:   e.secrets,
+		files:     e.files,
+	}
+	for name, fn := range e.functions {
+		clone.functions[name] = fn
+	}
+	for key, resource := range e.resources {
+		clone.resources[key] = resource
+	}
+	return clone
+}
+
+// RegisterResource adds a cluster-scoped resource, or one in the default
+// namespace, to the registry for cross-resource references
 func (e *Evaluator) RegisterResource(apiVersion, kind, name string, resource map[string]interface{}) {
-	key := fmt.Sprintf("%s/%s/%s", apiVersion, kind, name)
-	e.resources[key] = resource
+	e.RegisterNamespacedResource(apiVersion, kind, "", name, resource)
+}
+
+// RegisterNamespacedResource adds a resource in namespace to the registry for
+// cross-resource references. An empty namespace is the same as RegisterResource.
+func (e *Evaluator) RegisterNamespacedResource(apiVersion, kind, namespace, name string, resource map[string]interface{}) {
+	e.resources[resourceKey(apiVersion, kind, namespace, name)] = resource
+}
+
+// resourceKey builds the registry key for a resource
+func resourceKey(apiVersion, kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s/%s", apiVersion, kind, namespace, name)
+}
+
+// SetMaxDepth limits how deeply the values walked by EvaluateStrings may
+// nest; 0 restores DefaultMaxDepth
+func (e *Evaluator) SetMaxDepth(depth int) {
+	e.maxDepth = depth
+}
+
+// MaxDepth returns the nesting limit in effect
+func (e *Evaluator) MaxDepth() int {
+	if e.maxDepth > 0 {
+		return e.maxDepth
+	}
+	return DefaultMaxDepth
+}
+
+// SetResourceResolver makes resource() look resources up with resolver, for
+// example from a cluster or a cache, instead of the registry filled by
+// RegisterResource. A nil resolver restores the registry.
+func (e *Evaluator) SetResourceResolver(resolver ResourceResolver) {
+	e.resolver = resolver
+}
+
+// resourceResolver returns the resolver used for resource() lookups
+func (e *Evaluator) resourceResolver() ResourceResolver {
+	if e.resolver != nil {
+		return e.resolver
+	}
+	return registryResolver(e.resources)
 }
 
 // GetResources returns all registered resources
@@ -41,13 +208,43 @@ func (e *Evaluator) GetResources() map[string]map[string]interface{} {
 	return e.resources
 }
 
-// RegisterFunction registers a custom function
+// RegisterFunction registers a custom function. It replaces any built-in of
+// the same name, including try, now, secret and file.
 func (e *Evaluator) RegisterFunction(name string, fn Function) {
 	e.functions[name] = fn
 }
 
+// SetTrace installs fn to be called after every expression is evaluated,
+// including nested sub-expressions. A nil fn disables tracing.
+func (e *Evaluator) SetTrace(fn TraceFunc) {
+	e.trace = fn
+}
+
+// SetSubstitutionFallback installs fn to handle $(...) expressions that fail
+// to evaluate in EvaluateString. A nil fn makes every failure an error.
+func (e *Evaluator) SetSubstitutionFallback(fn SubstitutionFallback) {
+	e.fallback = fn
+}
+
+// SetClock makes now() read the current time from clock, for example to
+// freeze it in tests. A nil clock restores time.Now.
+func (e *Evaluator) SetClock(clock func() time.Time) {
+	e.clock = clock
+}
+
 // Evaluate evaluates an expression
 func (e *Evaluator) Evaluate(expr *Expression) (interface{}, error) {
+	if e.trace == nil {
+		return e.evaluate(expr)
+	}
+
+	result, err := e.evaluate(expr)
+	e.trace(exprToString(expr), result, err)
+	return result, err
+}
+
+// evaluate dispatches on the expression type
+func (e *Evaluator) evaluate(expr *Expression) (interface{}, error) {
 	switch expr.Type {
 	case ExprPath:
 		return e.evaluatePath(expr.Path)
@@ -65,20 +262,71 @@ func (e *Evaluator) Evaluate(expr *Expression) (interface{}, error) {
 		return e.evaluateResourceRef(expr.ResourceRef)
 	case ExprUnary:
 		return e.evaluateUnary(expr)
+	case ExprArrayLiteral:
+		return e.evaluateArrayLiteral(expr)
+	case ExprMapLiteral:
+		return e.evaluateMapLiteral(expr)
 	default:
 		return nil, fmt.Errorf("unknown expression type: %d", expr.Type)
 	}
 }
 
-// EvaluateString evaluates a string that may contain variable substitutions
+// EvaluateString evaluates a string that may contain variable substitutions.
+// An escaped "$$(" or "$$if(" is emitted as a literal "$(" or "$if(" without
+// being evaluated.
 func (e *Evaluator) EvaluateString(input string) (string, error) {
-	result := input
+	return replaceSubstitutions(input, "$", func(exprStr string) (string, error) {
+		expr, err := ParseExpression(exprStr)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse expression '%s': %w", exprStr, err)
+		}
+
+		value, err := e.Evaluate(expr)
+		if err != nil {
+			if e.fallback != nil {
+				return e.fallback(exprStr, err)
+			}
+			return "", fmt.Errorf("failed to evaluate expression '%s': %w", exprStr, err)
+		}
+
+		return fmt.Sprintf("%v", value), nil
+	})
+}
+
+// Substitutions returns the $(...) and $if(...) expressions in input without
+// their delimiters, as EvaluateString would evaluate them
+func Substitutions(input string) ([]string, error) {
+	var exprs []string
+	_, err := replaceSubstitutions(input, "", func(exprStr string) (string, error) {
+		exprs = append(exprs, exprStr)
+		return "", nil
+	})
+	return exprs, err
+}
+
+// replaceSubstitutions replaces every $(...) and $if(...) expression in input
+// with the result of replace, which is passed the expression without its
+// delimiters ($if(...) is passed as a call to if). The "$$" of an escaped
+// "$$(" or "$$if(" is replaced by escape and the text after it is not treated
+// as an expression.
+func replaceSubstitutions(input, escape string, replace func(exprStr string) (string, error)) (string, error) {
+	var result strings.Builder
+	rest := input
 
-	// Find all $(...) and $if(...) expressions, handling nested parentheses
+	// Find all $(...) and $if(...) expressions, handling nested parentheses.
+	// Output is built left to right, so escapes and substituted values are never rescanned.
 	for {
-		// Check for $if( first (inline ternary)
-		ifStart := strings.Index(result, "$if(")
-		dollarStart := strings.Index(result, "$(")
+		escapeStart := indexEscape(rest)
+		ifStart := strings.Index(rest, "$if(")
+		dollarStart := strings.Index(rest, "$(")
+
+		// An escape always precedes the "$(" or "$if(" it contains
+		if escapeStart != -1 && (ifStart == -1 || escapeStart < ifStart) && (dollarStart == -1 || escapeStart < dollarStart) {
+			result.WriteString(rest[:escapeStart])
+			result.WriteString(escape)
+			rest = rest[escapeStart+2:]
+			continue
+		}
 
 		var start int
 		var prefixLen int
@@ -97,10 +345,10 @@ func (e *Evaluator) EvaluateString(input string) (string, error) {
 		// Find matching closing parenthesis
 		depth := 0
 		end := -1
-		for i := start + prefixLen - 1; i < len(result); i++ {
-			if result[i] == '(' {
+		for i := start + prefixLen - 1; i < len(rest); i++ {
+			if rest[i] == '(' {
 				depth++
-			} else if result[i] == ')' {
+			} else if rest[i] == ')' {
 				depth--
 				if depth == 0 {
 					end = i
@@ -114,84 +362,311 @@ func (e *Evaluator) EvaluateString(input string) (string, error) {
 		}
 
 		// Extract expression (without prefix and ))
-		fullMatch := result[start : end+1]
-		exprStr := result[start+prefixLen : end]
+		exprStr := rest[start+prefixLen : end]
 
 		// If this was a $if( expression, wrap it as a function call
 		if prefixLen == 4 {
 			exprStr = "if(" + exprStr + ")"
 		}
 
+		replacement, err := replace(exprStr)
+		if err != nil {
+			return "", err
+		}
+
+		result.WriteString(rest[:start])
+		result.WriteString(replacement)
+		rest = rest[end+1:]
+	}
+
+	result.WriteString(rest)
+	return result.String(), nil
+}
+
+// indexEscape returns the index of the first escaped "$$(" or "$$if(" in s,
+// or -1 if there is none
+func indexEscape(s string) int {
+	for i := 0; i+2 < len(s); i++ {
+		if s[i] == '$' && s[i+1] == '$' && (s[i+2] == '(' || strings.HasPrefix(s[i+2:], "if(")) {
+			return i
+		}
+	}
+	return -1
+}
+
+// BindVariables rewrites the $(...) expressions in input so that paths rooted
+// at any of the named variables are replaced by their current values. The
+// result can be evaluated later, e.g. in the hydrator's reference pass, by an
+// evaluator where the variables are no longer in scope. Bound paths must
+// evaluate to strings, numbers or booleans. Escaped "$$(" and "$$if(" are kept as is.
+func (e *Evaluator) BindVariables(input string, names []string) (string, error) {
+	bound := make(map[string]bool, len(names))
+	for _, name := range names {
+		bound[name] = true
+	}
+
+	return replaceSubstitutions(input, "$$", func(exprStr string) (string, error) {
 		expr, err := ParseExpression(exprStr)
 		if err != nil {
 			return "", fmt.Errorf("failed to parse expression '%s': %w", exprStr, err)
 		}
 
-		value, err := e.Evaluate(expr)
+		rewritten, changed, err := e.bindExpression(expr, bound)
 		if err != nil {
-			return "", fmt.Errorf("failed to evaluate expression '%s': %w", exprStr, err)
+			return "", fmt.Errorf("failed to bind variables in expression '%s': %w", exprStr, err)
+		}
+		if !changed {
+			return "$(" + exprStr + ")", nil
 		}
+		return "$(" + exprToString(rewritten) + ")", nil
+	})
+}
 
-		// Convert value to string
-		valueStr := fmt.Sprintf("%v", value)
-		result = strings.Replace(result, fullMatch, valueStr, 1)
+// bindExpression returns a copy of expr with paths rooted at bound variables
+// replaced by literals, and whether anything was replaced
+func (e *Evaluator) bindExpression(expr *Expression, bound map[string]bool) (*Expression, bool, error) {
+	if expr == nil {
+		return nil, false, nil
 	}
 
-	return result, nil
-}
+	switch expr.Type {
+	case ExprPath, ExprArrayIndex:
+		root := expr.Path
+		if i := strings.IndexAny(root, ".["); i >= 0 {
+			root = root[:i]
+		}
+		if bound[root] {
+			value, err := e.Evaluate(expr)
+			if err != nil {
+				return nil, false, err
+			}
+			literal, err := literalExpression(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("%s: %w", root, err)
+			}
+			return literal, true, nil
+		}
+		if expr.Type == ExprArrayIndex {
+			index, changed, err := e.bindExpression(expr.Index, bound)
+			if err != nil || !changed {
+				return expr, false, err
+			}
+			copied := *expr
+			copied.Index = index
+			return &copied, true, nil
+		}
+		return expr, false, nil
 
-// evaluatePath evaluates a path expression like ".spec.name" or "envVar.name"
-func (e *Evaluator) evaluatePath(path string) (interface{}, error) {
-	// Handle paths that start with '.' (regular paths from root)
-	var parts []string
-	if strings.HasPrefix(path, ".") {
-		// Remove leading dot
-		path = path[1:]
-		parts = strings.Split(path, ".")
-	} else {
-		// Loop variable path (e.g., "envVar.name")
-		parts = strings.Split(path, ".")
-	}
+	case ExprFunction:
+		args := make([]string, len(expr.Args))
+		changed := false
+		for i, arg := range expr.Args {
+			argExpr, err := ParseExpression(arg)
+			if err != nil {
+				return nil, false, fmt.Errorf("failed to parse argument: %w", err)
+			}
+			boundArg, argChanged, err := e.bindExpression(argExpr, bound)
+			if err != nil {
+				return nil, false, err
+			}
+			args[i] = arg
+			if argChanged {
+				args[i] = exprToString(boundArg)
+				changed = true
+			}
+		}
+		if !changed {
+			return expr, false, nil
+		}
+		copied := *expr
+		copied.Args = args
+		return &copied, true, nil
 
-	// Navigate through the data structure
-	current := e.data
-	for _, part := range parts {
-		if part == "" {
-			continue
+	case ExprBinary:
+		left, leftChanged, err := e.bindExpression(expr.Left, bound)
+		if err != nil {
+			return nil, false, err
+		}
+		right, rightChanged, err := e.bindExpression(expr.Right, bound)
+		if err != nil {
+			return nil, false, err
+		}
+		if !leftChanged && !rightChanged {
+			return expr, false, nil
 		}
+		copied := *expr
+		copied.Left, copied.Right = left, right
+		return &copied, true, nil
 
-		val := reflect.ValueOf(current)
+	case ExprUnary:
+		operand, changed, err := e.bindExpression(expr.Operand, bound)
+		if err != nil || !changed {
+			return expr, false, err
+		}
+		copied := *expr
+		copied.Operand = operand
+		return &copied, true, nil
+
+	case ExprConcat, ExprArrayLiteral, ExprMapLiteral:
+		elements := make([]*Expression, len(expr.Elements))
+		changed := false
+		for i, element := range expr.Elements {
+			boundElement, elementChanged, err := e.bindExpression(element, bound)
+			if err != nil {
+				return nil, false, err
+			}
+			elements[i] = boundElement
+			changed = changed || elementChanged
+		}
+		if !changed {
+			return expr, false, nil
+		}
+		copied := *expr
+		copied.Elements = elements
+		return &copied, true, nil
 
-		// Handle pointers
-		if val.Kind() == reflect.Ptr {
-			val = val.Elem()
+	case ExprResourceRef:
+		name, nameChanged, err := e.bindExpression(expr.ResourceRef.Name, bound)
+		if err != nil {
+			return nil, false, err
+		}
+		namespace, namespaceChanged, err := e.bindExpression(expr.ResourceRef.Namespace, bound)
+		if err != nil {
+			return nil, false, err
 		}
+		if !nameChanged && !namespaceChanged {
+			return expr, false, nil
+		}
+		ref := *expr.ResourceRef
+		ref.Name, ref.Namespace = name, namespace
+		copied := *expr
+		copied.ResourceRef = &ref
+		return &copied, true, nil
 
-		switch val.Kind() {
-		case reflect.Map:
-			// Handle map access
-			key := reflect.ValueOf(part)
-			mapVal := val.MapIndex(key)
-			if !mapVal.IsValid() {
-				return nil, fmt.Errorf("key '%s' not found in map", part)
+	default:
+		return expr, false, nil
+	}
+}
+
+// literalExpression returns a literal expression that evaluates to value
+func literalExpression(value interface{}) (*Expression, error) {
+	switch v := value.(type) {
+	case string:
+		// String literals have no escapes, so pick a quote the value does not contain
+		quote := "\""
+		if strings.Contains(v, quote) {
+			quote = "'"
+			if strings.Contains(v, quote) {
+				return nil, fmt.Errorf("cannot bind a string containing both quote characters: %s", v)
 			}
-			current = mapVal.Interface()
+		}
+		return &Expression{Type: ExprLiteral, Path: quote + v + quote}, nil
+	case int, int32, int64:
+		return &Expression{Type: ExprLiteral, Path: fmt.Sprintf("%d", v)}, nil
+	case float32, float64:
+		num, _ := toFloat64(v)
+		literal := strconv.FormatFloat(num, 'f', -1, 64)
+		if !strings.Contains(literal, ".") {
+			literal += ".0" // Keep whole floats from parsing back as integers
+		}
+		return &Expression{Type: ExprLiteral, Path: literal}, nil
+	case bool:
+		return &Expression{Type: ExprLiteral, Path: strconv.FormatBool(v)}, nil
+	default:
+		return nil, fmt.Errorf("only strings, numbers and booleans can be bound, got %T", value)
+	}
+}
 
-		case reflect.Struct:
-			// Handle struct field access
-			field := val.FieldByName(strings.Title(part))
-			if !field.IsValid() {
-				// Try lowercase
-				field = val.FieldByName(part)
+// EvaluateStrings returns a copy of value, a tree of maps, slices and scalars
+// such as decoded YAML, with EvaluateString applied to every string leaf
+func (e *Evaluator) EvaluateStrings(value interface{}) (interface{}, error) {
+	return e.EvaluateStringsMatching(value, nil)
+}
+
+// EvaluateStringsMatching is like EvaluateStrings, but only evaluates the
+// strings for which match returns true and copies the others unchanged.
+// A nil match evaluates every string.
+func (e *Evaluator) EvaluateStringsMatching(value interface{}, match func(string) bool) (interface{}, error) {
+	return e.evaluateStrings(value, "", 0, match)
+}
+
+// evaluateStrings walks value, prefixing errors with the path of the failing string
+func (e *Evaluator) evaluateStrings(value interface{}, path string, depth int, match func(string) bool) (interface{}, error) {
+	if depth > e.MaxDepth() {
+		return nil, fmt.Errorf("%s: %w (limit %d)", path, ErrMaxDepthExceeded, e.MaxDepth())
+	}
+
+	switch v := value.(type) {
+	case string:
+		if match != nil && !match(v) {
+			return v, nil
+		}
+		result, err := e.EvaluateString(v)
+		if err != nil {
+			if path == "" {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return result, nil
+
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
 			}
-			if !field.IsValid() {
-				return nil, fmt.Errorf("field '%s' not found in struct", part)
+			processed, err := e.evaluateStrings(val, childPath, depth+1, match)
+			if err != nil {
+				return nil, err
 			}
-			current = field.Interface()
+			result[key] = processed
+		}
+		return result, nil
 
-		default:
-			return nil, fmt.Errorf("cannot access '%s' on type %s", part, val.Kind())
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			processed, err := e.evaluateStrings(item, fmt.Sprintf("%s[%d]", path, i), depth+1, match)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = processed
+		}
+		return result, nil
+
+	default:
+		return v, nil
+	}
+}
+
+// evaluatePath evaluates a path expression like ".spec.name" or "envVar.name"
+func (e *Evaluator) evaluatePath(path string) (interface{}, error) {
+	// Paths that start with '.' are regular paths from root, others are
+	// loop variable paths (e.g., "envVar.name")
+	segments, err := splitFieldPath(strings.TrimPrefix(path, "."))
+	if err != nil {
+		return nil, err
+	}
+
+	// Navigate through the data structure
+	current := e.data
+	for _, segment := range segments {
+		if segment.optional && current == nil {
+			return nil, nil
 		}
+		value, found, err := lookupSegment(current, segment)
+		if err != nil {
+			return nil, err
+		}
+		if !found || (segment.optional && value == nil) {
+			if segment.optional {
+				return nil, nil
+			}
+			return nil, &keyNotFoundError{key: segment.key}
+		}
+		current = value
 	}
 
 	return current, nil
@@ -199,7 +674,24 @@ func (e *Evaluator) evaluatePath(path string) (interface{}, error) {
 
 // evaluateFunction evaluates a function call
 func (e *Evaluator) evaluateFunction(name string, args []string) (interface{}, error) {
+	// try() needs its raw first argument so evaluation errors can be
+	// recovered, and now(), secret() and file() read the evaluator's clock,
+	// secrets and files, so they are bound here rather than registered as
+	// functions that clones would share. A function registered under one of
+	// these names replaces the built-in.
 	fn, ok := e.functions[name]
+	if !ok {
+		switch name {
+		case "try":
+			return e.evaluateTry(args)
+		case "now":
+			fn, ok = e.evaluateNow, true
+		case "secret":
+			fn, ok = e.evaluateSecret, true
+		case "file":
+			fn, ok = e.evaluateFile, true
+		}
+	}
 	if !ok {
 		return nil, fmt.Errorf("unknown function: %s", name)
 	}
@@ -214,7 +706,12 @@ func (e *Evaluator) evaluateFunction(name string, args []string) (interface{}, e
 
 		val, err := e.Evaluate(expr)
 		if err != nil {
-			return nil, fmt.Errorf("failed to evaluate argument: %w", err)
+			// len() and contains() treat a missing field as null, so
+			// "len(.spec.items) == 0" holds when items is absent
+			if (name != "len" && name != "contains") || !isMissingValue(err) {
+				return nil, fmt.Errorf("failed to evaluate argument: %w", err)
+			}
+			val = nil
 		}
 
 		evalArgs[i] = val
@@ -223,37 +720,86 @@ func (e *Evaluator) evaluateFunction(name string, args []string) (interface{}, e
 	return fn(evalArgs...)
 }
 
+// evaluateNow evaluates now(layout), the current UTC time formatted with the
+// Go layout or RFC 3339 when no layout is given
+func (e *Evaluator) evaluateNow(args ...interface{}) (interface{}, error) {
+	if len(args) > 1 {
+		return nil, fmt.Errorf("now() takes at most 1 argument: layout")
+	}
+	layout := time.RFC3339
+	if len(args) == 1 {
+		layout = fmt.Sprintf("%v", args[0])
+	}
+
+	now := time.Now()
+	if e.clock != nil {
+		now = e.clock()
+	}
+	return now.UTC().Format(layout), nil
+}
+
+// evaluateTry evaluates try(expr, fallback), returning fallback if expr fails to
+// parse or evaluate
+func (e *Evaluator) evaluateTry(args []string) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("try() requires 2 arguments")
+	}
+
+	if expr, err := ParseExpression(args[0]); err == nil {
+		if val, err := e.Evaluate(expr); err == nil {
+			return val, nil
+		}
+	}
+
+	fallback, err := ParseExpression(args[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse argument: %w", err)
+	}
+
+	val, err := e.Evaluate(fallback)
+	if err != nil {
+		return nil, fmt.Errorf("failed to evaluate argument: %w", err)
+	}
+
+	return val, nil
+}
+
 // evaluateBinary evaluates a binary expression
 func (e *Evaluator) evaluateBinary(expr *Expression) (interface{}, error) {
 	left, err := e.Evaluate(expr.Left)
 	if err != nil {
-		// For comparison operators, treat evaluation errors (e.g., missing fields) as nil
+		// For comparison operators, treat missing fields as nil
 		// This allows expressions like "ws.disabled != true" to work when disabled doesn't exist
-		switch expr.Operator {
-		case "==", "!=", ">", "<", ">=", "<=":
-			left = nil
-		default:
+		if !isComparison(expr.Operator) || !isMissingValue(err) {
 			return nil, err
 		}
+		left = nil
 	}
 
 	right, err := e.Evaluate(expr.Right)
 	if err != nil {
 		// Same treatment for right side
-		switch expr.Operator {
-		case "==", "!=", ">", "<", ">=", "<=":
-			right = nil
-		default:
+		if !isComparison(expr.Operator) || !isMissingValue(err) {
 			return nil, err
 		}
+		right = nil
 	}
 
 	switch expr.Operator {
 	// Comparison operators
 	case "==":
-		return fmt.Sprintf("%v", left) == fmt.Sprintf("%v", right), nil
+		return valuesEqual(left, right), nil
 	case "!=":
-		return fmt.Sprintf("%v", left) != fmt.Sprintf("%v", right), nil
+		return !valuesEqual(left, right), nil
+	}
+
+	// A missing value is neither less nor greater than anything, so
+	// "len(.spec.items) > 0" is false when items is absent
+	if (left == nil || right == nil) && isOrdering(expr.Operator) {
+		return false, nil
+	}
+
+	switch expr.Operator {
 	case ">":
 		return compareValues(left, right) > 0, nil
 	case "<":
@@ -263,11 +809,25 @@ func (e *Evaluator) evaluateBinary(expr *Expression) (interface{}, error) {
 	case "<=":
 		return compareValues(left, right) <= 0, nil
 
+	// Membership operators
+	case "in":
+		return contains(right, left)
+	case "not in":
+		found, err := contains(right, left)
+		if err != nil {
+			return nil, err
+		}
+		return !found, nil
+
 	// Arithmetic operators
 	case "+":
-		// Check if either operand is a string - if so, do string concatenation
+		// Check if either operand is a string - if so, do string concatenation,
+		// unless a number is added to a numeric-looking string
 		_, leftIsStr := left.(string)
 		_, rightIsStr := right.(string)
+		if leftIsStr != rightIsStr && isNumeric(left) && isNumeric(right) {
+			return performArithmetic(left, right, "+")
+		}
 		if leftIsStr || rightIsStr {
 			// String concatenation
 			return fmt.Sprintf("%v", left) + fmt.Sprintf("%v", right), nil
@@ -288,17 +848,134 @@ func (e *Evaluator) evaluateBinary(expr *Expression) (interface{}, error) {
 	}
 }
 
+// isComparison reports whether operator compares its operands, treating
+// missing fields as nil
+func isComparison(operator string) bool {
+	switch operator {
+	case "==", "!=", ">", "<", ">=", "<=", "in", "not in":
+		return true
+	}
+	return false
+}
+
+// isOrdering reports whether operator orders its operands
+func isOrdering(operator string) bool {
+	switch operator {
+	case ">", "<", ">=", "<=":
+		return true
+	}
+	return false
+}
+
+// valuesEqual reports whether two values are equal for == and !=. Maps and
+// arrays are equal when they have equal elements, compared the same way;
+// other values are compared as strings, so 1 == "1". A collection never
+// equals a scalar.
+func valuesEqual(a, b interface{}) bool {
+	av, bv := reflect.ValueOf(a), reflect.ValueOf(b)
+	aKind, bKind := collectionKind(av), collectionKind(bv)
+	if aKind != bKind {
+		return false
+	}
+
+	switch aKind {
+	case reflect.Map:
+		if av.Len() != bv.Len() {
+			return false
+		}
+		// Keys are matched as strings, like scalar values
+		bValues := make(map[string]interface{}, bv.Len())
+		for _, key := range bv.MapKeys() {
+			bValues[fmt.Sprintf("%v", key.Interface())] = bv.MapIndex(key).Interface()
+		}
+		for _, key := range av.MapKeys() {
+			bValue, ok := bValues[fmt.Sprintf("%v", key.Interface())]
+			if !ok || !valuesEqual(av.MapIndex(key).Interface(), bValue) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if av.Len() != bv.Len() {
+			return false
+		}
+		for i := 0; i < av.Len(); i++ {
+			if !valuesEqual(av.Index(i).Interface(), bv.Index(i).Interface()) {
+				return false
+			}
+		}
+		return true
+	}
+
+	return fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
+
+// collectionKind returns reflect.Map for maps and reflect.Slice for arrays
+// and slices, or reflect.Invalid for any other value
+func collectionKind(v reflect.Value) reflect.Kind {
+	switch v.Kind() {
+	case reflect.Map:
+		return reflect.Map
+	case reflect.Slice, reflect.Array:
+		return reflect.Slice
+	}
+	return reflect.Invalid
+}
+
+// contains reports whether an element of list equals value when both are
+// compared as strings. A nil list has no elements.
+func contains(list, value interface{}) (bool, error) {
+	if list == nil {
+		return false, nil
+	}
+	items, ok := list.([]interface{})
+	if !ok {
+		return false, fmt.Errorf("right operand of 'in' must be an array, got %T", list)
+	}
+	for _, item := range items {
+		if fmt.Sprintf("%v", item) == fmt.Sprintf("%v", value) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// evaluateArrayLiteral evaluates each element of an array literal
+func (e *Evaluator) evaluateArrayLiteral(expr *Expression) (interface{}, error) {
+	result := make([]interface{}, 0, len(expr.Elements))
+	for _, element := range expr.Elements {
+		value, err := e.Evaluate(element)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// evaluateMapLiteral evaluates each value of a map literal under its key
+func (e *Evaluator) evaluateMapLiteral(expr *Expression) (interface{}, error) {
+	result := make(map[string]interface{}, len(expr.Keys))
+	for i, key := range expr.Keys {
+		value, err := e.Evaluate(expr.Elements[i])
+		if err != nil {
+			return nil, fmt.Errorf("map key %s: %w", key, err)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
 // evaluateUnary evaluates unary expressions (!, -, etc.)
 func (e *Evaluator) evaluateUnary(expr *Expression) (interface{}, error) {
 	// Evaluate the operand
 	operand, err := e.Evaluate(expr.Operand)
 	if err != nil {
 		// For NOT operator, treat missing fields as false/nil
-		if expr.Operator == "!" {
-			operand = nil
-		} else {
+		if expr.Operator != "!" || !isMissingValue(err) {
 			return nil, err
 		}
+		operand = nil
 	}
 
 	switch expr.Operator {
@@ -311,6 +988,10 @@ func (e *Evaluator) evaluateUnary(expr *Expression) (interface{}, error) {
 		if err != nil {
 			return nil, fmt.Errorf("cannot negate non-numeric value: %v", operand)
 		}
+		// Like arithmetic, whole numbers stay integers
+		if val == float64(int64(val)) {
+			return -int64(val), nil
+		}
 		return -val, nil
 	default:
 		return nil, fmt.Errorf("unknown unary operator: %s", expr.Operator)
@@ -338,6 +1019,22 @@ func isTruthy(val interface{}) bool {
 	}
 }
 
+// isEmpty reports whether a value is nil, an empty string, or an empty slice or map.
+// Zero numbers and false are not empty.
+func isEmpty(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	default:
+		return false
+	}
+}
+
 // evaluateArrayIndex evaluates array indexing expressions
 func (e *Evaluator) evaluateArrayIndex(expr *Expression) (interface{}, error) {
 	// Evaluate the base path to get the array/map
@@ -362,7 +1059,7 @@ func (e *Evaluator) evaluateArrayIndex(expr *Expression) (interface{}, error) {
 		keyVal := reflect.ValueOf(indexValue)
 		mapVal := val.MapIndex(keyVal)
 		if !mapVal.IsValid() {
-			return nil, fmt.Errorf("key %v not found in map", indexValue)
+			return nil, &noValueError{msg: fmt.Sprintf("key %v not found in map", indexValue)}
 		}
 		return mapVal.Interface(), nil
 
@@ -373,12 +1070,12 @@ func (e *Evaluator) evaluateArrayIndex(expr *Expression) (interface{}, error) {
 			return nil, fmt.Errorf("array index must be an integer: %w", err)
 		}
 		if index < 0 || index >= val.Len() {
-			return nil, fmt.Errorf("array index %d out of bounds (length %d)", index, val.Len())
+			return nil, &noValueError{msg: fmt.Sprintf("array index %d out of bounds (length %d)", index, val.Len())}
 		}
 		return val.Index(index).Interface(), nil
 
 	default:
-		return nil, fmt.Errorf("cannot index into type %s", val.Kind())
+		return nil, &noValueError{msg: fmt.Sprintf("cannot index into type %s", val.Kind())}
 	}
 }
 
@@ -409,18 +1106,29 @@ func (e *Evaluator) evaluateResourceRef(ref *ResourceReference) (interface{}, er
 
 	name := fmt.Sprintf("%v", nameValue)
 
-	// Build resource key
-	key := fmt.Sprintf("%s/%s/%s", ref.APIVersion, ref.Kind, name)
+	// Evaluate the namespace, defaulting to the instance namespace
+	namespace := ""
+	if ref.Namespace != nil {
+		namespaceValue, err := e.Evaluate(ref.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate resource namespace: %w", err)
+		}
+		namespace = fmt.Sprintf("%v", namespaceValue)
+	} else if namespaceValue, err := e.evaluatePath(".metadata.namespace"); err == nil && namespaceValue != nil {
+		namespace = fmt.Sprintf("%v", namespaceValue)
+	}
 
 	// Look up resource
-	resource, ok := e.resources[key]
-	if !ok {
-		// Provide helpful error message with available resources
-		available := []string{}
-		for k := range e.resources {
-			available = append(available, k)
+	resolver := e.resourceResolver()
+	resource, err := resolver.Resolve(ref.APIVersion, ref.Kind, namespace, name)
+	if errors.Is(err, ErrResourceNotFound) && ref.Namespace == nil && namespace != "" {
+		// Resources without an explicit namespace are registered with an empty one
+		if fallback, fallbackErr := resolver.Resolve(ref.APIVersion, ref.Kind, "", name); fallbackErr == nil {
+			resource, err = fallback, nil
 		}
-		return nil, fmt.Errorf("resource not found: %s\nAvailable resources: %v", key, available)
+	}
+	if err != nil {
+		return nil, err
 	}
 
 	// If no field path, return the entire resource
@@ -434,91 +1142,150 @@ func (e *Evaluator) evaluateResourceRef(ref *ResourceReference) (interface{}, er
 
 // navigateResourceField navigates to a field in a resource
 func (e *Evaluator) navigateResourceField(resource map[string]interface{}, fieldPath string) (interface{}, error) {
-	// Parse field path (e.g., "spec.clusterIP" or "spec.ports[0].port")
-	parts := strings.Split(fieldPath, ".")
+	// Parse field path (e.g., "spec.clusterIP", "spec.ports[0].port" or
+	// `metadata.annotations["my.domain/key"]`)
+	segments, err := splitFieldPath(fieldPath)
+	if err != nil {
+		return nil, err
+	}
 
 	current := interface{}(resource)
-	for _, part := range parts {
-		if part == "" {
-			continue
+	for _, segment := range segments {
+		if segment.optional && current == nil {
+			return nil, nil
+		}
+		value, found, err := lookupSegment(current, segment)
+		if err != nil {
+			return nil, err
+		}
+		if !found || (segment.optional && value == nil) {
+			if segment.optional {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("field '%s' not found in resource", segment.key)
 		}
+		current = value
+	}
 
-		// Check for array indexing in the part
-		if strings.Contains(part, "[") && strings.Contains(part, "]") {
-			// Parse array access: field[index]
-			openBracket := strings.Index(part, "[")
-			closeBracket := strings.Index(part, "]")
+	return current, nil
+}
 
-			fieldName := part[:openBracket]
-			indexStr := part[openBracket+1 : closeBracket]
+// pathSegment is one step of a field path: a map key or struct field, or an array index
+type pathSegment struct {
+	key      string
+	index    int
+	isIndex  bool
+	optional bool // Next to a "?.", so a missing or null value ends the path with nil
+}
 
-			// Navigate to the field first
-			if fieldName != "" {
-				val := reflect.ValueOf(current)
-				if val.Kind() == reflect.Ptr {
-					val = val.Elem()
-				}
+// splitFieldPath splits a field path like "spec.ports[0].port" into segments.
+// Bracketed quoted keys such as ["my.domain/key"] are kept whole, so they may contain dots.
+// The keys on both sides of a "?." are optional: in "spec?.tls" neither a
+// missing spec nor a missing tls is an error.
+func splitFieldPath(path string) ([]pathSegment, error) {
+	var segments []pathSegment
+	var current strings.Builder
+	optional := false
+	flush := func() {
+		if current.Len() > 0 {
+			segments = append(segments, pathSegment{key: current.String(), optional: optional})
+			current.Reset()
+			optional = false
+		}
+	}
 
-				switch val.Kind() {
-				case reflect.Map:
-					key := reflect.ValueOf(fieldName)
-					mapVal := val.MapIndex(key)
-					if !mapVal.IsValid() {
-						return nil, fmt.Errorf("field '%s' not found in resource", fieldName)
-					}
-					current = mapVal.Interface()
-				default:
-					return nil, fmt.Errorf("cannot access field '%s' on type %s", fieldName, val.Kind())
+	for i := 0; i < len(path); i++ {
+		switch ch := path[i]; ch {
+		case '.':
+			flush()
+		case '?':
+			if i+1 >= len(path) || path[i+1] != '.' {
+				return nil, fmt.Errorf("unexpected '?' in path '%s' (use '?.' for optional field access)", path)
+			}
+			flush()
+			if len(segments) > 0 {
+				segments[len(segments)-1].optional = true
+			}
+			optional = true
+			i++
+		case '[':
+			flush()
+			if i+1 < len(path) && (path[i+1] == '"' || path[i+1] == '\'') {
+				// Quoted map key: ["key"] or ['key']
+				quote := path[i+1]
+				end := strings.IndexByte(path[i+2:], quote)
+				if end == -1 || i+2+end+1 >= len(path) || path[i+2+end+1] != ']' {
+					return nil, fmt.Errorf("unterminated quoted key in path '%s'", path)
 				}
+				segments = append(segments, pathSegment{key: path[i+2 : i+2+end], optional: optional})
+				optional = false
+				i += 2 + end + 1
+				continue
 			}
 
-			// Parse index
+			// Array index: [0]
+			end := strings.IndexByte(path[i:], ']')
+			if end == -1 {
+				return nil, fmt.Errorf("missing ']' in path '%s'", path)
+			}
+			indexStr := path[i+1 : i+end]
 			index, err := strconv.Atoi(indexStr)
 			if err != nil {
 				return nil, fmt.Errorf("invalid array index '%s': %w", indexStr, err)
 			}
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			i += end
+		default:
+			current.WriteByte(ch)
+		}
+	}
+	flush()
 
-			// Access array element
-			val := reflect.ValueOf(current)
-			if val.Kind() == reflect.Slice || val.Kind() == reflect.Array {
-				if index < 0 || index >= val.Len() {
-					return nil, fmt.Errorf("array index %d out of bounds (length %d)", index, val.Len())
-				}
-				current = val.Index(index).Interface()
-			} else {
-				return nil, fmt.Errorf("cannot index into type %s", val.Kind())
-			}
-		} else {
-			// Regular field access
-			val := reflect.ValueOf(current)
-			if val.Kind() == reflect.Ptr {
-				val = val.Elem()
-			}
-
-			switch val.Kind() {
-			case reflect.Map:
-				key := reflect.ValueOf(part)
-				mapVal := val.MapIndex(key)
-				if !mapVal.IsValid() {
-					return nil, fmt.Errorf("field '%s' not found in resource", part)
-				}
-				current = mapVal.Interface()
-			case reflect.Struct:
-				field := val.FieldByName(strings.Title(part))
-				if !field.IsValid() {
-					field = val.FieldByName(part)
-				}
-				if !field.IsValid() {
-					return nil, fmt.Errorf("field '%s' not found in struct", part)
-				}
-				current = field.Interface()
-			default:
-				return nil, fmt.Errorf("cannot access '%s' on type %s", part, val.Kind())
-			}
+	return segments, nil
+}
+
+// lookupSegment resolves a single path segment against value. found is false
+// when a map key is missing, so callers can report it in their own terms.
+func lookupSegment(value interface{}, segment pathSegment) (result interface{}, found bool, err error) {
+	val := reflect.ValueOf(value)
+
+	// Handle pointers
+	if val.Kind() == reflect.Ptr {
+		val = val.Elem()
+	}
+
+	if segment.isIndex {
+		if val.Kind() != reflect.Slice && val.Kind() != reflect.Array {
+			return nil, false, &noValueError{msg: fmt.Sprintf("cannot index into type %s", val.Kind())}
 		}
+		if segment.index < 0 || segment.index >= val.Len() {
+			return nil, false, &noValueError{msg: fmt.Sprintf("array index %d out of bounds (length %d)", segment.index, val.Len())}
+		}
+		return val.Index(segment.index).Interface(), true, nil
 	}
 
-	return current, nil
+	switch val.Kind() {
+	case reflect.Map:
+		mapVal := val.MapIndex(reflect.ValueOf(segment.key))
+		if !mapVal.IsValid() {
+			return nil, false, nil
+		}
+		return mapVal.Interface(), true, nil
+
+	case reflect.Struct:
+		field := val.FieldByName(strings.Title(segment.key))
+		if !field.IsValid() {
+			// Try lowercase
+			field = val.FieldByName(segment.key)
+		}
+		if !field.IsValid() {
+			return nil, false, fmt.Errorf("field '%s' not found in struct", segment.key)
+		}
+		return field.Interface(), true, nil
+
+	default:
+		return nil, false, &noValueError{msg: fmt.Sprintf("cannot access '%s' on type %s", segment.key, val.Kind())}
+	}
 }
 
 // evaluateLiteral evaluates a literal value
@@ -586,32 +1353,129 @@ func (e *Evaluator) registerBuiltinFunctions() {
 		return strings.ReplaceAll(str, old, new), nil
 	})
 
-	e.RegisterFunction("trimPrefix", func(args ...interface{}) (interface{}, error) {
-		if len(args) != 2 {
-			return nil, fmt.Errorf("trimPrefix() requires 2 arguments")
+	e.RegisterFunction("trimPrefix", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("trimPrefix() requires 2 arguments")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		prefix := fmt.Sprintf("%v", args[1])
+		return strings.TrimPrefix(str, prefix), nil
+	})
+
+	e.RegisterFunction("trimSuffix", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("trimSuffix() requires 2 arguments")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		suffix := fmt.Sprintf("%v", args[1])
+		return strings.TrimSuffix(str, suffix), nil
+	})
+
+	e.RegisterFunction("dedent", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("dedent() requires 1 argument")
+		}
+		return dedent(fmt.Sprintf("%v", args[0])), nil
+	})
+
+	e.RegisterFunction("trimEmptyLines", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("trimEmptyLines() requires 1 argument")
+		}
+		return trimEmptyLines(fmt.Sprintf("%v", args[0])), nil
+	})
+
+	// Hash functions
+	e.RegisterFunction("sha256", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("sha256() requires 1 argument")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		hash := sha256.Sum256([]byte(str))
+		return hex.EncodeToString(hash[:]), nil
+	})
+
+	e.RegisterFunction("sha1", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("sha1() requires 1 argument")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		hash := sha1.Sum([]byte(str))
+		return hex.EncodeToString(hash[:]), nil
+	})
+
+	// md5 is for checksums and change detection only, not for security
+	e.RegisterFunction("md5", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("md5() requires 1 argument")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		hash := md5.Sum([]byte(str))
+		return hex.EncodeToString(hash[:]), nil
+	})
+
+	e.RegisterFunction("adler32", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("adler32() requires 1 argument")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		return fmt.Sprintf("%08x", adler32.Checksum([]byte(str))), nil
+	})
+
+	// shortHash returns the first n hex characters of the sha256 hash
+	e.RegisterFunction("shortHash", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("shortHash() requires 2 arguments: length, string")
+		}
+		n, err := toInt(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("shortHash() length must be an integer: %w", err)
+		}
+		if n < 1 || n > sha256.Size*2 {
+			return nil, fmt.Errorf("shortHash() length must be between 1 and %d, got %d", sha256.Size*2, n)
+		}
+		str := fmt.Sprintf("%v", args[1])
+		hash := sha256.Sum256([]byte(str))
+		return hex.EncodeToString(hash[:])[:n], nil
+	})
+
+	// Encoding functions
+	e.RegisterFunction("base32encode", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("base32encode() requires 1 argument")
+		}
+		return base32.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v", args[0]))), nil
+	})
+
+	e.RegisterFunction("base32decode", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("base32decode() requires 1 argument")
 		}
 		str := fmt.Sprintf("%v", args[0])
-		prefix := fmt.Sprintf("%v", args[1])
-		return strings.TrimPrefix(str, prefix), nil
+		decoded, err := base32.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, fmt.Errorf("base32decode() invalid base32 '%s': %w", str, err)
+		}
+		return string(decoded), nil
 	})
 
-	e.RegisterFunction("trimSuffix", func(args ...interface{}) (interface{}, error) {
-		if len(args) != 2 {
-			return nil, fmt.Errorf("trimSuffix() requires 2 arguments")
+	e.RegisterFunction("hexencode", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("hexencode() requires 1 argument")
 		}
-		str := fmt.Sprintf("%v", args[0])
-		suffix := fmt.Sprintf("%v", args[1])
-		return strings.TrimSuffix(str, suffix), nil
+		return hex.EncodeToString([]byte(fmt.Sprintf("%v", args[0]))), nil
 	})
 
-	// Hash functions
-	e.RegisterFunction("sha256", func(args ...interface{}) (interface{}, error) {
+	e.RegisterFunction("hexdecode", func(args ...interface{}) (interface{}, error) {
 		if len(args) != 1 {
-			return nil, fmt.Errorf("sha256() requires 1 argument")
+			return nil, fmt.Errorf("hexdecode() requires 1 argument")
 		}
 		str := fmt.Sprintf("%v", args[0])
-		hash := sha256.Sum256([]byte(str))
-		return hex.EncodeToString(hash[:]), nil
+		decoded, err := hex.DecodeString(str)
+		if err != nil {
+			return nil, fmt.Errorf("hexdecode() invalid hex '%s': %w", str, err)
+		}
+		return string(decoded), nil
 	})
 
 	// Utility functions
@@ -625,6 +1489,17 @@ func (e *Evaluator) registerBuiltinFunctions() {
 		return args[0], nil
 	})
 
+	// Unlike default, keeps 0 and false and also falls back for empty lists and maps
+	e.RegisterFunction("defaultIfEmpty", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("defaultIfEmpty() requires 2 arguments")
+		}
+		if isEmpty(args[0]) {
+			return args[1], nil
+		}
+		return args[0], nil
+	})
+
 	// Inline if function (ternary operator)
 	e.RegisterFunction("if", func(args ...interface{}) (interface{}, error) {
 		if len(args) != 3 {
@@ -754,6 +1629,196 @@ func (e *Evaluator) registerBuiltinFunctions() {
 		return result, nil
 	})
 
+	// list wraps a scalar in a one-element array so fields that accept either
+	// a single value or an array can be iterated uniformly
+	e.RegisterFunction("list", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("list() requires 1 argument")
+		}
+		switch v := args[0].(type) {
+		case []interface{}:
+			return v, nil
+		case []string:
+			result := make([]interface{}, len(v))
+			for i, s := range v {
+				result[i] = s
+			}
+			return result, nil
+		case nil:
+			return []interface{}{}, nil
+		default:
+			return []interface{}{v}, nil
+		}
+	})
+
+	e.RegisterFunction("len", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("len() requires 1 argument")
+		}
+		if args[0] == nil {
+			return int64(0), nil
+		}
+		switch v := reflect.ValueOf(args[0]); v.Kind() {
+		case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+			return int64(v.Len()), nil
+		default:
+			return nil, fmt.Errorf("len() requires a string, array or map, got %T", args[0])
+		}
+	})
+
+	e.RegisterFunction("contains", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("contains() requires 2 arguments: array or string, value")
+		}
+		switch v := args[0].(type) {
+		case string:
+			return strings.Contains(v, fmt.Sprintf("%v", args[1])), nil
+		case map[string]interface{}:
+			_, ok := v[fmt.Sprintf("%v", args[1])]
+			return ok, nil
+		default:
+			found, err := contains(args[0], args[1])
+			if err != nil {
+				return nil, fmt.Errorf("contains() requires an array, string or map, got %T", args[0])
+			}
+			return found, nil
+		}
+	})
+
+	// filter keeps the map elements whose field equals value, reject drops them
+	e.RegisterFunction("filter", func(args ...interface{}) (interface{}, error) {
+		return filterByField("filter", true, args)
+	})
+
+	e.RegisterFunction("reject", func(args ...interface{}) (interface{}, error) {
+		return filterByField("reject", false, args)
+	})
+
+	// Map functions
+	e.RegisterFunction("pickPrefix", func(args ...interface{}) (interface{}, error) {
+		return filterByPrefix("pickPrefix", true, args)
+	})
+
+	e.RegisterFunction("omitPrefix", func(args ...interface{}) (interface{}, error) {
+		return filterByPrefix("omitPrefix", false, args)
+	})
+
+	// Math functions
+	e.RegisterFunction("min", func(args ...interface{}) (interface{}, error) {
+		return extremum("min", args, func(candidate, current float64) bool { return candidate < current })
+	})
+
+	e.RegisterFunction("max", func(args ...interface{}) (interface{}, error) {
+		return extremum("max", args, func(candidate, current float64) bool { return candidate > current })
+	})
+
+	e.RegisterFunction("round", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("round() requires 1 argument")
+		}
+		num, err := toFloat64(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("round() argument must be numeric, got %v", args[0])
+		}
+		return int64(math.Round(num)), nil
+	})
+
+	e.RegisterFunction("ceilDiv", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 2 {
+			return nil, fmt.Errorf("ceilDiv() requires 2 arguments: dividend, divisor")
+		}
+		a, err := Coerce(args[0], "int")
+		if err != nil {
+			return nil, fmt.Errorf("ceilDiv() arguments must be integers: %w", err)
+		}
+		b, err := Coerce(args[1], "int")
+		if err != nil {
+			return nil, fmt.Errorf("ceilDiv() arguments must be integers: %w", err)
+		}
+		return ceilDiv(a.(int64), b.(int64))
+	})
+
+	e.RegisterFunction("clamp", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 3 {
+			return nil, fmt.Errorf("clamp() requires 3 arguments: value, min, max")
+		}
+		return clamp(args[0], args[1], args[2])
+	})
+
+	// Time functions
+	e.RegisterFunction("toSeconds", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("toSeconds() requires 1 argument: duration string")
+		}
+		str := fmt.Sprintf("%v", args[0])
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			return nil, fmt.Errorf("toSeconds() invalid duration '%s': %w", str, err)
+		}
+		return int64(d / time.Second), nil
+	})
+
+	e.RegisterFunction("duration", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("duration() requires 1 argument: seconds")
+		}
+		seconds, err := toFloat64(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("duration() argument must be numeric, got %v", args[0])
+		}
+		return time.Duration(seconds * float64(time.Second)).String(), nil
+	})
+
+	// Kubernetes helper functions
+	e.RegisterFunction("toEnvList", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("toEnvList() requires 1 argument: map")
+		}
+
+		// Normalize the map argument to string keys
+		env := make(map[string]interface{})
+		switch v := args[0].(type) {
+		case map[string]interface{}:
+			env = v
+		case map[string]string:
+			for k, val := range v {
+				env[k] = val
+			}
+		case nil:
+			// Missing map yields an empty list
+		default:
+			return nil, fmt.Errorf("toEnvList() argument must be a map, got %T", args[0])
+		}
+
+		// Sort keys so the generated list is deterministic
+		keys := make([]string, 0, len(env))
+		for k := range env {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		result := make([]interface{}, 0, len(keys))
+		for _, k := range keys {
+			result = append(result, map[string]interface{}{
+				"name":  k,
+				"value": fmt.Sprintf("%v", env[k]),
+			})
+		}
+
+		return result, nil
+	})
+
+	e.RegisterFunction("k8sName", func(args ...interface{}) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("k8sName() requires 1 argument")
+		}
+		name := K8sName(fmt.Sprintf("%v", args[0]))
+		if name == "" {
+			return nil, fmt.Errorf("k8sName(%q) has no characters valid in a Kubernetes name", args[0])
+		}
+		return name, nil
+	})
+
 	// Existence checking functions
 	e.RegisterFunction("has", func(args ...interface{}) (interface{}, error) {
 		if len(args) != 1 {
@@ -780,6 +1845,137 @@ func (e *Evaluator) registerBuiltinFunctions() {
 
 }
 
+// dedent removes the leading whitespace common to all non-blank lines of s.
+// Blank lines are emptied and do not count towards the common prefix.
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
+
+	prefix, found := "", false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			prefix, found = indent, true
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		} else {
+			lines[i] = strings.TrimPrefix(line, prefix)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// trimEmptyLines removes the blank lines at the start and end of s,
+// including its trailing newline
+func trimEmptyLines(s string) string {
+	lines := strings.Split(s, "\n")
+	start, end := 0, len(lines)
+	for start < end && strings.TrimSpace(lines[start]) == "" {
+		start++
+	}
+	for end > start && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return strings.Join(lines[start:end], "\n")
+}
+
+// maxK8sNameLength is the RFC 1123 label length limit for Kubernetes names
+const maxK8sNameLength = 63
+
+// K8sName converts s to an RFC 1123 label: lowercase alphanumerics with
+// single '-' separators, no leading or trailing '-', at most 63 characters.
+// It returns "" if s has no characters valid in a label.
+func K8sName(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	name := b.String()
+	if len(name) > maxK8sNameLength {
+		name = strings.TrimRight(name[:maxK8sNameLength], "-")
+	}
+	return name
+}
+
+// filterByField returns the map elements of args[0] whose args[1] field
+// equals args[2] (keep) or does not (!keep). Non-map elements are excluded.
+func filterByField(name string, keep bool, args []interface{}) (interface{}, error) {
+	if len(args) != 3 {
+		return nil, fmt.Errorf("%s() requires 3 arguments: array, field, value", name)
+	}
+
+	var arr []interface{}
+	switch v := args[0].(type) {
+	case []interface{}:
+		arr = v
+	case nil:
+		// Missing array yields an empty list
+	default:
+		return nil, fmt.Errorf("%s() first argument must be an array, got %T", name, args[0])
+	}
+
+	field := fmt.Sprintf("%v", args[1])
+	want := fmt.Sprintf("%v", args[2])
+
+	result := make([]interface{}, 0, len(arr))
+	for _, item := range arr {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		value, found := m[field]
+		matches := found && fmt.Sprintf("%v", value) == want
+		if matches == keep {
+			result = append(result, item)
+		}
+	}
+
+	return result, nil
+}
+
+// filterByPrefix returns the entries of the args[0] map whose keys start with
+// the args[1] prefix (keep) or do not (!keep)
+func filterByPrefix(name string, keep bool, args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("%s() requires 2 arguments: map, prefix", name)
+	}
+
+	m, ok := args[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s() first argument must be a map, got %T", name, args[0])
+	}
+	prefix := fmt.Sprintf("%v", args[1])
+
+	result := make(map[string]interface{})
+	for k, v := range m {
+		if strings.HasPrefix(k, prefix) == keep {
+			result[k] = v
+		}
+	}
+
+	return result, nil
+}
+
 // compareValues compares two values numerically
 func compareValues(a, b interface{}) int {
 	// Try to convert to numbers
@@ -821,6 +2017,93 @@ func toFloat64(v interface{}) (float64, error) {
 	}
 }
 
+// arithmeticVerbs names arithmetic operators for error messages
+var arithmeticVerbs = map[string]string{
+	"+": "add",
+	"-": "subtract",
+	"*": "multiply",
+	"/": "divide",
+	"%": "take modulo",
+}
+
+// isNumeric reports whether a value is a number or a string that parses as one
+func isNumeric(v interface{}) bool {
+	num, err := toFloat64(v)
+	return err == nil && !math.IsInf(num, 0) && !math.IsNaN(num)
+}
+
+// formatOperand formats an operand for error messages, quoting strings
+func formatOperand(v interface{}) string {
+	if str, ok := v.(string); ok {
+		return strconv.Quote(str)
+	}
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Coerce converts value to the named type: "int" (int64), "float"
+// (float64), "bool" or "string". Numeric and boolean strings are parsed, and
+// a float only converts to an int when it has no fractional part.
+func Coerce(value interface{}, typeName string) (interface{}, error) {
+	if value == nil {
+		return nil, fmt.Errorf("cannot convert null to %s", typeName)
+	}
+
+	switch typeName {
+	case "int":
+		switch v := value.(type) {
+		case int:
+			return int64(v), nil
+		case int32:
+			return int64(v), nil
+		case int64:
+			return v, nil
+		case string:
+			if i, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64); err == nil {
+				return i, nil
+			}
+		}
+		num, err := toFloat64(value)
+		if err != nil || num != math.Trunc(num) || math.IsInf(num, 0) {
+			return nil, fmt.Errorf("cannot convert %s to int", formatOperand(value))
+		}
+		return int64(num), nil
+
+	case "float":
+		if str, ok := value.(string); ok {
+			value = strings.TrimSpace(str)
+		}
+		num, err := toFloat64(value)
+		if err != nil {
+			return nil, fmt.Errorf("cannot convert %s to float", formatOperand(value))
+		}
+		return num, nil
+
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+				return b, nil
+			}
+		}
+		return nil, fmt.Errorf("cannot convert %s to bool", formatOperand(value))
+
+	case "string":
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("cannot convert %T to string", value)
+		}
+		return fmt.Sprintf("%v", value), nil
+
+	default:
+		return nil, fmt.Errorf("unknown type '%s'", typeName)
+	}
+}
+
 // toInt converts a value to int
 func toInt(v interface{}) (int, error) {
 	switch val := v.(type) {
@@ -842,17 +2125,150 @@ func toInt(v interface{}) (int, error) {
 	}
 }
 
+// extremum returns the argument that wins every comparison by better,
+// keeping its original type so integers stay integers. A single list
+// argument is compared element by element.
+func extremum(name string, args []interface{}, better func(candidate, current float64) bool) (interface{}, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s() requires at least 1 argument", name)
+	}
+	if len(args) == 1 {
+		if list, ok := args[0].([]interface{}); ok {
+			if len(list) == 0 {
+				return nil, fmt.Errorf("%s() of an empty list", name)
+			}
+			args = list
+		}
+	}
+
+	var result interface{}
+	var resultNum float64
+	for i, arg := range args {
+		num, err := toFloat64(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s() arguments must be numeric, got %v", name, arg)
+		}
+		if i == 0 || better(num, resultNum) {
+			result, resultNum = arg, num
+		}
+	}
+	return result, nil
+}
+
+// ceilDiv divides a by b, rounding towards positive infinity
+func ceilDiv(a, b int64) (interface{}, error) {
+	if b == 0 {
+		return nil, fmt.Errorf("ceilDiv() division by zero")
+	}
+	if a == math.MinInt64 && b == -1 {
+		return nil, fmt.Errorf("ceilDiv() result overflows int64")
+	}
+	quotient := a / b
+	if a%b != 0 && (a < 0) == (b < 0) {
+		quotient++
+	}
+	return quotient, nil
+}
+
+// clamp limits value to the range [low, high], returning whichever argument
+// is the result in its original type so integers stay integers
+func clamp(value, low, high interface{}) (interface{}, error) {
+	nums := make([]float64, 3)
+	for i, arg := range []interface{}{value, low, high} {
+		num, err := toFloat64(arg)
+		if err != nil {
+			return nil, fmt.Errorf("clamp() arguments must be numeric, got %v", arg)
+		}
+		nums[i] = num
+	}
+
+	switch {
+	case nums[1] > nums[2]:
+		return nil, fmt.Errorf("clamp() min %v is greater than max %v", low, high)
+	case nums[0] < nums[1]:
+		return low, nil
+	case nums[0] > nums[2]:
+		return high, nil
+	default:
+		return value, nil
+	}
+}
+
+// exactInt64 returns v as an int64 if it is an integer type
+func exactInt64(v interface{}) (int64, bool) {
+	switch val := v.(type) {
+	case int:
+		return int64(val), true
+	case int32:
+		return int64(val), true
+	case int64:
+		return val, true
+	default:
+		return 0, false
+	}
+}
+
+// integerArithmetic applies operator to two integers, reporting false when
+// the result would overflow int64 or is not a whole number, so the caller
+// falls back to float64
+func integerArithmetic(left, right int64, operator string) (int64, bool) {
+	switch operator {
+	case "+":
+		result := left + right
+		if (right > 0 && result < left) || (right < 0 && result > left) {
+			return 0, false
+		}
+		return result, true
+	case "-":
+		result := left - right
+		if (right < 0 && result < left) || (right > 0 && result > left) {
+			return 0, false
+		}
+		return result, true
+	case "*":
+		if left == 0 || right == 0 {
+			return 0, true
+		}
+		result := left * right
+		if result/right != left || (left == -1 && right == math.MinInt64) || (right == -1 && left == math.MinInt64) {
+			return 0, false
+		}
+		return result, true
+	case "/":
+		if right == 0 || left%right != 0 || (left == math.MinInt64 && right == -1) {
+			return 0, false
+		}
+		return left / right, true
+	case "%":
+		if right == 0 {
+			return 0, false
+		}
+		return left % right, true
+	default:
+		return 0, false
+	}
+}
+
 // performArithmetic performs arithmetic operations
 func performArithmetic(left, right interface{}, operator string) (interface{}, error) {
+	// Integers beyond 2^53 lose precision in float64, so integer operands stay int64
+	if leftInt, ok := exactInt64(left); ok {
+		if rightInt, ok := exactInt64(right); ok {
+			if result, ok := integerArithmetic(leftInt, rightInt, operator); ok {
+				return result, nil
+			}
+		}
+	}
+
 	// Convert both operands to float64
 	leftNum, err := toFloat64(left)
 	if err != nil {
-		return nil, fmt.Errorf("left operand: %w", err)
+		return nil, fmt.Errorf("cannot %s: left operand %s is not numeric", arithmeticVerbs[operator], formatOperand(left))
 	}
 
 	rightNum, err := toFloat64(right)
 	if err != nil {
-		return nil, fmt.Errorf("right operand: %w", err)
+		return nil, fmt.Errorf("cannot %s: right operand %s is not numeric", arithmeticVerbs[operator], formatOperand(right))
 	}
 
 	var result float64
@@ -873,14 +2289,14 @@ func performArithmetic(left, right interface{}, operator string) (interface{}, e
 		if rightNum == 0 {
 			return nil, fmt.Errorf("modulo by zero")
 		}
-		// For modulo, convert to int
-		result = float64(int64(leftNum) % int64(rightNum))
+		// The remainder has the sign of the dividend, as for integers
+		result = math.Mod(leftNum, rightNum)
 	default:
 		return nil, fmt.Errorf("unknown arithmetic operator: %s", operator)
 	}
 
-	// If the result is a whole number, return as int64
-	if result == float64(int64(result)) {
+	// If the result is a whole number within range, return as int64
+	if math.Abs(result) < math.MaxInt64 && result == float64(int64(result)) {
 		return int64(result), nil
 	}
 
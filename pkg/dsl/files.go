@@ -0,0 +1,37 @@
+package dsl
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"path/filepath"
+)
+
+// SetFiles sets the directory file() reads from, normally the template
+// directory. Without one file() fails.
+func (e *Evaluator) SetFiles(fsys fs.FS) {
+	e.files = fsys
+}
+
+// evaluateFile evaluates file(path), the contents of the file at path
+// relative to the template directory. Paths leaving the directory are rejected.
+func (e *Evaluator) evaluateFile(args ...interface{}) (interface{}, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("file() requires 1 argument: path")
+	}
+	if e.files == nil {
+		return nil, fmt.Errorf("file() requires a template directory")
+	}
+
+	name := fmt.Sprintf("%v", args[0])
+	cleaned := path.Clean(filepath.ToSlash(name))
+	if !fs.ValidPath(cleaned) {
+		return nil, fmt.Errorf("file() path %s is outside the template directory", name)
+	}
+
+	data, err := fs.ReadFile(e.files, cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("file() failed to read %s: %w", name, err)
+	}
+	return string(data), nil
+}
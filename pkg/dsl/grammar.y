@@ -3,6 +3,7 @@ package dsl
 
 import (
 	"fmt"
+	"strings"
 )
 %}
 
@@ -10,23 +11,23 @@ import (
 	expr     *Expression
 	exprs    []*Expression
 	str      string
-	num      float64
 }
 
-%token <str> IDENTIFIER STRING
-%token <num> NUMBER
-%token DOT LPAREN RPAREN LBRACKET RBRACKET COMMA
+%token <str> IDENTIFIER STRING NUMBER
+%token DOT OPTDOT LPAREN RPAREN LBRACKET RBRACKET LBRACE RBRACE COMMA COLON
 %token PLUS MINUS MULTIPLY DIVIDE MODULO
 %token EQ NE LT LE GT GE
-%token AND OR NOT
+%token AND OR NOT NOTKW IN
 %token TRUE FALSE
 
-%type <expr> expression primary binary unary call array_index literal path
+%type <expr> expression primary binary unary call array_index array_literal map_literal map_entries literal path
 %type <exprs> argument_list argument_list_opt
+%type <str> map_key
 
 %left OR
 %left AND
-%left EQ NE
+%right NOTKW
+%left EQ NE IN
 %left LT LE GT GE
 %left PLUS MINUS
 %left MULTIPLY DIVIDE MODULO
@@ -167,6 +168,24 @@ binary:
 			Right:    $3,
 		}
 	}
+	| expression IN expression
+	{
+		$$ = &Expression{
+			Type:     ExprBinary,
+			Operator: "in",
+			Left:     $1,
+			Right:    $3,
+		}
+	}
+	| expression NOTKW IN expression %prec IN
+	{
+		$$ = &Expression{
+			Type:     ExprBinary,
+			Operator: "not in",
+			Left:     $1,
+			Right:    $4,
+		}
+	}
 	;
 
 unary:
@@ -178,6 +197,16 @@ unary:
 			Operand:  $2,
 		}
 	}
+	| NOTKW expression
+	{
+		// Keyword not binds looser than comparisons, so not .a in [1]
+		// negates the membership test rather than .a
+		$$ = &Expression{
+			Type:     ExprUnary,
+			Operator: "!",
+			Operand:  $2,
+		}
+	}
 	| MINUS expression %prec UMINUS
 	{
 		$$ = &Expression{
@@ -193,6 +222,8 @@ primary:
 	| path
 	| call
 	| array_index
+	| array_literal
+	| map_literal
 	| LPAREN expression RPAREN
 	{
 		$$ = $2
@@ -214,6 +245,13 @@ path:
 			Path: $1.Path + "." + $3,
 		}
 	}
+	| path OPTDOT IDENTIFIER
+	{
+		$$ = &Expression{
+			Type: ExprPath,
+			Path: $1.Path + "?." + $3,
+		}
+	}
 	| IDENTIFIER
 	{
 		$$ = &Expression{
@@ -228,6 +266,13 @@ path:
 			Path: $1 + "." + $3,
 		}
 	}
+	| IDENTIFIER OPTDOT IDENTIFIER
+	{
+		$$ = &Expression{
+			Type: ExprPath,
+			Path: $1 + "?." + $3,
+		}
+	}
 	;
 
 call:
@@ -265,6 +310,74 @@ array_index:
 	}
 	;
 
+array_literal:
+	LBRACKET argument_list_opt RBRACKET
+	{
+		$$ = &Expression{
+			Type:     ExprArrayLiteral,
+			Elements: $2,
+		}
+	}
+	| LBRACKET argument_list COMMA RBRACKET
+	{
+		$$ = &Expression{
+			Type:     ExprArrayLiteral,
+			Elements: $2,
+		}
+	}
+	;
+
+map_literal:
+	LBRACE RBRACE
+	{
+		$$ = &Expression{
+			Type: ExprMapLiteral,
+		}
+	}
+	| LBRACE map_entries RBRACE
+	{
+		$$ = $2
+	}
+	| LBRACE map_entries COMMA RBRACE
+	{
+		$$ = $2
+	}
+	;
+
+map_entries:
+	map_key COLON expression
+	{
+		$$ = &Expression{
+			Type:     ExprMapLiteral,
+			Keys:     []string{$1},
+			Elements: []*Expression{$3},
+		}
+	}
+	| map_entries COMMA map_key COLON expression
+	{
+		for _, key := range $1.Keys {
+			if key == $3 {
+				yylex.Error(fmt.Sprintf("duplicate map key: %s", $3))
+			}
+		}
+		$1.Keys = append($1.Keys, $3)
+		$1.Elements = append($1.Elements, $5)
+		$$ = $1
+	}
+	;
+
+map_key:
+	IDENTIFIER
+	{
+		$$ = $1
+	}
+	| STRING
+	{
+		// Strip the quotes the lexer keeps on string tokens
+		$$ = $1[1 : len($1)-1]
+	}
+	;
+
 literal:
 	STRING
 	{
@@ -275,9 +388,10 @@ literal:
 	}
 	| NUMBER
 	{
+		// Keep the number as written so integers stay exact and "1.0" stays a float
 		$$ = &Expression{
 			Type: ExprLiteral,
-			Path: fmt.Sprintf("%v", $1),
+			Path: $1,
 		}
 	}
 	| TRUE
@@ -335,12 +449,15 @@ func exprToString(expr *Expression) string {
 		return expr.Path
 		
 	case ExprBinary:
-		left := exprToString(expr.Left)
-		right := exprToString(expr.Right)
+		// Operators are left-associative, so only a right operand of the
+		// same precedence needs parentheses
+		precedence := operatorPrecedence(expr.Operator)
+		left := operandToString(expr.Left, precedence)
+		right := operandToString(expr.Right, precedence+1)
 		return left + " " + expr.Operator + " " + right
 		
 	case ExprUnary:
-		operand := exprToString(expr.Operand)
+		operand := operandToString(expr.Operand, unaryPrecedence)
 		return expr.Operator + operand
 		
 	case ExprFunction:
@@ -357,8 +474,75 @@ func exprToString(expr *Expression) string {
 		index := exprToString(expr.Index)
 		return expr.Path + "[" + index + "]"
 		
+	case ExprResourceRef:
+		ref := expr.ResourceRef
+		args := "\"" + ref.APIVersion + "\", \"" + ref.Kind + "\", " + exprToString(ref.Name)
+		if ref.Namespace != nil {
+			args += ", " + exprToString(ref.Namespace)
+		}
+		if ref.FieldPath == "" {
+			return "resource(" + args + ")"
+		}
+		return "resource(" + args + ")." + ref.FieldPath
+		
+	case ExprArrayLiteral:
+		elements := ""
+		for i, element := range expr.Elements {
+			if i > 0 {
+				elements += ", "
+			}
+			elements += exprToString(element)
+		}
+		return "[" + elements + "]"
+		
+	case ExprMapLiteral:
+		entries := ""
+		for i, key := range expr.Keys {
+			if i > 0 {
+				entries += ", "
+			}
+			quote := "\""
+			if strings.Contains(key, quote) {
+				quote = "'"
+			}
+			entries += quote + key + quote + ": " + exprToString(expr.Elements[i])
+		}
+		return "{" + entries + "}"
+		
 	default:
 		return fmt.Sprintf("<expr:%d>", expr.Type)
 	}
 }
 
+
+// unaryPrecedence binds tighter than any binary operator
+const unaryPrecedence = 6
+
+// operatorPrecedence returns the precedence of a binary operator, matching
+// the %left declarations above
+func operatorPrecedence(operator string) int {
+	switch operator {
+	case "||":
+		return 0
+	case "&&":
+		return 1
+	case "==", "!=", "in", "not in":
+		return 2
+	case "<", "<=", ">", ">=":
+		return 3
+	case "+", "-":
+		return 4
+	default:
+		return 5
+	}
+}
+
+// operandToString converts an operand to string, parenthesizing binary
+// expressions that bind looser than min so the result parses back with the
+// same grouping
+func operandToString(expr *Expression, min int) string {
+	if expr != nil && expr.Type == ExprBinary && operatorPrecedence(expr.Operator) < min {
+		return "(" + exprToString(expr) + ")"
+	}
+	return exprToString(expr)
+}
@@ -11,6 +11,7 @@ import (
 type Lexer struct {
 	input  string
 	pos    int
+	last   int // Previous token, used to tell a minus operator from a negative number
 	result *Expression
 	err    error
 }
@@ -25,6 +26,21 @@ func NewLexer(input string) *Lexer {
 
 // Lex returns the next token for the parser
 func (l *Lexer) Lex(lval *yySymType) int {
+	l.last = l.lex(lval)
+	return l.last
+}
+
+// endsOperand reports whether token can end an operand, in which case a
+// following '-' is the binary minus operator
+func endsOperand(token int) bool {
+	switch token {
+	case IDENTIFIER, STRING, NUMBER, TRUE, FALSE, RPAREN, RBRACKET, RBRACE:
+		return true
+	}
+	return false
+}
+
+func (l *Lexer) lex(lval *yySymType) int {
 	// Skip whitespace
 	for l.pos < len(l.input) && unicode.IsSpace(rune(l.input[l.pos])) {
 		l.pos++
@@ -41,6 +57,13 @@ func (l *Lexer) Lex(lval *yySymType) int {
 	case '.':
 		l.pos++
 		return DOT
+	case '?':
+		if l.pos+1 < len(l.input) && l.input[l.pos+1] == '.' {
+			l.pos += 2
+			return OPTDOT
+		}
+		l.Error("unexpected '?' (use '?.' for optional field access)")
+		return 0
 	case '(':
 		l.pos++
 		return LPAREN
@@ -53,6 +76,15 @@ func (l *Lexer) Lex(lval *yySymType) int {
 	case ']':
 		l.pos++
 		return RBRACKET
+	case '{':
+		l.pos++
+		return LBRACE
+	case '}':
+		l.pos++
+		return RBRACE
+	case ':':
+		l.pos++
+		return COLON
 	case ',':
 		l.pos++
 		return COMMA
@@ -61,7 +93,7 @@ func (l *Lexer) Lex(lval *yySymType) int {
 		return PLUS
 	case '-':
 		// Could be minus operator or negative number
-		if l.pos+1 < len(l.input) && unicode.IsDigit(rune(l.input[l.pos+1])) {
+		if !endsOperand(l.last) && l.pos+1 < len(l.input) && unicode.IsDigit(rune(l.input[l.pos+1])) {
 			return l.lexNumber(lval)
 		}
 		l.pos++
@@ -129,8 +161,8 @@ func (l *Lexer) Lex(lval *yySymType) int {
 		return l.lexNumber(lval)
 	}
 
-	// Identifiers and keywords
-	if unicode.IsLetter(rune(ch)) || ch == '_' {
+	// Identifiers and keywords ($ prefixes injected variables such as $values)
+	if unicode.IsLetter(rune(ch)) || ch == '_' || ch == '$' {
 		return l.lexIdentifier(lval)
 	}
 
@@ -193,18 +225,20 @@ func (l *Lexer) lexNumber(lval *yySymType) int {
 	}
 
 	numStr := l.input[start:l.pos]
-	num, err := strconv.ParseFloat(numStr, 64)
-	if err != nil {
+	if _, err := strconv.ParseFloat(numStr, 64); err != nil {
 		l.Error(fmt.Sprintf("invalid number: %s", numStr))
 		return 0
 	}
 
-	lval.num = num
+	lval.str = numStr
 	return NUMBER
 }
 
 func (l *Lexer) lexIdentifier(lval *yySymType) int {
 	start := l.pos
+	if l.input[l.pos] == '$' {
+		l.pos++
+	}
 
 	for l.pos < len(l.input) {
 		ch := l.input[l.pos]
@@ -228,7 +262,15 @@ func (l *Lexer) lexIdentifier(lval *yySymType) int {
 	case "or":
 		return OR
 	case "not":
-		return NOT
+		return NOTKW
+	case "in":
+		// "in" is only an operator after an operand or "not", so fields
+		// named "in" stay usable, as in .spec.in
+		if endsOperand(l.last) || l.last == NOTKW {
+			return IN
+		}
+		lval.str = id
+		return IDENTIFIER
 	default:
 		lval.str = id
 		return IDENTIFIER
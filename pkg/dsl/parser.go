@@ -3,6 +3,7 @@ package dsl
 import (
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -102,7 +103,8 @@ type Expression struct {
 	Operator    string
 	Left        *Expression
 	Right       *Expression
-	Elements    []*Expression      // For concatenation
+	Elements    []*Expression      // For concatenation, array literals, and map literal values
+	Keys        []string           // For map literals, parallel to Elements
 	ResourceRef *ResourceReference // For resource references
 	Operand     *Expression        // For unary operations
 }
@@ -117,6 +119,8 @@ type ExpressionVisitor interface {
 	VisitConcat(expr *Expression) (interface{}, error)
 	VisitResourceRef(expr *Expression) (interface{}, error)
 	VisitUnary(expr *Expression) (interface{}, error)
+	VisitArrayLiteral(expr *Expression) (interface{}, error)
+	VisitMapLiteral(expr *Expression) (interface{}, error)
 }
 
 // Accept allows a visitor to visit this expression
@@ -138,6 +142,10 @@ func (e *Expression) Accept(visitor ExpressionVisitor) (interface{}, error) {
 		return visitor.VisitResourceRef(e)
 	case ExprUnary:
 		return visitor.VisitUnary(e)
+	case ExprArrayLiteral:
+		return visitor.VisitArrayLiteral(e)
+	case ExprMapLiteral:
+		return visitor.VisitMapLiteral(e)
 	default:
 		return nil, fmt.Errorf("unknown expression type: %d", e.Type)
 	}
@@ -154,6 +162,7 @@ type ResourceReference struct {
 	APIVersion string
 	Kind       string
 	Name       *Expression // Name can be an expression
+	Namespace  *Expression // Optional namespace; nil defaults to the instance namespace
 	FieldPath  string
 }
 
@@ -169,6 +178,8 @@ const (
 	ExprConcat
 	ExprResourceRef
 	ExprUnary
+	ExprArrayLiteral
+	ExprMapLiteral
 )
 
 // ParseExpression parses a DSL expression
@@ -182,10 +193,31 @@ func ParseExpression(expr string) (*Expression, error) {
 		return parseResourceRef(expr)
 	}
 
+	// The grammar handles "!" and "not" itself, but a negated resource()
+	// has to be unwrapped here so the reference gets the custom parsing
+	if operand, ok := trimNegation(expr); ok && strings.HasPrefix(operand, "resource(") {
+		ref, err := parseResourceRef(operand)
+		if err != nil {
+			return nil, err
+		}
+		return &Expression{Type: ExprUnary, Operator: "!", Operand: ref}, nil
+	}
+
 	// Use yacc parser for all other expressions
 	return ParseExpressionWithYacc(expr)
 }
 
+// trimNegation strips a leading "!" or "not" operator from expr
+func trimNegation(expr string) (string, bool) {
+	if strings.HasPrefix(expr, "!") && !strings.HasPrefix(expr, "!=") {
+		return strings.TrimSpace(expr[1:]), true
+	}
+	if strings.HasPrefix(expr, "not ") {
+		return strings.TrimSpace(expr[len("not "):]), true
+	}
+	return expr, false
+}
+
 // ParseForLoop parses a for loop expression like "item in .spec.items" or "port in container.ports"
 func ParseForLoop(expr string) (varName string, iterPath string, err error) {
 	parts := strings.Split(expr, " in ")
@@ -196,33 +228,168 @@ func ParseForLoop(expr string) (varName string, iterPath string, err error) {
 	varName = strings.TrimSpace(parts[0])
 	iterPath = strings.TrimSpace(parts[1])
 
-	// Iteration path can start with '.' (root path) or be a loop variable reference
-	// Examples: ".spec.items" or "container.ports"
-	if !strings.HasPrefix(iterPath, ".") && !isIdentifier(strings.Split(iterPath, ".")[0]) {
-		return "", "", fmt.Errorf("iteration path must start with '.' or be a variable reference: %s", iterPath)
+	// Iteration path can start with '.' (root path), be a loop variable reference
+	// or call a function that returns a list
+	// Examples: ".spec.items", "container.ports" or "list(.spec.hosts)"
+	head := iterPath
+	if i := strings.IndexAny(head, ".("); i >= 0 {
+		head = head[:i]
+	}
+	if !strings.HasPrefix(iterPath, ".") && !isIdentifier(head) {
+		return "", "", fmt.Errorf("iteration path must start with '.', be a variable reference or call a function: %s", iterPath)
 	}
 
 	return varName, iterPath, nil
 }
 
+// ParseDestructure parses a loop variable of the form "{name, port}" into the
+// names of the fields it binds. It returns nil if varName is not a destructure list.
+func ParseDestructure(varName string) ([]string, error) {
+	if !strings.HasPrefix(varName, "{") {
+		return nil, nil
+	}
+	if !strings.HasSuffix(varName, "}") {
+		return nil, fmt.Errorf("invalid destructure list: %s (expected '{a, b}')", varName)
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, part := range strings.Split(varName[1:len(varName)-1], ",") {
+		name := strings.TrimSpace(part)
+		if !isIdentifier(name) {
+			return nil, fmt.Errorf("invalid field name '%s' in destructure list: %s", name, varName)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate field '%s' in destructure list: %s", name, varName)
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// ForClause is one "var in path" clause of a for loop expression
+type ForClause struct {
+	VarName  string
+	IterPath string
+}
+
 // ParseForLoopWithFilter parses a for loop expression with optional where clause
-// Supports: "item in .path" or "item in .path where item.field != value"
+// Supports: "item in .path" or "item in .path where item.field != value".
+// Use ParseForLoopWithPaging for expressions with limit and offset clauses.
 func ParseForLoopWithFilter(expr string) (varName string, iterPath string, filterExpr string, err error) {
+	varName, iterPath, filterExpr, limit, offset, err := ParseForLoopWithPaging(expr)
+	if err != nil {
+		return "", "", "", err
+	}
+	if limit != nil || offset != 0 {
+		return "", "", "", fmt.Errorf("invalid for loop expression: %s (limit and offset require ParseForLoopWithPaging)", expr)
+	}
+	return varName, iterPath, filterExpr, nil
+}
+
+// ParseForLoopWithPaging is like ParseForLoopWithFilter, but also accepts
+// limit and offset clauses after the where clause, as in
+// "item in .path where item.enabled limit 5 offset 2". A nil limit means no limit.
+func ParseForLoopWithPaging(expr string) (varName string, iterPath string, filterExpr string, limit *int, offset int, err error) {
+	clauses, filterExpr, limit, offset, err := ParseForLoopClauses(expr)
+	if err != nil {
+		return "", "", "", nil, 0, err
+	}
+	if len(clauses) != 1 {
+		return "", "", "", nil, 0, fmt.Errorf("invalid for loop expression: %s (expected a single 'var in path')", expr)
+	}
+	return clauses[0].VarName, clauses[0].IterPath, filterExpr, limit, offset, nil
+}
+
+// ParseForLoopClauses is like ParseForLoopWithPaging, but accepts several
+// comma-separated "var in path" clauses, as in "r in .spec.regions, t in .spec.tiers",
+// which are iterated as a cartesian product. The where, limit and offset
+// clauses follow the last one and apply to each combination.
+func ParseForLoopClauses(expr string) (clauses []ForClause, filterExpr string, limit *int, offset int, err error) {
+	expr, limit, offset, err = parseForLoopPaging(expr)
+	if err != nil {
+		return nil, "", nil, 0, err
+	}
+
 	// Check for "where" clause
+	loopPart := expr
 	whereIndex := strings.Index(expr, " where ")
 	if whereIndex > 0 {
 		// Split into loop part and filter part
-		loopPart := strings.TrimSpace(expr[:whereIndex])
+		loopPart = strings.TrimSpace(expr[:whereIndex])
 		filterExpr = strings.TrimSpace(expr[whereIndex+7:]) // +7 for " where "
+	}
 
-		// Parse the loop part
-		varName, iterPath, err = ParseForLoop(loopPart)
-		return varName, iterPath, filterExpr, err
+	for _, part := range splitForClauses(loopPart) {
+		varName, iterPath, err := ParseForLoop(part)
+		if err != nil {
+			return nil, "", nil, 0, err
+		}
+		clauses = append(clauses, ForClause{VarName: varName, IterPath: iterPath})
+	}
+	return clauses, filterExpr, limit, offset, nil
+}
+
+// splitForClauses splits a for loop expression at the commas that are not
+// inside brackets or quotes, such as those of a destructure list or a call
+func splitForClauses(expr string) []string {
+	var parts []string
+	start, depth := 0, 0
+	var quote byte
+	for i := 0; i < len(expr); i++ {
+		ch := expr[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '"' || ch == '\'':
+			quote = ch
+		case ch == '(' || ch == '[' || ch == '{':
+			depth++
+		case ch == ')' || ch == ']' || ch == '}':
+			depth--
+		case ch == ',' && depth == 0:
+			parts = append(parts, strings.TrimSpace(expr[start:i]))
+			start = i + 1
+		}
 	}
+	return append(parts, strings.TrimSpace(expr[start:]))
+}
+
+// forLoopPagingPattern matches a trailing "limit N" or "offset N" clause
+var forLoopPagingPattern = regexp.MustCompile(`\s+(limit|offset)\s+(\S+)\s*$`)
+
+// parseForLoopPaging strips trailing limit and offset clauses, in either order, from a for loop expression
+func parseForLoopPaging(expr string) (rest string, limit *int, offset int, err error) {
+	rest = expr
+	seen := map[string]bool{}
+	for {
+		match := forLoopPagingPattern.FindStringSubmatchIndex(rest)
+		if match == nil {
+			return rest, limit, offset, nil
+		}
+
+		clause := rest[match[2]:match[3]]
+		value := rest[match[4]:match[5]]
+		if seen[clause] {
+			return "", nil, 0, fmt.Errorf("duplicate %s clause in for loop expression: %s", clause, expr)
+		}
+		seen[clause] = true
+
+		n, convErr := strconv.Atoi(value)
+		if convErr != nil || n < 0 {
+			return "", nil, 0, fmt.Errorf("%s must be a non-negative integer, got '%s'", clause, value)
+		}
 
-	// No where clause, use regular parsing
-	varName, iterPath, err = ParseForLoop(expr)
-	return varName, iterPath, "", err
+		if clause == "limit" {
+			limit = &n
+		} else {
+			offset = n
+		}
+		rest = rest[:match[0]]
+	}
 }
 
 // parseResourceRef parses a resource reference like resource("v1", "Service", "my-app").spec.clusterIP
@@ -266,8 +433,8 @@ func parseResourceRef(expr string) (*Expression, error) {
 		return nil, fmt.Errorf("failed to parse resource reference arguments: %w", err)
 	}
 
-	if len(args) != 3 {
-		return nil, fmt.Errorf("resource() requires 3 arguments (apiVersion, kind, name), got %d", len(args))
+	if len(args) != 3 && len(args) != 4 {
+		return nil, fmt.Errorf("resource() requires 3 or 4 arguments (apiVersion, kind, name[, namespace]), got %d", len(args))
 	}
 
 	// Parse the name argument (could be an expression)
@@ -276,12 +443,22 @@ func parseResourceRef(expr string) (*Expression, error) {
 		return nil, fmt.Errorf("failed to parse resource name: %w", err)
 	}
 
+	// Parse the optional namespace argument (could be an expression)
+	var namespaceExpr *Expression
+	if len(args) == 4 {
+		namespaceExpr, err = ParseExpression(args[3])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse resource namespace: %w", err)
+		}
+	}
+
 	return &Expression{
 		Type: ExprResourceRef,
 		ResourceRef: &ResourceReference{
 			APIVersion: strings.Trim(args[0], "\""),
 			Kind:       strings.Trim(args[1], "\""),
 			Name:       nameExpr,
+			Namespace:  namespaceExpr,
 			FieldPath:  fieldPath,
 		},
 	}, nil
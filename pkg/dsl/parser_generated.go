@@ -9,43 +9,49 @@ import __yyfmt__ "fmt"
 
 import (
 	"fmt"
+	"strings"
 )
 
-//line grammar.y:9
+//line grammar.y:10
 type yySymType struct {
 	yys   int
 	expr  *Expression
 	exprs []*Expression
 	str   string
-	num   float64
 }
 
 const IDENTIFIER = 57346
 const STRING = 57347
 const NUMBER = 57348
 const DOT = 57349
-const LPAREN = 57350
-const RPAREN = 57351
-const LBRACKET = 57352
-const RBRACKET = 57353
-const COMMA = 57354
-const PLUS = 57355
-const MINUS = 57356
-const MULTIPLY = 57357
-const DIVIDE = 57358
-const MODULO = 57359
-const EQ = 57360
-const NE = 57361
-const LT = 57362
-const LE = 57363
-const GT = 57364
-const GE = 57365
-const AND = 57366
-const OR = 57367
-const NOT = 57368
-const TRUE = 57369
-const FALSE = 57370
-const UMINUS = 57371
+const OPTDOT = 57350
+const LPAREN = 57351
+const RPAREN = 57352
+const LBRACKET = 57353
+const RBRACKET = 57354
+const LBRACE = 57355
+const RBRACE = 57356
+const COMMA = 57357
+const COLON = 57358
+const PLUS = 57359
+const MINUS = 57360
+const MULTIPLY = 57361
+const DIVIDE = 57362
+const MODULO = 57363
+const EQ = 57364
+const NE = 57365
+const LT = 57366
+const LE = 57367
+const GT = 57368
+const GE = 57369
+const AND = 57370
+const OR = 57371
+const NOT = 57372
+const NOTKW = 57373
+const IN = 57374
+const TRUE = 57375
+const FALSE = 57376
+const UMINUS = 57377
 
 var yyToknames = [...]string{
 	"$end",
@@ -55,11 +61,15 @@ var yyToknames = [...]string{
 	"STRING",
 	"NUMBER",
 	"DOT",
+	"OPTDOT",
 	"LPAREN",
 	"RPAREN",
 	"LBRACKET",
 	"RBRACKET",
+	"LBRACE",
+	"RBRACE",
 	"COMMA",
+	"COLON",
 	"PLUS",
 	"MINUS",
 	"MULTIPLY",
@@ -74,6 +84,8 @@ var yyToknames = [...]string{
 	"AND",
 	"OR",
 	"NOT",
+	"NOTKW",
+	"IN",
 	"TRUE",
 	"FALSE",
 	"UMINUS",
@@ -85,7 +97,7 @@ const yyEofCode = 1
 const yyErrCode = 2
 const yyInitialStackSize = 16
 
-//line grammar.y:322
+//line grammar.y:435
 
 // Helper function to convert expression to string for Args field
 // This maintains compatibility with the existing Expression struct
@@ -102,12 +114,15 @@ func exprToString(expr *Expression) string {
 		return expr.Path
 
 	case ExprBinary:
-		left := exprToString(expr.Left)
-		right := exprToString(expr.Right)
+		// Operators are left-associative, so only a right operand of the
+		// same precedence needs parentheses
+		precedence := operatorPrecedence(expr.Operator)
+		left := operandToString(expr.Left, precedence)
+		right := operandToString(expr.Right, precedence+1)
 		return left + " " + expr.Operator + " " + right
 
 	case ExprUnary:
-		operand := exprToString(expr.Operand)
+		operand := operandToString(expr.Operand, unaryPrecedence)
 		return expr.Operator + operand
 
 	case ExprFunction:
@@ -124,11 +139,78 @@ func exprToString(expr *Expression) string {
 		index := exprToString(expr.Index)
 		return expr.Path + "[" + index + "]"
 
+	case ExprResourceRef:
+		ref := expr.ResourceRef
+		args := "\"" + ref.APIVersion + "\", \"" + ref.Kind + "\", " + exprToString(ref.Name)
+		if ref.Namespace != nil {
+			args += ", " + exprToString(ref.Namespace)
+		}
+		if ref.FieldPath == "" {
+			return "resource(" + args + ")"
+		}
+		return "resource(" + args + ")." + ref.FieldPath
+
+	case ExprArrayLiteral:
+		elements := ""
+		for i, element := range expr.Elements {
+			if i > 0 {
+				elements += ", "
+			}
+			elements += exprToString(element)
+		}
+		return "[" + elements + "]"
+
+	case ExprMapLiteral:
+		entries := ""
+		for i, key := range expr.Keys {
+			if i > 0 {
+				entries += ", "
+			}
+			quote := "\""
+			if strings.Contains(key, quote) {
+				quote = "'"
+			}
+			entries += quote + key + quote + ": " + exprToString(expr.Elements[i])
+		}
+		return "{" + entries + "}"
+
 	default:
 		return fmt.Sprintf("<expr:%d>", expr.Type)
 	}
 }
 
+// unaryPrecedence binds tighter than any binary operator
+const unaryPrecedence = 6
+
+// operatorPrecedence returns the precedence of a binary operator, matching
+// the %left declarations above
+func operatorPrecedence(operator string) int {
+	switch operator {
+	case "||":
+		return 0
+	case "&&":
+		return 1
+	case "==", "!=", "in", "not in":
+		return 2
+	case "<", "<=", ">", ">=":
+		return 3
+	case "+", "-":
+		return 4
+	default:
+		return 5
+	}
+}
+
+// operandToString converts an operand to string, parenthesizing binary
+// expressions that bind looser than min so the result parses back with the
+// same grouping
+func operandToString(expr *Expression, min int) string {
+	if expr != nil && expr.Type == ExprBinary && operatorPrecedence(expr.Operator) < min {
+		return "(" + exprToString(expr) + ")"
+	}
+	return exprToString(expr)
+}
+
 //line yacctab:1
 var yyExca = [...]int8{
 	-1, 1,
@@ -138,73 +220,94 @@ var yyExca = [...]int8{
 
 const yyPrivate = 57344
 
-const yyLast = 146
+const yyLast = 225
 
 var yyAct = [...]int8{
-	2, 63, 19, 20, 21, 22, 23, 32, 33, 26,
-	27, 28, 29, 36, 19, 20, 21, 22, 23, 64,
-	41, 42, 43, 44, 45, 46, 47, 48, 49, 50,
-	51, 52, 53, 21, 22, 23, 55, 57, 54, 37,
-	60, 61, 65, 1, 19, 20, 21, 22, 23, 24,
-	25, 26, 27, 28, 29, 30, 31, 38, 39, 34,
-	40, 58, 35, 59, 62, 66, 19, 20, 21, 22,
-	23, 24, 25, 26, 27, 28, 29, 30, 31, 56,
-	9, 8, 11, 19, 20, 21, 22, 23, 24, 25,
-	26, 27, 28, 29, 30, 31, 19, 20, 21, 22,
-	23, 24, 25, 26, 27, 28, 29, 30, 31, 18,
-	13, 14, 17, 12, 10, 4, 3, 5, 0, 7,
-	19, 20, 21, 22, 23, 24, 25, 26, 27, 28,
-	29, 6, 15, 16, 19, 20, 21, 22, 23, 24,
-	25, 26, 27, 28, 29, 30,
+	53, 2, 56, 51, 52, 73, 98, 39, 40, 41,
+	24, 25, 26, 27, 28, 87, 45, 31, 32, 33,
+	34, 26, 27, 28, 91, 59, 60, 61, 62, 63,
+	64, 65, 66, 67, 68, 69, 70, 71, 72, 24,
+	25, 26, 27, 28, 84, 76, 85, 86, 42, 43,
+	92, 82, 44, 80, 81, 24, 25, 26, 27, 28,
+	29, 30, 31, 32, 33, 34, 35, 36, 83, 38,
+	37, 57, 58, 90, 88, 1, 57, 58, 47, 48,
+	49, 95, 50, 79, 78, 94, 54, 10, 97, 96,
+	75, 74, 94, 46, 9, 89, 55, 14, 13, 99,
+	24, 25, 26, 27, 28, 29, 30, 31, 32, 33,
+	34, 35, 36, 77, 38, 37, 12, 11, 4, 3,
+	24, 25, 26, 27, 28, 29, 30, 31, 32, 33,
+	34, 35, 36, 5, 38, 37, 24, 25, 26, 27,
+	28, 29, 30, 31, 32, 33, 34, 35, 36, 0,
+	38, 37, 21, 16, 17, 20, 0, 15, 0, 22,
+	93, 23, 21, 16, 17, 20, 8, 15, 0, 22,
+	0, 23, 0, 0, 0, 0, 8, 0, 6, 7,
+	0, 18, 19, 0, 0, 0, 0, 0, 6, 7,
+	0, 18, 19, 24, 25, 26, 27, 28, 29, 30,
+	31, 32, 33, 34, 35, 0, 0, 38, 37, 24,
+	25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+	0, 0, 0, 38, 37,
 }
 
 var yyPact = [...]int16{
-	105, -1000, 83, -1000, -1000, -1000, 105, 105, -1000, 52,
-	-1000, -1000, 105, -1000, -1000, -1000, -1000, 35, 50, 105,
-	105, 105, 105, 105, 105, 105, 105, 105, 105, 105,
-	105, 105, -1000, -1000, 34, 105, 70, -1000, 33, 105,
-	105, 18, 18, -1000, -1000, -1000, -11, -11, 1, 1,
-	1, 1, 107, 121, -1000, 53, -1000, -1000, -8, 7,
-	83, 31, -1000, -1000, 105, -1000, 83,
+	158, -1000, 119, -1000, -1000, -1000, 158, 158, 158, -1000,
+	41, -1000, -1000, -1000, -1000, 158, -1000, -1000, -1000, -1000,
+	89, 71, 158, 72, 158, 158, 158, 158, 158, 158,
+	158, 158, 158, 158, 158, 158, 158, 158, -27, -1000,
+	192, -1000, 87, 86, 158, 103, -1000, 80, 79, 158,
+	158, 56, 29, 119, -1000, 32, -1, -1000, -1000, 2,
+	2, -1000, -1000, -1000, -7, -7, 22, 22, 22, 22,
+	192, 176, -7, 158, -1000, -1000, 83, -1000, -1000, -1000,
+	63, 9, 38, -1000, 148, -1000, 67, 158, -7, -1000,
+	-1000, 158, -1000, -1000, 119, -1000, -10, 119, 158, 119,
 }
 
-var yyPgo = [...]int8{
-	0, 0, 117, 116, 115, 114, 82, 81, 80, 63,
-	61, 43,
+var yyPgo = [...]uint8{
+	0, 0, 133, 119, 118, 117, 116, 98, 97, 96,
+	94, 87, 4, 3, 2, 75,
 }
 
 var yyR1 = [...]int8{
-	0, 11, 1, 1, 1, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 4, 4,
-	2, 2, 2, 2, 2, 8, 8, 8, 8, 5,
-	6, 6, 7, 7, 7, 7, 10, 10, 9, 9,
+	0, 15, 1, 1, 1, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	4, 4, 4, 2, 2, 2, 2, 2, 2, 2,
+	11, 11, 11, 11, 11, 11, 5, 6, 6, 7,
+	7, 8, 8, 8, 9, 9, 14, 14, 10, 10,
+	10, 10, 13, 13, 12, 12,
 }
 
 var yyR2 = [...]int8{
 	0, 1, 1, 1, 1, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 2, 2,
-	1, 1, 1, 1, 3, 2, 3, 1, 3, 4,
-	4, 4, 1, 1, 1, 1, 0, 1, 1, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 4,
+	2, 2, 2, 1, 1, 1, 1, 1, 1, 3,
+	2, 3, 3, 1, 3, 3, 4, 4, 4, 3,
+	4, 2, 3, 4, 3, 5, 1, 1, 1, 1,
+	1, 1, 0, 1, 1, 3,
 }
 
 var yyChk = [...]int16{
-	-1000, -11, -1, -3, -4, -2, 26, 14, -7, -8,
-	-5, -6, 8, 5, 6, 27, 28, 7, 4, 13,
-	14, 15, 16, 17, 18, 19, 20, 21, 22, 23,
-	24, 25, -1, -1, 7, 10, -1, 4, 7, 8,
-	10, -1, -1, -1, -1, -1, -1, -1, -1, -1,
-	-1, -1, -1, -1, 4, -1, 9, 4, -10, -9,
-	-1, -1, 11, 9, 12, 11, -1,
+	-1000, -15, -1, -3, -4, -2, 30, 31, 18, -10,
+	-11, -5, -6, -7, -8, 9, 5, 6, 33, 34,
+	7, 4, 11, 13, 17, 18, 19, 20, 21, 22,
+	23, 24, 25, 26, 27, 28, 29, 32, 31, -1,
+	-1, -1, 7, 8, 11, -1, 4, 7, 8, 9,
+	11, -13, -12, -1, 14, -9, -14, 4, 5, -1,
+	-1, -1, -1, -1, -1, -1, -1, -1, -1, -1,
+	-1, -1, -1, 32, 4, 4, -1, 10, 4, 4,
+	-13, -12, -1, 12, 15, 14, 15, 16, -1, 12,
+	10, 15, 12, 12, -1, 14, -14, -1, 16, -1,
 }
 
 var yyDef = [...]int8{
-	0, -2, 1, 2, 3, 4, 0, 0, 20, 21,
-	22, 23, 0, 32, 33, 34, 35, 0, 27, 0,
-	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 18, 19, 0, 0, 0, 25, 0, 36,
-	0, 5, 6, 7, 8, 9, 10, 11, 12, 13,
-	14, 15, 16, 17, 26, 0, 24, 28, 0, 37,
-	38, 0, 30, 29, 0, 31, 39,
+	0, -2, 1, 2, 3, 4, 0, 0, 0, 23,
+	24, 25, 26, 27, 28, 0, 48, 49, 50, 51,
+	0, 33, 52, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 20,
+	21, 22, 0, 0, 0, 0, 30, 0, 0, 52,
+	0, 0, 53, 54, 41, 0, 0, 46, 47, 5,
+	6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+	16, 17, 18, 0, 31, 32, 0, 29, 34, 35,
+	0, 53, 0, 39, 0, 42, 0, 0, 19, 37,
+	36, 0, 38, 40, 55, 43, 0, 44, 0, 45,
 }
 
 var yyTok1 = [...]int8{
@@ -214,7 +317,8 @@ var yyTok1 = [...]int8{
 var yyTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
 	12, 13, 14, 15, 16, 17, 18, 19, 20, 21,
-	22, 23, 24, 25, 26, 27, 28, 29,
+	22, 23, 24, 25, 26, 27, 28, 29, 30, 31,
+	32, 33, 34, 35,
 }
 
 var yyTok3 = [...]int8{
@@ -560,13 +664,13 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:40
+//line grammar.y:41
 		{
 			yylex.(*Lexer).result = yyDollar[1].expr
 		}
 	case 5:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:53
+//line grammar.y:54
 		{
 			// Check if it's string concatenation or arithmetic
 			yyVAL.expr = &Expression{
@@ -578,7 +682,7 @@ yydefault:
 		}
 	case 6:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:63
+//line grammar.y:64
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -589,7 +693,7 @@ yydefault:
 		}
 	case 7:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:72
+//line grammar.y:73
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -600,7 +704,7 @@ yydefault:
 		}
 	case 8:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:81
+//line grammar.y:82
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -611,7 +715,7 @@ yydefault:
 		}
 	case 9:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:90
+//line grammar.y:91
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -622,7 +726,7 @@ yydefault:
 		}
 	case 10:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:99
+//line grammar.y:100
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -633,7 +737,7 @@ yydefault:
 		}
 	case 11:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:108
+//line grammar.y:109
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -644,7 +748,7 @@ yydefault:
 		}
 	case 12:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:117
+//line grammar.y:118
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -655,7 +759,7 @@ yydefault:
 		}
 	case 13:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:126
+//line grammar.y:127
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -666,7 +770,7 @@ yydefault:
 		}
 	case 14:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:135
+//line grammar.y:136
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -677,7 +781,7 @@ yydefault:
 		}
 	case 15:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:144
+//line grammar.y:145
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -688,7 +792,7 @@ yydefault:
 		}
 	case 16:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:153
+//line grammar.y:154
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -699,7 +803,7 @@ yydefault:
 		}
 	case 17:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:162
+//line grammar.y:163
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprBinary,
@@ -709,8 +813,30 @@ yydefault:
 			}
 		}
 	case 18:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammar.y:172
+		{
+			yyVAL.expr = &Expression{
+				Type:     ExprBinary,
+				Operator: "in",
+				Left:     yyDollar[1].expr,
+				Right:    yyDollar[3].expr,
+			}
+		}
+	case 19:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line grammar.y:181
+		{
+			yyVAL.expr = &Expression{
+				Type:     ExprBinary,
+				Operator: "not in",
+				Left:     yyDollar[1].expr,
+				Right:    yyDollar[4].expr,
+			}
+		}
+	case 20:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammar.y:174
+//line grammar.y:193
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprUnary,
@@ -718,9 +844,21 @@ yydefault:
 				Operand:  yyDollar[2].expr,
 			}
 		}
-	case 19:
+	case 21:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammar.y:201
+		{
+			// Keyword not binds looser than comparisons, so not .a in [1]
+			// negates the membership test rather than .a
+			yyVAL.expr = &Expression{
+				Type:     ExprUnary,
+				Operator: "!",
+				Operand:  yyDollar[2].expr,
+			}
+		}
+	case 22:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammar.y:182
+//line grammar.y:211
 		{
 			yyVAL.expr = &Expression{
 				Type:     ExprUnary,
@@ -728,56 +866,73 @@ yydefault:
 				Operand:  yyDollar[2].expr,
 			}
 		}
-	case 24:
+	case 29:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:197
+//line grammar.y:228
 		{
 			yyVAL.expr = yyDollar[2].expr
 		}
-	case 25:
+	case 30:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line grammar.y:204
+//line grammar.y:235
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprPath,
 				Path: "." + yyDollar[2].str,
 			}
 		}
-	case 26:
+	case 31:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:211
+//line grammar.y:242
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprPath,
 				Path: yyDollar[1].expr.Path + "." + yyDollar[3].str,
 			}
 		}
-	case 27:
+	case 32:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammar.y:249
+		{
+			yyVAL.expr = &Expression{
+				Type: ExprPath,
+				Path: yyDollar[1].expr.Path + "?." + yyDollar[3].str,
+			}
+		}
+	case 33:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:218
+//line grammar.y:256
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprPath,
 				Path: yyDollar[1].str,
 			}
 		}
-	case 28:
+	case 34:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:225
+//line grammar.y:263
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprPath,
 				Path: yyDollar[1].str + "." + yyDollar[3].str,
 			}
 		}
-	case 29:
+	case 35:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammar.y:270
+		{
+			yyVAL.expr = &Expression{
+				Type: ExprPath,
+				Path: yyDollar[1].str + "?." + yyDollar[3].str,
+			}
+		}
+	case 36:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammar.y:235
+//line grammar.y:280
 		{
 			args := make([]string, len(yyDollar[3].exprs))
 			for i, expr := range yyDollar[3].exprs {
 				// Convert expression back to string for compatibility
-				// This is a simplification - in practice you'd evaluate or store the expression
 				args[i] = exprToString(expr)
 			}
 			yyVAL.expr = &Expression{
@@ -786,9 +941,9 @@ yydefault:
 				Args:     args,
 			}
 		}
-	case 30:
+	case 37:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammar.y:252
+//line grammar.y:296
 		{
 			yyVAL.expr = &Expression{
 				Type:  ExprArrayIndex,
@@ -796,9 +951,9 @@ yydefault:
 				Index: yyDollar[3].expr,
 			}
 		}
-	case 31:
+	case 38:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line grammar.y:260
+//line grammar.y:304
 		{
 			yyVAL.expr = &Expression{
 				Type:  ExprArrayIndex,
@@ -806,63 +961,138 @@ yydefault:
 				Index: yyDollar[3].expr,
 			}
 		}
-	case 32:
+	case 39:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammar.y:315
+		{
+			yyVAL.expr = &Expression{
+				Type:     ExprArrayLiteral,
+				Elements: yyDollar[2].exprs,
+			}
+		}
+	case 40:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line grammar.y:322
+		{
+			yyVAL.expr = &Expression{
+				Type:     ExprArrayLiteral,
+				Elements: yyDollar[2].exprs,
+			}
+		}
+	case 41:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line grammar.y:332
+		{
+			yyVAL.expr = &Expression{
+				Type: ExprMapLiteral,
+			}
+		}
+	case 42:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammar.y:338
+		{
+			yyVAL.expr = yyDollar[2].expr
+		}
+	case 43:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line grammar.y:342
+		{
+			yyVAL.expr = yyDollar[2].expr
+		}
+	case 44:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line grammar.y:349
+		{
+			yyVAL.expr = &Expression{
+				Type:     ExprMapLiteral,
+				Keys:     []string{yyDollar[1].str},
+				Elements: []*Expression{yyDollar[3].expr},
+			}
+		}
+	case 45:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line grammar.y:357
+		{
+			for _, key := range yyDollar[1].expr.Keys {
+				if key == yyDollar[3].str {
+					yylex.Error(fmt.Sprintf("duplicate map key: %s", yyDollar[3].str))
+				}
+			}
+			yyDollar[1].expr.Keys = append(yyDollar[1].expr.Keys, yyDollar[3].str)
+			yyDollar[1].expr.Elements = append(yyDollar[1].expr.Elements, yyDollar[5].expr)
+			yyVAL.expr = yyDollar[1].expr
+		}
+	case 46:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammar.y:371
+		{
+			yyVAL.str = yyDollar[1].str
+		}
+	case 47:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:271
+//line grammar.y:375
+		{
+			// Strip the quotes the lexer keeps on string tokens
+			yyVAL.str = yyDollar[1].str[1 : len(yyDollar[1].str)-1]
+		}
+	case 48:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line grammar.y:383
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprLiteral,
 				Path: yyDollar[1].str,
 			}
 		}
-	case 33:
+	case 49:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:278
+//line grammar.y:390
 		{
+			// Keep the number as written so integers stay exact and "1.0" stays a float
 			yyVAL.expr = &Expression{
 				Type: ExprLiteral,
-				Path: fmt.Sprintf("%v", yyDollar[1].num),
+				Path: yyDollar[1].str,
 			}
 		}
-	case 34:
+	case 50:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:285
+//line grammar.y:398
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprLiteral,
 				Path: "true",
 			}
 		}
-	case 35:
+	case 51:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:292
+//line grammar.y:405
 		{
 			yyVAL.expr = &Expression{
 				Type: ExprLiteral,
 				Path: "false",
 			}
 		}
-	case 36:
+	case 52:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line grammar.y:302
+//line grammar.y:415
 		{
 			yyVAL.exprs = []*Expression{}
 		}
-	case 37:
+	case 53:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:306
+//line grammar.y:419
 		{
 			yyVAL.exprs = yyDollar[1].exprs
 		}
-	case 38:
+	case 54:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line grammar.y:313
+//line grammar.y:426
 		{
 			yyVAL.exprs = []*Expression{yyDollar[1].expr}
 		}
-	case 39:
+	case 55:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line grammar.y:317
+//line grammar.y:430
 		{
 			yyVAL.exprs = append(yyDollar[1].exprs, yyDollar[3].expr)
 		}
@@ -0,0 +1,131 @@
+package dsl
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// CachingResolver wraps a ResourceResolver, such as one backed by a cluster,
+// and remembers each lookup for a TTL so repeated references to the same
+// resource resolve once. Lookups of missing resources are cached too; other
+// errors are not, so transient failures are retried. At most maxEntries
+// lookups are kept, evicting the least recently used. A CachingResolver is
+// safe for concurrent use, and concurrent lookups of the same resource share
+// a single call to the wrapped resolver.
+type CachingResolver struct {
+	resolver   ResourceResolver
+	ttl        time.Duration
+	maxEntries int
+	clock      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element // Values are *cacheEntry
+	recent  *list.List               // Most recently used first
+}
+
+// cacheEntry is a cached lookup. done is closed once resource and err are set.
+type cacheEntry struct {
+	key      string
+	resource map[string]interface{}
+	err      error
+	expires  time.Time
+	done     chan struct{}
+}
+
+// NewCachingResolver creates a cache in front of resolver. A ttl of 0 keeps
+// lookups until they are evicted, and maxEntries of 0 does not bound the cache.
+func NewCachingResolver(resolver ResourceResolver, ttl time.Duration, maxEntries int) *CachingResolver {
+	return &CachingResolver{
+		resolver:   resolver,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		recent:     list.New(),
+	}
+}
+
+// SetClock makes the cache read the current time from clock when checking
+// expiry, for example in tests. A nil clock restores time.Now.
+func (c *CachingResolver) SetClock(clock func() time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock = clock
+}
+
+// Len returns the number of cached lookups, including expired ones not yet evicted
+func (c *CachingResolver) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.recent.Len()
+}
+
+// Resolve implements ResourceResolver. The returned resource is shared by
+// every caller and must not be modified.
+func (c *CachingResolver) Resolve(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	key := resourceKey(apiVersion, kind, namespace, name)
+
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		select {
+		case <-entry.done:
+			if c.ttl <= 0 || c.now().Before(entry.expires) {
+				c.recent.MoveToFront(element)
+				c.mu.Unlock()
+				return entry.resource, entry.err
+			}
+			c.remove(element)
+		default:
+			// Another goroutine is resolving the same resource
+			c.mu.Unlock()
+			<-entry.done
+			return entry.resource, entry.err
+		}
+	}
+
+	entry := &cacheEntry{key: key, done: make(chan struct{})}
+	element := c.recent.PushFront(entry)
+	c.entries[key] = element
+	c.evict()
+	c.mu.Unlock()
+
+	resource, err := c.resolver.Resolve(apiVersion, kind, namespace, name)
+
+	c.mu.Lock()
+	entry.resource, entry.err = resource, err
+	entry.expires = c.now().Add(c.ttl)
+	close(entry.done)
+	if err != nil && !errors.Is(err, ErrResourceNotFound) {
+		// Only remove the entry if it has not already been evicted or replaced
+		if c.entries[key] == element {
+			c.remove(element)
+		}
+	}
+	c.mu.Unlock()
+
+	return resource, err
+}
+
+// evict removes the least recently used entries while the cache is over its
+// bound. It must be called with c.mu held.
+func (c *CachingResolver) evict() {
+	for c.maxEntries > 0 && c.recent.Len() > c.maxEntries {
+		c.remove(c.recent.Back())
+	}
+}
+
+// remove drops an entry from the cache. It must be called with c.mu held.
+func (c *CachingResolver) remove(element *list.Element) {
+	c.recent.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).key)
+}
+
+// now returns the current time from the cache's clock
+func (c *CachingResolver) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
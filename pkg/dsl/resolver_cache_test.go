@@ -0,0 +1,165 @@
+package dsl
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingResolver serves every resource except "missing" and counts lookups
+// per key; it is safe for concurrent use
+type countingResolver struct {
+	mu      sync.Mutex
+	lookups map[string]int
+	err     error
+	delay   time.Duration
+}
+
+func (r *countingResolver) Resolve(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	key := resourceKey(apiVersion, kind, namespace, name)
+	r.mu.Lock()
+	if r.lookups == nil {
+		r.lookups = map[string]int{}
+	}
+	r.lookups[key]++
+	err := r.err
+	r.mu.Unlock()
+
+	time.Sleep(r.delay)
+	if err != nil {
+		return nil, err
+	}
+	if name == "missing" {
+		return nil, fmt.Errorf("%w: %s", ErrResourceNotFound, key)
+	}
+	return map[string]interface{}{"metadata": map[string]interface{}{"name": name}}, nil
+}
+
+func (r *countingResolver) count(key string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lookups[key]
+}
+
+func TestCachingResolver(t *testing.T) {
+	underlying := &countingResolver{}
+	cache := NewCachingResolver(underlying, time.Minute, 0)
+
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	cache.SetClock(func() time.Time { return now })
+
+	for i := 0; i < 3; i++ {
+		resource, err := cache.Resolve("v1", "Service", "default", "web")
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		if resource["metadata"].(map[string]interface{})["name"] != "web" {
+			t.Errorf("Resolve() = %v, want the web Service", resource)
+		}
+	}
+	if got := underlying.count("v1/Service/default/web"); got != 1 {
+		t.Errorf("Expected repeated lookups to hit the cache, underlying resolver called %d times", got)
+	}
+
+	// Missing resources are cached
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Resolve("v1", "Service", "default", "missing"); !errors.Is(err, ErrResourceNotFound) {
+			t.Errorf("Expected ErrResourceNotFound, got %v", err)
+		}
+	}
+	if got := underlying.count("v1/Service/default/missing"); got != 1 {
+		t.Errorf("Expected missing resource to be cached, underlying resolver called %d times", got)
+	}
+
+	// Lookups expire after the TTL
+	now = now.Add(59 * time.Second)
+	if _, err := cache.Resolve("v1", "Service", "default", "web"); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got := underlying.count("v1/Service/default/web"); got != 1 {
+		t.Errorf("Expected lookup within the TTL to hit the cache, underlying resolver called %d times", got)
+	}
+	now = now.Add(time.Second)
+	if _, err := cache.Resolve("v1", "Service", "default", "web"); err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if got := underlying.count("v1/Service/default/web"); got != 2 {
+		t.Errorf("Expected expired lookup to be resolved again, underlying resolver called %d times", got)
+	}
+
+	// Other errors are not cached
+	underlying.err = errors.New("cluster unreachable")
+	if _, err := cache.Resolve("v1", "ConfigMap", "default", "settings"); !errors.Is(err, underlying.err) {
+		t.Errorf("Expected resolver error, got %v", err)
+	}
+	underlying.err = nil
+	if _, err := cache.Resolve("v1", "ConfigMap", "default", "settings"); err != nil {
+		t.Errorf("Expected failed lookup to be retried, got %v", err)
+	}
+	if got := underlying.count("v1/ConfigMap/default/settings"); got != 2 {
+		t.Errorf("Expected failed lookup not to be cached, underlying resolver called %d times", got)
+	}
+}
+
+func TestCachingResolverMaxEntries(t *testing.T) {
+	underlying := &countingResolver{}
+	cache := NewCachingResolver(underlying, 0, 2)
+
+	resolve := func(name string) {
+		t.Helper()
+		if _, err := cache.Resolve("v1", "Service", "default", name); err != nil {
+			t.Fatalf("Resolve(%s) error = %v", name, err)
+		}
+	}
+
+	resolve("a")
+	resolve("b")
+	resolve("a") // a is now the most recently used
+	resolve("c") // evicts b
+
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 cached lookups, got %d", cache.Len())
+	}
+
+	resolve("a")
+	resolve("b")
+	if got := underlying.count("v1/Service/default/a"); got != 1 {
+		t.Errorf("Expected recently used lookup to stay cached, underlying resolver called %d times", got)
+	}
+	if got := underlying.count("v1/Service/default/b"); got != 2 {
+		t.Errorf("Expected least recently used lookup to be evicted, underlying resolver called %d times", got)
+	}
+}
+
+func TestCachingResolverConcurrent(t *testing.T) {
+	underlying := &countingResolver{delay: 10 * time.Millisecond}
+	cache := NewCachingResolver(underlying, time.Minute, 0)
+
+	var wg sync.WaitGroup
+	var failures int32
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("svc-%d", i%5)
+			resource, err := cache.Resolve("v1", "Service", "default", name)
+			if err != nil || resource["metadata"].(map[string]interface{})["name"] != name {
+				atomic.AddInt32(&failures, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if failures > 0 {
+		t.Errorf("Expected every concurrent lookup to succeed, %d failed", failures)
+	}
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("v1/Service/default/svc-%d", i)
+		if got := underlying.count(key); got != 1 {
+			t.Errorf("Expected concurrent lookups of %s to share one call, got %d", key, got)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package dsl
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 )
 
@@ -244,3 +247,366 @@ func TestResourceRefInString(t *testing.T) {
 		})
 	}
 }
+
+func TestResourceRefNamespaces(t *testing.T) {
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "my-app",
+			"namespace": "staging",
+		},
+	}
+
+	evaluator := NewEvaluator(instance)
+
+	for namespace, clusterIP := range map[string]string{"staging": "10.0.0.1", "prod": "10.0.0.2"} {
+		service := map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Service",
+			"metadata": map[string]interface{}{
+				"name":      "my-app",
+				"namespace": namespace,
+			},
+			"spec": map[string]interface{}{
+				"clusterIP": clusterIP,
+			},
+		}
+		evaluator.RegisterNamespacedResource("v1", "Service", namespace, "my-app", service)
+	}
+
+	clusterRole := map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRole",
+		"metadata": map[string]interface{}{
+			"name": "reader",
+		},
+	}
+	evaluator.RegisterResource("rbac.authorization.k8s.io/v1", "ClusterRole", "reader", clusterRole)
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "defaults to instance namespace",
+			expr:     `resource("v1", "Service", "my-app").spec.clusterIP`,
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "explicit namespace",
+			expr:     `resource("v1", "Service", "my-app", "prod").spec.clusterIP`,
+			expected: "10.0.0.2",
+		},
+		{
+			name:     "namespace expression",
+			expr:     `resource("v1", "Service", "my-app", .metadata.namespace).spec.clusterIP`,
+			expected: "10.0.0.1",
+		},
+		{
+			name:     "cluster-scoped resource",
+			expr:     `resource("rbac.authorization.k8s.io/v1", "ClusterRole", "reader").metadata.name`,
+			expected: "reader",
+		},
+		{
+			name:    "unknown namespace",
+			expr:    `resource("v1", "Service", "my-app", "dev").spec.clusterIP`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := evaluator.Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestResourceRefQuotedKeys(t *testing.T) {
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name": "my-app",
+			"annotations": map[string]interface{}{
+				"my.domain/key": "from-instance",
+			},
+		},
+	}
+
+	evaluator := NewEvaluator(instance)
+
+	service := map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Service",
+		"metadata": map[string]interface{}{
+			"name": "my-app",
+			"annotations": map[string]interface{}{
+				"my.domain/key":                     "annotated",
+				"service.beta.kubernetes.io/aws-lb": "nlb",
+			},
+			"labels": map[string]interface{}{
+				"app.kubernetes.io/name": "my-app",
+			},
+		},
+		"spec": map[string]interface{}{
+			"ports": []interface{}{
+				map[string]interface{}{"port": int64(80)},
+			},
+		},
+	}
+	evaluator.RegisterResource("v1", "Service", "my-app", service)
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		wantErr  bool
+	}{
+		{
+			name:     "double-quoted dotted annotation key",
+			expr:     `resource("v1", "Service", "my-app").metadata.annotations["my.domain/key"]`,
+			expected: "annotated",
+		},
+		{
+			name:     "single-quoted dotted label key",
+			expr:     `resource("v1", "Service", "my-app").metadata.labels['app.kubernetes.io/name']`,
+			expected: "my-app",
+		},
+		{
+			name:     "array index still works",
+			expr:     `resource("v1", "Service", "my-app").spec.ports[0].port`,
+			expected: int64(80),
+		},
+		{
+			name:     "instance path with dotted key",
+			expr:     `.metadata.annotations["my.domain/key"]`,
+			expected: "from-instance",
+		},
+		{
+			name:    "missing dotted key",
+			expr:    `resource("v1", "Service", "my-app").metadata.annotations["other.domain/key"]`,
+			wantErr: true,
+		},
+		{
+			name:    "unterminated quoted key",
+			expr:    `resource("v1", "Service", "my-app").metadata.annotations["my.domain/key]`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := evaluator.Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSplitFieldPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected []pathSegment
+		wantErr  bool
+	}{
+		{
+			path:     "spec.clusterIP",
+			expected: []pathSegment{{key: "spec"}, {key: "clusterIP"}},
+		},
+		{
+			path:     "spec.ports[1].port",
+			expected: []pathSegment{{key: "spec"}, {key: "ports"}, {index: 1, isIndex: true}, {key: "port"}},
+		},
+		{
+			path:     `metadata.annotations["my.domain/key"]`,
+			expected: []pathSegment{{key: "metadata"}, {key: "annotations"}, {key: "my.domain/key"}},
+		},
+		{
+			path:     `data['config.yaml'].size`,
+			expected: []pathSegment{{key: "data"}, {key: "config.yaml"}, {key: "size"}},
+		},
+		{
+			path:     `items["0"]`,
+			expected: []pathSegment{{key: "items"}, {key: "0"}},
+		},
+		{path: "spec.ports[x]", wantErr: true},
+		{path: "spec.ports[0", wantErr: true},
+		{path: `metadata.annotations["key`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			segments, err := splitFieldPath(tt.path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("splitFieldPath() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(segments, tt.expected) {
+				t.Errorf("splitFieldPath() = %+v, want %+v", segments, tt.expected)
+			}
+		})
+	}
+}
+
+func TestEvaluatorClone(t *testing.T) {
+	original := NewEvaluator(map[string]interface{}{"spec": map[string]interface{}{"name": "app"}})
+	original.RegisterResource("v1", "Service", "shared", map[string]interface{}{"kind": "Service"})
+
+	clone := original.Clone()
+	clone.RegisterResource("v1", "Service", "clone-only", map[string]interface{}{"kind": "Service"})
+	clone.RegisterFunction("shout", func(args ...interface{}) (interface{}, error) {
+		return "!", nil
+	})
+
+	if len(original.GetResources()) != 1 {
+		t.Errorf("Expected original to keep 1 resource, got %d", len(original.GetResources()))
+	}
+	if len(clone.GetResources()) != 2 {
+		t.Errorf("Expected clone to have 2 resources, got %d", len(clone.GetResources()))
+	}
+	if _, ok := original.functions["shout"]; ok {
+		t.Error("Expected function registered on clone not to leak into original")
+	}
+
+	expr, err := ParseExpression("upper(.spec.name)")
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	if result, err := clone.Evaluate(expr); err != nil || result != "APP" {
+		t.Errorf("Expected clone to evaluate against shared data, got %v (err %v)", result, err)
+	}
+}
+
+// stubResolver serves resources from a fixed set and records every lookup
+type stubResolver struct {
+	resources map[string]map[string]interface{}
+	lookups   []string
+	err       error
+}
+
+func (s *stubResolver) Resolve(apiVersion, kind, namespace, name string) (map[string]interface{}, error) {
+	key := resourceKey(apiVersion, kind, namespace, name)
+	s.lookups = append(s.lookups, key)
+	if s.err != nil {
+		return nil, s.err
+	}
+	if resource, ok := s.resources[key]; ok {
+		return resource, nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrResourceNotFound, key)
+}
+
+func TestResourceRefCustomResolver(t *testing.T) {
+	instance := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"name":      "my-app",
+			"namespace": "staging",
+		},
+	}
+
+	resolver := &stubResolver{
+		resources: map[string]map[string]interface{}{
+			"v1/Service/staging/my-app": {
+				"spec": map[string]interface{}{"clusterIP": "10.0.0.1"},
+			},
+			"v1/Namespace//staging": {
+				"metadata": map[string]interface{}{"name": "staging"},
+			},
+		},
+	}
+
+	evaluator := NewEvaluator(instance)
+	// Registered resources are ignored once a resolver is set
+	evaluator.RegisterNamespacedResource("v1", "Service", "staging", "my-app", map[string]interface{}{
+		"spec": map[string]interface{}{"clusterIP": "registry"},
+	})
+	evaluator.SetResourceResolver(resolver)
+
+	tests := []struct {
+		name     string
+		expr     string
+		expected interface{}
+		lookups  []string
+		wantErr  bool
+	}{
+		{
+			name:     "resolved in instance namespace",
+			expr:     `resource("v1", "Service", "my-app").spec.clusterIP`,
+			expected: "10.0.0.1",
+			lookups:  []string{"v1/Service/staging/my-app"},
+		},
+		{
+			name:     "falls back to cluster scope",
+			expr:     `resource("v1", "Namespace", "staging").metadata.name`,
+			expected: "staging",
+			lookups:  []string{"v1/Namespace/staging/staging", "v1/Namespace//staging"},
+		},
+		{
+			name:    "explicit namespace does not fall back",
+			expr:    `resource("v1", "Namespace", "staging", "prod").metadata.name`,
+			lookups: []string{"v1/Namespace/prod/staging"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolver.lookups = nil
+
+			expr, err := ParseExpression(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpression() error = %v", err)
+			}
+
+			result, err := evaluator.Evaluate(expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Evaluate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result != tt.expected {
+				t.Errorf("Evaluate() = %v, want %v", result, tt.expected)
+			}
+			if !reflect.DeepEqual(resolver.lookups, tt.lookups) {
+				t.Errorf("Expected lookups %v, got %v", tt.lookups, resolver.lookups)
+			}
+		})
+	}
+
+	// Resolver failures other than not found are returned as is
+	resolver.err = errors.New("cluster unreachable")
+	resolver.lookups = nil
+	expr, err := ParseExpression(`resource("v1", "Service", "my-app").spec.clusterIP`)
+	if err != nil {
+		t.Fatalf("ParseExpression() error = %v", err)
+	}
+	if _, err := evaluator.Evaluate(expr); !errors.Is(err, resolver.err) {
+		t.Errorf("Expected resolver error, got %v", err)
+	}
+	if len(resolver.lookups) != 1 {
+		t.Errorf("Expected no fallback lookup after a resolver failure, got %v", resolver.lookups)
+	}
+
+	// Clearing the resolver restores the registry
+	evaluator.SetResourceResolver(nil)
+	result, err := evaluator.Evaluate(expr)
+	if err != nil || result != "registry" {
+		t.Errorf("Expected registry resource after SetResourceResolver(nil), got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,70 @@
+package dsl
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SecretResolver looks up the secret values read with secret(). Secret
+// returns an error wrapping ErrSecretNotFound when the secret or key does
+// not exist.
+type SecretResolver interface {
+	Secret(name, key string) (string, error)
+}
+
+// ErrSecretNotFound is returned by a SecretResolver for a missing secret or key
+var ErrSecretNotFound = errors.New("secret not found")
+
+// SecretMap resolves secrets from a map of secret name to key to value, such
+// as one read from a local secrets file
+type SecretMap map[string]map[string]string
+
+// Secret implements SecretResolver
+func (m SecretMap) Secret(name, key string) (string, error) {
+	secret, ok := m[name]
+	if !ok {
+		names := make([]string, 0, len(m))
+		for n := range m {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return "", fmt.Errorf("%w: no secret named '%s'\nAvailable secrets: %v", ErrSecretNotFound, name, names)
+	}
+
+	value, ok := secret[key]
+	if !ok {
+		keys := make([]string, 0, len(secret))
+		for k := range secret {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return "", fmt.Errorf("%w: secret '%s' has no key '%s'\nAvailable keys: %v", ErrSecretNotFound, name, key, keys)
+	}
+	return value, nil
+}
+
+// SetSecretResolver makes secret() read values from resolver. Without a
+// resolver secret() fails.
+func (e *Evaluator) SetSecretResolver(resolver SecretResolver) {
+	e.secrets = resolver
+}
+
+// evaluateSecret evaluates secret(name, key), the base64-encoded value of key
+// in the named secret, ready for a Secret's data
+func (e *Evaluator) evaluateSecret(args ...interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("secret() requires 2 arguments: name, key")
+	}
+	if e.secrets == nil {
+		return nil, fmt.Errorf("secret() requires a secrets file")
+	}
+
+	name, key := fmt.Sprintf("%v", args[0]), fmt.Sprintf("%v", args[1])
+	value, err := e.secrets.Secret(name, key)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.EncodeToString([]byte(value)), nil
+}
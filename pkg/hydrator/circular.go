@@ -57,6 +57,7 @@ func (h *Hydrator) findResourceReferences(value interface{}) []string {
 // extractResourceRefsFromString extracts resource references from a string
 func extractResourceRefsFromString(s string) []string {
 	refs := []string{}
+	s = stripEscapedSubstitutions(s)
 
 	// Find all resource() calls
 	for {
@@ -100,6 +101,39 @@ func extractResourceRefsFromString(s string) []string {
 	return refs
 }
 
+// stripEscapedSubstitutions removes every escaped "$$(...)" and "$$if(...)"
+// from s, since the reference pass emits those verbatim instead of resolving them
+func stripEscapedSubstitutions(s string) string {
+	var result strings.Builder
+	for {
+		start := strings.Index(s, "$$(")
+		if ifStart := strings.Index(s, "$$if("); ifStart != -1 && (start == -1 || ifStart < start) {
+			start = ifStart
+		}
+		if start == -1 {
+			result.WriteString(s)
+			return result.String()
+		}
+		result.WriteString(s[:start])
+
+		// Skip up to the matching closing parenthesis
+		depth := 0
+		end := len(s) - 1
+		for i := start + 2; i < len(s); i++ {
+			if s[i] == '(' {
+				depth++
+			} else if s[i] == ')' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		s = s[end+1:]
+	}
+}
+
 // parseResourceKey extracts the resource key from a resource() call
 func parseResourceKey(refStr string) string {
 	// Extract arguments from resource("apiVersion", "kind", "name")
@@ -160,6 +160,16 @@ func TestExtractResourceRefsFromString(t *testing.T) {
 			input:    `$(resource("v1", "Secret", .metadata.name + "-secret").metadata.name)`,
 			expected: []string{"v1/Secret/*"}, // Can't determine name statically
 		},
+		{
+			name:     "escaped reference",
+			input:    `$$(resource("v1", "Service", "raw").spec.clusterIP) $(resource("v1", "Service", "api").spec.clusterIP)`,
+			expected: []string{"v1/Service/api"},
+		},
+		{
+			name:     "escaped inline if",
+			input:    `$$if(.spec.ha, resource("v1", "Service", "raw").spec.clusterIP, "") $(resource("v1", "Service", "api").spec.clusterIP)`,
+			expected: []string{"v1/Service/api"},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,288 @@
+package hydrator
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FieldComments holds the comments written on a template field and on the
+// fields below it
+type FieldComments struct {
+	Head   string                    // Comment lines above the field
+	Line   string                    // Comment at the end of the field's line
+	Fields map[string]*FieldComments // Fields of a map value; the items of a list share the "-" entry
+}
+
+// listItemKey is the entry of FieldComments.Fields holding the comments of list items
+const listItemKey = "-"
+
+// TemplateComments holds the comments of a template's resources by kind, and
+// by kind and name for resources whose name is written literally. A resource
+// is matched by its kind and name first, then by its kind, so when a template
+// has several resources of one kind with expression names the first comment
+// written on a field wins.
+type TemplateComments map[string]*FieldComments
+
+// parseTemplateComments collects the comments on the resources of template
+// YAML, which may span several documents
+func parseTemplateComments(data []byte) (TemplateComments, error) {
+	comments := TemplateComments{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document yaml.Node
+		if err := decoder.Decode(&document); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse template comments: %w", err)
+		}
+		if len(document.Content) == 0 || document.Content[0].Kind != yaml.MappingNode {
+			continue
+		}
+
+		root := document.Content[0]
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if root.Content[i].Value == "resources" {
+				comments.collect(root.Content[i+1])
+			}
+		}
+	}
+
+	return comments, nil
+}
+
+// collect finds the resources in node, looking through control flow, and
+// records their comments under their kind and name. Resources whose kind is
+// an expression cannot be matched and are skipped.
+func (c TemplateComments) collect(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			c.collect(item)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "kind" && value.Kind == yaml.ScalarNode {
+				if !strings.HasPrefix(value.Value, "@") {
+					resource := &FieldComments{Head: node.HeadComment}
+					resource.collectFields(node)
+					c.Merge(TemplateComments{value.Value: resource})
+					if name, ok := literalName(node); ok {
+						c.Merge(TemplateComments{value.Value + "/" + name: resource})
+					}
+				}
+				return
+			}
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			c.collect(node.Content[i])
+		}
+	}
+}
+
+// literalName returns the metadata.name of a resource node unless it is
+// missing or an expression
+func literalName(node *yaml.Node) (string, bool) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "metadata" || node.Content[i+1].Kind != yaml.MappingNode {
+			continue
+		}
+		metadata := node.Content[i+1]
+		for j := 0; j+1 < len(metadata.Content); j += 2 {
+			name := metadata.Content[j+1]
+			if metadata.Content[j].Value == "name" && name.Kind == yaml.ScalarNode &&
+				!strings.HasPrefix(name.Value, "@") && !strings.Contains(name.Value, "$(") {
+				return name.Value, true
+			}
+		}
+	}
+	return "", false
+}
+
+// collectFields records the comments on the fields of a map node. Control
+// flow keys add their fields to the enclosing map, and fields with
+// expression keys are skipped.
+func (f *FieldComments) collectFields(node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		if isControlFlowKey(key.Value) {
+			for _, body := range controlFlowBodies(value) {
+				f.collectFields(body)
+			}
+			continue
+		}
+		if strings.HasPrefix(key.Value, "@") {
+			continue
+		}
+
+		field := &FieldComments{Head: key.HeadComment, Line: key.LineComment}
+		if field.Line == "" && value.Kind == yaml.ScalarNode {
+			field.Line = value.LineComment
+		}
+		field.collectValue(value)
+		f.addField(key.Value, field)
+	}
+}
+
+// collectValue records the comments inside a field's value
+func (f *FieldComments) collectValue(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		f.collectFields(node)
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if item.Kind == yaml.MappingNode && len(item.Content) == 2 && isControlFlowKey(item.Content[0].Value) {
+				// A loop or conditional inside a list generates the list's items
+				for _, body := range controlFlowBodies(item.Content[1]) {
+					f.collectValue(&yaml.Node{Kind: yaml.SequenceNode, Content: []*yaml.Node{body}})
+				}
+				continue
+			}
+			entry := &FieldComments{Head: item.HeadComment, Line: item.LineComment}
+			entry.collectValue(item)
+			f.addField(listItemKey, entry)
+		}
+	}
+}
+
+// isControlFlowKey reports whether a map key is a @for, @if, @else, @when,
+// @switch, @case or @default directive
+func isControlFlowKey(key string) bool {
+	for _, prefix := range []string{"@for(", "@if(", "@else", "@when(", "@switch(", "@case(", "@default"} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// controlFlowBodies returns the maps generated by the body of a control flow key
+func controlFlowBodies(node *yaml.Node) []*yaml.Node {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return []*yaml.Node{node}
+	case yaml.SequenceNode:
+		var bodies []*yaml.Node
+		for _, item := range node.Content {
+			bodies = append(bodies, controlFlowBodies(item)...)
+		}
+		return bodies
+	}
+	return nil
+}
+
+// addField merges field into the comments of the field named key
+func (f *FieldComments) addField(key string, field *FieldComments) {
+	if f.Fields == nil {
+		f.Fields = make(map[string]*FieldComments)
+	}
+	if existing, ok := f.Fields[key]; ok {
+		existing.merge(field)
+		return
+	}
+	f.Fields[key] = field
+}
+
+// merge adds the comments of other that f does not have
+func (f *FieldComments) merge(other *FieldComments) {
+	if f.Head == "" {
+		f.Head = other.Head
+	}
+	if f.Line == "" {
+		f.Line = other.Line
+	}
+	for key, field := range other.Fields {
+		f.addField(key, field.clone())
+	}
+}
+
+// clone returns a deep copy of f
+func (f *FieldComments) clone() *FieldComments {
+	copied := &FieldComments{Head: f.Head, Line: f.Line}
+	for key, field := range f.Fields {
+		copied.addField(key, field.clone())
+	}
+	return copied
+}
+
+// Merge adds the comments of other that c does not have, so comments already
+// in c take precedence
+func (c TemplateComments) Merge(other TemplateComments) {
+	for kind, resource := range other {
+		if existing, ok := c[kind]; ok {
+			existing.merge(resource)
+		} else {
+			c[kind] = resource.clone()
+		}
+	}
+}
+
+// Node encodes resource as a YAML node carrying the comments recorded for
+// its kind and name, or for its kind
+func (c TemplateComments) Node(resource map[string]interface{}) (*yaml.Node, error) {
+	node := &yaml.Node{}
+	if err := node.Encode(resource); err != nil {
+		return nil, err
+	}
+
+	kind, _ := resource["kind"].(string)
+	comments, ok := c[kind]
+	if metadata, isMap := resource["metadata"].(map[string]interface{}); isMap {
+		if name, isString := metadata["name"].(string); isString {
+			if named, found := c[kind+"/"+name]; found {
+				comments, ok = named, true
+			}
+		}
+	}
+	if ok && node.Kind == yaml.MappingNode {
+		node.HeadComment = comments.Head
+		comments.applyFields(node)
+	}
+	return node, nil
+}
+
+// applyFields sets the recorded comments on the fields of a map node
+func (f *FieldComments) applyFields(node *yaml.Node) {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		field, ok := f.Fields[key.Value]
+		if !ok {
+			continue
+		}
+
+		key.HeadComment = field.Head
+		if value.Kind == yaml.ScalarNode {
+			value.LineComment = field.Line
+		} else {
+			key.LineComment = field.Line
+		}
+		field.applyValue(value)
+	}
+}
+
+// applyValue sets the recorded comments inside a field's value
+func (f *FieldComments) applyValue(node *yaml.Node) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		f.applyFields(node)
+	case yaml.SequenceNode:
+		item, ok := f.Fields[listItemKey]
+		if !ok {
+			return
+		}
+		for _, element := range node.Content {
+			element.HeadComment = item.Head
+			if element.Kind == yaml.ScalarNode {
+				element.LineComment = item.Line
+			}
+			item.applyValue(element)
+		}
+	}
+}
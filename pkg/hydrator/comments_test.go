@@ -0,0 +1,168 @@
+package hydrator
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const commentedTemplate = `resources:
+  # The application Deployment
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      # Scaled by the HorizontalPodAutoscaler, keep in sync with minReplicas
+      replicas: 2
+      template:
+        spec:
+          containers:
+            - name: app
+              # Pinned by the platform team
+              image: "@expr(.spec.image)"
+              "@if(.spec.debug)":
+                # Only set for debugging
+                args: ["--debug"]
+  - "@for(name in .spec.configs)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(name)"
+      data:
+        mode: production # Read by the entrypoint
+`
+
+func TestHydratePreserveComments(t *testing.T) {
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "shop"},
+		"spec": map[string]interface{}{
+			"image":   "shop:2.0",
+			"debug":   true,
+			"configs": []interface{}{"a", "b"},
+		},
+	}
+
+	h := NewHydrator("", false)
+	result, err := h.HydrateWithTemplate(instance, []byte(commentedTemplate))
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if result.Comments != nil {
+		t.Errorf("Expected no comments unless preserving them, got %v", result.Comments)
+	}
+
+	h.SetPreserveComments(true)
+	result, err = h.HydrateWithTemplate(instance, []byte(commentedTemplate))
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+
+	rendered := make(map[string]string)
+	for _, resource := range result.Resources {
+		node, err := result.Comments.Node(resource)
+		if err != nil {
+			t.Fatalf("Node() error = %v", err)
+		}
+		data, err := yaml.Marshal(node)
+		if err != nil {
+			t.Fatalf("yaml.Marshal() error = %v", err)
+		}
+		name := resource["metadata"].(map[string]interface{})["name"].(string)
+		rendered[name] = string(data)
+	}
+
+	deployment := rendered["shop"]
+	for _, want := range []string{
+		"# The application Deployment\napiVersion: apps/v1\n",
+		"# Scaled by the HorizontalPodAutoscaler, keep in sync with minReplicas\n    replicas: 2\n",
+		"# Pinned by the platform team\n                  image: shop:2.0\n",
+		"# Only set for debugging\n                  args:\n",
+	} {
+		if !strings.Contains(deployment, want) {
+			t.Errorf("Expected Deployment to contain %q, got:\n%s", want, deployment)
+		}
+	}
+
+	for _, name := range []string{"a", "b"} {
+		if !strings.Contains(rendered[name], "mode: production # Read by the entrypoint\n") {
+			t.Errorf("Expected ConfigMap %s to keep the line comment, got:\n%s", name, rendered[name])
+		}
+	}
+}
+
+func TestTemplateCommentsMerge(t *testing.T) {
+	child, err := parseTemplateComments([]byte("resources:\n  - kind: Service\n    spec:\n      # Child\n      type: ClusterIP\n"))
+	if err != nil {
+		t.Fatalf("parseTemplateComments() error = %v", err)
+	}
+	base, err := parseTemplateComments([]byte("resources:\n  - kind: Service\n    spec:\n      # Base\n      type: ClusterIP\n      # Base port\n      port: 80\n"))
+	if err != nil {
+		t.Fatalf("parseTemplateComments() error = %v", err)
+	}
+
+	merged := TemplateComments{}
+	merged.Merge(child)
+	merged.Merge(base)
+
+	spec := merged["Service"].Fields["spec"]
+	if spec.Fields["type"].Head != "# Child" {
+		t.Errorf("Expected the first comment on a field to win, got %q", spec.Fields["type"].Head)
+	}
+	if spec.Fields["port"].Head != "# Base port" {
+		t.Errorf("Expected comments missing from the child to be added, got %q", spec.Fields["port"].Head)
+	}
+	if base["Service"].Fields["spec"].Fields["type"].Head != "# Base" {
+		t.Error("Expected Merge not to modify the merged comments")
+	}
+}
+
+func TestTemplateCommentsByName(t *testing.T) {
+	comments, err := parseTemplateComments([]byte(`resources:
+  - kind: ConfigMap
+    metadata:
+      name: app
+    data:
+      mode: production # Read by the app
+  - kind: ConfigMap
+    metadata:
+      name: proxy
+    data:
+      mode: production # Read by the proxy
+  - kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      mode: production # Read by the instance
+`))
+	if err != nil {
+		t.Fatalf("parseTemplateComments() error = %v", err)
+	}
+
+	tests := map[string]string{
+		"app":   "# Read by the app",
+		"proxy": "# Read by the proxy",
+		// Resources with expression names fall back to the first comment on their kind
+		"shop": "# Read by the app",
+	}
+	for name, want := range tests {
+		node, err := comments.Node(map[string]interface{}{
+			"kind":     "ConfigMap",
+			"metadata": map[string]interface{}{"name": name},
+			"data":     map[string]interface{}{"mode": "production"},
+		})
+		if err != nil {
+			t.Fatalf("Node() error = %v", err)
+		}
+		data, err := yaml.Marshal(node)
+		if err != nil {
+			t.Fatalf("yaml.Marshal() error = %v", err)
+		}
+		if !strings.Contains(string(data), "mode: production "+want+"\n") {
+			t.Errorf("Expected ConfigMap %s to have comment %q, got:\n%s", name, want, data)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package hydrator
+
+// ResourceIdentity returns the apiVersion/kind/name key used for dependency
+// tracking, qualified with the namespace when one is set. It reports false for
+// resources without an apiVersion, kind or name.
+func ResourceIdentity(resource map[string]interface{}) (string, bool) {
+	key, err := getResourceKey(resource)
+	if err != nil {
+		return "", false
+	}
+	if metadata, ok := resource["metadata"].(map[string]interface{}); ok {
+		if namespace, ok := metadata["namespace"].(string); ok && namespace != "" {
+			key += " in namespace " + namespace
+		}
+	}
+	return key, true
+}
+
+// DuplicateResources returns the identity of every resource that appears more
+// than once, in order of its second appearance. Resources without a name are
+// never duplicates.
+func DuplicateResources(resources []map[string]interface{}) []string {
+	seen := make(map[string]int, len(resources))
+	var duplicates []string
+
+	for _, resource := range resources {
+		key, ok := ResourceIdentity(resource)
+		if !ok {
+			continue // Structural problems are reported elsewhere
+		}
+
+		seen[key]++
+		if seen[key] == 2 {
+			duplicates = append(duplicates, key)
+		}
+	}
+
+	return duplicates
+}
@@ -0,0 +1,63 @@
+package hydrator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDuplicateResources(t *testing.T) {
+	resource := func(kind, namespace, name string) map[string]interface{} {
+		metadata := map[string]interface{}{"name": name}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		return map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       kind,
+			"metadata":   metadata,
+		}
+	}
+
+	tests := []struct {
+		name      string
+		resources []map[string]interface{}
+		expected  []string
+	}{
+		{
+			name: "unique",
+			resources: []map[string]interface{}{
+				resource("ConfigMap", "", "a"),
+				resource("ConfigMap", "", "b"),
+				resource("Secret", "", "a"),
+				resource("ConfigMap", "prod", "a"),
+			},
+		},
+		{
+			name: "same identity",
+			resources: []map[string]interface{}{
+				resource("ConfigMap", "", "a"),
+				resource("ConfigMap", "prod", "b"),
+				resource("ConfigMap", "", "a"),
+				resource("ConfigMap", "prod", "b"),
+				resource("ConfigMap", "", "a"),
+			},
+			expected: []string{"v1/ConfigMap/a", "v1/ConfigMap/b in namespace prod"},
+		},
+		{
+			name: "unnamed resources",
+			resources: []map[string]interface{}{
+				{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"generateName": "job-"}},
+				{"apiVersion": "v1", "kind": "Pod", "metadata": map[string]interface{}{"generateName": "job-"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			duplicates := DuplicateResources(tt.resources)
+			if !reflect.DeepEqual(duplicates, tt.expected) {
+				t.Errorf("DuplicateResources() = %v, want %v", duplicates, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+package hydrator
+
+import (
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// templateSource reads templates and the base templates they extend, either
+// from the disk or from a filesystem
+type templateSource struct {
+	fsys     fs.FS // nil reads from the disk
+	comments bool  // Collect the templates' comments
+}
+
+// load reads and parses the template at name, applying its extends chain
+func (s templateSource) load(name string) (*Template, error) {
+	return s.loadChain(name, nil)
+}
+
+// loadChain loads the template at name, which is extended by the templates in chain
+func (s templateSource) loadChain(name string, chain []string) (*Template, error) {
+	var data []byte
+	var err error
+	if s.fsys != nil {
+		data, err = fs.ReadFile(s.fsys, name)
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := s.parse(data)
+	if err != nil {
+		return nil, err
+	}
+	template.Files = []string{name}
+	return s.extend(template, s.dir(name), append(append([]string{}, chain...), s.key(name)))
+}
+
+// parse parses template YAML, collecting its comments if the source does
+func (s templateSource) parse(data []byte) (*Template, error) {
+	template, err := parseTemplate(data)
+	if err != nil {
+		return nil, err
+	}
+	if s.comments {
+		if template.Comments, err = parseTemplateComments(data); err != nil {
+			return nil, err
+		}
+	}
+	return template, nil
+}
+
+// extend merges template over the base template it extends, if any. The
+// base is resolved relative to dir; chain holds the templates already being
+// loaded, to detect cycles.
+func (s templateSource) extend(template *Template, dir string, chain []string) (*Template, error) {
+	if template.Extends == "" {
+		return template, nil
+	}
+
+	name, err := s.resolve(dir, template.Extends)
+	if err != nil {
+		return nil, err
+	}
+	for _, loaded := range chain {
+		if loaded == s.key(name) {
+			return nil, fmt.Errorf("extends cycle detected: %s", strings.Join(append(chain, s.key(name)), " -> "))
+		}
+	}
+
+	base, err := s.loadChain(name, chain)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load base template %s: %w", template.Extends, err)
+	}
+
+	notes := template.Notes
+	if notes == "" {
+		notes = base.Notes
+	}
+	merged := &Template{
+		Resources: mergeTemplateResources(base.Resources, template.Resources),
+		Notes:     notes,
+		Files:     append(append([]string{}, template.Files...), base.Files...),
+	}
+	if s.comments {
+		// The child's comments take precedence over the base's
+		merged.Comments = TemplateComments{}
+		merged.Comments.Merge(template.Comments)
+		merged.Comments.Merge(base.Comments)
+	}
+	return merged, nil
+}
+
+// resolve returns the path of the template name, relative to dir
+func (s templateSource) resolve(dir, name string) (string, error) {
+	if s.fsys != nil {
+		resolved := path.Join(dir, name)
+		if !fs.ValidPath(resolved) {
+			return "", fmt.Errorf("base template %s is outside the template filesystem", resolved)
+		}
+		return resolved, nil
+	}
+
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// dir returns the directory of the template at name
+func (s templateSource) dir(name string) string {
+	if s.fsys != nil {
+		return path.Dir(name)
+	}
+	return filepath.Dir(name)
+}
+
+// key identifies the template at name in an extends chain
+func (s templateSource) key(name string) string {
+	if s.fsys != nil {
+		return path.Clean(name)
+	}
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
+
+// mergeTemplateResources applies a child template's resources to its base's.
+// A child resource with the same kind and metadata.name as a top-level base
+// resource overrides it: maps are merged recursively, a null value removes
+// the field, and any other value replaces the base's. Other child resources
+// are added after the base's. Names are compared as written, so an
+// expression name matches the same expression.
+func mergeTemplateResources(base, child interface{}) interface{} {
+	if child == nil {
+		return base
+	}
+	if base == nil {
+		return child
+	}
+
+	merged := append([]interface{}{}, templateResourceList(base)...)
+	for _, resource := range templateResourceList(child) {
+		key, ok := templateResourceKey(resource)
+		overridden := false
+		for i, baseResource := range merged {
+			if baseKey, baseOK := templateResourceKey(baseResource); ok && baseOK && key == baseKey {
+				merged[i] = mergeOverride(baseResource.(map[string]interface{}), resource.(map[string]interface{}))
+				overridden = true
+			}
+		}
+		if !overridden {
+			merged = append(merged, resource)
+		}
+	}
+	return merged
+}
+
+// templateResourceList returns the items of a template's resources. A map
+// root holds a single resource or control flow block.
+func templateResourceList(resources interface{}) []interface{} {
+	if list, ok := resources.([]interface{}); ok {
+		return list
+	}
+	return []interface{}{resources}
+}
+
+// templateResourceKey returns the kind and metadata.name of a resource as
+// written in a template, or false for control flow and unnamed resources
+func templateResourceKey(resource interface{}) (string, bool) {
+	fields, ok := resource.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	kind, ok := fields["kind"].(string)
+	if !ok {
+		return "", false
+	}
+	metadata, ok := fields["metadata"].(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	name, ok := metadata["name"].(string)
+	if !ok {
+		return "", false
+	}
+	return kind + "/" + name, true
+}
+
+// mergeOverride returns base with override merged into it, without modifying either
+func mergeOverride(base, override map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+		baseMap, baseIsMap := merged[key].(map[string]interface{})
+		overrideMap, overrideIsMap := value.(map[string]interface{})
+		if baseIsMap && overrideIsMap {
+			merged[key] = mergeOverride(baseMap, overrideMap)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
@@ -0,0 +1,194 @@
+package hydrator
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+const baseWebServiceTemplate = `resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+      labels:
+        app: "@expr(.metadata.name)"
+        tier: web
+    spec:
+      replicas: 2
+      template:
+        spec:
+          containers:
+            - name: app
+              image: nginx:1.25
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      ports:
+        - port: 80
+notes: "Base notes for $(.metadata.name)"
+`
+
+const childWebServiceTemplate = `extends: base.yaml
+resources:
+  - kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+      labels:
+        tier: null
+    spec:
+      template:
+        spec:
+          containers:
+            - name: app
+              image: "@expr(.spec.image)"
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name + \"-config\")"
+`
+
+func TestHydrateExtends(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "extends-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"base.yaml":                   baseWebServiceTemplate,
+		"webservice_v1alpha1.yaml":    childWebServiceTemplate,
+		"webservice_v1alpha2.yaml":    "extends: webservice_v1alpha1.yaml\nnotes: Child notes\n",
+		"cycle_a.yaml":                "extends: cycle_b.yaml\nresources: []\n",
+		"cycle_b.yaml":                "extends: cycle_a.yaml\nresources: []\n",
+		"looper_v1alpha1.yaml":        "extends: cycle_a.yaml\nresources: []\n",
+		"orphan_v1alpha1.yaml":        "extends: missing.yaml\nresources: []\n",
+		"selfextending_v1alpha1.yaml": "extends: selfextending_v1alpha1.yaml\nresources: []\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	instance := func(kind, version string) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "platform.example.com/" + version,
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": "shop"},
+			"spec":       map[string]interface{}{"image": "shop:2.0"},
+		}
+	}
+
+	h := NewHydrator(tempDir, false)
+	for _, version := range []string{"v1alpha1", "v1alpha2"} {
+		result, err := h.Hydrate(instance("WebService", version))
+		if err != nil {
+			t.Fatalf("%s: Hydrate() error = %v", version, err)
+		}
+
+		var kinds []string
+		for _, resource := range result.Resources {
+			kinds = append(kinds, resource["kind"].(string))
+		}
+		if strings.Join(kinds, ",") != "Deployment,Service,ConfigMap" {
+			t.Fatalf("%s: expected the base's resources followed by the child's, got %v", version, kinds)
+		}
+
+		deployment := result.Resources[0]
+		if deployment["apiVersion"] != "apps/v1" {
+			t.Errorf("%s: expected apiVersion inherited from the base, got %v", version, deployment["apiVersion"])
+		}
+		spec := deployment["spec"].(map[string]interface{})
+		if spec["replicas"] != float64(2) {
+			t.Errorf("%s: expected replicas inherited from the base, got %v (%T)", version, spec["replicas"], spec["replicas"])
+		}
+		containers := spec["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+		if image := containers[0].(map[string]interface{})["image"]; image != "shop:2.0" {
+			t.Errorf("%s: expected the child's image to override the base's, got %v", version, image)
+		}
+		labels := deployment["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+		if _, ok := labels["tier"]; ok || labels["app"] != "shop" {
+			t.Errorf("%s: expected tier label removed and app label kept, got %v", version, labels)
+		}
+
+		wantNotes := "Base notes for shop"
+		if version == "v1alpha2" {
+			wantNotes = "Child notes"
+		}
+		if result.Notes != wantNotes {
+			t.Errorf("%s: Notes = %q, want %q", version, result.Notes, wantNotes)
+		}
+	}
+
+	for _, kind := range []string{"Looper", "SelfExtending"} {
+		if _, err := h.Hydrate(instance(kind, "v1alpha1")); err == nil || !strings.Contains(err.Error(), "extends cycle detected") {
+			t.Errorf("%s: expected extends cycle error, got %v", kind, err)
+		}
+	}
+	if _, err := h.Hydrate(instance("Orphan", "v1alpha1")); err == nil || !strings.Contains(err.Error(), "missing.yaml") {
+		t.Errorf("Expected error for a missing base template, got %v", err)
+	}
+
+	// Parsing for lint applies the extends chain too
+	root, err := ParseTemplateFile(filepath.Join(tempDir, "webservice_v1alpha1.yaml"))
+	if err != nil {
+		t.Fatalf("ParseTemplateFile() error = %v", err)
+	}
+	if len(root.Resources) != 3 {
+		t.Errorf("Expected 3 resources in the parsed child template, got %d", len(root.Resources))
+	}
+
+	// An in-memory template finds its base in the template directory
+	result, err := h.HydrateWithTemplate(instance("WebService", "v1alpha1"), []byte(childWebServiceTemplate))
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Resources) != 3 {
+		t.Errorf("Expected 3 resources from in-memory child template, got %d", len(result.Resources))
+	}
+}
+
+func TestHydrateExtendsFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"templates/base.yaml":                {Data: []byte(baseWebServiceTemplate)},
+		"templates/webservice_v1alpha1.yaml": {Data: []byte(childWebServiceTemplate)},
+		"templates/escape_v1alpha1.yaml":     {Data: []byte("extends: ../../base.yaml\nresources: []\n")},
+	}
+
+	h := NewHydrator("templates", false)
+	h.SetTemplateFS(fsys)
+
+	result, err := h.Hydrate(map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "shop"},
+		"spec":       map[string]interface{}{"image": "shop:2.0"},
+	})
+	if err != nil {
+		t.Fatalf("Hydrate() error = %v", err)
+	}
+	if len(result.Resources) != 3 {
+		t.Fatalf("Expected 3 resources, got %d", len(result.Resources))
+	}
+
+	_, err = h.Hydrate(map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "Escape",
+		"metadata":   map[string]interface{}{"name": "shop"},
+	})
+	if err == nil || !strings.Contains(err.Error(), "outside the template filesystem") {
+		t.Errorf("Expected error for a base outside the filesystem, got %v", err)
+	}
+}
+
+func TestParseTemplateConflictingExtends(t *testing.T) {
+	_, err := parseTemplate([]byte("extends: a.yaml\nresources: []\n---\nextends: b.yaml\nresources: []\n"))
+	if err == nil {
+		t.Error("Expected error for documents extending different templates")
+	}
+}
@@ -1,73 +1,334 @@
 package hydrator
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/zachaller/k8s-client-api-builder/pkg/ast"
-	"sigs.k8s.io/yaml"
+	"github.com/zachaller/k8s-client-api-builder/pkg/dsl"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
 )
 
-// Hydrator handles the hydration of abstractions into K8s resources
+// Hydrator handles the hydration of abstractions into K8s resources.
+// Hydrate and HydrateWithTemplate create fresh evaluators for every call, so
+// they are safe to call concurrently once the hydrator is configured; the
+// Set* and Add* methods must not be called while hydrations are running.
 type Hydrator struct {
-	templateDir string
-	verbose     bool
+	templateDir        string
+	templateFS         fs.FS
+	values             map[string]interface{}
+	secrets            dsl.SecretResolver
+	commonLabels       map[string]string
+	commonAnnotations  map[string]string
+	labelsFrom         []string
+	expandGenerateName bool
+	namespace          string
+	clusterScopedKinds map[string]bool
+	transforms         []InstanceTransform
+	maxDepth           int
+	strictLoops        bool
+	nilMissingFields   bool
+	onUnresolved       string
+	placeholder        string
+	clock              func() time.Time
+	parallelism        int
+	preserveComments   bool
+	profile            *Profile
+	verbose            bool
+	// stderr receives the verbose output, so it stays out of the generated YAML
+	stderr io.Writer
 }
 
+// Modes for handling resource references that cannot be resolved in pass 2
+const (
+	// UnresolvedKeep keeps a resource with an unresolved reference as pass 1
+	// generated it and reports the error
+	UnresolvedKeep = "keep"
+	// UnresolvedBlank replaces each unresolved reference with the placeholder
+	// and reports a warning
+	UnresolvedBlank = "blank"
+	// UnresolvedError fails hydration
+	UnresolvedError = "error"
+)
+
+// InstanceTransform rewrites an instance before it is hydrated, for example
+// to normalize fields or fill in defaults
+type InstanceTransform func(instance map[string]interface{}) (map[string]interface{}, error)
+
 // NewHydrator creates a new hydrator
 func NewHydrator(templateDir string, verbose bool) *Hydrator {
 	return &Hydrator{
 		templateDir: templateDir,
 		verbose:     verbose,
+		stderr:      os.Stderr,
 	}
 }
 
+// SetVerboseOutput sets where verbose output, such as the trace of evaluated
+// expressions, is written; the default is os.Stderr
+func (h *Hydrator) SetVerboseOutput(w io.Writer) {
+	h.stderr = w
+}
+
+// SetTemplateFS makes the hydrator read templates from fsys, such as an
+// embed.FS, instead of the disk. The template directory is then a slash
+// separated path within fsys.
+func (h *Hydrator) SetTemplateFS(fsys fs.FS) {
+	h.templateFS = fsys
+}
+
+// SetValues sets external values exposed to templates as $values
+func (h *Hydrator) SetValues(values map[string]interface{}) {
+	h.values = values
+}
+
+// SetSecretResolver sets where the secret() function reads secret values
+func (h *Hydrator) SetSecretResolver(resolver dsl.SecretResolver) {
+	h.secrets = resolver
+}
+
+// SetExpandGenerateName enables synthesizing metadata.name from metadata.generateName
+func (h *Hydrator) SetExpandGenerateName(expand bool) {
+	h.expandGenerateName = expand
+}
+
+// SetCommonLabels sets labels added to every generated resource. Values may
+// contain $(...) expressions evaluated against the instance.
+func (h *Hydrator) SetCommonLabels(labels map[string]string) {
+	h.commonLabels = labels
+}
+
+// SetCommonAnnotations sets annotations added to every generated resource. Values
+// may contain $(...) expressions evaluated against the instance.
+func (h *Hydrator) SetCommonAnnotations(annotations map[string]string) {
+	h.commonAnnotations = annotations
+}
+
+// SetLabelsFrom promotes instance fields, such as .spec.team, to labels on
+// every generated resource. Each label is named after the last field of its
+// path and its value is sanitized with dsl.K8sName; fields the instance does
+// not set are skipped, and labels the template or common labels set are kept.
+func (h *Hydrator) SetLabelsFrom(paths []string) error {
+	promoted := make(map[string]string, len(paths))
+	for _, path := range paths {
+		if _, err := dsl.ParseExpression(path); err != nil || !strings.HasPrefix(path, ".") {
+			return fmt.Errorf("invalid label path '%s': expected a field path such as .spec.team", path)
+		}
+		key := promotedLabelKey(path)
+		if !labelKeyPattern.MatchString(key) {
+			return fmt.Errorf("invalid label path '%s': '%s' is not a valid label name", path, key)
+		}
+		if other, ok := promoted[key]; ok {
+			return fmt.Errorf("label paths '%s' and '%s' both promote to label '%s'", other, path, key)
+		}
+		promoted[key] = path
+	}
+	h.labelsFrom = paths
+	return nil
+}
+
+// SetMaxDepth limits how deeply template structures may nest during both
+// evaluation passes; 0 uses dsl.DefaultMaxDepth
+func (h *Hydrator) SetMaxDepth(depth int) {
+	h.maxDepth = depth
+}
+
+// SetStrictLoops makes a @for over a nil or absent iterable fail instead of
+// producing no iterations
+func (h *Hydrator) SetStrictLoops(strict bool) {
+	h.strictLoops = strict
+}
+
+// SetNilMissingFields binds nil for fields of a @for destructure list that
+// are missing from an element, instead of failing the loop
+func (h *Hydrator) SetNilMissingFields(enabled bool) {
+	h.nilMissingFields = enabled
+}
+
+// SetOnUnresolved sets how resource references that cannot be resolved are
+// handled: UnresolvedKeep (the default), UnresolvedBlank, which substitutes
+// placeholder, or UnresolvedError
+func (h *Hydrator) SetOnUnresolved(mode, placeholder string) error {
+	switch mode {
+	case "":
+		mode = UnresolvedKeep
+	case UnresolvedKeep, UnresolvedBlank, UnresolvedError:
+	default:
+		return fmt.Errorf("unknown unresolved reference mode '%s' (expected '%s', '%s' or '%s')", mode, UnresolvedKeep, UnresolvedBlank, UnresolvedError)
+	}
+	h.onUnresolved = mode
+	h.placeholder = placeholder
+	return nil
+}
+
+// SetClock makes now() in templates read the current time from clock, for
+// example to freeze it for reproducible output; nil restores time.Now
+func (h *Hydrator) SetClock(clock func() time.Time) {
+	h.clock = clock
+}
+
+// SetParallelism resolves the cross-resource references of up to workers
+// resources at once in pass 2, each worker with its own evaluator over a
+// shared read-only registry of the pass 1 resources; 0 or 1 resolves them
+// one at a time
+func (h *Hydrator) SetParallelism(workers int) {
+	h.parallelism = workers
+}
+
+// SetPreserveComments makes hydration collect the comments written in
+// templates, returned in HydrateResult.Comments to be written to the output
+func (h *Hydrator) SetPreserveComments(preserve bool) {
+	h.preserveComments = preserve
+}
+
+// SetProfile records the time spent parsing templates and in each evaluation
+// pass into profile; nil disables profiling
+func (h *Hydrator) SetProfile(profile *Profile) {
+	h.profile = profile
+}
+
+// AddInstanceTransform registers a transform applied to every instance before
+// hydration. Transforms run in the order they were added.
+func (h *Hydrator) AddInstanceTransform(transform InstanceTransform) {
+	h.transforms = append(h.transforms, transform)
+}
+
+// transformInstance runs the registered transforms over instance
+func (h *Hydrator) transformInstance(instance map[string]interface{}) (map[string]interface{}, error) {
+	for i, transform := range h.transforms {
+		transformed, err := transform(instance)
+		if err != nil {
+			return nil, fmt.Errorf("instance transform %d failed: %w", i, err)
+		}
+		instance = transformed
+	}
+	return instance, nil
+}
+
 // Template represents a hydration template
 type Template struct {
 	Resources interface{} `yaml:"resources"` // Can be []interface{} or map with conditionals
+	Notes     string      `yaml:"notes"`     // Next steps shown to users, with $(...) expressions
+	Extends   string      `yaml:"extends"`   // Base template whose resources this one overrides
+
+	// Comments are only collected when the hydrator preserves comments
+	Comments TemplateComments `json:"-" yaml:"-"`
+
+	// Files are the template files read to load it: its own and the ones it extends
+	Files []string `json:"-" yaml:"-"`
 }
 
 // HydrateResult contains the hydrated resources
 type HydrateResult struct {
 	Resources []map[string]interface{}
 	Errors    []error
+	Notes     string // The template's notes rendered against the instance
+
+	// Comments holds the template's comments when the hydrator preserves them
+	Comments TemplateComments
+
+	// Files lists the files hydration read besides the instance: the
+	// template, the templates it extends, its @imports and the files read
+	// with file()
+	Files []string
 }
 
 // Hydrate processes an abstraction instance and generates K8s resources
 // Uses AST-based parsing and evaluation with two-pass processing for cross-resource references
 func (h *Hydrator) Hydrate(instance map[string]interface{}) (*HydrateResult, error) {
+	instance, err := h.transformInstance(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load template
+	start := time.Now()
+	templatePath, err := h.TemplatePath(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.verbose {
+		fmt.Fprintf(h.stderr, "Loading template: %s\n", templatePath)
+	}
+
+	template, err := h.loadTemplate(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+
+	// Parse template YAML to AST, resolving @import relative to the template
+	var astRoot *ast.RootNode
+	if h.templateFS != nil {
+		astRoot, err = ast.ParseTemplateFS(template.Resources, h.templateFS, templatePath)
+	} else {
+		astRoot, err = ast.ParseTemplateFile(template.Resources, templatePath)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template to AST: %w", err)
+	}
+	h.profile.Track(PhaseTemplateParse, start)
+
+	return h.hydrateAST(instance, astRoot, template)
+}
+
+// TemplatePath returns the path of the template used to hydrate instance
+func (h *Hydrator) TemplatePath(instance map[string]interface{}) (string, error) {
 	// Extract kind from instance
 	kind, ok := instance["kind"].(string)
 	if !ok {
-		return nil, fmt.Errorf("instance missing 'kind' field")
+		return "", fmt.Errorf("instance missing 'kind' field")
 	}
 
 	// Extract version from apiVersion
 	apiVersion, ok := instance["apiVersion"].(string)
 	if !ok {
-		return nil, fmt.Errorf("instance missing 'apiVersion' field")
+		return "", fmt.Errorf("instance missing 'apiVersion' field")
 	}
 
 	parts := strings.Split(apiVersion, "/")
 	if len(parts) != 2 {
-		return nil, fmt.Errorf("invalid apiVersion format: %s", apiVersion)
+		return "", fmt.Errorf("invalid apiVersion format: %s", apiVersion)
 	}
 	version := parts[1]
 
-	// Load template
 	templatePath := h.findTemplate(kind, version)
 	if templatePath == "" {
-		return nil, fmt.Errorf("template not found for kind '%s' version '%s'", kind, version)
+		return "", fmt.Errorf("template not found for kind '%s' version '%s'", kind, version)
 	}
 
-	if h.verbose {
-		fmt.Printf("Loading template: %s\n", templatePath)
+	return templatePath, nil
+}
+
+// HydrateWithTemplate hydrates an instance using an in-memory template instead of
+// looking one up on disk
+func (h *Hydrator) HydrateWithTemplate(instance map[string]interface{}, templateYAML []byte) (*HydrateResult, error) {
+	instance, err := h.transformInstance(instance)
+	if err != nil {
+		return nil, err
 	}
 
-	template, err := h.loadTemplate(templatePath)
+	start := time.Now()
+	source := h.templateSource()
+	template, err := source.parse(templateYAML)
+	if err == nil {
+		// The base of an in-memory template is found in the template directory
+		template, err = source.extend(template, h.templateDir, nil)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to load template: %w", err)
 	}
@@ -77,33 +338,90 @@ func (h *Hydrator) Hydrate(instance map[string]interface{}) (*HydrateResult, err
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse template to AST: %w", err)
 	}
+	h.profile.Track(PhaseTemplateParse, start)
+
+	return h.hydrateAST(instance, astRoot, template)
+}
+
+// HydrateTemplateFile hydrates an instance using the template at path instead
+// of looking one up by kind and version. @import resolves relative to path.
+func (h *Hydrator) HydrateTemplateFile(instance map[string]interface{}, path string) (*HydrateResult, error) {
+	instance, err := h.transformInstance(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	template, astRoot, err := readTemplateFile(templateSource{comments: h.preserveComments}, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load template: %w", err)
+	}
+	h.profile.Track(PhaseTemplateParse, start)
+
+	return h.hydrateAST(instance, astRoot, template)
+}
 
+// hydrateAST runs both evaluation passes over a parsed template and renders
+// its notes
+func (h *Hydrator) hydrateAST(instance map[string]interface{}, astRoot *ast.RootNode, template *Template) (*HydrateResult, error) {
 	if h.verbose {
 		printer := ast.NewPrinter()
 		astStr, _ := printer.Print(astRoot)
-		fmt.Printf("Template AST:\n%s\n", astStr)
+		fmt.Fprintf(h.stderr, "Template AST:\n%s\n", astStr)
 	}
 
 	// Pass 1: Evaluate AST to generate resources (without resolving resource references)
-	evaluator := ast.NewEvaluator(instance)
+	start := time.Now()
+	files := h.recordTemplateFiles()
+	evaluator := h.newEvaluator(instance, files)
 	pass1Resources, err := evaluator.Evaluate(astRoot)
 	if err != nil {
 		return nil, fmt.Errorf("pass 1 evaluation failed: %w", err)
 	}
+	h.profile.Track(PhasePass1, start)
+
+	// Name resources that only set generateName so they can be referenced in pass 2
+	if h.expandGenerateName {
+		if err := expandGenerateNames(pass1Resources); err != nil {
+			return nil, err
+		}
+	}
 
 	// Pass 2: Resolve cross-resource references
-	finalResources, errors := h.hydratePass2AST(pass1Resources, instance)
+	start = time.Now()
+	finalResources, errs := h.hydratePass2AST(pass1Resources, instance, files)
+	h.profile.Track(PhasePass2, start)
+	if h.onUnresolved == UnresolvedError && len(errs) > 0 {
+		return nil, fmt.Errorf("pass 2 evaluation failed: %w", errors.Join(errs...))
+	}
+
+	// Stamp common labels and annotations without overriding the template's own
+	if err := h.applyCommonMetadata(finalResources, instance, files); err != nil {
+		return nil, err
+	}
+	h.applyNamespace(finalResources)
+
+	notes := template.Notes
+	if notes != "" {
+		notes, err = h.newEvaluator(instance, files).GetDSLEvaluator().EvaluateString(notes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render notes: %w", err)
+		}
+	}
 
 	return &HydrateResult{
 		Resources: finalResources,
-		Errors:    errors,
+		Errors:    errs,
+		Notes:     notes,
+		Comments:  template.Comments,
+		Files:     uniqueFiles(template.Files, astRoot.Imports, h.readTemplateFiles(files)),
 	}, nil
 }
 
 // hydratePass2AST resolves cross-resource references using AST evaluator
-func (h *Hydrator) hydratePass2AST(resources []map[string]interface{}, instance map[string]interface{}) ([]map[string]interface{}, []error) {
+func (h *Hydrator) hydratePass2AST(resources []map[string]interface{}, instance map[string]interface{}, files *fileRecorder) ([]map[string]interface{}, []error) {
 	// Create new evaluator with instance data
-	evaluator := ast.NewEvaluator(instance)
+	evaluator := h.newEvaluator(instance, files)
 
 	// Register all resources
 	for _, resource := range resources {
@@ -124,89 +442,333 @@ func (h *Hydrator) hydratePass2AST(resources []map[string]interface{}, instance
 		return nil, []error{fmt.Errorf("circular resource references detected: %v", cycles)}
 	}
 
-	// Process each resource again to resolve references
-	finalResources := []map[string]interface{}{}
-	errors := []error{}
+	// Process each resource again to resolve references. Results and errors
+	// are kept per resource so they are reported in order however many
+	// workers resolve them.
+	finalResources := make([]map[string]interface{}, len(resources))
+	resourceErrs := make([][]error, len(resources))
 
-	for i, resource := range resources {
+	resolve := func(evaluator *ast.Evaluator, i int) {
+		resource := resources[i]
 		if h.verbose {
-			fmt.Printf("Pass 2: Resolving references in resource %d/%d\n", i+1, len(resources))
+			fmt.Fprintf(h.stderr, "Pass 2: Resolving references in resource %d/%d\n", i+1, len(resources))
 		}
 
-		resolved, err := h.resolveResourceReferencesAST(resource, evaluator, instance)
+		// Substitute the placeholder for references that cannot be resolved
+		if h.onUnresolved == UnresolvedBlank {
+			evaluator.GetDSLEvaluator().SetSubstitutionFallback(func(expr string, err error) (string, error) {
+				resourceErrs[i] = append(resourceErrs[i], fmt.Errorf("resource %d: replaced unresolved '%s' with '%s': %w", i, expr, h.placeholder, err))
+				return h.placeholder, nil
+			})
+		}
+
+		resolved, err := h.resolveResourceReferencesAST(resource, evaluator)
 		if err != nil {
-			errors = append(errors, fmt.Errorf("resource %d: %w", i, err))
+			resourceErrs[i] = append(resourceErrs[i], fmt.Errorf("resource %d: %w", i, err))
 			// Still include the resource even if resolution fails
-			finalResources = append(finalResources, resource)
-			continue
+			finalResources[i] = resource
+			return
 		}
 
 		// Type assert resolved value
 		resolvedResource, ok := resolved.(map[string]interface{})
 		if !ok {
-			errors = append(errors, fmt.Errorf("resource %d: resolved value is not a map", i))
-			finalResources = append(finalResources, resource)
-			continue
+			resourceErrs[i] = append(resourceErrs[i], fmt.Errorf("resource %d: resolved value is not a map", i))
+			finalResources[i] = resource
+			return
 		}
 
-		finalResources = append(finalResources, resolvedResource)
+		finalResources[i] = resolvedResource
+	}
+
+	if h.parallelism <= 1 || len(resources) <= 1 {
+		for i := range resources {
+			resolve(evaluator, i)
+		}
+	} else {
+		workers := h.parallelism
+		if workers > len(resources) {
+			workers = len(resources)
+		}
+
+		indexes := make(chan int)
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			// Each worker sets its own substitution fallback, so it gets its
+			// own evaluator; the registered resources are only read
+			go func(evaluator *ast.Evaluator) {
+				defer wg.Done()
+				for i := range indexes {
+					resolve(evaluator, i)
+				}
+			}(evaluator.Clone())
+		}
+		for i := range resources {
+			indexes <- i
+		}
+		close(indexes)
+		wg.Wait()
+	}
+
+	errors := []error{}
+	for _, errs := range resourceErrs {
+		errors = append(errors, errs...)
 	}
 
 	return finalResources, errors
 }
 
 // resolveResourceReferencesAST resolves resource references in a resource using the AST evaluator
-func (h *Hydrator) resolveResourceReferencesAST(resource map[string]interface{}, evaluator *ast.Evaluator, context map[string]interface{}) (interface{}, error) {
-	result := make(map[string]interface{})
+func (h *Hydrator) resolveResourceReferencesAST(resource map[string]interface{}, evaluator *ast.Evaluator) (interface{}, error) {
+	resolved, err := evaluator.GetDSLEvaluator().EvaluateStringsMatching(resource, needsPass2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve field %w", err)
+	}
+	return resolved, nil
+}
+
+// needsPass2 reports whether a string holds a resource() reference or an
+// escaped "$$(" or "$$if(" that pass 2 has to resolve
+func needsPass2(value string) bool {
+	return strings.Contains(value, "resource(") || strings.Contains(value, "$$(") || strings.Contains(value, "$$if(")
+}
 
-	for key, value := range resource {
-		processedValue, err := h.resolveValueReferencesAST(value, evaluator, context)
+// expandGenerateNames sets metadata.name to generateName plus a short hash of the
+// resource content for every resource with a generateName and no name, so repeated
+// generation produces stable names without the API server
+func expandGenerateNames(resources []map[string]interface{}) error {
+	for _, resource := range resources {
+		metadata, ok := resource["metadata"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		generateName, ok := metadata["generateName"].(string)
+		if !ok || generateName == "" {
+			continue
+		}
+		if name, ok := metadata["name"].(string); ok && name != "" {
+			continue
+		}
+
+		// encoding/json sorts map keys, so the hash is stable across runs
+		delete(metadata, "generateName")
+		data, err := json.Marshal(resource)
 		if err != nil {
-			return nil, fmt.Errorf("failed to resolve field %s: %w", key, err)
+			metadata["generateName"] = generateName
+			return fmt.Errorf("failed to hash resource for generateName %s: %w", generateName, err)
 		}
-		result[key] = processedValue
+
+		sum := sha256.Sum256(data)
+		metadata["name"] = generateName + hex.EncodeToString(sum[:])[:generateNameSuffixLength]
+	}
+
+	return nil
+}
+
+// applyCommonMetadata adds the common labels and annotations to every resource,
+// keeping any value the template already set for the same key
+func (h *Hydrator) applyCommonMetadata(resources []map[string]interface{}, instance map[string]interface{}, files *fileRecorder) error {
+	if len(h.commonLabels) == 0 && len(h.commonAnnotations) == 0 && len(h.labelsFrom) == 0 {
+		return nil
 	}
 
+	evaluator := h.newEvaluator(instance, files).GetDSLEvaluator()
+
+	labels, err := evaluateMetadataValues(evaluator, h.commonLabels)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate common labels: %w", err)
+	}
+	annotations, err := evaluateMetadataValues(evaluator, h.commonAnnotations)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate common annotations: %w", err)
+	}
+	promoted, err := promoteLabels(evaluator, h.labelsFrom)
+	if err != nil {
+		return fmt.Errorf("failed to promote labels: %w", err)
+	}
+
+	for _, resource := range resources {
+		metadata, ok := resource["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+			resource["metadata"] = metadata
+		}
+		mergeMetadataField(metadata, "labels", labels)
+		mergeMetadataField(metadata, "labels", promoted)
+		mergeMetadataField(metadata, "annotations", annotations)
+	}
+
+	return nil
+}
+
+// evaluateMetadataValues evaluates the $(...) expressions in each value
+func evaluateMetadataValues(evaluator *dsl.Evaluator, values map[string]string) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(values))
+	for key, value := range values {
+		evaluated, err := evaluator.EvaluateString(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		result[key] = evaluated
+	}
 	return result, nil
 }
 
-// resolveValueReferencesAST resolves references in any value type
-func (h *Hydrator) resolveValueReferencesAST(value interface{}, evaluator *ast.Evaluator, context map[string]interface{}) (interface{}, error) {
-	switch v := value.(type) {
-	case string:
-		// Check if string contains resource() reference
-		if strings.Contains(v, "resource(") {
-			// Use DSL evaluator to resolve
-			dslEval := evaluator.GetDSLEvaluator()
-			return dslEval.EvaluateString(v)
-		}
-		return v, nil
-
-	case map[string]interface{}:
-		result := make(map[string]interface{})
-		for key, val := range v {
-			processedVal, err := h.resolveValueReferencesAST(val, evaluator, context)
-			if err != nil {
-				return nil, err
-			}
-			result[key] = processedVal
+// labelKeyPattern matches the name part of a Kubernetes label key
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]([-A-Za-z0-9_.]{0,61}[A-Za-z0-9])?$`)
+
+// promotedLabelKey returns the label a path promotes to, its last field
+func promotedLabelKey(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
+
+// promoteLabels evaluates each path against the instance and returns its
+// value, sanitized with dsl.K8sName, under the path's label key
+func promoteLabels(evaluator *dsl.Evaluator, paths []string) (map[string]interface{}, error) {
+	labels := make(map[string]interface{}, len(paths))
+	for _, path := range paths {
+		expr, err := dsl.ParseExpression(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		value, err := evaluator.Evaluate(expr)
+		if errors.Is(err, dsl.ErrKeyNotFound) || (err == nil && value == nil) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s: cannot promote a %T to a label", path, value)
+		}
+		label := dsl.K8sName(fmt.Sprintf("%v", value))
+		if label == "" {
+			return nil, fmt.Errorf("%s: '%v' has no characters valid in a label", path, value)
+		}
+		labels[promotedLabelKey(path)] = label
+	}
+	return labels, nil
+}
+
+// mergeMetadataField adds values to metadata[field], keeping existing keys
+func mergeMetadataField(metadata map[string]interface{}, field string, values map[string]interface{}) {
+	if len(values) == 0 {
+		return
+	}
+
+	existing, ok := metadata[field].(map[string]interface{})
+	if !ok {
+		existing = map[string]interface{}{}
+		metadata[field] = existing
+	}
+	for key, value := range values {
+		if _, ok := existing[key]; !ok {
+			existing[key] = value
 		}
-		return result, nil
+	}
+}
+
+// generateNameSuffixLength matches the length of the API server's random suffix
+const generateNameSuffixLength = 5
+
+// newEvaluator creates an AST evaluator for instance with the hydrator's depth
+// limit that traces every expression it evaluates when the hydrator is verbose
+func (h *Hydrator) newEvaluator(instance map[string]interface{}, files *fileRecorder) *ast.Evaluator {
+	evaluator := ast.NewEvaluatorWithValues(instance, h.values)
+	evaluator.SetMaxDepth(h.maxDepth)
+	evaluator.SetStrictLoops(h.strictLoops)
+	evaluator.SetNilMissingFields(h.nilMissingFields)
+	evaluator.SetClock(h.clock)
+	evaluator.SetSecretResolver(h.secrets)
+	if files.fsys != nil {
+		evaluator.SetFiles(files)
+	}
+	if h.verbose {
+		evaluator.SetTrace(h.traceExpression)
+	}
+	return evaluator
+}
 
-	case []interface{}:
-		result := make([]interface{}, 0, len(v))
-		for _, item := range v {
-			processedItem, err := h.resolveValueReferencesAST(item, evaluator, context)
-			if err != nil {
-				return nil, err
+// templateFiles returns the template directory, which file() reads from
+func (h *Hydrator) templateFiles() fs.FS {
+	dir := h.templateDir
+	if dir == "" {
+		dir = "." // The working directory
+	}
+	if h.templateFS != nil {
+		files, err := fs.Sub(h.templateFS, dir)
+		if err != nil {
+			return nil
+		}
+		return files
+	}
+	return os.DirFS(dir)
+}
+
+// fileRecorder is the template directory file() reads from during one
+// hydration. It records the names read, which the result lists as inputs.
+type fileRecorder struct {
+	fsys  fs.FS
+	mu    sync.Mutex
+	names []string
+}
+
+// Open opens the file name and records it
+func (r *fileRecorder) Open(name string) (fs.File, error) {
+	r.mu.Lock()
+	r.names = append(r.names, name)
+	r.mu.Unlock()
+	return r.fsys.Open(name)
+}
+
+// recordTemplateFiles returns a recorder of the files read from the template directory
+func (h *Hydrator) recordTemplateFiles() *fileRecorder {
+	return &fileRecorder{fsys: h.templateFiles()}
+}
+
+// readTemplateFiles returns the paths of the files recorded by files
+func (h *Hydrator) readTemplateFiles(files *fileRecorder) []string {
+	files.mu.Lock()
+	defer files.mu.Unlock()
+
+	paths := make([]string, 0, len(files.names))
+	for _, name := range files.names {
+		if h.templateFS != nil {
+			paths = append(paths, path.Join(h.templateDir, name))
+		} else {
+			paths = append(paths, filepath.Join(h.templateDir, filepath.FromSlash(name)))
+		}
+	}
+	return paths
+}
+
+// uniqueFiles concatenates lists of files, keeping the first of each
+func uniqueFiles(lists ...[]string) []string {
+	seen := map[string]bool{}
+	var files []string
+	for _, list := range lists {
+		for _, file := range list {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
 			}
-			result = append(result, processedItem)
 		}
-		return result, nil
+	}
+	return files
+}
 
-	default:
-		return v, nil
+// traceExpression writes an evaluated expression and its result to the
+// verbose output
+func (h *Hydrator) traceExpression(expr string, result interface{}, err error) {
+	if err != nil {
+		fmt.Fprintf(h.stderr, "  %s -> error: %v\n", expr, err)
+		return
 	}
+	fmt.Fprintf(h.stderr, "  %s -> %v\n", expr, result)
 }
 
 // registerResourceInEvaluator registers a resource in the evaluator's resource registry
@@ -231,23 +793,148 @@ func registerResourceInEvaluator(evaluator *ast.Evaluator, resource map[string]i
 		return fmt.Errorf("resource missing metadata.name")
 	}
 
-	evaluator.RegisterResource(apiVersion, kind, name, resource)
+	// Cluster-scoped resources have no namespace
+	namespace, _ := metadata["namespace"].(string)
+
+	evaluator.RegisterNamespacedResource(apiVersion, kind, namespace, name, resource)
 	return nil
 }
 
-// loadTemplate loads a template file
-func (h *Hydrator) loadTemplate(path string) (*Template, error) {
-	data, err := ioutil.ReadFile(path)
+// loadTemplate loads a template file and the templates it extends
+func (h *Hydrator) loadTemplate(name string) (*Template, error) {
+	return h.templateSource().load(name)
+}
+
+// templateSource returns the source the hydrator reads templates from
+func (h *Hydrator) templateSource() templateSource {
+	return templateSource{fsys: h.templateFS, comments: h.preserveComments}
+}
+
+// ParseTemplateFile parses the template file at path into an AST without evaluating it
+func ParseTemplateFile(path string) (*ast.RootNode, error) {
+	_, root, err := readTemplateFile(templateSource{}, path)
+	return root, err
+}
+
+// readTemplateFile reads the template file at path from the disk using
+// source and parses its resources into an AST
+func readTemplateFile(source templateSource, path string) (*Template, *ast.RootNode, error) {
+	template, err := source.load(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, fmt.Errorf("failed to read template: %w", err)
+	}
+
+	root, err := ast.ParseTemplateFile(template.Resources, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return template, root, nil
+}
+
+// parseTemplate parses template YAML. A template may span several YAML
+// documents, whose resources are merged in document order and whose notes
+// are joined.
+func parseTemplate(data []byte) (*Template, error) {
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), 4096)
+
+	var documents []Template
+	var notes []string
+	extends := ""
+	for {
+		var document Template
+		if err := decoder.Decode(&document); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, fmt.Errorf("failed to parse template: %w", err)
+		}
+		if document.Notes != "" {
+			notes = append(notes, document.Notes)
+		}
+		if document.Extends != "" {
+			if extends != "" && extends != document.Extends {
+				return nil, fmt.Errorf("template extends both %s and %s", extends, document.Extends)
+			}
+			extends = document.Extends
+		}
+		if document.Resources == nil {
+			continue
+		}
+		documents = append(documents, document)
+	}
+
+	template := &Template{Notes: strings.Join(notes, "\n"), Extends: extends}
+	switch len(documents) {
+	case 0:
+		return template, nil
+	case 1:
+		template.Resources = documents[0].Resources
+		return template, nil
+	}
+
+	merged := []interface{}{}
+	for _, document := range documents {
+		switch v := document.Resources.(type) {
+		case []interface{}:
+			merged = append(merged, v...)
+		default:
+			// A map root holds a single resource or control flow block
+			merged = append(merged, v)
+		}
+	}
+	template.Resources = merged
+
+	return template, nil
+}
+
+// TemplateKind is a kind that has a template in the template directory
+type TemplateKind struct {
+	Kind    string // Lowercase kind, as used in the template filename
+	Version string // API version; empty for a template used by every version
+	Path    string // Template file
+}
+
+// templateVersionPattern matches the version part of <kind>_<version>.yaml
+var templateVersionPattern = regexp.MustCompile(`^v[0-9]+((alpha|beta)[0-9]+)?$`)
+
+// ListTemplates returns the kinds with a template in dir, sorted by kind and
+// version. Only <kind>_<version>.yaml and <kind>_template.yaml files are
+// listed; a bare <kind>.yaml cannot be told apart from other YAML files.
+func ListTemplates(dir string) ([]TemplateKind, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read template directory: %w", err)
 	}
 
-	var template Template
-	if err := yaml.Unmarshal(data, &template); err != nil {
-		return nil, fmt.Errorf("failed to parse template: %w", err)
+	var kinds []TemplateKind
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".yaml") {
+			continue
+		}
+
+		base := strings.TrimSuffix(name, ".yaml")
+		i := strings.LastIndex(base, "_")
+		if i <= 0 {
+			continue
+		}
+		kind, suffix := base[:i], base[i+1:]
+
+		switch {
+		case suffix == "template":
+			kinds = append(kinds, TemplateKind{Kind: kind, Path: filepath.Join(dir, name)})
+		case templateVersionPattern.MatchString(suffix):
+			kinds = append(kinds, TemplateKind{Kind: kind, Version: suffix, Path: filepath.Join(dir, name)})
+		}
 	}
 
-	return &template, nil
+	sort.Slice(kinds, func(i, j int) bool {
+		if kinds[i].Kind != kinds[j].Kind {
+			return kinds[i].Kind < kinds[j].Kind
+		}
+		return kinds[i].Version < kinds[j].Version
+	})
+	return kinds, nil
 }
 
 // findTemplate finds the template file for a given kind and version
@@ -256,22 +943,24 @@ func (h *Hydrator) findTemplate(kind, version string) string {
 	// Expected naming: <kind_lower>_<version>.yaml or <kind_lower>_template.yaml
 	kindLower := strings.ToLower(kind)
 
-	// Try with version first
-	path := filepath.Join(h.templateDir, fmt.Sprintf("%s_%s.yaml", kindLower, version))
-	if _, err := os.Stat(path); err == nil {
-		return path
-	}
-
-	// Try without version
-	path = filepath.Join(h.templateDir, fmt.Sprintf("%s_template.yaml", kindLower))
-	if _, err := os.Stat(path); err == nil {
-		return path
+	candidates := []string{
+		fmt.Sprintf("%s_%s.yaml", kindLower, version), // Try with version first
+		fmt.Sprintf("%s_template.yaml", kindLower),    // Try without version
+		fmt.Sprintf("%s.yaml", kindLower),             // Try exact kind name
 	}
+	for _, name := range candidates {
+		if h.templateFS != nil {
+			name = path.Join(h.templateDir, name)
+			if _, err := fs.Stat(h.templateFS, name); err == nil {
+				return name
+			}
+			continue
+		}
 
-	// Try exact kind name
-	path = filepath.Join(h.templateDir, fmt.Sprintf("%s.yaml", kindLower))
-	if _, err := os.Stat(path); err == nil {
-		return path
+		name = filepath.Join(h.templateDir, name)
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
 	}
 
 	return ""
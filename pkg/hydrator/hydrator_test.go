@@ -1,7 +1,17 @@
 package hydrator
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
+
+	"sigs.k8s.io/yaml"
 )
 
 func TestNewHydrator(t *testing.T) {
@@ -59,6 +69,1438 @@ func containsString(s, substr string) bool {
 	return len(s) >= len(substr) && s[len(s)-len(substr):] == substr
 }
 
+func TestHydrateWithTemplate(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      ports:
+        - "@for(port in .spec.ports)":
+            name: "@expr(port.name)"
+            port: "@expr(port.port)"
+  - "@if(.spec.enableIngress)":
+      apiVersion: networking.k8s.io/v1
+      kind: Ingress
+      metadata:
+        name: "@expr(.metadata.name)"
+      spec:
+        serviceName: $(resource("v1", "Service", "my-app").metadata.name)
+`)
+
+	tests := []struct {
+		name          string
+		enableIngress bool
+		wantKinds     []string
+	}{
+		{
+			name:          "conditional included",
+			enableIngress: true,
+			wantKinds:     []string{"Service", "Ingress"},
+		},
+		{
+			name:          "conditional excluded",
+			enableIngress: false,
+			wantKinds:     []string{"Service"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"name": "my-app"},
+				"spec": map[string]interface{}{
+					"enableIngress": tt.enableIngress,
+					"ports": []interface{}{
+						map[string]interface{}{"name": "http", "port": int64(80)},
+						map[string]interface{}{"name": "https", "port": int64(443)},
+					},
+				},
+			}
+
+			h := NewHydrator("", false)
+			result, err := h.HydrateWithTemplate(instance, template)
+			if err != nil {
+				t.Fatalf("HydrateWithTemplate() error = %v", err)
+			}
+			if len(result.Errors) > 0 {
+				t.Fatalf("HydrateWithTemplate() returned errors: %v", result.Errors)
+			}
+
+			if len(result.Resources) != len(tt.wantKinds) {
+				t.Fatalf("Expected %d resources, got %d", len(tt.wantKinds), len(result.Resources))
+			}
+			for i, kind := range tt.wantKinds {
+				if result.Resources[i]["kind"] != kind {
+					t.Errorf("Expected resource %d kind '%s', got '%v'", i, kind, result.Resources[i]["kind"])
+				}
+			}
+
+			ports := result.Resources[0]["spec"].(map[string]interface{})["ports"].([]interface{})
+			if len(ports) != 2 {
+				t.Fatalf("Expected 2 ports, got %d", len(ports))
+			}
+			if ports[1].(map[string]interface{})["name"] != "https" {
+				t.Errorf("Expected second port 'https', got '%v'", ports[1].(map[string]interface{})["name"])
+			}
+
+			if tt.enableIngress {
+				spec := result.Resources[1]["spec"].(map[string]interface{})
+				if spec["serviceName"] != "my-app" {
+					t.Errorf("Expected serviceName 'my-app', got '%v'", spec["serviceName"])
+				}
+			}
+		})
+	}
+}
+
+func TestHydrateWithTemplateInvalidYAML(t *testing.T) {
+	h := NewHydrator("", false)
+	instance := map[string]interface{}{"kind": "WebService"}
+
+	if _, err := h.HydrateWithTemplate(instance, []byte("resources: [")); err == nil {
+		t.Error("Expected error for invalid template YAML")
+	}
+}
+
+func TestHydrateMultiDocumentTemplate(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "hydrator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	template := `resources:
+  - apiVersion: v1
+    kind: ServiceAccount
+    metadata:
+      name: "@expr(.metadata.name)"
+---
+# Workloads
+resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+---
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "webservice_v1alpha1.yaml"), []byte(template), 0644); err != nil {
+		t.Fatalf("failed to write template: %v", err)
+	}
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+	}
+
+	result, err := NewHydrator(tmpDir, false).Hydrate(instance)
+	if err != nil {
+		t.Fatalf("Hydrate() error = %v", err)
+	}
+
+	var kinds []string
+	for _, resource := range result.Resources {
+		kinds = append(kinds, resource["kind"].(string))
+	}
+	expected := []string{"ServiceAccount", "Deployment", "Service"}
+	if !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected kinds %v, got %v", expected, kinds)
+	}
+}
+
+func TestHydrateMultilineExpression(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      config: "@expr(.spec.config)"
+`)
+
+	tests := []struct {
+		name     string
+		config   string
+		expected string
+	}{
+		{
+			name:   "block scalar",
+			config: "server {\n  listen 80;\n}\n",
+			expected: `  config: |
+    server {
+      listen 80;
+    }
+`,
+		},
+		{
+			// A block scalar cannot hold CRLF line endings or trailing
+			// whitespace, so the value is kept exactly and quoted
+			name:     "CRLF and trailing whitespace",
+			config:   "server {  \r\n  listen 80;\r\n}\r\n",
+			expected: `  config: "server {  \r\n  listen 80;\r\n}\r\n"` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"name": "my-app"},
+				"spec":       map[string]interface{}{"config": tt.config},
+			}
+
+			result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+			if err != nil {
+				t.Fatalf("HydrateWithTemplate() error = %v", err)
+			}
+
+			data := result.Resources[0]["data"].(map[string]interface{})
+			if data["config"] != tt.config {
+				t.Errorf("Expected the expression's value unchanged, got %q", data["config"])
+			}
+
+			out, err := yaml.Marshal(result.Resources[0])
+			if err != nil {
+				t.Fatalf("failed to marshal resource: %v", err)
+			}
+			if !strings.Contains(string(out), tt.expected) {
+				t.Errorf("Expected:\n%s\ngot:\n%s", tt.expected, out)
+			}
+		})
+	}
+}
+
+func TestHydrateExpandGenerateName(t *testing.T) {
+	template := []byte(`resources:
+  - "@for(job in .spec.jobs)":
+      apiVersion: batch/v1
+      kind: Job
+      metadata:
+        generateName: "@expr(.metadata.name + \"-\")"
+      spec:
+        command: "@expr(job)"
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: fixed
+      generateName: ignored-
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "Migration",
+		"metadata":   map[string]interface{}{"name": "migrate"},
+		"spec": map[string]interface{}{
+			"jobs": []interface{}{"up", "seed"},
+		},
+	}
+
+	hydrate := func(expand bool) []map[string]interface{} {
+		h := NewHydrator("", false)
+		h.SetExpandGenerateName(expand)
+		result, err := h.HydrateWithTemplate(instance, template)
+		if err != nil {
+			t.Fatalf("HydrateWithTemplate() error = %v", err)
+		}
+		return result.Resources
+	}
+
+	names := func(resources []map[string]interface{}) []interface{} {
+		var result []interface{}
+		for _, resource := range resources {
+			result = append(result, resource["metadata"].(map[string]interface{})["name"])
+		}
+		return result
+	}
+
+	first := names(hydrate(true))
+	second := names(hydrate(true))
+
+	if len(first) != 3 {
+		t.Fatalf("Expected 3 resources, got %d", len(first))
+	}
+	for i := 0; i < 2; i++ {
+		name, ok := first[i].(string)
+		if !ok || !strings.HasPrefix(name, "migrate-") || len(name) != len("migrate-")+5 {
+			t.Errorf("Expected name 'migrate-' plus a 5 character suffix, got %v", first[i])
+		}
+		if first[i] != second[i] {
+			t.Errorf("Expected stable name, got %v then %v", first[i], second[i])
+		}
+	}
+	if first[0] == first[1] {
+		t.Errorf("Expected distinct names for distinct resources, both got %v", first[0])
+	}
+	if first[2] != "fixed" {
+		t.Errorf("Expected explicit name to be kept, got %v", first[2])
+	}
+
+	// Disabled by default
+	if name := names(hydrate(false))[0]; name != nil {
+		t.Errorf("Expected no name without --expand-generate-name, got %v", name)
+	}
+}
+
+func TestHydrateEscapedSubstitution(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name + \"-scripts\")"
+    data:
+      start.sh: echo "started at $$(date)"
+      probe.sh: curl $(resource("v1", "Service", "my-app").metadata.name) --max-time $$(cat /etc/timeout)
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+	}
+
+	result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("HydrateWithTemplate() returned errors: %v", result.Errors)
+	}
+
+	data := result.Resources[1]["data"].(map[string]interface{})
+	if data["start.sh"] != `echo "started at $(date)"` {
+		t.Errorf("Expected escaped substitution to be emitted literally, got %q", data["start.sh"])
+	}
+	if data["probe.sh"] != "curl my-app --max-time $(cat /etc/timeout)" {
+		t.Errorf("Expected mixed substitution and escape, got %q", data["probe.sh"])
+	}
+}
+
+func TestHydrateConcurrent(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+      labels:
+        tier: "@expr($values.tier)"
+  - "@for(port in .spec.ports)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(.metadata.name + \"-\" + port)"
+      data:
+        service: $(resource("v1", "Service", .metadata.name).metadata.name)
+`)
+
+	h := NewHydrator("", false)
+	h.SetValues(map[string]interface{}{"tier": "backend"})
+
+	const workers = 32
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			name := fmt.Sprintf("app-%d", i)
+			instance := map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"name": name},
+				"spec": map[string]interface{}{
+					"ports": []interface{}{"http", "grpc"},
+				},
+			}
+
+			result, err := h.HydrateWithTemplate(instance, template)
+			if err != nil {
+				t.Errorf("%s: HydrateWithTemplate() error = %v", name, err)
+				return
+			}
+			if len(result.Errors) > 0 || len(result.Resources) != 3 {
+				t.Errorf("%s: expected 3 resources without errors, got %d (%v)", name, len(result.Resources), result.Errors)
+				return
+			}
+
+			labels := result.Resources[0]["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+			if labels["tier"] != "backend" {
+				t.Errorf("%s: expected tier 'backend', got %v", name, labels["tier"])
+			}
+			for j, port := range []string{"http", "grpc"} {
+				resource := result.Resources[j+1]
+				if got := resource["metadata"].(map[string]interface{})["name"]; got != name+"-"+port {
+					t.Errorf("%s: expected ConfigMap %s-%s, got %v", name, name, port, got)
+				}
+				if got := resource["data"].(map[string]interface{})["service"]; got != name {
+					t.Errorf("%s: expected service reference %s, got %v", name, name, got)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+func TestHydrateParallelPass2(t *testing.T) {
+	// Each ConfigMap references the next one, and every fifth references one
+	// that does not exist
+	const count = 50
+	var template strings.Builder
+	template.WriteString("resources:\n")
+	for i := 0; i < count; i++ {
+		next := fmt.Sprintf("cm-%d", (i+1)%count)
+		if i%5 == 0 {
+			next = "missing"
+		}
+		fmt.Fprintf(&template, `  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: cm-%d
+    data:
+      id: "%d"
+      next: $(resource("v1", "ConfigMap", "%s").data.id)
+`, i, i, next)
+	}
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+	}
+
+	hydrate := func(parallelism int) *HydrateResult {
+		t.Helper()
+		h := NewHydrator("", false)
+		if err := h.SetOnUnresolved(UnresolvedBlank, "UNRESOLVED"); err != nil {
+			t.Fatalf("SetOnUnresolved() error = %v", err)
+		}
+		h.SetParallelism(parallelism)
+
+		result, err := h.HydrateWithTemplate(instance, []byte(template.String()))
+		if err != nil {
+			t.Fatalf("parallelism %d: HydrateWithTemplate() error = %v", parallelism, err)
+		}
+		return result
+	}
+
+	sequential := hydrate(0)
+	if len(sequential.Resources) != count || len(sequential.Errors) != count/5 {
+		t.Fatalf("Expected %d resources and %d errors, got %d and %d", count, count/5, len(sequential.Resources), len(sequential.Errors))
+	}
+	for i, resource := range sequential.Resources {
+		want := fmt.Sprintf("%d", (i+1)%count)
+		if i%5 == 0 {
+			want = "UNRESOLVED"
+		}
+		if got := resource["data"].(map[string]interface{})["next"]; got != want {
+			t.Errorf("cm-%d: expected next %s, got %v", i, want, got)
+		}
+	}
+
+	for _, parallelism := range []int{2, 8, count * 2} {
+		parallel := hydrate(parallelism)
+		if !reflect.DeepEqual(parallel.Resources, sequential.Resources) {
+			t.Errorf("parallelism %d: resources differ from sequential resolution", parallelism)
+		}
+		if fmt.Sprint(parallel.Errors) != fmt.Sprint(sequential.Errors) {
+			t.Errorf("parallelism %d: errors differ from sequential resolution:\n%v\n%v", parallelism, parallel.Errors, sequential.Errors)
+		}
+	}
+}
+
+func TestHydrateCoercionOutput(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      ports:
+        - port: "@int(.spec.port)"
+          targetPort: "@expr(.spec.port)"
+      publishNotReadyAddresses: "@bool(.spec.publish)"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec":       map[string]interface{}{"port": "8080", "publish": "yes"},
+	}
+
+	if _, err := NewHydrator("", false).HydrateWithTemplate(instance, template); err == nil {
+		t.Fatal("Expected error coercing 'yes' to bool")
+	}
+
+	instance["spec"].(map[string]interface{})["publish"] = "true"
+	result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+
+	spec := result.Resources[0]["spec"].(map[string]interface{})
+	port := spec["ports"].([]interface{})[0].(map[string]interface{})
+	if port["port"] != int64(8080) {
+		t.Errorf("Expected @int to produce int64 8080, got %v (%T)", port["port"], port["port"])
+	}
+
+	data, err := yaml.Marshal(result.Resources[0])
+	if err != nil {
+		t.Fatalf("yaml.Marshal() error = %v", err)
+	}
+	output := string(data)
+	for _, want := range []string{"- port: 8080\n", `targetPort: "8080"`, "publishNotReadyAddresses: true\n"} {
+		if !strings.Contains(output, want) {
+			t.Errorf("Expected output to contain %q, got:\n%s", want, output)
+		}
+	}
+}
+
+func TestHydrateCommonMetadata(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+      labels:
+        app.kubernetes.io/managed-by: template
+        tier: backend
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name + \"-config\")"
+      annotations:
+        owner: platform
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+	}
+
+	h := NewHydrator("", false)
+	h.SetCommonLabels(map[string]string{
+		"app.kubernetes.io/managed-by": "krm-sdk",
+		"app.kubernetes.io/instance":   "$(.metadata.name)",
+	})
+	h.SetCommonAnnotations(map[string]string{"generated-by": "krm-sdk"})
+
+	result, err := h.HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+
+	metadata := func(i int, field string) map[string]interface{} {
+		return result.Resources[i]["metadata"].(map[string]interface{})[field].(map[string]interface{})
+	}
+
+	serviceLabels := metadata(0, "labels")
+	if serviceLabels["app.kubernetes.io/managed-by"] != "template" {
+		t.Errorf("Expected template label to win, got %v", serviceLabels["app.kubernetes.io/managed-by"])
+	}
+	if serviceLabels["tier"] != "backend" {
+		t.Errorf("Expected template label 'tier' to be kept, got %v", serviceLabels["tier"])
+	}
+	if serviceLabels["app.kubernetes.io/instance"] != "my-app" {
+		t.Errorf("Expected computed instance label 'my-app', got %v", serviceLabels["app.kubernetes.io/instance"])
+	}
+
+	configLabels := metadata(1, "labels")
+	if configLabels["app.kubernetes.io/managed-by"] != "krm-sdk" {
+		t.Errorf("Expected injected label on resource without labels, got %v", configLabels["app.kubernetes.io/managed-by"])
+	}
+
+	configAnnotations := metadata(1, "annotations")
+	if configAnnotations["owner"] != "platform" || configAnnotations["generated-by"] != "krm-sdk" {
+		t.Errorf("Expected merged annotations, got %v", configAnnotations)
+	}
+	if metadata(0, "annotations")["generated-by"] != "krm-sdk" {
+		t.Error("Expected injected annotation on every resource")
+	}
+}
+
+func TestHydrateLabelsFrom(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+      labels:
+        tier: template
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name + \"-config\")"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec": map[string]interface{}{
+			"team": "Payments Core",
+			"tier": "Backend",
+		},
+	}
+
+	h := NewHydrator("", false)
+	if err := h.SetLabelsFrom([]string{".spec.team", ".spec.tier", ".spec.region"}); err != nil {
+		t.Fatalf("SetLabelsFrom() error = %v", err)
+	}
+
+	result, err := h.HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+
+	labels := func(i int) map[string]interface{} {
+		return result.Resources[i]["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+	}
+
+	serviceLabels := labels(0)
+	if serviceLabels["team"] != "payments-core" {
+		t.Errorf("Expected sanitized label team=payments-core, got %v", serviceLabels["team"])
+	}
+	if serviceLabels["tier"] != "template" {
+		t.Errorf("Expected template label 'tier' to win, got %v", serviceLabels["tier"])
+	}
+	if _, ok := serviceLabels["region"]; ok {
+		t.Errorf("Expected absent .spec.region to be skipped, got %v", serviceLabels["region"])
+	}
+
+	configLabels := labels(1)
+	if configLabels["team"] != "payments-core" || configLabels["tier"] != "backend" {
+		t.Errorf("Expected promoted labels team=payments-core and tier=backend, got %v", configLabels)
+	}
+}
+
+func TestSetLabelsFromErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths []string
+	}{
+		{name: "not a path", paths: []string{"team"}},
+		{name: "index in last field", paths: []string{".spec.teams[0]"}},
+		{name: "duplicate label", paths: []string{".spec.team", ".metadata.labels.team"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewHydrator("", false).SetLabelsFrom(tt.paths); err == nil {
+				t.Errorf("SetLabelsFrom(%v) expected error", tt.paths)
+			}
+		})
+	}
+
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: test
+`)
+	instance := map[string]interface{}{
+		"spec": map[string]interface{}{"team": map[string]interface{}{"name": "payments"}},
+	}
+
+	h := NewHydrator("", false)
+	if err := h.SetLabelsFrom([]string{".spec.team"}); err != nil {
+		t.Fatalf("SetLabelsFrom() error = %v", err)
+	}
+	if _, err := h.HydrateWithTemplate(instance, template); err == nil {
+		t.Error("Expected error promoting a map to a label")
+	}
+}
+
+func TestHydrateNotes(t *testing.T) {
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app", "namespace": "shop"},
+		"spec":       map[string]interface{}{"host": "shop.example.com"},
+	}
+
+	tests := []struct {
+		name     string
+		template string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name: "expressions substituted",
+			template: `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+notes: |
+  Your app is available at https://$(.spec.host)
+  Check it with: kubectl -n $(.metadata.namespace) get svc $(.metadata.name)
+`,
+			expected: "Your app is available at https://shop.example.com\nCheck it with: kubectl -n shop get svc my-app\n",
+		},
+		{
+			name: "notes in a separate document",
+			template: `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+---
+notes: "Host: $(upper(.spec.host))"
+`,
+			expected: "Host: SHOP.EXAMPLE.COM",
+		},
+		{
+			name: "no notes",
+			template: `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+		},
+		{
+			name: "invalid expression",
+			template: `resources: []
+notes: "Host: $(.spec.missing)"
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := NewHydrator("", false).HydrateWithTemplate(instance, []byte(tt.template))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HydrateWithTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && result.Notes != tt.expected {
+				t.Errorf("Notes = %q, want %q", result.Notes, tt.expected)
+			}
+		})
+	}
+}
+
 // Note: Full hydration testing is done in integration tests
 // (test/integration/*_test.go) and real-world scenario tests
 // (examples/iks-airv2/scripts/test_all_examples.sh)
+
+func TestHydrateInstanceTransforms(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      replicas: "@expr(.spec.replicas)"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec":       map[string]interface{}{},
+	}
+
+	h := NewHydrator("", false)
+	h.AddInstanceTransform(func(instance map[string]interface{}) (map[string]interface{}, error) {
+		instance["spec"].(map[string]interface{})["replicas"] = int64(2)
+		return instance, nil
+	})
+	h.AddInstanceTransform(func(instance map[string]interface{}) (map[string]interface{}, error) {
+		spec := instance["spec"].(map[string]interface{})
+		spec["replicas"] = spec["replicas"].(int64) * 3
+		return instance, nil
+	})
+
+	result, err := h.HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+
+	spec := result.Resources[0]["spec"].(map[string]interface{})
+	if spec["replicas"] != int64(6) {
+		t.Errorf("Expected replicas 6 from chained transforms, got %v", spec["replicas"])
+	}
+
+	h.AddInstanceTransform(func(instance map[string]interface{}) (map[string]interface{}, error) {
+		return nil, fmt.Errorf("boom")
+	})
+	if _, err := h.HydrateWithTemplate(instance, template); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("Expected transform error, got %v", err)
+	}
+}
+
+func TestHydrateRaw(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      "@raw":
+        name: "$(.metadata.name)"
+        service: "$(resource(\"v1\", \"Service\", \"my-app\").metadata.name)"
+        escaped: "$$(literal)"
+        inlineIf: "$if(.metadata.name, \"a\", \"b\") $(date)"
+        escapedIf: "$$if(literal)"
+        expr: "@expr(.spec.port)"
+        items:
+          - "@for(item in .spec.items)": "$(item)"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+	}
+
+	result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Unexpected hydration errors: %v", result.Errors)
+	}
+
+	expected := map[string]interface{}{
+		"name":      "$(.metadata.name)",
+		"service":   `$(resource("v1", "Service", "my-app").metadata.name)`,
+		"escaped":   "$$(literal)",
+		"inlineIf":  `$if(.metadata.name, "a", "b") $(date)`,
+		"escapedIf": "$$if(literal)",
+		"expr":      "@expr(.spec.port)",
+		"items": []interface{}{
+			map[string]interface{}{"@for(item in .spec.items)": "$(item)"},
+		},
+	}
+	if data := result.Resources[0]["data"]; !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected raw data %v, got %v", expected, data)
+	}
+}
+
+func TestHydrateSpliceStructuredExpr(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      template: "@expr(.spec.podTemplate)"
+      ports: "@expr(.spec.ports)"
+      first: "@expr(.spec.ports[0])"
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+    spec:
+      ports: "@expr(.spec.ports)"
+`)
+
+	podTemplate := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]interface{}{"app": "my-app"},
+		},
+		"spec": map[string]interface{}{
+			"containers": []interface{}{
+				map[string]interface{}{
+					"name":  "app",
+					"image": "nginx",
+					// Kubernetes' own escape syntax must survive untouched
+					"args": []interface{}{"--home=$(HOME)", "--literal=$$(HOME)", "@expr(.metadata.name)"},
+				},
+			},
+		},
+	}
+	ports := []interface{}{
+		map[string]interface{}{"name": "http", "port": int64(80)},
+		map[string]interface{}{"name": "ref", "port": `$(resource("v1", "Service", "my-app").metadata.name)`},
+	}
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec": map[string]interface{}{
+			"podTemplate": podTemplate,
+			"ports":       ports,
+		},
+	}
+
+	result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Unexpected hydration errors: %v", result.Errors)
+	}
+	if len(result.Resources) != 2 {
+		t.Fatalf("Expected 2 resources, got %d", len(result.Resources))
+	}
+
+	// Spliced values are copied verbatim, without evaluating strings inside them
+	spec := result.Resources[0]["spec"].(map[string]interface{})
+	if !reflect.DeepEqual(spec["template"], podTemplate) {
+		t.Errorf("Expected spliced pod template %v, got %v", podTemplate, spec["template"])
+	}
+	if !reflect.DeepEqual(spec["ports"], ports) {
+		t.Errorf("Expected spliced ports %v, got %v", ports, spec["ports"])
+	}
+	if !reflect.DeepEqual(spec["first"], ports[0]) {
+		t.Errorf("Expected spliced port %v, got %v", ports[0], spec["first"])
+	}
+
+	// Each resource gets its own copy, so changing one leaves the other and the instance alone
+	spec["ports"].([]interface{})[0].(map[string]interface{})["port"] = int64(8080)
+	servicePorts := result.Resources[1]["spec"].(map[string]interface{})["ports"].([]interface{})
+	if servicePorts[0].(map[string]interface{})["port"] != int64(80) {
+		t.Errorf("Expected Service ports to be independent of the Deployment's, got %v", servicePorts)
+	}
+	if ports[0].(map[string]interface{})["port"] != int64(80) {
+		t.Errorf("Expected instance ports to be unchanged, got %v", ports)
+	}
+}
+
+func TestHydrateResourceRefWithLoopVariable(t *testing.T) {
+	template := []byte(`resources:
+  - "@for(svc in .spec.services)":
+      apiVersion: v1
+      kind: Service
+      metadata:
+        name: "@expr(svc.name)"
+      spec:
+        clusterIP: "@expr(svc.ip)"
+  - "@for(svc in .spec.services)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(svc.name + \"-endpoint\")"
+      data:
+        endpoint: $(resource("v1", "Service", svc.name).spec.clusterIP):$(svc.port)
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec": map[string]interface{}{
+			"services": []interface{}{
+				map[string]interface{}{"name": "web", "ip": "10.0.0.1", "port": int64(80)},
+				map[string]interface{}{"name": "api", "ip": "10.0.0.2", "port": int64(8080)},
+			},
+		},
+	}
+
+	result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("Expected no pass 2 errors, got %v", result.Errors)
+	}
+
+	for i, want := range []string{"10.0.0.1:80", "10.0.0.2:8080"} {
+		data := result.Resources[i+2]["data"].(map[string]interface{})
+		if data["endpoint"] != want {
+			t.Errorf("ConfigMap %d: endpoint = %v, want %s", i, data["endpoint"], want)
+		}
+	}
+}
+
+func TestHydrateAbsentLoopList(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+  - "@for(svc in .spec.services)":
+      apiVersion: v1
+      kind: Service
+      metadata:
+        name: "@expr(svc.name)"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec":       map[string]interface{}{},
+	}
+
+	h := NewHydrator("", false)
+	result, err := h.HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Resources) != 1 || result.Resources[0]["kind"] != "ConfigMap" {
+		t.Errorf("Expected only the ConfigMap, got %v", result.Resources)
+	}
+
+	h.SetStrictLoops(true)
+	if _, err := h.HydrateWithTemplate(instance, template); err == nil {
+		t.Error("Expected an error for an absent list with strict loops")
+	}
+}
+
+func TestHydrateNilMissingFields(t *testing.T) {
+	template := []byte(`resources:
+  - "@for({name, port} in .spec.services)":
+      apiVersion: v1
+      kind: Service
+      metadata:
+        name: "@expr(name)"
+      spec:
+        ports:
+          - port: "@expr(default(port, 80))"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec": map[string]interface{}{
+			"services": []interface{}{map[string]interface{}{"name": "web"}},
+		},
+	}
+
+	h := NewHydrator("", false)
+	if _, err := h.HydrateWithTemplate(instance, template); err == nil {
+		t.Error("Expected an error for a missing destructured field")
+	}
+
+	h.SetNilMissingFields(true)
+	result, err := h.HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	ports := result.Resources[0]["spec"].(map[string]interface{})["ports"].([]interface{})
+	if port := ports[0].(map[string]interface{})["port"]; port != int64(80) {
+		t.Errorf("port = %v (%T), want 80", port, port)
+	}
+}
+
+func TestHydrateVerboseTrace(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+	}
+
+	h := NewHydrator("", true)
+	if h.stderr != os.Stderr {
+		t.Errorf("Expected verbose output to default to os.Stderr, got %v", h.stderr)
+	}
+
+	var out strings.Builder
+	h.SetVerboseOutput(&out)
+	if _, err := h.HydrateWithTemplate(instance, template); err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "  .metadata.name -> my-app\n") {
+		t.Errorf("Expected the expression trace in the verbose output, got:\n%s", out.String())
+	}
+}
+
+func TestHydrateOnUnresolved(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      endpoint: $(resource("v1", "Service", "missing").spec.clusterIP):$(.spec.port)
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec":       map[string]interface{}{"port": int64(80)},
+	}
+
+	tests := []struct {
+		name         string
+		mode         string
+		placeholder  string
+		wantErr      bool
+		wantEndpoint string
+	}{
+		{
+			name:         "keep",
+			mode:         UnresolvedKeep,
+			wantEndpoint: `$(resource("v1", "Service", "missing").spec.clusterIP):$(.spec.port)`,
+		},
+		{
+			name:         "default is keep",
+			mode:         "",
+			wantEndpoint: `$(resource("v1", "Service", "missing").spec.clusterIP):$(.spec.port)`,
+		},
+		{
+			name:         "blank",
+			mode:         UnresolvedBlank,
+			wantEndpoint: ":80",
+		},
+		{
+			name:         "blank with placeholder",
+			mode:         UnresolvedBlank,
+			placeholder:  "UNRESOLVED",
+			wantEndpoint: "UNRESOLVED:80",
+		},
+		{
+			name:    "error",
+			mode:    UnresolvedError,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHydrator("", false)
+			if err := h.SetOnUnresolved(tt.mode, tt.placeholder); err != nil {
+				t.Fatalf("SetOnUnresolved() error = %v", err)
+			}
+
+			result, err := h.HydrateWithTemplate(instance, template)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "missing") {
+					t.Errorf("Expected error naming the missing resource, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HydrateWithTemplate() error = %v", err)
+			}
+
+			if len(result.Errors) != 1 {
+				t.Errorf("Expected 1 reported error, got %v", result.Errors)
+			}
+			data := result.Resources[0]["data"].(map[string]interface{})
+			if data["endpoint"] != tt.wantEndpoint {
+				t.Errorf("endpoint = %v, want %s", data["endpoint"], tt.wantEndpoint)
+			}
+		})
+	}
+
+	if err := NewHydrator("", false).SetOnUnresolved("ignore", ""); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestHydrateTemplateFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"api/v1/webservice_v1.yaml": &fstest.MapFile{Data: []byte(`resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+  - "@import(shared/config.yaml)"
+`)},
+		"api/v1/shared/config.yaml": &fstest.MapFile{Data: []byte(`resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name + \"-config\")"
+`)},
+		"api/v1/database_template.yaml": &fstest.MapFile{Data: []byte(`resources:
+  - apiVersion: apps/v1
+    kind: StatefulSet
+    metadata:
+      name: "@expr(.metadata.name)"
+`)},
+	}
+
+	h := NewHydrator("api/v1", false)
+	h.SetTemplateFS(fsys)
+
+	tests := []struct {
+		kind      string
+		wantPath  string
+		wantKinds []string
+	}{
+		{"WebService", "api/v1/webservice_v1.yaml", []string{"Service", "ConfigMap"}},
+		{"Database", "api/v1/database_template.yaml", []string{"StatefulSet"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			instance := map[string]interface{}{
+				"apiVersion": "platform.example.com/v1",
+				"kind":       tt.kind,
+				"metadata":   map[string]interface{}{"name": "my-app"},
+			}
+
+			path, err := h.TemplatePath(instance)
+			if err != nil {
+				t.Fatalf("TemplatePath() error = %v", err)
+			}
+			if path != tt.wantPath {
+				t.Errorf("TemplatePath() = %s, want %s", path, tt.wantPath)
+			}
+
+			result, err := h.Hydrate(instance)
+			if err != nil {
+				t.Fatalf("Hydrate() error = %v", err)
+			}
+			var kinds []string
+			for _, resource := range result.Resources {
+				kinds = append(kinds, resource["kind"].(string))
+			}
+			if !reflect.DeepEqual(kinds, tt.wantKinds) {
+				t.Errorf("Expected kinds %v, got %v", tt.wantKinds, kinds)
+			}
+		})
+	}
+
+	// Kinds without a template in the filesystem fail
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1",
+		"kind":       "Cache",
+	}
+	if _, err := h.Hydrate(instance); err == nil {
+		t.Error("Expected an error for a kind without a template in the filesystem")
+	}
+}
+
+func TestHydrateClock(t *testing.T) {
+	template := []byte(`resources:
+  - "@for(name in .spec.names)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(name)"
+        annotations:
+          generatedAt: "@expr(now())"
+          date: "@expr(now(\"2006-01-02\"))"
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "my-app"},
+		"spec":       map[string]interface{}{"names": []interface{}{"a", "b"}},
+	}
+
+	h := NewHydrator("", false)
+	h.SetClock(func() time.Time { return time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC) })
+
+	for run := 0; run < 2; run++ {
+		result, err := h.HydrateWithTemplate(instance, template)
+		if err != nil {
+			t.Fatalf("HydrateWithTemplate() error = %v", err)
+		}
+		for _, resource := range result.Resources {
+			annotations := resource["metadata"].(map[string]interface{})["annotations"].(map[string]interface{})
+			if annotations["generatedAt"] != "2024-01-02T15:04:05Z" || annotations["date"] != "2024-01-02" {
+				t.Errorf("Expected the frozen time, got %v", annotations)
+			}
+		}
+	}
+}
+
+func TestHydrateConditionalResourceList(t *testing.T) {
+	template := []byte(`resources:
+  "@if(.spec.monitoring)":
+    - apiVersion: v1
+      kind: Service
+      metadata:
+        name: "@expr(.metadata.name + \"-metrics\")"
+      spec:
+        clusterIP: 10.0.0.9
+    - apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(.metadata.name + \"-scrape\")"
+      data:
+        target: $(resource("v1", "Service", "my-app-metrics").spec.clusterIP):9090
+    - apiVersion: monitoring.coreos.com/v1
+      kind: ServiceMonitor
+      metadata:
+        name: "@expr(.metadata.name)"
+`)
+
+	for _, monitoring := range []bool{true, false} {
+		instance := map[string]interface{}{
+			"apiVersion": "platform.example.com/v1alpha1",
+			"kind":       "WebService",
+			"metadata":   map[string]interface{}{"name": "my-app"},
+			"spec":       map[string]interface{}{"monitoring": monitoring},
+		}
+
+		result, err := NewHydrator("", false).HydrateWithTemplate(instance, template)
+		if err != nil {
+			t.Fatalf("HydrateWithTemplate() error = %v", err)
+		}
+		if len(result.Errors) > 0 {
+			t.Fatalf("Expected no pass 2 errors, got %v", result.Errors)
+		}
+
+		if !monitoring {
+			if len(result.Resources) != 0 {
+				t.Errorf("Expected no resources when disabled, got %v", result.Resources)
+			}
+			continue
+		}
+		if len(result.Resources) != 3 {
+			t.Fatalf("Expected all 3 resources when enabled, got %d", len(result.Resources))
+		}
+		data := result.Resources[1]["data"].(map[string]interface{})
+		if data["target"] != "10.0.0.9:9090" {
+			t.Errorf("Expected the reference within the gated list to resolve, got %v", data["target"])
+		}
+	}
+}
+
+func TestHydrateFileFunction(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "hydrator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	nginxConf := "server {\n  listen 8080;\n  location / {\n    proxy_pass http://backend;\n  }\n}\n"
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      nginx.conf: "@expr(file(\"files/nginx.conf\"))"
+`
+	files := map[string]string{
+		"proxy_v1alpha1.yaml":  template,
+		"files/nginx.conf":     nginxConf,
+		"escape_v1alpha1.yaml": strings.Replace(template, "files/nginx.conf", "../outside.conf", 1),
+	}
+	templateDir := filepath.Join(tempDir, "templates")
+	for name, content := range files {
+		path := filepath.Join(templateDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "outside.conf"), []byte("secret"), 0644); err != nil {
+		t.Fatalf("failed to write outside.conf: %v", err)
+	}
+
+	instance := func(kind string) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "platform.example.com/v1alpha1",
+			"kind":       kind,
+			"metadata":   map[string]interface{}{"name": "proxy"},
+		}
+	}
+
+	result, err := NewHydrator(templateDir, false).Hydrate(instance("Proxy"))
+	if err != nil {
+		t.Fatalf("Hydrate() error = %v", err)
+	}
+	data := result.Resources[0]["data"].(map[string]interface{})
+	if data["nginx.conf"] != nginxConf {
+		t.Errorf("Expected the file's contents in the ConfigMap, got %q", data["nginx.conf"])
+	}
+
+	// The path is relative to the template directory, not the working directory
+	t.Chdir(tempDir)
+	if _, err := NewHydrator("templates", false).Hydrate(instance("Proxy")); err != nil {
+		t.Errorf("Hydrate() with a relative template directory error = %v", err)
+	}
+
+	if _, err := NewHydrator(templateDir, false).Hydrate(instance("Escape")); err == nil || !strings.Contains(err.Error(), "outside the template directory") {
+		t.Errorf("Expected error for a file outside the template directory, got %v", err)
+	}
+
+	// Templates read from a filesystem read files from it too
+	h := NewHydrator("templates", false)
+	h.SetTemplateFS(fstest.MapFS{
+		"templates/proxy_v1alpha1.yaml": {Data: []byte(template)},
+		"templates/files/nginx.conf":    {Data: []byte(nginxConf)},
+	})
+	result, err = h.Hydrate(instance("Proxy"))
+	if err != nil {
+		t.Fatalf("Hydrate() from a filesystem error = %v", err)
+	}
+	if data := result.Resources[0]["data"].(map[string]interface{}); data["nginx.conf"] != nginxConf {
+		t.Errorf("Expected the file's contents from the filesystem, got %q", data["nginx.conf"])
+	}
+}
+
+func TestHydrateResultFiles(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "hydrator-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string]string{
+		"webservice_v1alpha1.yaml": "extends: base.yaml\nresources:\n  - \"@import(parts/service.yaml)\"\n",
+		"base.yaml": `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: "@expr(.metadata.name)"
+    data:
+      config: "@expr(file(\"files/config.txt\"))"
+`,
+		"parts/service.yaml": "resources:\n  - \"@import(ports.yaml)\"\n",
+		"parts/ports.yaml": `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: "@expr(.metadata.name)"
+`,
+		"files/config.txt": "key=value\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+
+	result, err := NewHydrator(tempDir, false).Hydrate(map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "web"},
+	})
+	if err != nil {
+		t.Fatalf("Hydrate() error = %v", err)
+	}
+
+	want := []string{
+		filepath.Join(tempDir, "webservice_v1alpha1.yaml"),
+		filepath.Join(tempDir, "base.yaml"),
+		filepath.Join(tempDir, "parts/service.yaml"),
+		filepath.Join(tempDir, "parts/ports.yaml"),
+		filepath.Join(tempDir, "files/config.txt"),
+	}
+	if !reflect.DeepEqual(result.Files, want) {
+		t.Errorf("Files = %v, want %v", result.Files, want)
+	}
+}
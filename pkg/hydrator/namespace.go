@@ -0,0 +1,80 @@
+package hydrator
+
+// DefaultClusterScopedKinds lists the built-in Kubernetes kinds that are not
+// namespaced, so a namespace is never injected into them
+var DefaultClusterScopedKinds = []string{
+	"APIService",
+	"CertificateSigningRequest",
+	"ClusterRole",
+	"ClusterRoleBinding",
+	"CSIDriver",
+	"CSINode",
+	"CustomResourceDefinition",
+	"IngressClass",
+	"MutatingWebhookConfiguration",
+	"Namespace",
+	"Node",
+	"PersistentVolume",
+	"PriorityClass",
+	"RuntimeClass",
+	"StorageClass",
+	"ValidatingAdmissionPolicy",
+	"ValidatingAdmissionPolicyBinding",
+	"ValidatingWebhookConfiguration",
+	"VolumeAttachment",
+}
+
+// SetNamespace sets the namespace given to every generated namespaced resource
+// that does not set one; empty leaves namespaces unchanged
+func (h *Hydrator) SetNamespace(namespace string) {
+	h.namespace = namespace
+}
+
+// AddClusterScopedKinds marks kinds, such as custom cluster-scoped resources,
+// as not namespaced in addition to DefaultClusterScopedKinds
+func (h *Hydrator) AddClusterScopedKinds(kinds ...string) {
+	if h.clusterScopedKinds == nil {
+		h.clusterScopedKinds = map[string]bool{}
+	}
+	for _, kind := range kinds {
+		h.clusterScopedKinds[kind] = true
+	}
+}
+
+// IsClusterScoped reports whether resources of kind are not namespaced
+func (h *Hydrator) IsClusterScoped(kind string) bool {
+	if h.clusterScopedKinds[kind] {
+		return true
+	}
+	for _, clusterScoped := range DefaultClusterScopedKinds {
+		if kind == clusterScoped {
+			return true
+		}
+	}
+	return false
+}
+
+// applyNamespace sets metadata.namespace on every namespaced resource without
+// one, leaving cluster-scoped resources namespace-free
+func (h *Hydrator) applyNamespace(resources []map[string]interface{}) {
+	if h.namespace == "" {
+		return
+	}
+
+	for _, resource := range resources {
+		kind, _ := resource["kind"].(string)
+		if h.IsClusterScoped(kind) {
+			continue
+		}
+
+		metadata, ok := resource["metadata"].(map[string]interface{})
+		if !ok {
+			metadata = map[string]interface{}{}
+			resource["metadata"] = metadata
+		}
+		if namespace, ok := metadata["namespace"].(string); ok && namespace != "" {
+			continue
+		}
+		metadata["namespace"] = h.namespace
+	}
+}
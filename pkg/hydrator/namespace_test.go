@@ -0,0 +1,90 @@
+package hydrator
+
+import "testing"
+
+func TestHydrateNamespace(t *testing.T) {
+	template := []byte(`resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: web
+  - apiVersion: rbac.authorization.k8s.io/v1
+    kind: ClusterRoleBinding
+    metadata:
+      name: web-reader
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: web-config
+      namespace: shared
+    data:
+      deployment: '$(resource("apps/v1", "Deployment", "web").metadata.name)'
+  - apiVersion: example.com/v1
+    kind: ClusterWidget
+    metadata:
+      name: widget
+`)
+
+	instance := map[string]interface{}{
+		"apiVersion": "platform.example.com/v1alpha1",
+		"kind":       "WebService",
+		"metadata":   map[string]interface{}{"name": "web"},
+	}
+
+	h := NewHydrator("", false)
+	h.SetNamespace("prod")
+	h.AddClusterScopedKinds("ClusterWidget")
+
+	result, err := h.HydrateWithTemplate(instance, template)
+	if err != nil {
+		t.Fatalf("HydrateWithTemplate() error = %v", err)
+	}
+	if len(result.Errors) > 0 {
+		t.Fatalf("HydrateWithTemplate() resolution errors = %v", result.Errors)
+	}
+
+	tests := []struct {
+		kind          string
+		wantNamespace interface{}
+	}{
+		{kind: "Deployment", wantNamespace: "prod"},
+		{kind: "ClusterRoleBinding", wantNamespace: nil},
+		{kind: "ConfigMap", wantNamespace: "shared"},
+		{kind: "ClusterWidget", wantNamespace: nil},
+	}
+
+	for i, tt := range tests {
+		metadata := result.Resources[i]["metadata"].(map[string]interface{})
+		if metadata["namespace"] != tt.wantNamespace {
+			t.Errorf("%s namespace = %v, want %v", tt.kind, metadata["namespace"], tt.wantNamespace)
+		}
+	}
+
+	data := result.Resources[2]["data"].(map[string]interface{})
+	if data["deployment"] != "web" {
+		t.Errorf("Expected reference to resolve, got %v", data["deployment"])
+	}
+}
+
+func TestIsClusterScoped(t *testing.T) {
+	h := NewHydrator("", false)
+	h.AddClusterScopedKinds("ClusterIssuer")
+
+	tests := []struct {
+		kind string
+		want bool
+	}{
+		{kind: "Namespace", want: true},
+		{kind: "ClusterRole", want: true},
+		{kind: "CustomResourceDefinition", want: true},
+		{kind: "ClusterIssuer", want: true},
+		{kind: "Deployment", want: false},
+		{kind: "RoleBinding", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := h.IsClusterScoped(tt.kind); got != tt.want {
+			t.Errorf("IsClusterScoped(%q) = %v, want %v", tt.kind, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package hydrator
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Phases recorded by the hydrator when profiling
+const (
+	PhaseTemplateParse = "template parse"
+	PhasePass1         = "pass 1"
+	PhasePass2         = "pass 2"
+)
+
+// Profile accumulates the time spent in each phase of generation. It is safe
+// for concurrent use, and a nil Profile records nothing.
+type Profile struct {
+	mu     sync.Mutex
+	phases []string
+	totals map[string]time.Duration
+	counts map[string]int
+}
+
+// NewProfile creates an empty profile
+func NewProfile() *Profile {
+	return &Profile{
+		totals: make(map[string]time.Duration),
+		counts: make(map[string]int),
+	}
+}
+
+// Track adds the time elapsed since start to phase
+func (p *Profile) Track(phase string, start time.Time) {
+	if p == nil {
+		return
+	}
+	elapsed := time.Since(start)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.totals[phase]; !ok {
+		p.phases = append(p.phases, phase)
+	}
+	p.totals[phase] += elapsed
+	p.counts[phase]++
+}
+
+// Write prints the total time and number of runs of each phase, in the order
+// the phases were first recorded
+func (p *Profile) Write(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, err := fmt.Fprintln(w, "Profile:"); err != nil {
+		return err
+	}
+	for _, phase := range p.phases {
+		if _, err := fmt.Fprintf(w, "  %-16s %12s  (%d)\n", phase, p.totals[phase].Round(time.Microsecond), p.counts[phase]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
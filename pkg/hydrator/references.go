@@ -0,0 +1,98 @@
+package hydrator
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/ast"
+)
+
+// DanglingReferences statically checks the resource() references in a parsed
+// template and returns a warning for each one whose apiVersion and kind no
+// resource in the template creates, which usually means a typo. Nothing is
+// reported when a resource sets its kind with an expression, since the kinds
+// the template creates are then unknown.
+func DanglingReferences(root *ast.RootNode) []string {
+	created, ok := createdKinds(root)
+	if !ok {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	var warnings []string
+	ast.Inspect(root, func(node ast.Node) bool {
+		literal, ok := node.(*ast.LiteralNode)
+		if !ok {
+			return true
+		}
+		value, ok := literal.Value.(string)
+		if !ok {
+			return true
+		}
+
+		for _, key := range extractResourceRefsFromString(value) {
+			// Keys are apiVersion/kind/name, and the apiVersion may contain a slash
+			parts := strings.Split(key, "/")
+			if len(parts) < 3 || seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			apiVersion := strings.Join(parts[:len(parts)-2], "/")
+			kind := parts[len(parts)-2]
+			if created[apiVersion+"/"+kind] || created["*/"+kind] {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("reference to %s: the template creates no %s with apiVersion %s", key, kind, apiVersion))
+		}
+		return true
+	})
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// createdKinds returns the apiVersion/kind of every resource in the template,
+// with "*" for an apiVersion set by an expression. It reports false if a
+// resource sets its kind with an expression.
+func createdKinds(root *ast.RootNode) (map[string]bool, bool) {
+	created := map[string]bool{}
+	dynamic := false
+
+	ast.Inspect(root, func(node ast.Node) bool {
+		resource, ok := node.(*ast.MapNode)
+		if !ok {
+			return true // Control flow and lists of resources
+		}
+		if _, ok := resource.Fields["kind"]; !ok {
+			return false
+		}
+
+		kind, ok := literalString(resource.Fields["kind"])
+		if !ok {
+			dynamic = true
+			return false
+		}
+		apiVersion, ok := literalString(resource.Fields["apiVersion"])
+		if !ok {
+			apiVersion = "*"
+		}
+		created[apiVersion+"/"+kind] = true
+
+		// Resources are not searched for nested resources
+		return false
+	})
+
+	return created, !dynamic
+}
+
+// literalString returns the value of a literal string node
+func literalString(node ast.Node) (string, bool) {
+	literal, ok := node.(*ast.LiteralNode)
+	if !ok {
+		return "", false
+	}
+	value, ok := literal.Value.(string)
+	return value, ok
+}
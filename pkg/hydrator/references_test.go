@@ -0,0 +1,136 @@
+package hydrator
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/ast"
+	"sigs.k8s.io/yaml"
+)
+
+func TestDanglingReferences(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     []string
+	}{
+		{
+			name: "reference to a created kind",
+			template: `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: web
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: config
+    data:
+      ip: $(resource("v1", "Service", "web").spec.clusterIP)
+`,
+		},
+		{
+			name: "kind never created",
+			template: `resources:
+  - apiVersion: v1
+    kind: Service
+    metadata:
+      name: web
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: config
+    data:
+      ip: $(resource("v1", "Sevice", "web").spec.clusterIP)
+      replicas: $(resource("apps/v1", "Deployment", .metadata.name).spec.replicas)
+`,
+			want: []string{
+				"reference to apps/v1/Deployment/*: the template creates no Deployment with apiVersion apps/v1",
+				"reference to v1/Sevice/web: the template creates no Sevice with apiVersion v1",
+			},
+		},
+		{
+			name: "wrong apiVersion",
+			template: `resources:
+  - apiVersion: apps/v1
+    kind: Deployment
+    metadata:
+      name: web
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: config
+    data:
+      replicas: $(resource("v1", "Deployment", "web").spec.replicas)
+`,
+			want: []string{"reference to v1/Deployment/web: the template creates no Deployment with apiVersion v1"},
+		},
+		{
+			name: "resources created in control flow",
+			template: `resources:
+  - "@if(.spec.enabled)":
+      - "@for(svc in .spec.services)":
+          apiVersion: v1
+          kind: Service
+          metadata:
+            name: "@expr(svc.name)"
+  - "@for(svc in .spec.services)":
+      apiVersion: v1
+      kind: ConfigMap
+      metadata:
+        name: "@expr(svc.name)"
+      data:
+        ip: $(resource("v1", "Service", svc.name).spec.clusterIP)
+`,
+		},
+		{
+			name: "kinds nested in a resource are not created",
+			template: `resources:
+  - apiVersion: autoscaling/v2
+    kind: HorizontalPodAutoscaler
+    metadata:
+      name: web
+    spec:
+      scaleTargetRef:
+        apiVersion: apps/v1
+        kind: Deployment
+        name: web
+      minReplicas: $(resource("apps/v1", "Deployment", "web").spec.replicas)
+`,
+			want: []string{"reference to apps/v1/Deployment/web: the template creates no Deployment with apiVersion apps/v1"},
+		},
+		{
+			name: "kind set by an expression",
+			template: `resources:
+  - apiVersion: v1
+    kind: "@expr(.spec.kind)"
+    metadata:
+      name: web
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: config
+    data:
+      ip: $(resource("v1", "Service", "web").spec.clusterIP)
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var template Template
+			if err := yaml.Unmarshal([]byte(tt.template), &template); err != nil {
+				t.Fatalf("failed to parse template: %v", err)
+			}
+			root, err := ast.ParseTemplate(template.Resources)
+			if err != nil {
+				t.Fatalf("ParseTemplate() error = %v", err)
+			}
+
+			got := DanglingReferences(root)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DanglingReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package overlay
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -18,6 +19,19 @@ type KustomizeEngine struct {
 	overlayDir string
 	verbose    bool
 	fs         filesys.FileSystem
+
+	// baseNamespace and baseLabels are written into the base kustomization
+	// so that every overlay inherits them
+	baseNamespace string
+	baseLabels    map[string]string
+
+	// createdBase is set once WriteBase has created baseDir, so Cleanup never
+	// removes a directory the engine did not create
+	createdBase bool
+
+	// persistentBase lets WriteBase write into an existing baseDir, which
+	// Cleanup keeps
+	persistentBase bool
 }
 
 // NewKustomizeEngine creates a new kustomize engine
@@ -30,11 +44,44 @@ func NewKustomizeEngine(baseDir, overlayDir string, verbose bool) *KustomizeEngi
 	}
 }
 
-// WriteBase writes generated resources to base/ with kustomization.yaml
+// SetBaseNamespace sets the namespace the base kustomization applies to
+// every resource; empty leaves namespaces unchanged
+func (k *KustomizeEngine) SetBaseNamespace(namespace string) {
+	k.baseNamespace = namespace
+}
+
+// SetBaseLabels sets labels the base kustomization adds to every resource's
+// metadata. Selectors are left alone, since they are immutable on workloads.
+func (k *KustomizeEngine) SetBaseLabels(labels map[string]string) {
+	k.baseLabels = labels
+}
+
+// SetPersistentBase keeps the base written by WriteBase for later runs: it
+// may then be written into an existing directory and Cleanup leaves it
+func (k *KustomizeEngine) SetPersistentBase(persistent bool) {
+	k.persistentBase = persistent
+}
+
+// WriteBase writes generated resources to base/ with kustomization.yaml.
+// Unless the base is persistent, it refuses to write into a base directory
+// that already exists, so that resources are never mixed into, or cleaned up
+// with, a user's own files.
 func (k *KustomizeEngine) WriteBase(resources []map[string]interface{}) error {
 	// Create base directory
-	if err := os.MkdirAll(k.baseDir, 0755); err != nil {
-		return fmt.Errorf("failed to create base directory: %w", err)
+	if k.persistentBase {
+		if err := os.MkdirAll(k.baseDir, 0755); err != nil {
+			return fmt.Errorf("failed to create base directory: %w", err)
+		}
+	} else if !k.createdBase {
+		if _, err := os.Stat(k.baseDir); err == nil {
+			return fmt.Errorf("base directory %s already exists; remove it or use a different base directory", k.baseDir)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check base directory: %w", err)
+		}
+		if err := os.MkdirAll(k.baseDir, 0755); err != nil {
+			return fmt.Errorf("failed to create base directory: %w", err)
+		}
+		k.createdBase = true
 	}
 
 	if k.verbose {
@@ -75,6 +122,65 @@ func (k *KustomizeEngine) WriteBase(resources []map[string]interface{}) error {
 	return nil
 }
 
+// CheckOverlays returns an error if the overlay of any name already exists
+// under the overlay directory, so callers can fail before writing anything
+func (k *KustomizeEngine) CheckOverlays(names ...string) error {
+	for _, name := range names {
+		path := filepath.Join(k.overlayDir, name, "kustomization.yaml")
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("overlay %s already exists", path)
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to check overlay %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// WriteOverlays scaffolds an empty overlay for each name under the overlay
+// directory, with a kustomization.yaml that references the base. Existing
+// overlays are never overwritten: if any overlay exists, none are written.
+func (k *KustomizeEngine) WriteOverlays(names ...string) error {
+	if err := k.CheckOverlays(names...); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		dir := filepath.Join(k.overlayDir, name)
+		path := filepath.Join(dir, "kustomization.yaml")
+
+		base, err := filepath.Rel(dir, k.baseDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve base for overlay %s: %w", name, err)
+		}
+
+		kustomization := types.Kustomization{
+			TypeMeta: types.TypeMeta{
+				APIVersion: types.KustomizationVersion,
+				Kind:       types.KustomizationKind,
+			},
+			Resources: []string{filepath.ToSlash(base)},
+		}
+
+		data, err := yaml.Marshal(kustomization)
+		if err != nil {
+			return fmt.Errorf("failed to marshal kustomization: %w", err)
+		}
+
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create overlay directory: %w", err)
+		}
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+
+		if k.verbose {
+			fmt.Printf("  Writing: %s\n", path)
+		}
+	}
+
+	return nil
+}
+
 // ApplyOverlay runs kustomize build on the specified overlay path
 // overlayPath can be:
 // - A directory containing kustomization.yaml (e.g., "overlays/prod")
@@ -159,6 +265,10 @@ func (k *KustomizeEngine) createBaseKustomization(resourceFiles []string) error
 			Kind:       types.KustomizationKind,
 		},
 		Resources: resourceFiles,
+		Namespace: k.baseNamespace,
+	}
+	if len(k.baseLabels) > 0 {
+		kustomization.Labels = []types.Label{{Pairs: k.baseLabels}}
 	}
 
 	// Marshal to YAML
@@ -194,17 +304,53 @@ func (k *KustomizeEngine) resMapToResources(resMap interface{}) ([]map[string]in
 			continue
 		}
 
+		// Decode numbers as json.Number so integers don't come back as float64
 		var resource map[string]interface{}
-		if err := yaml.Unmarshal([]byte(doc), &resource); err != nil {
+		if err := yaml.Unmarshal([]byte(doc), &resource, useNumber); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal resource: %w", err)
 		}
 
-		resources = append(resources, resource)
+		resources = append(resources, NormalizeNumbers(resource).(map[string]interface{}))
 	}
 
 	return resources, nil
 }
 
+// useNumber makes the JSON decoder keep numbers as json.Number
+func useNumber(d *json.Decoder) *json.Decoder {
+	d.UseNumber()
+	return d
+}
+
+// NormalizeNumbers converts json.Number values in value, which is modified in
+// place, to int64 when they are whole numbers and to float64 otherwise, so
+// that values like replicas: 3 keep an integer type after decoding with
+// json.Decoder.UseNumber, such as in the kustomize round-trip
+func NormalizeNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case map[string]interface{}:
+		for key, val := range v {
+			v[key] = NormalizeNumbers(val)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = NormalizeNumbers(item)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
 // generateFilename generates a filename for a resource
 func (k *KustomizeEngine) generateFilename(resource map[string]interface{}, index int) string {
 	kind := "resource"
@@ -223,10 +369,16 @@ func (k *KustomizeEngine) generateFilename(resource map[string]interface{}, inde
 	return fmt.Sprintf("%s-%s.yaml", kind, name)
 }
 
-// Cleanup removes the base directory
+// Cleanup removes the base directory if WriteBase created it and it is not persistent
 func (k *KustomizeEngine) Cleanup() error {
+	if !k.createdBase || k.persistentBase {
+		return nil
+	}
 	if k.baseDir != "" && k.baseDir != "." && k.baseDir != "/" {
-		return os.RemoveAll(k.baseDir)
+		if err := os.RemoveAll(k.baseDir); err != nil {
+			return err
+		}
+		k.createdBase = false
 	}
 	return nil
 }
@@ -3,6 +3,7 @@ package overlay
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -303,3 +304,357 @@ resources:
 		})
 	}
 }
+
+func TestApplyOverlayPreservesIntegerTypes(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	baseDir := filepath.Join(tempDir, "base")
+	engine := NewKustomizeEngine(baseDir, "", false)
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata": map[string]interface{}{
+				"name":      "test-app",
+				"namespace": "default",
+			},
+			"spec": map[string]interface{}{
+				"replicas": 3,
+				"template": map[string]interface{}{
+					"spec": map[string]interface{}{
+						"containers": []interface{}{
+							map[string]interface{}{
+								"name":  "app",
+								"image": "nginx",
+								"ports": []interface{}{
+									map[string]interface{}{"containerPort": 8080},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	if err := engine.WriteBase(resources); err != nil {
+		t.Fatalf("WriteBase() error = %v", err)
+	}
+
+	overlayDir := filepath.Join(tempDir, "overlays", "dev")
+	if err := os.MkdirAll(overlayDir, 0755); err != nil {
+		t.Fatalf("failed to create overlay dir: %v", err)
+	}
+
+	kustomization := `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+
+resources:
+  - ../../base
+
+namePrefix: dev-
+`
+	if err := os.WriteFile(filepath.Join(overlayDir, "kustomization.yaml"), []byte(kustomization), 0644); err != nil {
+		t.Fatalf("failed to write kustomization: %v", err)
+	}
+
+	result, err := engine.ApplyOverlay(overlayDir)
+	if err != nil {
+		t.Fatalf("ApplyOverlay() error = %v", err)
+	}
+
+	if len(result) != 1 {
+		t.Fatalf("expected 1 resource, got %d", len(result))
+	}
+
+	spec := result[0]["spec"].(map[string]interface{})
+	replicas, ok := spec["replicas"].(int64)
+	if !ok {
+		t.Fatalf("expected replicas to be int64, got %T", spec["replicas"])
+	}
+	if replicas != 3 {
+		t.Errorf("expected replicas 3, got %d", replicas)
+	}
+
+	containers := spec["template"].(map[string]interface{})["spec"].(map[string]interface{})["containers"].([]interface{})
+	ports := containers[0].(map[string]interface{})["ports"].([]interface{})
+	if port, ok := ports[0].(map[string]interface{})["containerPort"].(int64); !ok || port != 8080 {
+		t.Errorf("expected containerPort int64 8080, got %T %v", ports[0].(map[string]interface{})["containerPort"], ports[0].(map[string]interface{})["containerPort"])
+	}
+}
+
+func TestWriteBasePreservesExistingDirectory(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// A base directory the user created themselves
+	baseDir := filepath.Join(tempDir, "base")
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		t.Fatalf("failed to create base dir: %v", err)
+	}
+	userFile := filepath.Join(baseDir, "kustomization.yaml")
+	if err := os.WriteFile(userFile, []byte("resources: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write user file: %v", err)
+	}
+
+	engine := NewKustomizeEngine(baseDir, "", false)
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "test"},
+		},
+	}
+	if err := engine.WriteBase(resources); err == nil {
+		t.Error("Expected WriteBase to refuse an existing base directory")
+	}
+	if err := engine.Cleanup(); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	data, err := os.ReadFile(userFile)
+	if err != nil {
+		t.Fatalf("Expected user's base directory to survive cleanup: %v", err)
+	}
+	if string(data) != "resources: []\n" {
+		t.Errorf("Expected user's kustomization.yaml to be untouched, got %q", data)
+	}
+}
+
+func TestCleanupRemovesCreatedBase(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	baseDir := filepath.Join(tempDir, "generated-base")
+	engine := NewKustomizeEngine(baseDir, "", false)
+	if err := engine.WriteBase(nil); err != nil {
+		t.Fatalf("WriteBase() error = %v", err)
+	}
+	if err := engine.Cleanup(); err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+
+	if _, err := os.Stat(baseDir); !os.IsNotExist(err) {
+		t.Errorf("Expected created base directory to be removed, stat error = %v", err)
+	}
+}
+
+func TestWriteOverlays(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	baseDir := filepath.Join(tempDir, "base")
+	overlayDir := filepath.Join(tempDir, "overlays")
+	engine := NewKustomizeEngine(baseDir, overlayDir, false)
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "test"},
+		},
+	}
+	if err := engine.WriteBase(resources); err != nil {
+		t.Fatalf("WriteBase() error = %v", err)
+	}
+	if err := engine.WriteOverlays("dev", "prod"); err != nil {
+		t.Fatalf("WriteOverlays() error = %v", err)
+	}
+
+	for _, name := range []string{"dev", "prod"} {
+		data, err := os.ReadFile(filepath.Join(overlayDir, name, "kustomization.yaml"))
+		if err != nil {
+			t.Fatalf("Expected %s overlay to be written: %v", name, err)
+		}
+		if !strings.Contains(string(data), "- ../../base") {
+			t.Errorf("Expected %s overlay to reference the base, got:\n%s", name, data)
+		}
+
+		// The scaffolded overlay builds to the base resources unchanged
+		built, err := engine.ApplyOverlay(filepath.Join(overlayDir, name))
+		if err != nil {
+			t.Fatalf("ApplyOverlay() error = %v", err)
+		}
+		if len(built) != 1 || built[0]["kind"] != "ConfigMap" {
+			t.Errorf("Expected the base ConfigMap from %s overlay, got %v", name, built)
+		}
+	}
+
+	if err := engine.WriteOverlays("dev"); err == nil {
+		t.Error("Expected WriteOverlays to refuse an existing overlay")
+	}
+
+	// No overlay is written when a later one already exists
+	if err := engine.WriteOverlays("staging", "prod"); err == nil {
+		t.Error("Expected WriteOverlays to refuse an existing overlay")
+	}
+	if _, err := os.Stat(filepath.Join(overlayDir, "staging")); !os.IsNotExist(err) {
+		t.Errorf("Expected no staging overlay to be written, got %v", err)
+	}
+}
+
+func TestWriteBaseNamespaceAndLabels(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	baseDir := filepath.Join(tempDir, "base")
+	engine := NewKustomizeEngine(baseDir, "", false)
+	engine.SetBaseNamespace("team-a")
+	engine.SetBaseLabels(map[string]string{"team": "platform"})
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "web"},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{"app": "web"},
+				},
+			},
+		},
+	}
+	if err := engine.WriteBase(resources); err != nil {
+		t.Fatalf("WriteBase() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(baseDir, "kustomization.yaml"))
+	if err != nil {
+		t.Fatalf("failed to read kustomization.yaml: %v", err)
+	}
+	for _, want := range []string{"namespace: team-a", "team: platform"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected kustomization.yaml to contain %q, got:\n%s", want, data)
+		}
+	}
+
+	built, err := engine.Build(baseDir)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(built) != 1 {
+		t.Fatalf("Expected 1 resource, got %d", len(built))
+	}
+	metadata := built[0]["metadata"].(map[string]interface{})
+	if metadata["namespace"] != "team-a" {
+		t.Errorf("Expected namespace team-a, got %v", metadata["namespace"])
+	}
+	if labels, _ := metadata["labels"].(map[string]interface{}); labels["team"] != "platform" {
+		t.Errorf("Expected label team=platform, got %v", metadata["labels"])
+	}
+	selector := built[0]["spec"].(map[string]interface{})["selector"].(map[string]interface{})["matchLabels"].(map[string]interface{})
+	if _, ok := selector["team"]; ok {
+		t.Errorf("Expected selector to be left alone, got %v", selector)
+	}
+}
+
+func TestApplyOverlayTempBase(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The project already has a base/ of its own
+	baseDir := filepath.Join(tempDir, "base")
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		t.Fatalf("failed to create base dir: %v", err)
+	}
+	userFile := filepath.Join(baseDir, "kustomization.yaml")
+	if err := os.WriteFile(userFile, []byte("resources: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write user file: %v", err)
+	}
+
+	devDir := filepath.Join(tempDir, "overlays", "dev")
+	if err := os.MkdirAll(devDir, 0755); err != nil {
+		t.Fatalf("failed to create dev overlay dir: %v", err)
+	}
+	kustomization := "resources:\n  - ../../base\ncommonLabels:\n  environment: dev\n"
+	if err := os.WriteFile(filepath.Join(devDir, "kustomization.yaml"), []byte(kustomization), 0644); err != nil {
+		t.Fatalf("failed to write kustomization: %v", err)
+	}
+
+	resources := []map[string]interface{}{
+		{
+			"apiVersion": "v1",
+			"kind":       "ConfigMap",
+			"metadata":   map[string]interface{}{"name": "test"},
+		},
+	}
+
+	// Every run gets a fresh base, so running twice works
+	for run := 1; run <= 2; run++ {
+		engine := NewKustomizeEngine(baseDir, filepath.Join(tempDir, "overlays"), false)
+		if err := engine.UseTempBase(); err != nil {
+			t.Fatalf("UseTempBase() error = %v", err)
+		}
+		tempBase := engine.baseDir
+		if err := engine.WriteBase(resources); err != nil {
+			t.Fatalf("run %d: WriteBase() error = %v", run, err)
+		}
+
+		result, err := engine.ApplyOverlay(devDir)
+		if err != nil {
+			t.Fatalf("run %d: ApplyOverlay() error = %v", run, err)
+		}
+		if len(result) != 1 {
+			t.Fatalf("run %d: expected the generated resource, got %d resources", run, len(result))
+		}
+		labels := result[0]["metadata"].(map[string]interface{})["labels"].(map[string]interface{})
+		if labels["environment"] != "dev" {
+			t.Errorf("run %d: expected environment label 'dev', got %v", run, labels["environment"])
+		}
+
+		if err := engine.Cleanup(); err != nil {
+			t.Fatalf("run %d: Cleanup() error = %v", run, err)
+		}
+		if _, err := os.Stat(tempBase); !os.IsNotExist(err) {
+			t.Errorf("run %d: expected the temporary base to be removed, stat error = %v", run, err)
+		}
+	}
+
+	data, err := os.ReadFile(userFile)
+	if err != nil || string(data) != "resources: []\n" {
+		t.Errorf("Expected the project's base/ to be untouched, got %q (%v)", data, err)
+	}
+}
+
+func TestWriteBasePersistent(t *testing.T) {
+	tempDir, err := os.MkdirTemp("", "kustomize-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	baseDir := filepath.Join(tempDir, "base")
+	for run := 1; run <= 2; run++ {
+		engine := NewKustomizeEngine(baseDir, "", false)
+		engine.SetPersistentBase(true)
+		if err := engine.WriteBase(nil); err != nil {
+			t.Fatalf("run %d: WriteBase() error = %v", run, err)
+		}
+		if err := engine.Cleanup(); err != nil {
+			t.Fatalf("run %d: Cleanup() error = %v", run, err)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(baseDir, "kustomization.yaml")); err != nil {
+		t.Errorf("Expected a persistent base to be kept: %v", err)
+	}
+}
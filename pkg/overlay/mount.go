@@ -0,0 +1,126 @@
+package overlay
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// UseTempBase makes WriteBase write to a new temporary directory, which
+// Cleanup removes. Overlays still find the base at the engine's base
+// directory, which is never touched, so a base/ already in the project is
+// left alone and every run starts from an empty base.
+func (k *KustomizeEngine) UseTempBase() error {
+	mountPoints, err := mountPoints(k.baseDir)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "krm-sdk-base-*")
+	if err != nil {
+		return fmt.Errorf("failed to create base directory: %w", err)
+	}
+
+	k.fs = mountedFS{FileSystem: k.fs, mountPoints: mountPoints, dir: dir}
+	k.baseDir = dir
+	k.createdBase = true
+	return nil
+}
+
+// mountPoints returns the absolute paths kustomize may use for dir: as
+// written and with the symlinks of its parent resolved, since kustomize
+// resolves symlinks in the paths it loads
+func mountPoints(dir string) ([]string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve base directory: %w", err)
+	}
+
+	points := []string{abs}
+	if parent, err := filepath.EvalSymlinks(filepath.Dir(abs)); err == nil {
+		if resolved := filepath.Join(parent, filepath.Base(abs)); resolved != abs {
+			points = append(points, resolved)
+		}
+	}
+	return points, nil
+}
+
+// mountedFS is a kustomize filesystem that serves dir in place of the
+// mount points and every other path from the underlying filesystem
+type mountedFS struct {
+	filesys.FileSystem
+	mountPoints []string
+	dir         string
+}
+
+// resolve returns the path name is served from
+func (m mountedFS) resolve(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	for _, point := range m.mountPoints {
+		if abs == point {
+			return m.dir
+		}
+		if strings.HasPrefix(abs, point+string(filepath.Separator)) {
+			return filepath.Join(m.dir, abs[len(point):])
+		}
+	}
+	return name
+}
+
+func (m mountedFS) Create(path string) (filesys.File, error) {
+	return m.FileSystem.Create(m.resolve(path))
+}
+
+func (m mountedFS) Mkdir(path string) error {
+	return m.FileSystem.Mkdir(m.resolve(path))
+}
+
+func (m mountedFS) MkdirAll(path string) error {
+	return m.FileSystem.MkdirAll(m.resolve(path))
+}
+
+func (m mountedFS) RemoveAll(path string) error {
+	return m.FileSystem.RemoveAll(m.resolve(path))
+}
+
+func (m mountedFS) Open(path string) (filesys.File, error) {
+	return m.FileSystem.Open(m.resolve(path))
+}
+
+func (m mountedFS) IsDir(path string) bool {
+	return m.FileSystem.IsDir(m.resolve(path))
+}
+
+func (m mountedFS) ReadDir(path string) ([]string, error) {
+	return m.FileSystem.ReadDir(m.resolve(path))
+}
+
+func (m mountedFS) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	return m.FileSystem.CleanedAbs(m.resolve(path))
+}
+
+func (m mountedFS) Exists(path string) bool {
+	return m.FileSystem.Exists(m.resolve(path))
+}
+
+func (m mountedFS) Glob(pattern string) ([]string, error) {
+	return m.FileSystem.Glob(m.resolve(pattern))
+}
+
+func (m mountedFS) ReadFile(path string) ([]byte, error) {
+	return m.FileSystem.ReadFile(m.resolve(path))
+}
+
+func (m mountedFS) WriteFile(path string, data []byte) error {
+	return m.FileSystem.WriteFile(m.resolve(path), data)
+}
+
+func (m mountedFS) Walk(path string, walkFn filepath.WalkFunc) error {
+	return m.FileSystem.Walk(m.resolve(path), walkFn)
+}
@@ -9,10 +9,11 @@ import (
 
 // APIConfig holds configuration for API scaffolding
 type APIConfig struct {
-	Group   string
-	Version string
-	Kind    string
-	Verbose bool
+	Group       string
+	Version     string
+	Kind        string
+	WithWebhook bool
+	Verbose     bool
 }
 
 // APIScaffolder handles API type scaffolding
@@ -67,6 +68,16 @@ func (s *APIScaffolder) Scaffold() error {
 		filepath.Join("config/samples", snakeName+".yaml"): s.generateSampleFile(domain),
 	}
 
+	if s.config.WithWebhook {
+		files[filepath.Join(apiDir, snakeName+"_webhook.go")] = s.generateWebhookFile()
+
+		// The Defaulter/Validator interfaces are shared by every kind in the version
+		webhookPath := filepath.Join(apiDir, "webhook.go")
+		if _, err := os.Stat(webhookPath); os.IsNotExist(err) {
+			files[webhookPath] = s.generateWebhookRegistry()
+		}
+	}
+
 	for filename, content := range files {
 		if s.config.Verbose {
 			fmt.Printf("Creating file: %s\n", filename)
@@ -208,6 +219,83 @@ spec:
 `, domain, s.config.Version, s.config.Kind, ToSnakeCase(s.config.Kind))
 }
 
+func (s *APIScaffolder) generateWebhookFile() string {
+	return fmt.Sprintf(`package %s
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+var _ Defaulter = &%s{}
+var _ Validator = &%s{}
+
+func init() {
+	RegisterWebhook(&%s{})
+}
+
+// Default sets default values on a %s
+func (r *%s) Default() {
+	// INSERT DEFAULTING LOGIC HERE
+}
+
+// ValidateCreate validates a %s when it is created
+func (r *%s) ValidateCreate() error {
+	// INSERT CREATE VALIDATION HERE
+	return nil
+}
+
+// ValidateUpdate validates a %s when it is updated from old
+func (r *%s) ValidateUpdate(old runtime.Object) error {
+	// INSERT UPDATE VALIDATION HERE
+	return nil
+}
+
+// ValidateDelete validates a %s when it is deleted
+func (r *%s) ValidateDelete() error {
+	// INSERT DELETE VALIDATION HERE
+	return nil
+}
+`, s.config.Version, s.config.Kind, s.config.Kind, s.config.Kind,
+		s.config.Kind, s.config.Kind,
+		s.config.Kind, s.config.Kind,
+		s.config.Kind, s.config.Kind,
+		s.config.Kind, s.config.Kind)
+}
+
+func (s *APIScaffolder) generateWebhookRegistry() string {
+	return fmt.Sprintf(`package %s
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// Defaulter sets default values on an object before it is validated
+type Defaulter interface {
+	Default()
+}
+
+// Validator validates an object on create, update and delete
+type Validator interface {
+	ValidateCreate() error
+	ValidateUpdate(old runtime.Object) error
+	ValidateDelete() error
+}
+
+// webhooks holds the objects registered for defaulting and validation
+var webhooks []runtime.Object
+
+// RegisterWebhook registers an object implementing Defaulter and/or Validator
+func RegisterWebhook(obj runtime.Object) {
+	webhooks = append(webhooks, obj)
+}
+
+// Webhooks returns the objects registered for defaulting and validation
+func Webhooks() []runtime.Object {
+	return webhooks
+}
+`, s.config.Version)
+}
+
 func (s *APIScaffolder) updateRegister(apiDir string) error {
 	registerPath := filepath.Join(apiDir, "register.go")
 
@@ -0,0 +1,72 @@
+package scaffold
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAPIScaffoldWithWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		withWebhook bool
+	}{
+		{name: "with webhook", withWebhook: true},
+		{name: "without webhook", withWebhook: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir, err := os.MkdirTemp("", "scaffold-test-*")
+			if err != nil {
+				t.Fatalf("failed to create temp dir: %v", err)
+			}
+			defer os.RemoveAll(tempDir)
+			t.Chdir(tempDir)
+
+			if err := os.WriteFile("PROJECT", []byte("domain: test.example.com\n"), 0644); err != nil {
+				t.Fatalf("failed to write PROJECT: %v", err)
+			}
+			if err := os.MkdirAll("config/samples", 0755); err != nil {
+				t.Fatalf("failed to create samples dir: %v", err)
+			}
+
+			scaffolder := NewAPIScaffolder(APIConfig{
+				Group:       "platform",
+				Version:     "v1alpha1",
+				Kind:        "WebService",
+				WithWebhook: tt.withWebhook,
+			})
+			if err := scaffolder.Scaffold(); err != nil {
+				t.Fatalf("Scaffold() error = %v", err)
+			}
+
+			webhookPath := filepath.Join("api", "v1alpha1", "web_service_webhook.go")
+			data, err := os.ReadFile(webhookPath)
+			if !tt.withWebhook {
+				if err == nil {
+					t.Errorf("expected no webhook file without --with-webhook")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected webhook file: %v", err)
+			}
+
+			for _, expected := range []string{
+				"func (r *WebService) Default()",
+				"func (r *WebService) ValidateCreate() error",
+				"RegisterWebhook(&WebService{})",
+			} {
+				if !strings.Contains(string(data), expected) {
+					t.Errorf("expected webhook file to contain %q, got:\n%s", expected, data)
+				}
+			}
+
+			if _, err := os.Stat(filepath.Join("api", "v1alpha1", "webhook.go")); err != nil {
+				t.Errorf("expected shared webhook.go: %v", err)
+			}
+		})
+	}
+}
@@ -377,17 +377,20 @@ func (s *ProjectScaffolder) generateMain() string {
 	return fmt.Sprintf(`package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
 	"%s/cmd/%s/commands"
+	"github.com/zachaller/k8s-client-api-builder/pkg/cli"
 )
 
 func main() {
-	if err := commands.Execute(); err != nil {
+	err := commands.Execute()
+	if err != nil && !errors.Is(err, cli.ErrDiffFound) {
 		fmt.Fprintf(os.Stderr, "Error: %%v\n", err)
-		os.Exit(1)
 	}
+	os.Exit(cli.ExitCode(err))
 }
 `, s.config.Repo, s.config.Name)
 }
@@ -5,7 +5,10 @@ import (
 	"flag"
 	"os"
 	"path/filepath"
+	"reflect"
 	"testing"
+
+	"sigs.k8s.io/yaml"
 )
 
 var update = flag.Bool("update", false, "update golden files")
@@ -61,7 +64,80 @@ func LoadGolden(t *testing.T, goldenFile string) []byte {
 func CompareYAMLWithGolden(t *testing.T, got []byte, goldenFile string) {
 	t.Helper()
 
-	// For now, just use byte comparison
-	// In the future, could parse YAML and do semantic comparison
-	CompareWithGolden(t, got, goldenFile)
+	if *update {
+		CompareWithGolden(t, got, goldenFile)
+		return
+	}
+
+	want, err := os.ReadFile(goldenFile)
+	if err != nil {
+		t.Fatalf("failed to read golden file %s: %v", goldenFile, err)
+	}
+
+	equal, err := yamlEqual(got, want)
+	if err != nil {
+		t.Fatalf("failed to compare with golden file %s: %v", goldenFile, err)
+	}
+
+	if !equal {
+		t.Errorf("output differs from golden file %s\n\nTo update: go test -update\n\nGot:\n%s\n\nWant:\n%s",
+			goldenFile, string(got), string(want))
+	}
+}
+
+// yamlEqual reports whether two multi-document YAML streams contain the same resources
+func yamlEqual(got, want []byte) (bool, error) {
+	gotResources, err := parseYAMLResources(got)
+	if err != nil {
+		return false, err
+	}
+
+	wantResources, err := parseYAMLResources(want)
+	if err != nil {
+		return false, err
+	}
+
+	return reflect.DeepEqual(gotResources, wantResources), nil
+}
+
+// AssertGolden generates resources from instanceFile, applying overlay when it is
+// not empty, and compares them semantically with goldenFile
+func (f *TestFramework) AssertGolden(instanceFile, goldenFile, overlay string) {
+	f.T.Helper()
+
+	var resources []map[string]interface{}
+	var err error
+	if overlay != "" {
+		resources, err = f.GenerateWithOverlay(instanceFile, overlay)
+	} else {
+		resources, err = f.GenerateResources(instanceFile)
+	}
+	if err != nil {
+		f.T.Fatalf("failed to generate resources: %v", err)
+	}
+
+	got, err := marshalResources(resources)
+	if err != nil {
+		f.T.Fatalf("failed to marshal resources: %v", err)
+	}
+
+	CompareYAMLWithGolden(f.T, got, goldenFile)
+}
+
+// marshalResources renders resources as a multi-document YAML stream
+func marshalResources(resources []map[string]interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	for i, resource := range resources {
+		if i > 0 {
+			buf.WriteString("---\n")
+		}
+
+		data, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
 }
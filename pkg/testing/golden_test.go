@@ -0,0 +1,81 @@
+package testing
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newFakeFramework returns a framework whose project binary echoes the
+// instance file back, standing in for a generated project
+func newFakeFramework(t *testing.T) *TestFramework {
+	t.Helper()
+
+	tempDir, err := os.MkdirTemp("", "golden-test-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+
+	// Invoked as: <binary> generate -f <instanceFile> [--overlay <overlay>]
+	binary := filepath.Join(tempDir, "fake-project")
+	script := "#!/bin/sh\ncat \"$3\"\n"
+	if err := os.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatalf("failed to write fake binary: %v", err)
+	}
+
+	return &TestFramework{
+		TempDir:    tempDir,
+		ProjectDir: tempDir,
+		BinaryPath: binary,
+		T:          t,
+	}
+}
+
+func TestAssertGolden(t *testing.T) {
+	f := newFakeFramework(t)
+	defer f.Cleanup()
+
+	instanceFile, err := filepath.Abs("testdata/instance.yaml")
+	if err != nil {
+		t.Fatalf("failed to resolve fixture: %v", err)
+	}
+
+	f.AssertGolden(instanceFile, "testdata/instance.golden.yaml", "")
+	f.AssertGolden(instanceFile, "testdata/instance.golden.yaml", "overlays/dev")
+}
+
+func TestYAMLEqual(t *testing.T) {
+	want, err := os.ReadFile("testdata/instance.golden.yaml")
+	if err != nil {
+		t.Fatalf("failed to read golden file: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		got      string
+		expected bool
+	}{
+		{
+			name:     "different resource",
+			got:      "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: other\n",
+			expected: false,
+		},
+		{
+			name:     "missing document",
+			got:      "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: app-config\n  namespace: default\ndata:\n  mode: production\n",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			equal, err := yamlEqual([]byte(tt.got), want)
+			if err != nil {
+				t.Fatalf("yamlEqual() error = %v", err)
+			}
+			if equal != tt.expected {
+				t.Errorf("yamlEqual() = %v, want %v", equal, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package testing
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zachaller/k8s-client-api-builder/pkg/hydrator"
+)
+
+// TemplateTester hydrates templates in-process, so template authors can
+// unit-test them without building a project binary
+type TemplateTester struct {
+	Hydrator *hydrator.Hydrator
+	T        *testing.T
+}
+
+// NewTemplateTester creates a template tester. Configure Hydrator, for
+// example with SetValues, before hydrating.
+func NewTemplateTester(t *testing.T) *TemplateTester {
+	t.Helper()
+
+	return &TemplateTester{
+		Hydrator: hydrator.NewHydrator("", false),
+		T:        t,
+	}
+}
+
+// Hydrate hydrates instance with the template YAML in template
+func (tt *TemplateTester) Hydrate(template string, instance map[string]interface{}) ([]map[string]interface{}, error) {
+	tt.T.Helper()
+
+	result, err := tt.Hydrator.HydrateWithTemplate(instance, []byte(template))
+	return hydrateResources(result, err)
+}
+
+// HydrateFile hydrates instance with the template file at path
+func (tt *TemplateTester) HydrateFile(path string, instance map[string]interface{}) ([]map[string]interface{}, error) {
+	tt.T.Helper()
+
+	result, err := tt.Hydrator.HydrateTemplateFile(instance, path)
+	return hydrateResources(result, err)
+}
+
+// ValidateOutput validates hydrated resources against expectations
+func (tt *TemplateTester) ValidateOutput(resources []map[string]interface{}, expectations []Expectation) error {
+	tt.T.Helper()
+
+	for _, expectation := range expectations {
+		if err := expectation.Validate(resources); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hydrateResources returns the resources of a hydration, treating unresolved
+// resource references as failures
+func hydrateResources(result *hydrator.HydrateResult, err error) ([]map[string]interface{}, error) {
+	if err != nil {
+		return nil, fmt.Errorf("hydration failed: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		return nil, fmt.Errorf("failed to resolve resource references: %w", errors.Join(result.Errors...))
+	}
+	return result.Resources, nil
+}
@@ -0,0 +1,112 @@
+package testing
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestTemplateTester(t *testing.T) {
+	template, err := os.ReadFile("testdata/webservice_template.yaml")
+	if err != nil {
+		t.Fatalf("failed to read template: %v", err)
+	}
+
+	instance := func(ingress bool) map[string]interface{} {
+		return map[string]interface{}{
+			"apiVersion": "platform.example.com/v1alpha1",
+			"kind":       "WebService",
+			"metadata":   map[string]interface{}{"name": "shop"},
+			"spec": map[string]interface{}{
+				"ingress": ingress,
+				"services": []interface{}{
+					map[string]interface{}{"name": "web", "port": int64(80)},
+					map[string]interface{}{"name": "api", "port": int64(8080)},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		hydrate      func(*TemplateTester, map[string]interface{}) ([]map[string]interface{}, error)
+		ingress      bool
+		expectations []Expectation
+		wantIngress  bool
+	}{
+		{
+			name: "template string with ingress",
+			hydrate: func(tt *TemplateTester, instance map[string]interface{}) ([]map[string]interface{}, error) {
+				return tt.Hydrate(string(template), instance)
+			},
+			ingress:     true,
+			wantIngress: true,
+		},
+		{
+			name: "template file without ingress",
+			hydrate: func(tt *TemplateTester, instance map[string]interface{}) ([]map[string]interface{}, error) {
+				return tt.HydrateFile("testdata/webservice_template.yaml", instance)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tester := NewTemplateTester(t)
+
+			resources, err := test.hydrate(tester, instance(test.ingress))
+			if err != nil {
+				t.Fatalf("hydrate error = %v", err)
+			}
+
+			expectations := []Expectation{
+				ExpectResource("Service", 2).WithLabel("app", "shop"),
+				ExpectResource("Service", 1).WithName("api").WithCheck(servicePort(8080)),
+			}
+			if err := tester.ValidateOutput(resources, expectations); err != nil {
+				t.Error(err)
+			}
+
+			ingresses := 0
+			for _, resource := range resources {
+				if resource["kind"] == "Ingress" {
+					ingresses++
+				}
+			}
+			if (ingresses == 1) != test.wantIngress {
+				t.Errorf("got %d Ingresses, wantIngress %v", ingresses, test.wantIngress)
+			}
+		})
+	}
+}
+
+// servicePort checks the port of a Service's first port entry
+func servicePort(port int64) ResourceCheck {
+	return func(resource map[string]interface{}) error {
+		spec, _ := resource["spec"].(map[string]interface{})
+		ports, _ := spec["ports"].([]interface{})
+		if len(ports) == 0 {
+			return fmt.Errorf("service has no ports")
+		}
+		if got := ports[0].(map[string]interface{})["port"]; got != port {
+			return fmt.Errorf("expected port %d, got %v", port, got)
+		}
+		return nil
+	}
+}
+
+func TestTemplateTesterUnresolvedReference(t *testing.T) {
+	template := `resources:
+  - apiVersion: v1
+    kind: ConfigMap
+    metadata:
+      name: config
+    data:
+      ip: $(resource("v1", "Service", "missing").spec.clusterIP)
+`
+
+	tester := NewTemplateTester(t)
+	if _, err := tester.Hydrate(template, map[string]interface{}{"metadata": map[string]interface{}{}}); err == nil {
+		t.Error("Expected error for an unresolved resource reference")
+	}
+}
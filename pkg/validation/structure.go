@@ -0,0 +1,55 @@
+package validation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	utilvalidation "k8s.io/apimachinery/pkg/util/validation"
+)
+
+// kindPattern matches a well-formed kind such as WebService
+var kindPattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9]*$`)
+
+// ValidateStructure checks that an instance has a well-formed apiVersion,
+// kind and metadata.name. Unlike Validate it needs no CRD schema, so it can
+// run before every hydration.
+func ValidateStructure(instance map[string]interface{}) error {
+	var errs []string
+
+	apiVersion, ok := instance["apiVersion"].(string)
+	switch {
+	case !ok || apiVersion == "":
+		errs = append(errs, "missing 'apiVersion' field")
+	default:
+		parts := strings.Split(apiVersion, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Sprintf("invalid apiVersion '%s': expected <group>/<version>", apiVersion))
+		}
+	}
+
+	kind, ok := instance["kind"].(string)
+	switch {
+	case !ok || kind == "":
+		errs = append(errs, "missing 'kind' field")
+	case !kindPattern.MatchString(kind):
+		errs = append(errs, fmt.Sprintf("invalid kind '%s': must be alphanumeric and start with an uppercase letter", kind))
+	}
+
+	metadata, _ := instance["metadata"].(map[string]interface{})
+	name, ok := metadata["name"].(string)
+	switch {
+	case !ok || name == "":
+		errs = append(errs, "missing 'metadata.name' field")
+	default:
+		if problems := utilvalidation.IsDNS1123Subdomain(name); len(problems) > 0 {
+			errs = append(errs, fmt.Sprintf("invalid metadata.name '%s': %s", name, strings.Join(problems, "; ")))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid instance:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package validation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateStructure(t *testing.T) {
+	tests := []struct {
+		name     string
+		instance map[string]interface{}
+		wantErr  string
+	}{
+		{
+			name: "valid instance",
+			instance: map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"name": "my-app"},
+			},
+		},
+		{
+			name: "missing name",
+			instance: map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"namespace": "default"},
+			},
+			wantErr: "missing 'metadata.name' field",
+		},
+		{
+			name: "missing metadata",
+			instance: map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+			},
+			wantErr: "missing 'metadata.name' field",
+		},
+		{
+			name: "missing kind",
+			instance: map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"metadata":   map[string]interface{}{"name": "my-app"},
+			},
+			wantErr: "missing 'kind' field",
+		},
+		{
+			name: "missing apiVersion",
+			instance: map[string]interface{}{
+				"kind":     "WebService",
+				"metadata": map[string]interface{}{"name": "my-app"},
+			},
+			wantErr: "missing 'apiVersion' field",
+		},
+		{
+			name: "apiVersion without group",
+			instance: map[string]interface{}{
+				"apiVersion": "v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"name": "my-app"},
+			},
+			wantErr: "invalid apiVersion 'v1alpha1'",
+		},
+		{
+			name: "lowercase kind",
+			instance: map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "webservice",
+				"metadata":   map[string]interface{}{"name": "my-app"},
+			},
+			wantErr: "invalid kind 'webservice'",
+		},
+		{
+			name: "invalid name",
+			instance: map[string]interface{}{
+				"apiVersion": "platform.example.com/v1alpha1",
+				"kind":       "WebService",
+				"metadata":   map[string]interface{}{"name": "My_App"},
+			},
+			wantErr: "invalid metadata.name 'My_App'",
+		},
+		{
+			name:     "reports every problem",
+			instance: map[string]interface{}{},
+			wantErr:  "missing 'apiVersion' field\n  missing 'kind' field\n  missing 'metadata.name' field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStructure(tt.instance)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("ValidateStructure() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ValidateStructure() expected error containing %q, got nil", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ValidateStructure() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}